@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
@@ -16,6 +18,7 @@ import (
 	"github.com/grafana/loki/pkg/logcli/output"
 	"github.com/grafana/loki/pkg/logcli/query"
 	"github.com/grafana/loki/pkg/logcli/seriesquery"
+	"github.com/grafana/loki/pkg/logql"
 )
 
 var (
@@ -75,6 +78,14 @@ https://github.com/grafana/loki/blob/master/docs/logql.md`)
 
 	seriesCmd   = app.Command("series", "Run series query.")
 	seriesQuery = newSeriesQuery(seriesCmd)
+
+	fmtCmd = app.Command("fmt", `Format a LogQL query.
+
+The "fmt" command pretty-prints the given query, or the query read from
+stdin when none is given. Queries that don't fit on a single line are
+split over multiple indented lines. The query is formatted locally and
+doesn't require a Loki server.`)
+	fmtQuery = fmtCmd.Arg("query", "eg '{foo=\"bar\",baz=~\".*blip\"} |~ \".*error.*\"'").String()
 )
 
 func main() {
@@ -125,6 +136,20 @@ func main() {
 		labelsQuery.DoLabels(queryClient)
 	case seriesCmd.FullCommand():
 		seriesQuery.DoSeries(queryClient)
+	case fmtCmd.FullCommand():
+		q := *fmtQuery
+		if q == "" {
+			b, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("Unable to read query from stdin: %s", err)
+			}
+			q = string(b)
+		}
+		formatted, err := logql.FormatQuery(q)
+		if err != nil {
+			log.Fatalf("Unable to format query: %s", err)
+		}
+		fmt.Println(formatted)
 	}
 }
 
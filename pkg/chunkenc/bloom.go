@@ -0,0 +1,77 @@
+package chunkenc
+
+const (
+	// The tokens of the bloom filters are the trigrams of the lines, a line
+	// contains a string only if it contains all its trigrams.
+	bloomTokenSize = 3
+	// bloomBitsPerToken and bloomHashes give a false positive rate of about
+	// 2.4% per token.
+	bloomBitsPerToken = 8
+	bloomHashes       = 4
+	// bloomMaxBytes caps the size of the filter of a block, the false
+	// positive rate increasing with the tokens above it.
+	bloomMaxBytes = 32 * 1024
+)
+
+// token returns the trigram of b starting at i.
+func token(b string, i int) uint32 {
+	return uint32(b[i])<<16 | uint32(b[i+1])<<8 | uint32(b[i+2])
+}
+
+// newBloom returns the bloom filter of the trigrams of the entries.
+func newBloom(entries []entry) []byte {
+	tokens := map[uint32]struct{}{}
+	for _, e := range entries {
+		for i := 0; i+bloomTokenSize <= len(e.s); i++ {
+			tokens[token(e.s, i)] = struct{}{}
+		}
+	}
+
+	size := (len(tokens)*bloomBitsPerToken + 7) / 8
+	if size > bloomMaxBytes {
+		size = bloomMaxBytes
+	}
+	if size == 0 {
+		size = 1
+	}
+	bloom := make([]byte, size)
+	for t := range tokens {
+		h1, h2 := bloomHash(t)
+		for i := uint32(0); i < bloomHashes; i++ {
+			bit := (h1 + i*h2) % uint32(len(bloom)*8)
+			bloom[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return bloom
+}
+
+// bloomMayContain returns false when no line of the bloom filter contains s.
+// An empty filter, from the blocks written without one, may contain anything.
+func bloomMayContain(bloom []byte, s string) bool {
+	if len(bloom) == 0 {
+		return true
+	}
+	for i := 0; i+bloomTokenSize <= len(s); i++ {
+		h1, h2 := bloomHash(token(s, i))
+		for j := uint32(0); j < bloomHashes; j++ {
+			bit := (h1 + j*h2) % uint32(len(bloom)*8)
+			if bloom[bit/8]&(1<<(bit%8)) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bloomHash returns the two hashes of a token combined into the hashes of the
+// bloom filter.
+func bloomHash(t uint32) (uint32, uint32) {
+	h1 := t * 0x9E3779B1
+	h2 := t ^ t>>15
+	h2 *= 0x85EBCA6B
+	h2 ^= h2 >> 13
+	h2 *= 0xC2B2AE35
+	h2 ^= h2 >> 16
+	// An odd second hash visits distinct bits.
+	return h1, h2 | 1
+}
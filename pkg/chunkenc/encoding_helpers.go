@@ -93,6 +93,22 @@ func (d *decbuf) uvarint64() uint64 {
 	return x
 }
 
+// uvarintBytes returns the bytes prefixed by their uvarint length. The returned
+// slice shares the decoded buffer.
+func (d *decbuf) uvarintBytes() []byte {
+	l := d.uvarint64()
+	if d.e != nil {
+		return nil
+	}
+	if uint64(len(d.b)) < l {
+		d.e = ErrInvalidSize
+		return nil
+	}
+	x := d.b[:l]
+	d.b = d.b[l:]
+	return x
+}
+
 func (d *decbuf) be32() uint32 {
 	if d.e != nil {
 		return 0
@@ -37,6 +37,9 @@ const (
 	EncLZ4_256k
 	EncLZ4_1M
 	EncLZ4_4M
+
+	EncFlate
+	EncZstd
 )
 
 var supportedEncoding = []Encoding{
@@ -44,6 +47,8 @@ var supportedEncoding = []Encoding{
 	EncGZIP,
 	EncLZ4_64k,
 	EncSnappy,
+	EncFlate,
+	EncZstd,
 }
 
 func (e Encoding) String() string {
@@ -64,6 +69,10 @@ func (e Encoding) String() string {
 		return "lz4-4M"
 	case EncSnappy:
 		return "snappy"
+	case EncFlate:
+		return "flate"
+	case EncZstd:
+		return "zstd"
 	default:
 		return "unknown"
 	}
@@ -9,6 +9,8 @@ func TestParseEncoding(t *testing.T) {
 		wantErr bool
 	}{
 		{"gzip", EncGZIP, false},
+		{"flate", EncFlate, false},
+		{"zstd", EncZstd, false},
 		{"bad", 0, true},
 	}
 	for _, tt := range tests {
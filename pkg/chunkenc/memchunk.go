@@ -9,6 +9,7 @@ import (
 	"hash"
 	"hash/crc32"
 	"io"
+	"sort"
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/util"
@@ -31,6 +32,11 @@ var (
 
 	chunkFormatV1 = byte(1)
 	chunkFormatV2 = byte(2)
+	// chunkFormatV3 adds the bloom filter of each block to the block metas.
+	chunkFormatV3 = byte(3)
+	// chunkFormatV4 adds the structured metadata of each entry to the blocks,
+	// the bloom filters of its blocks are optional.
+	chunkFormatV4 = byte(4)
 )
 
 // The table gets initialized with sync.Once but may still cause a race
@@ -66,6 +72,11 @@ type MemChunk struct {
 	// the chunk format default to v2
 	format   byte
 	encoding Encoding
+	// blooms is true when the cut blocks get a bloom filter.
+	blooms bool
+	// metadata is true when the chunk keeps the structured metadata of its
+	// entries, it's converted to format v4 by the first entry having some.
+	metadata bool
 
 	readers ReaderPool
 	writers WriterPool
@@ -80,6 +91,12 @@ type block struct {
 
 	offset           int // The offset of the block in the chunk.
 	uncompressedSize int // Total uncompressed size in bytes when the chunk is cut.
+
+	// The bloom filter of the block, empty before chunk format v3.
+	bloom []byte
+	// The format of the chunk, the entries have structured metadata from
+	// chunk format v4.
+	format byte
 }
 
 // This block holds the un-compressed entries. Once it has enough data, this is
@@ -90,28 +107,47 @@ type headBlock struct {
 	size    int // size of uncompressed bytes.
 
 	mint, maxt int64
+
+	// unordered head blocks accept entries in any order, they are sorted when
+	// the block is cut.
+	unordered bool
 }
 
 func (hb *headBlock) isEmpty() bool {
 	return len(hb.entries) == 0
 }
 
-func (hb *headBlock) append(ts int64, line string) error {
-	if !hb.isEmpty() && hb.maxt > ts {
+func (hb *headBlock) append(ts int64, line string, metadata []logproto.LabelPair) error {
+	if !hb.unordered && !hb.isEmpty() && hb.maxt > ts {
 		return ErrOutOfOrder
 	}
 
-	hb.entries = append(hb.entries, entry{ts, line})
+	if hb.isEmpty() || hb.maxt < ts {
+		hb.maxt = ts
+	}
 	if hb.mint == 0 || hb.mint > ts {
 		hb.mint = ts
 	}
-	hb.maxt = ts
-	hb.size += len(line)
+	hb.entries = append(hb.entries, entry{t: ts, s: line, metadata: metadata})
+	hb.size += len(line) + logproto.MetadataSize(metadata)
 
 	return nil
 }
 
-func (hb *headBlock) serialise(pool WriterPool) ([]byte, error) {
+// sortEntries sorts entries by timestamp, keeping the order in which entries
+// with the same timestamp were appended.
+func sortEntries(entries []entry) {
+	if sort.SliceIsSorted(entries, func(i, j int) bool { return entries[i].t < entries[j].t }) {
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].t < entries[j].t })
+}
+
+func (hb *headBlock) serialise(pool WriterPool, format byte) ([]byte, error) {
+	if hb.unordered {
+		sortEntries(hb.entries)
+	}
+
 	inBuf := serializeBytesBufferPool.Get().(*bytes.Buffer)
 	defer func() {
 		inBuf.Reset()
@@ -130,6 +166,20 @@ func (hb *headBlock) serialise(pool WriterPool) ([]byte, error) {
 		inBuf.Write(encBuf[:n])
 
 		inBuf.WriteString(logEntry.s)
+
+		if format < chunkFormatV4 {
+			continue
+		}
+		n = binary.PutUvarint(encBuf, uint64(len(logEntry.metadata)))
+		inBuf.Write(encBuf[:n])
+		for _, m := range logEntry.metadata {
+			n = binary.PutUvarint(encBuf, uint64(len(m.Name)))
+			inBuf.Write(encBuf[:n])
+			inBuf.WriteString(m.Name)
+			n = binary.PutUvarint(encBuf, uint64(len(m.Value)))
+			inBuf.Write(encBuf[:n])
+			inBuf.WriteString(m.Value)
+		}
 	}
 
 	if _, err := compressedWriter.Write(inBuf.Bytes()); err != nil {
@@ -142,9 +192,33 @@ func (hb *headBlock) serialise(pool WriterPool) ([]byte, error) {
 	return outBuf.Bytes(), nil
 }
 
+// checkpointBytes encodes the uncompressed entries of the head block, with
+// their structured metadata from chunk format v4.
+func (hb *headBlock) checkpointBytes(format byte) []byte {
+	eb := encbuf{b: make([]byte, 0, hb.size+len(hb.entries)*3*binary.MaxVarintLen64+binary.MaxVarintLen64)}
+	eb.putUvarint(len(hb.entries))
+	for _, e := range hb.entries {
+		eb.putVarint64(e.t)
+		eb.putUvarint(len(e.s))
+		eb.b = append(eb.b, e.s...)
+		if format < chunkFormatV4 {
+			continue
+		}
+		eb.putUvarint(len(e.metadata))
+		for _, m := range e.metadata {
+			eb.putUvarint(len(m.Name))
+			eb.b = append(eb.b, m.Name...)
+			eb.putUvarint(len(m.Value))
+			eb.b = append(eb.b, m.Value...)
+		}
+	}
+	return eb.get()
+}
+
 type entry struct {
-	t int64
-	s string
+	t        int64
+	s        string
+	metadata []logproto.LabelPair
 }
 
 // NewMemChunk returns a new in-mem chunk.
@@ -165,6 +239,25 @@ func NewMemChunk(enc Encoding, blockSize, targetSize int) *MemChunk {
 	return c
 }
 
+// NewUnorderedMemChunk returns a new in-mem chunk accepting entries in any
+// order and keeping their structured metadata. The entries of each block are
+// sorted when it is cut, the blocks can overlap until the chunk is closed or
+// encoded. The blocks are compressed with the level, 0 for the default level
+// of the encoding, and have a bloom filter of their lines if blooms is true.
+// Only the chunks having entries with structured metadata are written in
+// format v4, so that the other ones can be read by older readers.
+func NewUnorderedMemChunk(enc Encoding, level int, blooms bool, blockSize, targetSize int) *MemChunk {
+	c := NewMemChunk(enc, blockSize, targetSize)
+	c.head.unordered = true
+	c.writers = getWriterPoolLevel(enc, level)
+	if blooms {
+		c.format = chunkFormatV3
+	}
+	c.blooms = blooms
+	c.metadata = true
+	return c
+}
+
 // NewByteChunk returns a MemChunk on the passed bytes.
 func NewByteChunk(b []byte, blockSize, targetSize int) (*MemChunk, error) {
 	bc := &MemChunk{
@@ -186,7 +279,7 @@ func NewByteChunk(b []byte, blockSize, targetSize int) (*MemChunk, error) {
 	switch version {
 	case chunkFormatV1:
 		bc.readers, bc.writers = &Gzip, &Gzip
-	case chunkFormatV2:
+	case chunkFormatV2, chunkFormatV3, chunkFormatV4:
 		// format v2 has a byte for block encoding.
 		enc := Encoding(db.byte())
 		if db.err() != nil {
@@ -212,7 +305,7 @@ func NewByteChunk(b []byte, blockSize, targetSize int) (*MemChunk, error) {
 	bc.blocks = make([]block, 0, num)
 
 	for i := 0; i < num; i++ {
-		blk := block{}
+		blk := block{format: version}
 		// Read #entries.
 		blk.numEntries = db.uvarint()
 
@@ -225,6 +318,11 @@ func NewByteChunk(b []byte, blockSize, targetSize int) (*MemChunk, error) {
 		l := db.uvarint()
 		blk.b = b[blk.offset : blk.offset+l]
 
+		// Read the bloom filter.
+		if version >= chunkFormatV3 {
+			blk.bloom = db.uvarintBytes()
+		}
+
 		// Verify checksums.
 		expCRC := binary.BigEndian.Uint32(b[blk.offset+l:])
 		if expCRC != crc32.Checksum(blk.b, castagnoliTable) {
@@ -233,6 +331,10 @@ func NewByteChunk(b []byte, blockSize, targetSize int) (*MemChunk, error) {
 		}
 
 		bc.blocks = append(bc.blocks, blk)
+		// The blocks of a chunk have a bloom filter if the first one has.
+		if len(bc.blocks) == 1 {
+			bc.blooms = len(blk.bloom) > 0
+		}
 
 		// Update the counter used to track the size of cut blocks.
 		bc.cutBlockSize += len(blk.b)
@@ -253,6 +355,14 @@ func (c *MemChunk) Bytes() ([]byte, error) {
 			return nil, err
 		}
 	}
+	if err := c.reorder(); err != nil {
+		return nil, err
+	}
+	return c.blocksBytes()
+}
+
+// blocksBytes encodes the cut blocks of the chunk.
+func (c *MemChunk) blocksBytes() ([]byte, error) {
 	crc32Hash := newCRC32()
 
 	buf := bytes.NewBuffer(nil)
@@ -263,7 +373,7 @@ func (c *MemChunk) Bytes() ([]byte, error) {
 	// Write the header (magicNum + version).
 	eb.putBE32(magicNumber)
 	eb.putByte(c.format)
-	if c.format == chunkFormatV2 {
+	if c.format >= chunkFormatV2 {
 		// chunk format v2 has a byte for encoding.
 		eb.putByte(byte(c.encoding))
 	}
@@ -301,6 +411,10 @@ func (c *MemChunk) Bytes() ([]byte, error) {
 		eb.putVarint64(b.maxt)
 		eb.putUvarint(b.offset)
 		eb.putUvarint(len(b.b))
+		if c.format >= chunkFormatV3 {
+			eb.putUvarint(len(b.bloom))
+			eb.putBytes(b.bloom)
+		}
 	}
 	eb.putHash(crc32Hash)
 
@@ -320,6 +434,57 @@ func (c *MemChunk) Bytes() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// SerializeForCheckpoint returns the bytes of the cut blocks of the chunk and
+// the entries of its head block. Unlike Bytes, it doesn't cut the head block
+// so that checkpointing a chunk doesn't change how it's compressed.
+func (c *MemChunk) SerializeForCheckpoint() (chk, head []byte, err error) {
+	chk, err = c.blocksBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return chk, c.head.checkpointBytes(c.format), nil
+}
+
+// MemchunkFromCheckpoint restores a MemChunk from the bytes returned by
+// SerializeForCheckpoint. An unordered chunk keeps accepting entries in any
+// order, its next blocks are compressed with the level as in
+// NewUnorderedMemChunk.
+func MemchunkFromCheckpoint(chk, head []byte, unordered bool, level, blockSize, targetSize int) (*MemChunk, error) {
+	c, err := NewByteChunk(chk, blockSize, targetSize)
+	if err != nil {
+		return nil, err
+	}
+	c.head.unordered = unordered
+	c.metadata = unordered
+	if c.format >= chunkFormatV2 {
+		c.writers = getWriterPoolLevel(c.encoding, level)
+	}
+
+	db := decbuf{b: head}
+	num := db.uvarint()
+	for i := 0; i < num; i++ {
+		ts := db.varint64()
+		line := db.uvarintBytes()
+		var metadata []logproto.LabelPair
+		if c.format >= chunkFormatV4 {
+			n := db.uvarint()
+			for j := 0; j < n && db.err() == nil; j++ {
+				metadata = append(metadata, logproto.LabelPair{
+					Name:  string(db.uvarintBytes()),
+					Value: string(db.uvarintBytes()),
+				})
+			}
+		}
+		if db.err() != nil {
+			return nil, errors.Wrap(db.err(), "decoding head block")
+		}
+		if err := c.head.append(ts, string(line), metadata); err != nil {
+			return nil, err
+		}
+	}
+	return c, db.err()
+}
+
 // Encoding implements Chunk.
 func (c *MemChunk) Encoding() Encoding {
 	return c.encoding
@@ -349,7 +514,7 @@ func (c *MemChunk) SpaceFor(e *logproto.Entry) bool {
 	if c.targetSize > 0 {
 		// This is looking to see if the uncompressed lines will fit which is not
 		// a great check, but it will guarantee we are always under the target size
-		newHBSize := c.head.size + len(e.Line)
+		newHBSize := c.head.size + len(e.Line) + logproto.MetadataSize(e.StructuredMetadata)
 		return (c.cutBlockSize + newHBSize) < c.targetSize
 	}
 	// if targetSize is not defined, default to the original behavior of fixed blocks per chunk
@@ -398,11 +563,21 @@ func (c *MemChunk) Append(entry *logproto.Entry) error {
 
 	// If the head block is empty but there are cut blocks, we have to make
 	// sure the new entry is not out of order compared to the previous block
-	if c.head.isEmpty() && len(c.blocks) > 0 && c.blocks[len(c.blocks)-1].maxt > entryTimestamp {
+	if !c.head.unordered && c.head.isEmpty() && len(c.blocks) > 0 && c.blocks[len(c.blocks)-1].maxt > entryTimestamp {
 		return ErrOutOfOrder
 	}
 
-	if err := c.head.append(entryTimestamp, entry.Line); err != nil {
+	// The structured metadata is dropped by the chunk formats before v4,
+	// unless the chunk keeps it.
+	metadata := entry.StructuredMetadata
+	if len(metadata) > 0 && c.format < chunkFormatV4 {
+		if !c.metadata {
+			metadata = nil
+		} else if err := c.convert(chunkFormatV4); err != nil {
+			return err
+		}
+	}
+	if err := c.head.append(entryTimestamp, entry.Line, metadata); err != nil {
 		return err
 	}
 
@@ -416,32 +591,105 @@ func (c *MemChunk) Append(entry *logproto.Entry) error {
 // Close implements Chunk.
 // TODO: Fix this to check edge cases.
 func (c *MemChunk) Close() error {
+	if err := c.cut(); err != nil {
+		return err
+	}
+	return c.reorder()
+}
+
+// reorder re-cuts the blocks of an unordered chunk when they overlap, so that
+// the entries of the chunk are in order from its first block to its last one
+// as expected by the readers. Must be called once the head block is cut.
+func (c *MemChunk) reorder() error {
+	overlapping := false
+	for i := 1; i < len(c.blocks); i++ {
+		if c.blocks[i].mint < c.blocks[i-1].maxt {
+			overlapping = true
+			break
+		}
+	}
+	if !overlapping {
+		return nil
+	}
+
+	var entries []entry
+	for _, b := range c.blocks {
+		be, err := b.entries(c.readers)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, be...)
+	}
+	// The entries of each block are sorted, those with the same timestamp keep
+	// the order of their blocks.
+	sortEntries(entries)
+
+	c.blocks = make([]block, 0, len(c.blocks))
+	c.cutBlockSize = 0
+	for _, e := range entries {
+		if err := c.head.append(e.t, e.s, e.metadata); err != nil {
+			return err
+		}
+		if c.head.size >= c.blockSize {
+			if err := c.cut(); err != nil {
+				return err
+			}
+		}
+	}
 	return c.cut()
 }
 
+// convert encodes the cut blocks and the next ones in the format.
+func (c *MemChunk) convert(format byte) error {
+	for i, b := range c.blocks {
+		entries, err := b.entries(c.readers)
+		if err != nil {
+			return err
+		}
+		hb := headBlock{entries: entries}
+		bb, err := hb.serialise(c.writers, format)
+		if err != nil {
+			return err
+		}
+		c.cutBlockSize += len(bb) - len(b.b)
+		c.blocks[i].b = bb
+		c.blocks[i].format = format
+	}
+	c.format = format
+	return nil
+}
+
 // cut a new block and add it to finished blocks.
 func (c *MemChunk) cut() error {
 	if c.head.isEmpty() {
 		return nil
 	}
 
-	b, err := c.head.serialise(c.writers)
+	b, err := c.head.serialise(c.writers, c.format)
 	if err != nil {
 		return err
 	}
 
+	var bloom []byte
+	if c.blooms {
+		bloom = newBloom(c.head.entries)
+	}
+
 	c.blocks = append(c.blocks, block{
 		b:                b,
 		numEntries:       len(c.head.entries),
 		mint:             c.head.mint,
 		maxt:             c.head.maxt,
 		uncompressedSize: c.head.size,
+		bloom:            bloom,
+		format:           c.format,
 	})
 
 	c.cutBlockSize += len(b)
 
 	c.head.entries = c.head.entries[:0]
 	c.head.mint = 0 // Will be set on first append.
+	c.head.maxt = 0
 	c.head.size = 0
 
 	return nil
@@ -450,9 +698,14 @@ func (c *MemChunk) cut() error {
 // Bounds implements Chunk.
 func (c *MemChunk) Bounds() (fromT, toT time.Time) {
 	var from, to int64
-	if len(c.blocks) > 0 {
-		from = c.blocks[0].mint
-		to = c.blocks[len(c.blocks)-1].maxt
+	// The blocks of unordered chunks can overlap.
+	for i, b := range c.blocks {
+		if i == 0 || from > b.mint {
+			from = b.mint
+		}
+		if to < b.maxt {
+			to = b.maxt
+		}
 	}
 
 	if !c.head.isEmpty() {
@@ -473,18 +726,33 @@ func (c *MemChunk) Iterator(ctx context.Context, mintT, maxtT time.Time, directi
 	mint, maxt := mintT.UnixNano(), maxtT.UnixNano()
 	its := make([]iter.EntryIterator, 0, len(c.blocks)+1)
 
+	var (
+		overlapping bool
+		lastMaxt    int64
+	)
+	literals := logql.RequiredLiterals(filter)
 	for _, b := range c.blocks {
-		if maxt > b.mint && b.maxt > mint {
+		if maxt > b.mint && b.maxt > mint && b.mayContain(literals) {
+			overlapping = overlapping || (len(its) > 0 && b.mint < lastMaxt)
+			lastMaxt = b.maxt
 			its = append(its, b.iterator(ctx, c.readers, filter))
 		}
 	}
 
 	if !c.head.isEmpty() {
+		overlapping = overlapping || (len(its) > 0 && c.head.mint < lastMaxt)
 		its = append(its, c.head.iterator(ctx, mint, maxt, filter))
 	}
 
+	var it iter.EntryIterator
+	if overlapping {
+		// The blocks of unordered chunks are merged to return their entries in order.
+		it = iter.NewHeapIterator(ctx, its, logproto.FORWARD)
+	} else {
+		it = iter.NewNonOverlappingIterator(its, "")
+	}
 	iterForward := iter.NewTimeRangedIterator(
-		iter.NewNonOverlappingIterator(its, ""),
+		it,
 		time.Unix(0, mint),
 		time.Unix(0, maxt),
 	)
@@ -496,11 +764,35 @@ func (c *MemChunk) Iterator(ctx context.Context, mintT, maxtT time.Time, directi
 	return iter.NewEntryReversedIter(iterForward)
 }
 
+// mayContain returns false when no line of the block contains all the literals.
+func (b block) mayContain(literals [][]byte) bool {
+	for _, l := range literals {
+		if !bloomMayContain(b.bloom, string(l)) {
+			return false
+		}
+	}
+	return true
+}
+
+// entries decodes the entries of the block.
+func (b block) entries(pool ReaderPool) ([]entry, error) {
+	entries := make([]entry, 0, b.numEntries)
+	it := b.iterator(context.Background(), pool, nil)
+	for it.Next() {
+		e := it.Entry()
+		entries = append(entries, entry{t: e.Timestamp.UnixNano(), s: e.Line, metadata: e.StructuredMetadata})
+	}
+	if err := it.Error(); err != nil {
+		return nil, errors.Wrap(err, "reading block")
+	}
+	return entries, it.Close()
+}
+
 func (b block) iterator(ctx context.Context, pool ReaderPool, filter logql.LineFilter) iter.EntryIterator {
 	if len(b.b) == 0 {
 		return emptyIterator
 	}
-	return newBufferedIterator(ctx, pool, b.b, filter)
+	return newBufferedIterator(ctx, pool, b.b, b.format, filter)
 }
 
 func (hb *headBlock) iterator(ctx context.Context, mint, maxt int64, filter logql.LineFilter) iter.EntryIterator {
@@ -526,6 +818,9 @@ func (hb *headBlock) iterator(ctx context.Context, mint, maxt int64, filter logq
 	if len(entries) == 0 {
 		return emptyIterator
 	}
+	if hb.unordered {
+		sortEntries(entries)
+	}
 
 	return &listIterator{
 		entries: entries,
@@ -554,8 +849,9 @@ func (li *listIterator) Entry() logproto.Entry {
 	cur := li.entries[li.cur]
 
 	return logproto.Entry{
-		Timestamp: time.Unix(0, cur.t),
-		Line:      cur.s,
+		Timestamp:          time.Unix(0, cur.t),
+		Line:               cur.s,
+		StructuredMetadata: cur.metadata,
 	}
 }
 
@@ -581,9 +877,12 @@ type bufferedIterator struct {
 	closed bool
 
 	filter logql.LineFilter
+	// format is the chunk format of the block, the entries have structured
+	// metadata from chunk format v4.
+	format byte
 }
 
-func newBufferedIterator(ctx context.Context, pool ReaderPool, b []byte, filter logql.LineFilter) *bufferedIterator {
+func newBufferedIterator(ctx context.Context, pool ReaderPool, b []byte, format byte, filter logql.LineFilter) *bufferedIterator {
 	chunkStats := stats.GetChunkData(ctx)
 	chunkStats.CompressedBytes += int64(len(b))
 	return &bufferedIterator{
@@ -593,6 +892,7 @@ func newBufferedIterator(ctx context.Context, pool ReaderPool, b []byte, filter
 		bufReader: nil, // will be initialized later
 		pool:      pool,
 		filter:    filter,
+		format:    format,
 		decBuf:    make([]byte, binary.MaxVarintLen64),
 	}
 }
@@ -613,11 +913,25 @@ func (si *bufferedIterator) Next() bool {
 		// we decode always the line length and ts as varint
 		si.stats.DecompressedBytes += int64(len(line)) + 2*binary.MaxVarintLen64
 		si.stats.DecompressedLines++
-		if si.filter != nil && !si.filter.Filter(line) {
+		// The line is copied before the buffer is reused for the metadata.
+		var matched bool
+		if si.filter == nil || si.filter.Filter(line) {
+			matched = true
+			si.cur.Line = string(line)
+		}
+		var metadata []logproto.LabelPair
+		if si.format >= chunkFormatV4 {
+			metadata, ok = si.readMetadata(matched)
+			if !ok {
+				si.Close()
+				return false
+			}
+		}
+		if !matched {
 			continue
 		}
-		si.cur.Line = string(line)
 		si.cur.Timestamp = time.Unix(0, ts)
+		si.cur.StructuredMetadata = metadata
 		return true
 	}
 }
@@ -632,18 +946,62 @@ func (si *bufferedIterator) moveNext() (int64, []byte, bool) {
 		return 0, nil, false
 	}
 
+	line, ok := si.readBytes()
+	if !ok {
+		return 0, nil, false
+	}
+	return ts, line, true
+}
+
+// readMetadata reads the structured metadata of the entry, which is only
+// decoded if keep is true.
+func (si *bufferedIterator) readMetadata(keep bool) ([]logproto.LabelPair, bool) {
+	n, err := binary.ReadUvarint(si.bufReader)
+	if err != nil {
+		si.err = err
+		return nil, false
+	}
+	var metadata []logproto.LabelPair
+	if keep && n > 0 {
+		metadata = make([]logproto.LabelPair, 0, n)
+	}
+	for i := uint64(0); i < n; i++ {
+		name, ok := si.readBytes()
+		if !ok {
+			return nil, false
+		}
+		var m logproto.LabelPair
+		if keep {
+			m.Name = string(name)
+		}
+		value, ok := si.readBytes()
+		if !ok {
+			return nil, false
+		}
+		si.stats.DecompressedBytes += int64(len(name) + len(value))
+		if keep {
+			m.Value = string(value)
+			metadata = append(metadata, m)
+		}
+	}
+	return metadata, true
+}
+
+// readBytes reads a length prefixed byte slice into the buffer, it's only valid
+// until the next read.
+func (si *bufferedIterator) readBytes() ([]byte, bool) {
 	l, err := binary.ReadUvarint(si.bufReader)
 	if err != nil {
 		if err != io.EOF {
 			si.err = err
-			return 0, nil, false
+			return nil, false
 		}
 	}
 	lineSize := int(l)
 
 	if lineSize >= maxLineLength {
 		si.err = fmt.Errorf("line too long %d, maximum %d", lineSize, maxLineLength)
-		return 0, nil, false
+		return nil, false
 	}
 	// If the buffer is not yet initialize or too small, we get a new one.
 	if si.buf == nil || lineSize > cap(si.buf) {
@@ -654,7 +1012,7 @@ func (si *bufferedIterator) moveNext() (int64, []byte, bool) {
 		si.buf = BytesBufferPool.Get(lineSize).([]byte)
 		if lineSize > cap(si.buf) {
 			si.err = fmt.Errorf("could not get a line buffer of size %d, actual %d", lineSize, cap(si.buf))
-			return 0, nil, false
+			return nil, false
 		}
 	}
 
@@ -662,17 +1020,17 @@ func (si *bufferedIterator) moveNext() (int64, []byte, bool) {
 	n, err := si.bufReader.Read(si.buf[:lineSize])
 	if err != nil && err != io.EOF {
 		si.err = err
-		return 0, nil, false
+		return nil, false
 	}
 	for n < lineSize {
 		r, err := si.bufReader.Read(si.buf[n:lineSize])
 		if err != nil {
 			si.err = err
-			return 0, nil, false
+			return nil, false
 		}
 		n += r
 	}
-	return ts, si.buf[:lineSize], true
+	return si.buf[:lineSize], true
 }
 
 func (si *bufferedIterator) Entry() logproto.Entry {
@@ -30,6 +30,8 @@ var testEncoding = []Encoding{
 	EncLZ4_1M,
 	EncLZ4_4M,
 	EncSnappy,
+	EncFlate,
+	EncZstd,
 }
 
 var (
@@ -257,6 +259,47 @@ func TestSerialization(t *testing.T) {
 	}
 }
 
+func TestCheckpointSerialization(t *testing.T) {
+	for _, enc := range testEncoding {
+		t.Run(enc.String(), func(t *testing.T) {
+			chk := NewMemChunk(enc, testBlockSize, testTargetSize)
+
+			numSamples := 50000
+			for i := 0; i < numSamples; i++ {
+				require.NoError(t, chk.Append(logprotoEntry(int64(i), fmt.Sprintf("line %d", i))))
+			}
+			blocks := chk.Blocks()
+			require.False(t, chk.head.isEmpty())
+
+			byt, head, err := chk.SerializeForCheckpoint()
+			require.NoError(t, err)
+			// the head block is not cut.
+			require.Equal(t, blocks, chk.Blocks())
+
+			bc, err := MemchunkFromCheckpoint(byt, head, false, 0, testBlockSize, testTargetSize)
+			require.NoError(t, err)
+			require.Equal(t, chk.Blocks(), bc.Blocks())
+			require.Equal(t, len(chk.head.entries), len(bc.head.entries))
+
+			// the restored chunk can still be appended to.
+			require.NoError(t, bc.Append(logprotoEntry(int64(numSamples), fmt.Sprintf("line %d", numSamples))))
+			require.Equal(t, ErrOutOfOrder, bc.Append(logprotoEntry(0, "out of order")))
+
+			it, err := bc.Iterator(context.Background(), time.Unix(0, 0), time.Unix(0, math.MaxInt64), logproto.FORWARD, nil)
+			require.NoError(t, err)
+			for i := 0; i <= numSamples; i++ {
+				require.True(t, it.Next())
+
+				e := it.Entry()
+				require.Equal(t, int64(i), e.Timestamp.UnixNano())
+				require.Equal(t, fmt.Sprintf("line %d", i), e.Line)
+			}
+			require.False(t, it.Next())
+			require.NoError(t, it.Error())
+		})
+	}
+}
+
 func TestChunkFilling(t *testing.T) {
 	for _, enc := range testEncoding {
 		t.Run(enc.String(), func(t *testing.T) {
@@ -387,6 +430,111 @@ func TestMemChunk_AppendOutOfOrder(t *testing.T) {
 	}
 }
 
+func TestUnorderedMemChunk(t *testing.T) {
+	for _, enc := range testEncoding {
+		t.Run(enc.String(), func(t *testing.T) {
+			chk := NewUnorderedMemChunk(enc, 0, false, testBlockSize, testTargetSize)
+
+			// every block overlaps the previous ones.
+			for _, ts := range []int64{5, 3, 9, 1} {
+				require.NoError(t, chk.Append(logprotoEntry(ts, fmt.Sprintf("line %d", ts))))
+			}
+			require.NoError(t, chk.cut())
+			for _, ts := range []int64{8, 2, 6} {
+				require.NoError(t, chk.Append(logprotoEntry(ts, fmt.Sprintf("line %d", ts))))
+			}
+			require.NoError(t, chk.cut())
+			for _, ts := range []int64{7, 4} {
+				require.NoError(t, chk.Append(logprotoEntry(ts, fmt.Sprintf("line %d", ts))))
+			}
+
+			from, to := chk.Bounds()
+			require.Equal(t, int64(1), from.UnixNano())
+			require.Equal(t, int64(9), to.UnixNano())
+
+			// the chunk is read in order, before and after cutting the head block
+			// and from its bytes.
+			check := func(c Chunk) {
+				for _, direction := range []logproto.Direction{logproto.FORWARD, logproto.BACKWARD} {
+					it, err := c.Iterator(context.Background(), time.Unix(0, 0), time.Unix(0, 10), direction, nil)
+					require.NoError(t, err)
+					for i := int64(1); i <= 9; i++ {
+						ts := i
+						if direction == logproto.BACKWARD {
+							ts = 10 - i
+						}
+						require.True(t, it.Next())
+						require.Equal(t, ts, it.Entry().Timestamp.UnixNano())
+						require.Equal(t, fmt.Sprintf("line %d", ts), it.Entry().Line)
+					}
+					require.False(t, it.Next())
+					require.NoError(t, it.Error())
+					require.NoError(t, it.Close())
+				}
+			}
+			check(chk)
+
+			b, err := chk.Bytes()
+			require.NoError(t, err)
+			check(chk)
+
+			bc, err := NewByteChunk(b, testBlockSize, testTargetSize)
+			require.NoError(t, err)
+			check(bc)
+
+			// the blocks are written in order, for the readers iterating them one
+			// after the other and taking the bounds from the first and last ones.
+			var prev int64
+			for _, blk := range bc.blocks {
+				it := blk.iterator(context.Background(), bc.readers, nil)
+				for it.Next() {
+					require.LessOrEqual(t, prev, it.Entry().Timestamp.UnixNano())
+					prev = it.Entry().Timestamp.UnixNano()
+				}
+				require.NoError(t, it.Close())
+			}
+			require.Equal(t, int64(9), prev)
+			require.Equal(t, int64(1), bc.blocks[0].mint)
+			require.Equal(t, int64(9), bc.blocks[len(bc.blocks)-1].maxt)
+		})
+	}
+}
+
+func TestZstdLevels(t *testing.T) {
+	for _, level := range []int{0, 1, 3, 19} {
+		t.Run(fmt.Sprintf("%d", level), func(t *testing.T) {
+			chk := NewUnorderedMemChunk(EncZstd, level, false, testBlockSize, testTargetSize)
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, chk.Append(logprotoEntry(int64(i), fmt.Sprintf("line %d", i))))
+			}
+
+			// a chunk recovered from a checkpoint keeps compressing with the level.
+			cb, head, err := chk.SerializeForCheckpoint()
+			require.NoError(t, err)
+			cc, err := MemchunkFromCheckpoint(cb, head, true, level, testBlockSize, testTargetSize)
+			require.NoError(t, err)
+			require.Same(t, chk.writers, cc.writers)
+
+			b, err := chk.Bytes()
+			require.NoError(t, err)
+
+			// the level is not needed to read the chunk.
+			bc, err := NewByteChunk(b, testBlockSize, testTargetSize)
+			require.NoError(t, err)
+			require.Equal(t, EncZstd, bc.Encoding())
+
+			it, err := bc.Iterator(context.Background(), time.Unix(0, 0), time.Unix(0, math.MaxInt64), logproto.FORWARD, nil)
+			require.NoError(t, err)
+			for i := 0; i < 1000; i++ {
+				require.True(t, it.Next())
+				require.Equal(t, fmt.Sprintf("line %d", i), it.Entry().Line)
+			}
+			require.False(t, it.Next())
+			require.NoError(t, it.Error())
+		})
+	}
+}
+
 func TestChunkSize(t *testing.T) {
 	for _, enc := range testEncoding {
 		t.Run(enc.String(), func(t *testing.T) {
@@ -472,6 +620,167 @@ func TestChunkStats(t *testing.T) {
 	require.Equal(t, int64(inserted), s.Store.DecompressedLines)
 }
 
+func TestBlockBloomFilters(t *testing.T) {
+	expr, err := logql.ParseLogSelector(`{app="foo"} |= "trace=00000250"`)
+	require.NoError(t, err)
+	filter, err := expr.Filter()
+	require.NoError(t, err)
+
+	for _, blooms := range []bool{true, false} {
+		t.Run(fmt.Sprintf("blooms=%t", blooms), func(t *testing.T) {
+			chk := NewUnorderedMemChunk(EncSnappy, 0, blooms, testBlockSize, testTargetSize)
+			for i := 0; i < 500; i++ {
+				require.NoError(t, chk.Append(logprotoEntry(int64(i), fmt.Sprintf("msg=hello trace=%08d", i))))
+				if i%100 == 99 {
+					require.NoError(t, chk.cut())
+				}
+			}
+			b, err := chk.Bytes()
+			require.NoError(t, err)
+			bc, err := NewByteChunk(b, testBlockSize, testTargetSize)
+			require.NoError(t, err)
+
+			for _, c := range []*MemChunk{chk, bc} {
+				ctx := stats.NewContext(context.Background())
+				it, err := c.Iterator(ctx, time.Unix(0, 0), time.Unix(0, 500), logproto.FORWARD, filter)
+				require.NoError(t, err)
+				require.True(t, it.Next())
+				require.Equal(t, "msg=hello trace=00000250", it.Entry().Line)
+				require.False(t, it.Next())
+				require.NoError(t, it.Close())
+
+				// only the block containing the line is decompressed with the blooms.
+				expected := int64(500)
+				if blooms {
+					expected = 100
+				}
+				require.Equal(t, expected, stats.Snapshot(ctx, 0).Store.DecompressedLines)
+			}
+		})
+	}
+}
+
+func TestStructuredMetadata(t *testing.T) {
+	expr, err := logql.ParseLogSelector(`{app="foo"} |= "line 1"`)
+	require.NoError(t, err)
+	filter, err := expr.Filter()
+	require.NoError(t, err)
+
+	metadata := func(i int) []logproto.LabelPair {
+		if i%3 == 0 {
+			return nil
+		}
+		return []logproto.LabelPair{
+			{Name: "trace_id", Value: fmt.Sprintf("%04x", i)},
+			{Name: "pod_uid", Value: fmt.Sprintf("pod-%d", i%2)},
+		}
+	}
+	entry := func(i int) *logproto.Entry {
+		return &logproto.Entry{
+			Timestamp:          time.Unix(0, int64(i)),
+			Line:               fmt.Sprintf("line %d", i),
+			StructuredMetadata: metadata(i),
+		}
+	}
+
+	chk := NewUnorderedMemChunk(EncSnappy, 0, false, testBlockSize, testTargetSize)
+	for i := 0; i < 200; i++ {
+		require.NoError(t, chk.Append(entry(i)))
+		if i%50 == 49 {
+			require.NoError(t, chk.cut())
+		}
+	}
+
+	b, head, err := chk.SerializeForCheckpoint()
+	require.NoError(t, err)
+	cc, err := MemchunkFromCheckpoint(b, head, true, 0, testBlockSize, testTargetSize)
+	require.NoError(t, err)
+	b, err = chk.Bytes()
+	require.NoError(t, err)
+	bc, err := NewByteChunk(b, testBlockSize, testTargetSize)
+	require.NoError(t, err)
+
+	for _, c := range []*MemChunk{chk, cc, bc} {
+		it, err := c.Iterator(context.Background(), time.Unix(0, 0), time.Unix(0, 200), logproto.FORWARD, nil)
+		require.NoError(t, err)
+		for i := 0; i < 200; i++ {
+			require.True(t, it.Next())
+			require.Equal(t, *entry(i), it.Entry())
+		}
+		require.False(t, it.Next())
+		require.NoError(t, it.Close())
+
+		// the metadata of the entries skipped by the filter is skipped too.
+		it, err = c.Iterator(context.Background(), time.Unix(0, 0), time.Unix(0, 200), logproto.FORWARD, filter)
+		require.NoError(t, err)
+		var lines []string
+		for it.Next() {
+			e := it.Entry()
+			lines = append(lines, e.Line)
+			var i int
+			_, err := fmt.Sscanf(e.Line, "line %d", &i)
+			require.NoError(t, err)
+			require.Equal(t, metadata(i), e.StructuredMetadata)
+		}
+		require.NoError(t, it.Close())
+		require.Len(t, lines, 111)
+	}
+
+	// the chunk formats before v4 drop the metadata.
+	chk = NewMemChunk(EncSnappy, testBlockSize, testTargetSize)
+	require.NoError(t, chk.Append(entry(1)))
+	it, err := chk.Iterator(context.Background(), time.Unix(0, 0), time.Unix(0, 2), logproto.FORWARD, nil)
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	require.Nil(t, it.Entry().StructuredMetadata)
+}
+
+func TestUnorderedMemChunkFormat(t *testing.T) {
+	for _, tc := range []struct {
+		blooms   bool
+		metadata bool
+		format   byte
+	}{
+		{blooms: false, metadata: false, format: chunkFormatV2},
+		{blooms: true, metadata: false, format: chunkFormatV3},
+		{blooms: false, metadata: true, format: chunkFormatV4},
+		{blooms: true, metadata: true, format: chunkFormatV4},
+	} {
+		t.Run(fmt.Sprintf("blooms=%t,metadata=%t", tc.blooms, tc.metadata), func(t *testing.T) {
+			entry := func(i int) *logproto.Entry {
+				e := &logproto.Entry{Timestamp: time.Unix(0, int64(i)), Line: fmt.Sprintf("line %d", i)}
+				// the chunk is converted once blocks were cut.
+				if tc.metadata && i >= 150 {
+					e.StructuredMetadata = []logproto.LabelPair{{Name: "trace_id", Value: fmt.Sprintf("%04x", i)}}
+				}
+				return e
+			}
+
+			chk := NewUnorderedMemChunk(EncSnappy, 0, tc.blooms, testBlockSize, testTargetSize)
+			for i := 0; i < 200; i++ {
+				require.NoError(t, chk.Append(entry(i)))
+				if i%50 == 49 {
+					require.NoError(t, chk.cut())
+				}
+			}
+			b, err := chk.Bytes()
+			require.NoError(t, err)
+			require.Equal(t, tc.format, b[4])
+
+			bc, err := NewByteChunk(b, testBlockSize, testTargetSize)
+			require.NoError(t, err)
+			it, err := bc.Iterator(context.Background(), time.Unix(0, 0), time.Unix(0, 200), logproto.FORWARD, nil)
+			require.NoError(t, err)
+			for i := 0; i < 200; i++ {
+				require.True(t, it.Next())
+				require.Equal(t, *entry(i), it.Entry())
+			}
+			require.False(t, it.Next())
+			require.NoError(t, it.Close())
+		})
+	}
+}
+
 func TestIteratorClose(t *testing.T) {
 	for _, enc := range testEncoding {
 		t.Run(enc.String(), func(t *testing.T) {
@@ -627,7 +936,7 @@ func BenchmarkHeadBlockIterator(b *testing.B) {
 			h := headBlock{}
 
 			for i := 0; i < j; i++ {
-				if err := h.append(int64(i), "this is the append string"); err != nil {
+				if err := h.append(int64(i), "this is the append string", nil); err != nil {
 					b.Fatal(err)
 				}
 			}
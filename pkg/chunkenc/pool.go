@@ -4,10 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"io/ioutil"
 	"sync"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4"
 	"github.com/prometheus/prometheus/pkg/pool"
 )
@@ -35,6 +38,10 @@ var (
 
 	// Snappy is the snappy compression pool
 	Snappy SnappyPool
+	// Flate is the flate compression pool
+	Flate = FlatePool{level: flate.DefaultCompression}
+	// Zstd is the zstd compression pool, with the default level
+	Zstd = ZstdPool{level: zstd.SpeedDefault}
 	// Noop is the no compression pool
 	Noop NoopPool
 
@@ -58,6 +65,36 @@ func getWriterPool(enc Encoding) WriterPool {
 	return getReaderPool(enc).(WriterPool)
 }
 
+// zstdPools holds the zstd compression pools of the other levels than the
+// default one, by level.
+var (
+	zstdPoolsMtx sync.Mutex
+	zstdPools    = map[zstd.EncoderLevel]*ZstdPool{}
+)
+
+// getWriterPoolLevel returns the pool of the encoding compressing with the
+// level, 0 being the default level of the encoding. Only zstd supports other
+// levels than the default one.
+func getWriterPoolLevel(enc Encoding, level int) WriterPool {
+	if enc != EncZstd || level == 0 {
+		return getWriterPool(enc)
+	}
+
+	l := zstd.EncoderLevelFromZstd(level)
+	if l == Zstd.level {
+		return &Zstd
+	}
+
+	zstdPoolsMtx.Lock()
+	defer zstdPoolsMtx.Unlock()
+	pool, ok := zstdPools[l]
+	if !ok {
+		pool = &ZstdPool{level: l}
+		zstdPools[l] = pool
+	}
+	return pool
+}
+
 func getReaderPool(enc Encoding) ReaderPool {
 	switch enc {
 	case EncGZIP:
@@ -72,6 +109,10 @@ func getReaderPool(enc Encoding) ReaderPool {
 		return &Lz4_4M
 	case EncSnappy:
 		return &Snappy
+	case EncFlate:
+		return &Flate
+	case EncZstd:
+		return &Zstd
 	case EncNone:
 		return &Noop
 	default:
@@ -245,6 +286,119 @@ func (pool *SnappyPool) PutWriter(writer io.WriteCloser) {
 	pool.writers.Put(writer)
 }
 
+type FlatePool struct {
+	readers sync.Pool
+	writers sync.Pool
+	level   int
+}
+
+// GetReader gets or creates a new CompressionReader and reset it to read from src
+func (pool *FlatePool) GetReader(src io.Reader) io.Reader {
+	if r := pool.readers.Get(); r != nil {
+		reader := r.(flate.Resetter)
+		err := reader.Reset(src, nil)
+		if err != nil {
+			panic(err)
+		}
+		return reader.(io.Reader)
+	}
+	return flate.NewReader(src)
+}
+
+// PutReader places back in the pool a CompressionReader
+func (pool *FlatePool) PutReader(reader io.Reader) {
+	pool.readers.Put(reader)
+}
+
+// GetWriter gets or creates a new CompressionWriter and reset it to write to dst
+func (pool *FlatePool) GetWriter(dst io.Writer) io.WriteCloser {
+	if w := pool.writers.Get(); w != nil {
+		writer := w.(*flate.Writer)
+		writer.Reset(dst)
+		return writer
+	}
+
+	w, err := flate.NewWriter(dst, pool.level)
+	if err != nil {
+		panic(err) // never happens, error is only returned on wrong compression level.
+	}
+	return w
+}
+
+// PutWriter places back in the pool a CompressionWriter
+func (pool *FlatePool) PutWriter(writer io.WriteCloser) {
+	pool.writers.Put(writer)
+}
+
+type ZstdPool struct {
+	readers sync.Pool
+	writers sync.Pool
+	level   zstd.EncoderLevel
+}
+
+// zstdDecoder decodes the zstd readers. Its stateless decodes don't run the
+// goroutines of the streaming ones, which would leak from the pool.
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// zstdReader reads the whole decoded stream of a zstd reader.
+type zstdReader struct {
+	*bytes.Reader
+	buf []byte
+	err error
+}
+
+func (r *zstdReader) reset(src io.Reader) {
+	r.buf, r.err = r.buf[:0], nil
+	b, err := ioutil.ReadAll(src)
+	if err == nil {
+		r.buf, err = zstdDecoder.DecodeAll(b, r.buf)
+	}
+	r.err = err
+	r.Reader.Reset(r.buf)
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.Reader.Read(p)
+}
+
+// GetReader gets or creates a new CompressionReader and reset it to read from src
+func (pool *ZstdPool) GetReader(src io.Reader) io.Reader {
+	reader, ok := pool.readers.Get().(*zstdReader)
+	if !ok {
+		reader = &zstdReader{Reader: bytes.NewReader(nil)}
+	}
+	reader.reset(src)
+	return reader
+}
+
+// PutReader places back in the pool a CompressionReader
+func (pool *ZstdPool) PutReader(reader io.Reader) {
+	pool.readers.Put(reader)
+}
+
+// GetWriter gets or creates a new CompressionWriter and reset it to write to dst
+func (pool *ZstdPool) GetWriter(dst io.Writer) io.WriteCloser {
+	if w := pool.writers.Get(); w != nil {
+		writer := w.(*zstd.Encoder)
+		writer.Reset(dst)
+		return writer
+	}
+
+	w, err := zstd.NewWriter(dst, zstd.WithEncoderLevel(pool.level), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(err) // never happens, error is only returned on wrong options.
+	}
+	return w
+}
+
+// PutWriter places back in the pool a CompressionWriter
+func (pool *ZstdPool) PutWriter(writer io.WriteCloser) {
+	pool.writers.Put(writer)
+}
+
 type NoopPool struct{}
 
 // GetReader gets or creates a new CompressionReader and reset it to read from src
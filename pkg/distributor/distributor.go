@@ -188,7 +188,7 @@ func (d *Distributor) Push(ctx context.Context, req *logproto.PushRequest) (*log
 	lineCount := 0
 	for _, stream := range req.Streams {
 		for _, entry := range stream.Entries {
-			bytesCount += len(entry.Line)
+			bytesCount += entrySize(entry)
 			lineCount++
 		}
 	}
@@ -217,7 +217,7 @@ func (d *Distributor) Push(ctx context.Context, req *logproto.PushRequest) (*log
 				continue
 			}
 			entries = append(entries, entry)
-			validatedSamplesSize += len(entry.Line)
+			validatedSamplesSize += entrySize(entry)
 			validatedSamplesCount++
 		}
 
@@ -48,6 +48,7 @@ func TestDistributor(t *testing.T) {
 		lines            int
 		maxLineSize      uint64
 		mangleLabels     bool
+		metadata         []logproto.LabelPair
 		expectedResponse *logproto.PushResponse
 		expectedError    error
 	}{
@@ -71,6 +72,25 @@ func TestDistributor(t *testing.T) {
 			expectedResponse: success,
 			expectedError:    httpgrpc.Errorf(http.StatusBadRequest, "error parsing labels: parse error at line 1, col 4: literal not terminated"),
 		},
+		{
+			// the structured metadata counts in the rate limit.
+			lines:         5,
+			metadata:      []logproto.LabelPair{{Name: "trace_id", Value: "0123456789"}},
+			expectedError: httpgrpc.Errorf(http.StatusTooManyRequests, validation.RateLimitedErrorMsg(100, 5, 140)),
+		},
+		{
+			lines:            1,
+			maxLineSize:      20,
+			metadata:         []logproto.LabelPair{{Name: "trace_id", Value: "0123456789"}},
+			expectedResponse: success,
+			expectedError:    httpgrpc.Errorf(http.StatusBadRequest, validation.LineTooLongErrorMsg(20, 28, "{foo=\"bar\"}")),
+		},
+		{
+			lines:            1,
+			metadata:         []logproto.LabelPair{{Name: "trace-id", Value: "0123456789"}},
+			expectedResponse: success,
+			expectedError:    httpgrpc.Errorf(http.StatusBadRequest, validation.InvalidMetadataNameErrorMsg("{foo=\"bar\"}", "trace-id")),
+		},
 	} {
 		t.Run(fmt.Sprintf("[%d](samples=%v)", i, tc.lines), func(t *testing.T) {
 			limits := &validation.Limits{}
@@ -88,6 +108,9 @@ func TestDistributor(t *testing.T) {
 			if tc.mangleLabels {
 				request.Streams[0].Labels = `{ab"`
 			}
+			for j := range request.Streams[0].Entries {
+				request.Streams[0].Entries[j].StructuredMetadata = tc.metadata
+			}
 
 			response, err := d.Push(ctx, request)
 			assert.Equal(t, tc.expectedResponse, response)
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	cortex_client "github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/prometheus/common/model"
 	"github.com/weaveworks/common/httpgrpc"
 
 	"github.com/grafana/loki/pkg/logproto"
@@ -27,31 +28,47 @@ func NewValidator(l Limits) (*Validator, error) {
 
 // ValidateEntry returns an error if the entry is invalid
 func (v Validator) ValidateEntry(userID string, labels string, entry logproto.Entry) error {
+	size := entrySize(entry)
 	if v.RejectOldSamples(userID) && entry.Timestamp.UnixNano() < time.Now().Add(-v.RejectOldSamplesMaxAge(userID)).UnixNano() {
 		validation.DiscardedSamples.WithLabelValues(validation.GreaterThanMaxSampleAge, userID).Inc()
-		validation.DiscardedBytes.WithLabelValues(validation.GreaterThanMaxSampleAge, userID).Add(float64(len(entry.Line)))
+		validation.DiscardedBytes.WithLabelValues(validation.GreaterThanMaxSampleAge, userID).Add(float64(size))
 		return httpgrpc.Errorf(http.StatusBadRequest, validation.GreaterThanMaxSampleAgeErrorMsg(labels, entry.Timestamp))
 	}
 
 	if entry.Timestamp.UnixNano() > time.Now().Add(v.CreationGracePeriod(userID)).UnixNano() {
 		validation.DiscardedSamples.WithLabelValues(validation.TooFarInFuture, userID).Inc()
-		validation.DiscardedBytes.WithLabelValues(validation.TooFarInFuture, userID).Add(float64(len(entry.Line)))
+		validation.DiscardedBytes.WithLabelValues(validation.TooFarInFuture, userID).Add(float64(size))
 		return httpgrpc.Errorf(http.StatusBadRequest, validation.TooFarInFutureErrorMsg(labels, entry.Timestamp))
 	}
 
-	if maxSize := v.MaxLineSize(userID); maxSize != 0 && len(entry.Line) > maxSize {
+	// The structured metadata is stored with the line.
+	if maxSize := v.MaxLineSize(userID); maxSize != 0 && size > maxSize {
 		// I wish we didn't return httpgrpc errors here as it seems
 		// an orthogonal concept (we need not use ValidateLabels in this context)
 		// but the upstream cortex_validation pkg uses it, so we keep this
 		// for parity.
 		validation.DiscardedSamples.WithLabelValues(validation.LineTooLong, userID).Inc()
-		validation.DiscardedBytes.WithLabelValues(validation.LineTooLong, userID).Add(float64(len(entry.Line)))
-		return httpgrpc.Errorf(http.StatusBadRequest, validation.LineTooLongErrorMsg(maxSize, len(entry.Line), labels))
+		validation.DiscardedBytes.WithLabelValues(validation.LineTooLong, userID).Add(float64(size))
+		return httpgrpc.Errorf(http.StatusBadRequest, validation.LineTooLongErrorMsg(maxSize, size, labels))
+	}
+
+	for _, m := range entry.StructuredMetadata {
+		if !model.LabelName(m.Name).IsValid() {
+			validation.DiscardedSamples.WithLabelValues(validation.InvalidMetadataName, userID).Inc()
+			validation.DiscardedBytes.WithLabelValues(validation.InvalidMetadataName, userID).Add(float64(size))
+			return httpgrpc.Errorf(http.StatusBadRequest, validation.InvalidMetadataNameErrorMsg(labels, m.Name))
+		}
 	}
 
 	return nil
 }
 
+// entrySize returns the number of bytes of the line and structured metadata
+// of an entry.
+func entrySize(entry logproto.Entry) int {
+	return len(entry.Line) + logproto.MetadataSize(entry.StructuredMetadata)
+}
+
 // Validate labels returns an error if the labels are invalid
 func (v Validator) ValidateLabels(userID string, stream logproto.Stream) error {
 	ls, err := util.ToClientLabels(stream.Labels)
@@ -68,7 +85,7 @@ func (v Validator) ValidateLabels(userID string, stream logproto.Stream) error {
 		validation.DiscardedSamples.WithLabelValues(validation.MaxLabelNamesPerSeries, userID).Inc()
 		bytes := 0
 		for _, e := range stream.Entries {
-			bytes += len(e.Line)
+			bytes += entrySize(e)
 		}
 		validation.DiscardedBytes.WithLabelValues(validation.MaxLabelNamesPerSeries, userID).Add(float64(bytes))
 		return httpgrpc.Errorf(http.StatusBadRequest, validation.MaxLabelNamesPerSeriesErrorMsg(cortex_client.FromLabelAdaptersToMetric(ls).String(), numLabelNames, v.MaxLabelNamesPerSeries(userID)))
@@ -97,7 +114,7 @@ func updateMetrics(reason, userID string, stream logproto.Stream) {
 	validation.DiscardedSamples.WithLabelValues(reason, userID).Inc()
 	bytes := 0
 	for _, e := range stream.Entries {
-		bytes += len(e.Line)
+		bytes += entrySize(e)
 	}
 	validation.DiscardedBytes.WithLabelValues(reason, userID).Add(float64(bytes))
 }
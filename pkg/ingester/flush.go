@@ -249,6 +249,10 @@ func (i *Ingester) collectChunksToFlush(instance *instance, fp model.Fingerprint
 		if immediate || shouldFlush {
 			// Ensure no more writes happen to this chunk.
 			if !stream.chunks[j].closed {
+				// Closing the chunk puts the entries of its blocks in order.
+				if err := stream.chunks[j].chunk.Close(); err != nil {
+					level.Error(util.Logger).Log("msg", "failed to Close chunk", "err", err)
+				}
 				stream.chunks[j].closed = true
 			}
 			// Flush this chunk if it hasn't already been successfully flushed.
@@ -166,11 +166,15 @@ type testStore struct {
 }
 
 func newTestStore(t require.TestingT, cfg Config) (*testStore, *Ingester) {
+	return newTestStoreWithLimits(t, cfg, defaultLimitsTestConfig())
+}
+
+func newTestStoreWithLimits(t require.TestingT, cfg Config, limitsCfg validation.Limits) (*testStore, *Ingester) {
 	store := &testStore{
 		chunks: map[string][]chunk.Chunk{},
 	}
 
-	limits, err := validation.NewOverrides(defaultLimitsTestConfig(), nil)
+	limits, err := validation.NewOverrides(limitsCfg, nil)
 	require.NoError(t, err)
 
 	ing, err := New(cfg, client.Config{}, store, limits)
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
@@ -54,6 +55,8 @@ type Config struct {
 	BlockSize         int           `yaml:"chunk_block_size"`
 	TargetChunkSize   int           `yaml:"chunk_target_size"`
 	ChunkEncoding     string        `yaml:"chunk_encoding"`
+	ChunkZstdLevel    int           `yaml:"chunk_zstd_level"`
+	ChunkBlockBlooms  bool          `yaml:"chunk_block_bloom_filters"`
 	MaxChunkAge       time.Duration `yaml:"max_chunk_age"`
 
 	// Synchronization settings. Used to make sure that ingesters cut their chunks at the same moments.
@@ -62,6 +65,8 @@ type Config struct {
 
 	MaxReturnedErrors int `yaml:"max_returned_stream_errors"`
 
+	WAL WALConfig `yaml:"wal,omitempty"`
+
 	// For testing, you can override the address and ID of this ingester.
 	ingesterClientFactory func(cfg client.Config, addr string) (client.HealthAndIngesterClient, error)
 
@@ -82,11 +87,26 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&cfg.BlockSize, "ingester.chunks-block-size", 256*1024, "")
 	f.IntVar(&cfg.TargetChunkSize, "ingester.chunk-target-size", 0, "")
 	f.StringVar(&cfg.ChunkEncoding, "ingester.chunk-encoding", chunkenc.EncGZIP.String(), fmt.Sprintf("The algorithm to use for compressing chunk. (%s)", chunkenc.SupportedEncoding()))
+	f.IntVar(&cfg.ChunkZstdLevel, "ingester.chunk-zstd-level", 0, "The compression level of the chunks compressed with zstd, from 1 (fastest) to 22 (best compression). 0 for the default level.")
+	f.BoolVar(&cfg.ChunkBlockBlooms, "ingester.chunk-block-bloom-filters", false, "Write a bloom filter of the lines of each block of the chunks, for the queries filtering lines to skip the blocks not containing their filters. The chunks with bloom filters can't be read by the versions not supporting them.")
 	f.DurationVar(&cfg.SyncPeriod, "ingester.sync-period", 0, "How often to cut chunks to synchronize ingesters.")
 	f.Float64Var(&cfg.SyncMinUtilization, "ingester.sync-min-utilization", 0, "Minimum utilization of chunk when doing synchronization.")
 	f.IntVar(&cfg.MaxReturnedErrors, "ingester.max-ignored-stream-errors", 10, "Maximum number of ignored stream errors to return. 0 to return all errors.")
 	f.DurationVar(&cfg.MaxChunkAge, "ingester.max-chunk-age", time.Hour, "Maximum chunk age before flushing.")
 	f.DurationVar(&cfg.QueryStoreMaxLookBackPeriod, "ingester.query-store-max-look-back-period", 0, "How far back should an ingester be allowed to query the store for data, for use only with boltdb-shipper index and filesystem object store. -1 for infinite.")
+
+	cfg.WAL.RegisterFlags(f)
+}
+
+// Validate the config and returns an error if the validation
+// doesn't pass
+func (cfg *Config) Validate() error {
+	// The chunks recovered from the WAL would be duplicated with the
+	// transferred ones.
+	if cfg.WAL.Enabled && cfg.MaxTransferRetries > 0 {
+		return errors.New("chunk transfers must be disabled (-ingester.max-transfer-retries=0) when the WAL is enabled")
+	}
+	return nil
 }
 
 // Ingester builds chunks for incoming log streams.
@@ -116,7 +136,11 @@ type Ingester struct {
 	flushQueuesDone sync.WaitGroup
 
 	limiter *Limiter
-	factory func() chunkenc.Chunk
+
+	// The chunk encoding of the tenants not overriding it.
+	encoding chunkenc.Encoding
+
+	wal WAL
 }
 
 // ChunkStore is the interface we need to store chunks.
@@ -143,12 +167,17 @@ func New(cfg Config, clientConfig client.Config, store ChunkStore, limits *valid
 		loopQuit:     make(chan struct{}),
 		flushQueues:  make([]*util.PriorityQueue, cfg.ConcurrentFlushes),
 		tailersQuit:  make(chan struct{}),
-		factory: func() chunkenc.Chunk {
-			return chunkenc.NewMemChunk(enc, cfg.BlockSize, cfg.TargetChunkSize)
-		},
+		encoding:     enc,
+	}
+
+	i.wal, err = newWAL(cfg.WAL, i)
+	if err != nil {
+		return nil, err
 	}
 
-	i.lifecycler, err = ring.NewLifecycler(cfg.LifecyclerConfig, i, "ingester", ring.IngesterRingKey, true)
+	// With the WAL, the chunks are recovered on restart rather than flushed.
+	flushOnShutdown := !cfg.WAL.Enabled || cfg.WAL.FlushOnShutdown
+	i.lifecycler, err = ring.NewLifecycler(cfg.LifecyclerConfig, i, "ingester", ring.IngesterRingKey, flushOnShutdown)
 	if err != nil {
 		return nil, err
 	}
@@ -165,6 +194,12 @@ func New(cfg Config, clientConfig client.Config, store ChunkStore, limits *valid
 }
 
 func (i *Ingester) starting(ctx context.Context) error {
+	// Recover the chunks before joining the ring, the ingester becomes ACTIVE
+	// with all its data.
+	if err := i.wal.Start(); err != nil {
+		return err
+	}
+
 	i.flushQueuesDone.Add(i.cfg.ConcurrentFlushes)
 	for j := 0; j < i.cfg.ConcurrentFlushes; j++ {
 		i.flushQueues[j] = util.NewPriorityQueue(flushQueueLength)
@@ -223,6 +258,10 @@ func (i *Ingester) stopping(_ error) error {
 	}
 	i.flushQueuesDone.Wait()
 
+	// Checkpoint what wasn't flushed, after the flushes are done.
+	if walErr := i.wal.Stop(); err == nil {
+		err = walErr
+	}
 	return err
 }
 
@@ -267,12 +306,35 @@ func (i *Ingester) getOrCreateInstance(instanceID string) *instance {
 	defer i.instancesMtx.Unlock()
 	inst, ok = i.instances[instanceID]
 	if !ok {
-		inst = newInstance(&i.cfg, instanceID, i.factory, i.limiter, i.cfg.SyncPeriod, i.cfg.SyncMinUtilization)
+		inst = newInstance(&i.cfg, instanceID, i.chunkFactory(instanceID), i.limiter, i.wal, i.cfg.SyncPeriod, i.cfg.SyncMinUtilization)
 		i.instances[instanceID] = inst
 	}
 	return inst
 }
 
+// chunkFactory returns the factory of the chunks of a tenant.
+func (i *Ingester) chunkFactory(userID string) func() chunkenc.Chunk {
+	return func() chunkenc.Chunk {
+		// The streams enforce the ordering of their entries.
+		return chunkenc.NewUnorderedMemChunk(i.chunkEncoding(userID), i.cfg.ChunkZstdLevel, i.cfg.ChunkBlockBlooms, i.cfg.BlockSize, i.cfg.TargetChunkSize)
+	}
+}
+
+// chunkEncoding returns the chunk encoding of a tenant, its limits overriding
+// the configured one.
+func (i *Ingester) chunkEncoding(userID string) chunkenc.Encoding {
+	name := i.limiter.ChunkEncoding(userID)
+	if name == "" {
+		return i.encoding
+	}
+	enc, err := chunkenc.ParseEncoding(name)
+	if err != nil {
+		level.Warn(util.WithUserID(userID, util.Logger)).Log("msg", "invalid chunk encoding for tenant, using the default one", "err", err)
+		return i.encoding
+	}
+	return enc
+}
+
 // Query the ingests for log streams matching a set of matchers.
 func (i *Ingester) Query(req *logproto.QueryRequest, queryServer logproto.Querier_QueryServer) error {
 	// initialize stats collection for ingester queries and set grpc trailer with stats.
@@ -17,6 +17,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 
+	"github.com/grafana/loki/pkg/chunkenc"
 	"github.com/grafana/loki/pkg/ingester/client"
 	"github.com/grafana/loki/pkg/iter"
 	"github.com/grafana/loki/pkg/logproto"
@@ -231,6 +232,49 @@ func TestIngesterStreamLimitExceeded(t *testing.T) {
 	}
 }
 
+func TestIngesterTenantChunkEncoding(t *testing.T) {
+	ingesterConfig := defaultIngesterTestConfig(t)
+	ingesterConfig.ChunkEncoding = chunkenc.EncSnappy.String()
+	tenantLimits := defaultLimitsTestConfig()
+	tenantLimits.ChunkEncoding = chunkenc.EncZstd.String()
+	invalidLimits := defaultLimitsTestConfig()
+	invalidLimits.ChunkEncoding = "invalid"
+	overrides, err := validation.NewOverrides(defaultLimitsTestConfig(), func(userID string) *validation.Limits {
+		switch userID {
+		case "zstd":
+			return &tenantLimits
+		case "invalid":
+			return &invalidLimits
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	i, err := New(ingesterConfig, client.Config{}, &mockStore{chunks: map[string][]chunk.Chunk{}}, overrides)
+	require.NoError(t, err)
+	defer services.StopAndAwaitTerminated(context.Background(), i) //nolint:errcheck
+
+	for userID, enc := range map[string]chunkenc.Encoding{
+		"zstd":    chunkenc.EncZstd,
+		"default": chunkenc.EncSnappy,
+		"invalid": chunkenc.EncSnappy,
+	} {
+		_, err := i.Push(user.InjectOrgID(context.Background(), userID), &logproto.PushRequest{
+			Streams: []logproto.Stream{{
+				Labels:  `{foo="bar"}`,
+				Entries: []logproto.Entry{{Timestamp: time.Unix(0, 1), Line: "line"}},
+			}},
+		})
+		require.NoError(t, err)
+
+		inst, ok := i.getInstanceByID(userID)
+		require.True(t, ok)
+		for _, s := range inst.streams {
+			require.Equal(t, enc, s.chunks[0].chunk.(*chunkenc.MemChunk).Encoding(), userID)
+		}
+	}
+}
+
 type mockStore struct {
 	mtx    sync.Mutex
 	chunks map[string][]chunk.Chunk
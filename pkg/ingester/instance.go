@@ -71,13 +71,16 @@ type instance struct {
 
 	limiter *Limiter
 	factory func() chunkenc.Chunk
+	wal     WAL
+	// walSeq is the sequence number of the last push logged to the WAL.
+	walSeq uint64
 
 	// sync
 	syncPeriod  time.Duration
 	syncMinUtil float64
 }
 
-func newInstance(cfg *Config, instanceID string, factory func() chunkenc.Chunk, limiter *Limiter, syncPeriod time.Duration, syncMinUtil float64) *instance {
+func newInstance(cfg *Config, instanceID string, factory func() chunkenc.Chunk, limiter *Limiter, wal WAL, syncPeriod time.Duration, syncMinUtil float64) *instance {
 	i := &instance{
 		cfg:        cfg,
 		streams:    map[model.Fingerprint]*stream{},
@@ -90,6 +93,7 @@ func newInstance(cfg *Config, instanceID string, factory func() chunkenc.Chunk,
 		factory: factory,
 		tailers: map[uint32]*tailer{},
 		limiter: limiter,
+		wal:     wal,
 
 		syncPeriod:  syncPeriod,
 		syncMinUtil: syncMinUtil,
@@ -104,6 +108,38 @@ func (i *instance) consumeChunk(ctx context.Context, labels []client.LabelAdapte
 	i.streamsMtx.Lock()
 	defer i.streamsMtx.Unlock()
 
+	stream := i.getOrCreateStreamByLabels(labels)
+	err := stream.consumeChunk(ctx, chunk)
+	if err == nil {
+		memoryChunks.Inc()
+	}
+
+	return err
+}
+
+// recoverStream adds the chunks of a stream recovered from a checkpoint of the
+// WAL, which contain the pushes up to seq.
+func (i *instance) recoverStream(labels []client.LabelAdapter, chunks []chunkDesc, lastLine line, seq uint64) {
+	i.streamsMtx.Lock()
+	defer i.streamsMtx.Unlock()
+
+	stream := i.getOrCreateStreamByLabels(labels)
+	stream.chunks = append(stream.chunks, chunks...)
+	for _, c := range chunks {
+		stream.updateHighestTs(c.chunk)
+	}
+	stream.lastLine = lastLine
+	stream.checkpointSeq = seq
+	if seq > i.walSeq {
+		i.walSeq = seq
+	}
+	chunksCreatedTotal.Add(float64(len(chunks)))
+	memoryChunks.Add(float64(len(chunks)))
+}
+
+// getOrCreateStreamByLabels returns the stream of the labels, creating it
+// without enforcing the limits. Must hold streamsMtx.
+func (i *instance) getOrCreateStreamByLabels(labels []client.LabelAdapter) *stream {
 	rawFp := client.FastFingerprint(labels)
 	fp := i.mapper.mapFP(rawFp, labels)
 
@@ -116,19 +152,17 @@ func (i *instance) consumeChunk(ctx context.Context, labels []client.LabelAdapte
 		memoryStreams.WithLabelValues(i.instanceID).Inc()
 		i.addTailersToNewStream(stream)
 	}
-
-	err := stream.consumeChunk(ctx, chunk)
-	if err == nil {
-		memoryChunks.Inc()
-	}
-
-	return err
+	return stream
 }
 
 func (i *instance) Push(ctx context.Context, req *logproto.PushRequest) error {
 	i.streamsMtx.Lock()
 	defer i.streamsMtx.Unlock()
 
+	// Only the accepted entries are logged: the limits aren't enforced on
+	// replay, so anything else would be accepted after a restart.
+	accepted := &logproto.PushRequest{}
+
 	var appendErr error
 	for _, s := range req.Streams {
 
@@ -138,18 +172,68 @@ func (i *instance) Push(ctx context.Context, req *logproto.PushRequest) error {
 			continue
 		}
 
-		prevNumChunks := len(stream.chunks)
-		if err := stream.Push(ctx, s.Entries, i.syncPeriod, i.syncMinUtil); err != nil {
+		stored, err := i.pushStream(ctx, stream, s.Entries)
+		if len(stored) > 0 {
+			accepted.Streams = append(accepted.Streams, logproto.Stream{Labels: s.Labels, Entries: stored})
+		}
+		if err != nil {
 			appendErr = err
 			continue
 		}
+	}
 
-		memoryChunks.Add(float64(len(stream.chunks) - prevNumChunks))
+	if len(accepted.Streams) == 0 {
+		return appendErr
 	}
 
+	i.walSeq++
+	if err := i.wal.Log(i.instanceID, i.walSeq, accepted); err != nil {
+		return err
+	}
 	return appendErr
 }
 
+// replay appends the entries of a request recovered from the WAL. The limits
+// aren't enforced as the streams were accepted when the request was pushed,
+// the streams checkpointed after the request already contain its entries.
+func (i *instance) replay(ctx context.Context, seq uint64, req *logproto.PushRequest) error {
+	i.streamsMtx.Lock()
+	defer i.streamsMtx.Unlock()
+
+	if seq > i.walSeq {
+		i.walSeq = seq
+	}
+
+	var appendErr error
+	for _, s := range req.Streams {
+		labels, err := util.ToClientLabels(s.Labels)
+		if err != nil {
+			appendErr = httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+			continue
+		}
+
+		stream := i.getOrCreateStreamByLabels(labels)
+		if seq <= stream.checkpointSeq {
+			continue
+		}
+		if _, err := i.pushStream(ctx, stream, s.Entries); err != nil {
+			appendErr = err
+			continue
+		}
+	}
+
+	return appendErr
+}
+
+// pushStream appends entries to a stream and returns the ones that were
+// stored. Must hold streamsMtx.
+func (i *instance) pushStream(ctx context.Context, stream *stream, entries []logproto.Entry) ([]logproto.Entry, error) {
+	prevNumChunks := len(stream.chunks)
+	stored, err := stream.push(ctx, entries, i.syncPeriod, i.syncMinUtil, i.limiter.UnorderedWritesWindow(i.instanceID))
+	memoryChunks.Add(float64(len(stream.chunks) - prevNumChunks))
+	return stored, err
+}
+
 func (i *instance) getOrCreateStream(pushReqStream logproto.Stream) (*stream, error) {
 	labels, err := util.ToClientLabels(pushReqStream.Labels)
 	if err != nil {
@@ -168,7 +252,7 @@ func (i *instance) getOrCreateStream(pushReqStream logproto.Stream) (*stream, er
 		validation.DiscardedSamples.WithLabelValues(validation.StreamLimit, i.instanceID).Add(float64(len(pushReqStream.Entries)))
 		bytes := 0
 		for _, e := range pushReqStream.Entries {
-			bytes += len(e.Line)
+			bytes += len(e.Line) + logproto.MetadataSize(e.StructuredMetadata)
 		}
 		validation.DiscardedBytes.WithLabelValues(validation.StreamLimit, i.instanceID).Add(float64(bytes))
 		level.Warn(cutil.Logger).Log("message", "could not create new stream for tenant", "error", err)
@@ -203,6 +287,12 @@ func (i *instance) Query(ctx context.Context, req *logproto.QueryRequest) ([]ite
 	if err != nil {
 		return nil, err
 	}
+	pipeline, err := expr.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	// the merged part of the pipeline is applied by the querier.
+	pipeline, _ = pipeline.Split()
 
 	ingStats := stats.GetIngesterData(ctx)
 	var iters []iter.EntryIterator
@@ -210,7 +300,7 @@ func (i *instance) Query(ctx context.Context, req *logproto.QueryRequest) ([]ite
 		expr.Matchers(),
 		func(stream *stream) error {
 			ingStats.TotalChunksMatched += int64(len(stream.chunks))
-			iter, err := stream.Iterator(ctx, req.Start, req.End, req.Direction, filter)
+			iter, err := stream.Iterator(ctx, req.Start, req.End, req.Direction, filter, pipeline)
 			if err != nil {
 				return err
 			}
@@ -3,6 +3,7 @@ package ingester
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/grafana/loki/pkg/util/validation"
 )
@@ -61,6 +62,18 @@ func (l *Limiter) AssertMaxStreamsPerUser(userID string, streams int) error {
 	return fmt.Errorf(errMaxStreamsPerUserLimitExceeded, userID, streams, calculatedLimit, localLimit, globalLimit, adjustedGlobalLimit)
 }
 
+// UnorderedWritesWindow returns how much older than the newest entry of a
+// stream the entries of a tenant can be.
+func (l *Limiter) UnorderedWritesWindow(userID string) time.Duration {
+	return l.limits.UnorderedWritesWindow(userID)
+}
+
+// ChunkEncoding returns the chunk encoding of the tenant, empty to use the
+// configured one.
+func (l *Limiter) ChunkEncoding(userID string) string {
+	return l.limits.ChunkEncoding(userID)
+}
+
 func (l *Limiter) convertGlobalToLocalLimit(globalLimit int) int {
 	if globalLimit == 0 {
 		return 0
@@ -66,6 +66,13 @@ type stream struct {
 	labelsString string
 	factory      func() chunkenc.Chunk
 	lastLine     line
+	// The sequence number of the last push of the tenant contained in the
+	// chunks recovered from a checkpoint, the pushes up to it are skipped
+	// when the WAL is replayed.
+	checkpointSeq uint64
+	// The timestamp of the newest entry, entries older than it by more than
+	// the unordered writes window are rejected.
+	highestTs time.Time
 
 	tailers   map[uint32]*tailer
 	tailerMtx sync.RWMutex
@@ -107,11 +114,27 @@ func (s *stream) consumeChunk(_ context.Context, chunk *logproto.Chunk) error {
 	s.chunks = append(s.chunks, chunkDesc{
 		chunk: c,
 	})
+	s.updateHighestTs(c)
 	chunksCreatedTotal.Inc()
 	return nil
 }
 
-func (s *stream) Push(ctx context.Context, entries []logproto.Entry, synchronizePeriod time.Duration, minUtilization float64) error {
+// updateHighestTs accounts for the entries of a chunk added to the stream.
+func (s *stream) updateHighestTs(c chunkenc.Chunk) {
+	if _, to := c.Bounds(); to.After(s.highestTs) {
+		s.highestTs = to
+	}
+}
+
+// Push appends entries to the stream. Entries can be out of order, unless they
+// are older than the newest entry of the stream by more than unorderedWindow.
+func (s *stream) Push(ctx context.Context, entries []logproto.Entry, synchronizePeriod time.Duration, minUtilization float64, unorderedWindow time.Duration) error {
+	_, err := s.push(ctx, entries, synchronizePeriod, minUtilization, unorderedWindow)
+	return err
+}
+
+// push is like Push, but also returns the entries that were stored.
+func (s *stream) push(ctx context.Context, entries []logproto.Entry, synchronizePeriod time.Duration, minUtilization float64, unorderedWindow time.Duration) ([]logproto.Entry, error) {
 	var lastChunkTimestamp time.Time
 	if len(s.chunks) == 0 {
 		s.chunks = append(s.chunks, chunkDesc{
@@ -140,6 +163,11 @@ func (s *stream) Push(ctx context.Context, entries []logproto.Entry, synchronize
 			continue
 		}
 
+		if entries[i].Timestamp.Before(s.highestTs.Add(-unorderedWindow)) {
+			failedEntriesWithError = append(failedEntriesWithError, entryWithError{&entries[i], chunkenc.ErrOutOfOrder})
+			continue
+		}
+
 		chunk := &s.chunks[len(s.chunks)-1]
 		if chunk.closed || !chunk.chunk.SpaceFor(&entries[i]) || s.cutChunkForSynchronization(entries[i].Timestamp, lastChunkTimestamp, chunk, synchronizePeriod, minUtilization) {
 			// If the chunk has no more space call Close to make sure anything in the head block is cut and compressed
@@ -166,8 +194,13 @@ func (s *stream) Push(ctx context.Context, entries []logproto.Entry, synchronize
 		} else {
 			// send only stored entries to tailers
 			storedEntries = append(storedEntries, entries[i])
-			lastChunkTimestamp = entries[i].Timestamp
-			s.lastLine = line{ts: lastChunkTimestamp, content: entries[i].Line}
+			if entries[i].Timestamp.After(lastChunkTimestamp) {
+				lastChunkTimestamp = entries[i].Timestamp
+			}
+			if entries[i].Timestamp.After(s.highestTs) {
+				s.highestTs = entries[i].Timestamp
+			}
+			s.lastLine = line{ts: entries[i].Timestamp, content: entries[i].Line}
 		}
 		chunk.lastUpdated = time.Now()
 	}
@@ -219,18 +252,19 @@ func (s *stream) Push(ctx context.Context, entries []logproto.Entry, synchronize
 
 			fmt.Fprintf(&buf, "total ignored: %d out of %d", len(failedEntriesWithError), len(entries))
 
-			return httpgrpc.Errorf(http.StatusBadRequest, buf.String())
+			return storedEntries, httpgrpc.Errorf(http.StatusBadRequest, buf.String())
 		}
-		return lastEntryWithErr.e
+		return storedEntries, lastEntryWithErr.e
 	}
 
-	return nil
+	return storedEntries, nil
 }
 
 // Returns true, if chunk should be cut before adding new entry. This is done to make ingesters
 // cut the chunk for this stream at the same moment, so that new chunk will contain exactly the same entries.
 func (s *stream) cutChunkForSynchronization(entryTimestamp, prevEntryTimestamp time.Time, c *chunkDesc, synchronizePeriod time.Duration, minUtilization float64) bool {
-	if synchronizePeriod <= 0 || prevEntryTimestamp.IsZero() {
+	// Entries written out of order never trigger a synchronization.
+	if synchronizePeriod <= 0 || prevEntryTimestamp.IsZero() || entryTimestamp.Before(prevEntryTimestamp) {
 		return false
 	}
 
@@ -255,26 +289,39 @@ func (s *stream) cutChunkForSynchronization(entryTimestamp, prevEntryTimestamp t
 	return false
 }
 
-// Returns an iterator.
-func (s *stream) Iterator(ctx context.Context, from, through time.Time, direction logproto.Direction, filter logql.LineFilter) (iter.EntryIterator, error) {
+// Returns an iterator. The pipeline is applied to each entry that passed the filter.
+func (s *stream) Iterator(ctx context.Context, from, through time.Time, direction logproto.Direction, filter logql.LineFilter, pipeline logql.Pipeline) (iter.EntryIterator, error) {
 	iterators := make([]iter.EntryIterator, 0, len(s.chunks))
+	var (
+		overlapping bool
+		lastThrough time.Time
+	)
 	for _, c := range s.chunks {
 		itr, err := c.chunk.Iterator(ctx, from, through, direction, filter)
 		if err != nil {
 			return nil, err
 		}
 		if itr != nil {
+			// The chunks overlap when entries were written out of order.
+			chkFrom, chkThrough := c.chunk.Bounds()
+			overlapping = overlapping || (len(iterators) > 0 && chkFrom.Before(lastThrough))
+			lastThrough = chkThrough
 			iterators = append(iterators, itr)
 		}
 	}
 
+	if overlapping {
+		merged := iter.NewHeapIterator(ctx, iterators, direction)
+		return logql.NewPipelineIterator(iter.NewNonOverlappingIterator([]iter.EntryIterator{merged}, s.labelsString), pipeline), nil
+	}
+
 	if direction != logproto.FORWARD {
 		for left, right := 0, len(iterators)-1; left < right; left, right = left+1, right-1 {
 			iterators[left], iterators[right] = iterators[right], iterators[left]
 		}
 	}
 
-	return iter.NewNonOverlappingIterator(iterators, s.labelsString), nil
+	return logql.NewPipelineIterator(iter.NewNonOverlappingIterator(iterators, s.labelsString), pipeline), nil
 }
 
 func (s *stream) addTailer(t *tailer) {
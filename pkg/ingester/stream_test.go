@@ -43,7 +43,7 @@ func TestMaxReturnedStreamsErrors(t *testing.T) {
 
 			err := s.Push(context.Background(), []logproto.Entry{
 				{Timestamp: time.Unix(int64(numLogs), 0), Line: "log"},
-			}, 0, 0)
+			}, 0, 0, 0)
 			require.NoError(t, err)
 
 			newLines := make([]logproto.Entry, numLogs)
@@ -62,7 +62,7 @@ func TestMaxReturnedStreamsErrors(t *testing.T) {
 			fmt.Fprintf(&expected, "total ignored: %d out of %d", numLogs, numLogs)
 			expectErr := httpgrpc.Errorf(http.StatusBadRequest, expected.String())
 
-			err = s.Push(context.Background(), newLines, 0, 0)
+			err = s.Push(context.Background(), newLines, 0, 0, 0)
 			require.Error(t, err)
 			require.Equal(t, expectErr.Error(), err.Error())
 		})
@@ -83,13 +83,56 @@ func TestPushDeduplication(t *testing.T) {
 		{Timestamp: time.Unix(1, 0), Line: "test"},
 		{Timestamp: time.Unix(1, 0), Line: "test"},
 		{Timestamp: time.Unix(1, 0), Line: "newer, better test"},
-	}, 0, 0)
+	}, 0, 0, 0)
 	require.NoError(t, err)
 	require.Len(t, s.chunks, 1)
 	require.Equal(t, s.chunks[0].chunk.Size(), 2,
 		"expected exact duplicate to be dropped and newer content with same timestamp to be appended")
 }
 
+func TestPushUnorderedWritesWindow(t *testing.T) {
+	s := newStream(
+		&Config{},
+		model.Fingerprint(0),
+		labels.Labels{
+			{Name: "foo", Value: "bar"},
+		},
+		func() chunkenc.Chunk { return chunkenc.NewUnorderedMemChunk(chunkenc.EncGZIP, 0, false, 64, 0) },
+	)
+
+	// entries are written in reverse order, each one within the window.
+	var entries []logproto.Entry
+	for i := 100; i > 40; i-- {
+		entries = append(entries, logproto.Entry{Timestamp: time.Unix(int64(i), 0), Line: fmt.Sprintf("line %d", i)})
+	}
+	require.NoError(t, s.Push(context.Background(), entries, 0, 0, time.Minute))
+
+	// entries older than the newest one by more than the window are rejected.
+	err := s.Push(context.Background(), []logproto.Entry{
+		{Timestamp: time.Unix(40, 0), Line: "line 40"},
+		{Timestamp: time.Unix(39, 0), Line: "too old"},
+	}, 0, 0, time.Minute)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "total ignored: 1 out of 2")
+
+	it, err := s.Iterator(context.Background(), time.Unix(0, 0), time.Unix(200, 0), logproto.FORWARD, nil, nil)
+	require.NoError(t, err)
+	var lines []string
+	for it.Next() {
+		lines = append(lines, it.Entry().Line)
+	}
+	require.NoError(t, it.Close())
+	require.Len(t, lines, 61)
+	require.Equal(t, "line 40", lines[0])
+	require.Equal(t, "line 100", lines[60])
+	for i := 1; i < len(lines); i++ {
+		var prev, cur int
+		_, _ = fmt.Sscanf(lines[i-1], "line %d", &prev)
+		_, _ = fmt.Sscanf(lines[i], "line %d", &cur)
+		require.LessOrEqual(t, prev, cur)
+	}
+}
+
 func TestStreamIterator(t *testing.T) {
 	const chunks = 3
 	const entries = 100
@@ -119,7 +162,7 @@ func TestStreamIterator(t *testing.T) {
 			for i := 0; i < 100; i++ {
 				from := rand.Intn(chunks*entries - 1)
 				len := rand.Intn(chunks*entries-from) + 1
-				iter, err := s.Iterator(context.TODO(), time.Unix(int64(from), 0), time.Unix(int64(from+len), 0), logproto.FORWARD, nil)
+				iter, err := s.Iterator(context.TODO(), time.Unix(int64(from), 0), time.Unix(int64(from+len), 0), logproto.FORWARD, nil, nil)
 				require.NotNil(t, iter)
 				require.NoError(t, err)
 				testIteratorForward(t, iter, int64(from), int64(from+len))
@@ -129,7 +172,7 @@ func TestStreamIterator(t *testing.T) {
 			for i := 0; i < 100; i++ {
 				from := rand.Intn(entries - 1)
 				len := rand.Intn(chunks*entries-from) + 1
-				iter, err := s.Iterator(context.TODO(), time.Unix(int64(from), 0), time.Unix(int64(from+len), 0), logproto.BACKWARD, nil)
+				iter, err := s.Iterator(context.TODO(), time.Unix(int64(from), 0), time.Unix(int64(from+len), 0), logproto.BACKWARD, nil, nil)
 				require.NotNil(t, iter)
 				require.NoError(t, err)
 				testIteratorBackward(t, iter, int64(from), int64(from+len))
@@ -10,6 +10,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
 
 	"github.com/grafana/loki/pkg/logproto"
 	"github.com/grafana/loki/pkg/logql"
@@ -25,6 +26,11 @@ type tailer struct {
 	filter   logql.LineFilter
 	expr     logql.Expr
 
+	// Stages such as distinct are stateful, the pipeline is applied to one
+	// stream at a time.
+	pipeline    logql.Pipeline
+	pipelineMtx sync.Mutex
+
 	sendChan chan *logproto.Stream
 
 	// Signaling channel used to notify once the tailer gets closed
@@ -48,12 +54,17 @@ func newTailer(orgID, query string, conn logproto.Querier_TailServer) (*tailer,
 	if err != nil {
 		return nil, err
 	}
+	pipeline, err := expr.Pipeline()
+	if err != nil {
+		return nil, err
+	}
 	matchers := expr.Matchers()
 
 	return &tailer{
 		orgID:          orgID,
 		matchers:       matchers,
 		filter:         filter,
+		pipeline:       pipeline,
 		sendChan:       make(chan *logproto.Stream, bufferSizeForTailResponse),
 		conn:           conn,
 		droppedStreams: []*logproto.DroppedStream{},
@@ -118,32 +129,69 @@ func (t *tailer) send(stream logproto.Stream) {
 		return
 	}
 
-	t.filterEntriesInStream(&stream)
-
-	if len(stream.Entries) == 0 {
-		return
+	for _, s := range t.processStream(stream) {
+		select {
+		case t.sendChan <- s:
+		default:
+			t.dropStream(*s)
+		}
 	}
+}
 
-	select {
-	case t.sendChan <- &stream:
-	default:
-		t.dropStream(stream)
+// processStream applies the filter and the pipeline of the query to the entries of a stream.
+// Entries whose labels are changed by the pipeline are grouped into streams by their new labels.
+func (t *tailer) processStream(stream logproto.Stream) []*logproto.Stream {
+	// Optimization: skip filtering entirely, if no filter nor pipeline is set
+	if t.filter == nil && len(t.pipeline) == 0 {
+		if len(stream.Entries) == 0 {
+			return nil
+		}
+		return []*logproto.Stream{&stream}
 	}
-}
 
-func (t *tailer) filterEntriesInStream(stream *logproto.Stream) {
-	// Optimization: skip filtering entirely, if no filter is set
-	if t.filter == nil {
-		return
+	var base labels.Labels
+	if len(t.pipeline) > 0 {
+		var err error
+		base, err = parser.ParseMetric(stream.Labels)
+		if err != nil {
+			level.Error(cortex_util.Logger).Log("msg", "failed to parse stream labels", "labels", stream.Labels, "err", err)
+			return nil
+		}
+		t.pipelineMtx.Lock()
+		defer t.pipelineMtx.Unlock()
 	}
 
-	var filteredEntries []logproto.Entry
+	var (
+		builder  = logql.NewLabelsBuilder()
+		streams  []*logproto.Stream
+		byLabels = map[string]*logproto.Stream{}
+	)
 	for _, e := range stream.Entries {
-		if t.filter.Filter([]byte(e.Line)) {
-			filteredEntries = append(filteredEntries, e)
+		if t.filter != nil && !t.filter.Filter([]byte(e.Line)) {
+			continue
+		}
+		lbs := stream.Labels
+		if len(t.pipeline) > 0 {
+			builder.Reset(base)
+			builder.SetMetadata(e.StructuredMetadata)
+			line, ok := t.pipeline.Process([]byte(e.Line), builder)
+			if !ok {
+				continue
+			}
+			e.Line = string(line)
+			if builder.Modified() {
+				lbs = builder.Labels().String()
+			}
+		}
+		s, ok := byLabels[lbs]
+		if !ok {
+			s = &logproto.Stream{Labels: lbs}
+			byLabels[lbs] = s
+			streams = append(streams, s)
 		}
+		s.Entries = append(s.Entries, e)
 	}
-	stream.Entries = filteredEntries
+	return streams
 }
 
 // Returns true if tailer is interested in the passed labelset
@@ -46,3 +46,44 @@ func TestTailer_sendRaceConditionOnSendWhileClosing(t *testing.T) {
 		routines.Wait()
 	}
 }
+
+func TestTailer_sendPipeline(t *testing.T) {
+	tailer, err := newTailer("org-id", `{app="foo"} | logfmt | level="error"`, nil)
+	require.NoError(t, err)
+
+	tailer.send(logproto.Stream{
+		Labels: `{app="foo"}`,
+		Entries: []logproto.Entry{
+			{Timestamp: time.Unix(1, 0), Line: "level=error code=1"},
+			{Timestamp: time.Unix(2, 0), Line: "level=info code=1"},
+			{Timestamp: time.Unix(3, 0), Line: "level=error code=2"},
+			{Timestamp: time.Unix(4, 0), Line: "level=error code=1"},
+		},
+	})
+
+	// entries are grouped by the labels extracted by the parser.
+	expected := []logproto.Stream{
+		{
+			Labels: `{app="foo", code="1", level="error"}`,
+			Entries: []logproto.Entry{
+				{Timestamp: time.Unix(1, 0), Line: "level=error code=1"},
+				{Timestamp: time.Unix(4, 0), Line: "level=error code=1"},
+			},
+		},
+		{
+			Labels: `{app="foo", code="2", level="error"}`,
+			Entries: []logproto.Entry{
+				{Timestamp: time.Unix(3, 0), Line: "level=error code=2"},
+			},
+		},
+	}
+	for _, stream := range expected {
+		select {
+		case s := <-tailer.sendChan:
+			require.Equal(t, stream, *s)
+		default:
+			t.Fatalf("stream %s wasn't sent", stream.Labels)
+		}
+	}
+	require.Len(t, tailer.sendChan, 0)
+}
@@ -93,6 +93,7 @@ func TestTransferOut(t *testing.T) {
 				time.Unix(10, 0),
 				logproto.FORWARD,
 				logql.LineFilterFunc(func([]byte) bool { return true }),
+				nil,
 			)
 			if !assert.NoError(t, err) {
 				continue
@@ -0,0 +1,487 @@
+package ingester
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"golang.org/x/net/context"
+
+	"github.com/grafana/loki/pkg/chunkenc"
+	"github.com/grafana/loki/pkg/logproto"
+	loki_util "github.com/grafana/loki/pkg/util"
+)
+
+var (
+	walDiskSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "loki",
+		Name:      "ingester_wal_disk_size_bytes",
+		Help:      "Size of the write-ahead log on disk, including its last checkpoint.",
+	})
+	walRecordsLogged = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "loki",
+		Name:      "ingester_wal_records_logged_total",
+		Help:      "The total number of push requests written to the write-ahead log.",
+	})
+	walLoggedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "loki",
+		Name:      "ingester_wal_logged_bytes_total",
+		Help:      "The total number of bytes written to the write-ahead log, before compression.",
+	})
+	walReplayDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "loki",
+		Name:      "ingester_wal_replay_duration_seconds",
+		Help:      "Time taken to replay the last checkpoint and the write-ahead log when the ingester started.",
+	})
+	walCorruptionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "loki",
+		Name:      "ingester_wal_corruptions_total",
+		Help:      "The total number of corruptions found while replaying the write-ahead log and its checkpoints. The records after a corruption are discarded.",
+	})
+	checkpointDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "loki",
+		Name:      "ingester_checkpoint_duration_seconds",
+		Help:      "Time taken to create a checkpoint of the in-memory chunks.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 7),
+	})
+	checkpointFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "loki",
+		Name:      "ingester_checkpoint_creations_failed_total",
+		Help:      "The total number of checkpoints which failed to be created.",
+	})
+)
+
+// The types of the records of the WAL. Make sure to preserve their values as
+// they're written to disk.
+const (
+	// walRecordEntries holds a push request accepted by the ingester, with
+	// its sequence number in the pushes of the tenant.
+	walRecordEntries byte = iota + 1
+	// walRecordSeries holds the unflushed chunks of a stream in a checkpoint,
+	// with the sequence number of the last push of the tenant they contain.
+	walRecordSeries
+)
+
+const checkpointPrefix = "checkpoint."
+
+// WALConfig configures the write-ahead log of the ingester.
+type WALConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	Dir                string        `yaml:"dir"`
+	CheckpointDuration time.Duration `yaml:"checkpoint_duration"`
+	FlushOnShutdown    bool          `yaml:"flush_on_shutdown"`
+}
+
+// RegisterFlags registers the flags.
+func (cfg *WALConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ingester.wal-enabled", false, "Record the accepted pushes in a write-ahead log, replayed when the ingester starts so that a crash doesn't lose the unflushed chunks. Chunk transfers must be disabled.")
+	f.StringVar(&cfg.Dir, "ingester.wal-dir", "wal", "Directory to store the write-ahead log and its checkpoints in.")
+	f.DurationVar(&cfg.CheckpointDuration, "ingester.checkpoint-duration", 5*time.Minute, "Interval at which the in-memory chunks are checkpointed, which allows to delete the older segments of the write-ahead log.")
+	f.BoolVar(&cfg.FlushOnShutdown, "ingester.flush-on-shutdown", false, "Flush the chunks when the ingester shuts down with the write-ahead log enabled. They are otherwise recovered from the write-ahead log on restart.")
+}
+
+// WAL records the push requests accepted by the ingester, so that its
+// in-memory chunks can be recovered after a crash.
+type WAL interface {
+	// Start replays the WAL into the ingester and starts checkpointing it.
+	Start() error
+	// Log records a push request of a tenant, seq must increase with each
+	// push of the tenant.
+	Log(userID string, seq uint64, req *logproto.PushRequest) error
+	// Stop checkpoints the chunks left in memory and closes the WAL.
+	Stop() error
+}
+
+type noopWAL struct{}
+
+func (noopWAL) Start() error                                    { return nil }
+func (noopWAL) Log(string, uint64, *logproto.PushRequest) error { return nil }
+func (noopWAL) Stop() error                                     { return nil }
+
+// walWrapper is a WAL of segments holding the pushes, starting with the
+// checkpoint of the in-memory chunks taken when the first segment was cut.
+type walWrapper struct {
+	cfg      WALConfig
+	ingester *Ingester
+	wal      *wal.WAL
+
+	quit chan struct{}
+	wait sync.WaitGroup
+}
+
+func newWAL(cfg WALConfig, ingester *Ingester) (WAL, error) {
+	if !cfg.Enabled {
+		return noopWAL{}, nil
+	}
+
+	w, err := wal.New(util.Logger, nil, cfg.Dir, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening WAL")
+	}
+	return &walWrapper{
+		cfg:      cfg,
+		ingester: ingester,
+		wal:      w,
+		quit:     make(chan struct{}),
+	}, nil
+}
+
+func (w *walWrapper) Start() error {
+	if err := w.replay(); err != nil {
+		return errors.Wrap(err, "replaying WAL")
+	}
+
+	w.wait.Add(1)
+	go w.loop()
+	return nil
+}
+
+func (w *walWrapper) Log(userID string, seq uint64, req *logproto.PushRequest) error {
+	data, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+
+	enc := encoding.Encbuf{B: make([]byte, 0, 1+2*binary.MaxVarintLen64+len(userID)+len(data))}
+	enc.PutByte(walRecordEntries)
+	enc.PutUvarintStr(userID)
+	enc.PutUvarint64(seq)
+	enc.B = append(enc.B, data...)
+
+	if err := w.wal.Log(enc.Get()); err != nil {
+		return errors.Wrap(err, "writing to WAL")
+	}
+	walRecordsLogged.Inc()
+	walLoggedBytes.Add(float64(enc.Len()))
+	return nil
+}
+
+func (w *walWrapper) Stop() error {
+	close(w.quit)
+	w.wait.Wait()
+
+	err := w.checkpoint()
+	if err != nil {
+		level.Error(util.Logger).Log("msg", "failed to checkpoint the WAL on shutdown", "err", err)
+	}
+	if closeErr := w.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *walWrapper) loop() {
+	defer w.wait.Done()
+
+	ticker := time.NewTicker(w.cfg.CheckpointDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.checkpoint(); err != nil {
+				level.Error(util.Logger).Log("msg", "failed to checkpoint the WAL", "err", err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// checkpoint writes the unflushed chunks in a new checkpoint, replacing the
+// segments written before it was started. The streams are written one at a
+// time while pushes go on, each records the last push it contains so that
+// the pushes logged before it in the following segments are skipped on replay.
+func (w *walWrapper) checkpoint() (err error) {
+	start := time.Now()
+	defer func() {
+		checkpointDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			checkpointFailuresTotal.Inc()
+		}
+	}()
+
+	// The pushes logged in the segments up to the current one are in memory,
+	// the checkpoint replaces them.
+	_, last, err := w.wal.Segments()
+	if err != nil {
+		return err
+	}
+	if err := w.wal.NextSegment(); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(w.wal.Dir(), fmt.Sprintf("%s%08d", checkpointPrefix, last))
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	cp, err := wal.New(nil, nil, tmp, w.wal.CompressionEnabled())
+	if err != nil {
+		return errors.Wrap(err, "creating checkpoint")
+	}
+	for _, inst := range w.ingester.getInstances() {
+		if err := checkpointInstance(cp, inst); err != nil {
+			_ = cp.Close()
+			return err
+		}
+	}
+	if err := cp.Close(); err != nil {
+		return errors.Wrap(err, "closing checkpoint")
+	}
+	if err := fileutil.Replace(tmp, dir); err != nil {
+		return errors.Wrap(err, "renaming checkpoint")
+	}
+
+	if err := w.wal.Truncate(last + 1); err != nil {
+		return errors.Wrap(err, "truncating WAL")
+	}
+	if err := wal.DeleteCheckpoints(w.wal.Dir(), last); err != nil {
+		return errors.Wrap(err, "deleting previous checkpoints")
+	}
+	w.updateDiskSize()
+	return nil
+}
+
+func checkpointInstance(cp *wal.WAL, inst *instance) error {
+	inst.streamsMtx.RLock()
+	streams := make([]*stream, 0, len(inst.streams))
+	for _, s := range inst.streams {
+		streams = append(streams, s)
+	}
+	inst.streamsMtx.RUnlock()
+
+	for _, s := range streams {
+		// Serializing a chunk encodes the offsets of its blocks.
+		inst.streamsMtx.Lock()
+		rec, err := encodeSeries(inst.instanceID, inst.walSeq, s)
+		inst.streamsMtx.Unlock()
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			continue
+		}
+		if err := cp.Log(rec); err != nil {
+			return errors.Wrap(err, "writing checkpoint")
+		}
+	}
+	return nil
+}
+
+// encodeSeries encodes the unflushed chunks of a stream, which contain the
+// pushes of the tenant up to seq. Returns nil when they all were flushed.
+// Must hold the streamsMtx of the instance.
+func encodeSeries(userID string, seq uint64, s *stream) ([]byte, error) {
+	enc := encoding.Encbuf{}
+	numChunks := 0
+	for _, c := range s.chunks {
+		if !c.flushed.IsZero() {
+			continue
+		}
+
+		var chk, head []byte
+		var err error
+		if mc, ok := c.chunk.(*chunkenc.MemChunk); ok {
+			chk, head, err = mc.SerializeForCheckpoint()
+		} else {
+			chk, err = c.chunk.Bytes()
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "serializing chunk")
+		}
+
+		enc.PutByte(boolToByte(c.closed))
+		enc.PutByte(boolToByte(c.synced))
+		enc.PutVarint64(c.lastUpdated.UnixNano())
+		enc.PutUvarint(len(chk))
+		enc.B = append(enc.B, chk...)
+		enc.PutUvarint(len(head))
+		enc.B = append(enc.B, head...)
+		numChunks++
+	}
+	if numChunks == 0 {
+		return nil, nil
+	}
+
+	header := encoding.Encbuf{}
+	header.PutByte(walRecordSeries)
+	header.PutUvarintStr(userID)
+	header.PutUvarintStr(s.labelsString)
+	header.PutUvarint64(seq)
+	header.PutVarint64(s.lastLine.ts.UnixNano())
+	header.PutUvarintStr(s.lastLine.content)
+	header.PutUvarint(numChunks)
+	return append(header.Get(), enc.Get()...), nil
+}
+
+// replay recovers the chunks of the last checkpoint and the pushes logged
+// after it. The records following a corruption are discarded.
+func (w *walWrapper) replay() error {
+	start := time.Now()
+	level.Info(util.Logger).Log("msg", "recovering from WAL", "dir", w.wal.Dir())
+
+	cpDir, idx, err := wal.LastCheckpoint(w.wal.Dir())
+	switch {
+	case err == record.ErrNotFound:
+		idx = -1
+	case err != nil:
+		return errors.Wrap(err, "finding last checkpoint")
+	default:
+		r, err := wal.NewSegmentsReader(cpDir)
+		if err != nil {
+			return errors.Wrap(err, "opening checkpoint")
+		}
+		err = w.replayRecords(r)
+		_ = r.Close()
+		if err != nil {
+			// The checkpoint can't be repaired, only the records read before
+			// the corruption are recovered.
+			if _, ok := errors.Cause(err).(*wal.CorruptionErr); !ok {
+				return err
+			}
+			walCorruptionsTotal.Inc()
+			level.Error(util.Logger).Log("msg", "checkpoint is corrupted, the following records are lost", "dir", cpDir, "err", err)
+		}
+	}
+
+	r, err := wal.NewSegmentsRangeReader(wal.SegmentRange{Dir: w.wal.Dir(), First: idx + 1, Last: -1})
+	if err != nil {
+		return errors.Wrap(err, "opening segments")
+	}
+	err = w.replayRecords(r)
+	_ = r.Close()
+	if err != nil {
+		if _, ok := errors.Cause(err).(*wal.CorruptionErr); !ok {
+			return err
+		}
+		walCorruptionsTotal.Inc()
+		level.Error(util.Logger).Log("msg", "WAL is corrupted, truncating it at the first corrupted record", "err", err)
+		if err := w.wal.Repair(err); err != nil {
+			return errors.Wrap(err, "repairing WAL")
+		}
+	}
+
+	elapsed := time.Since(start)
+	walReplayDuration.Set(elapsed.Seconds())
+	w.updateDiskSize()
+	level.Info(util.Logger).Log("msg", "recovered from WAL", "time", elapsed)
+	return nil
+}
+
+func (w *walWrapper) replayRecords(r io.Reader) error {
+	reader := wal.NewReader(r)
+	for reader.Next() {
+		if err := w.replayRecord(reader.Record()); err != nil {
+			return err
+		}
+	}
+	return reader.Err()
+}
+
+// replayRecord applies a record to the ingester. The record is only valid
+// until the next one is read.
+func (w *walWrapper) replayRecord(rec []byte) error {
+	dec := encoding.Decbuf{B: rec}
+	typ := dec.Byte()
+	userID := dec.UvarintStr()
+	if err := dec.Err(); err != nil {
+		return errors.Wrap(err, "decoding record")
+	}
+	inst := w.ingester.getOrCreateInstance(userID)
+
+	switch typ {
+	case walRecordEntries:
+		seq := dec.Uvarint64()
+		if err := dec.Err(); err != nil {
+			return errors.Wrap(err, "decoding record")
+		}
+		var req logproto.PushRequest
+		if err := req.Unmarshal(dec.B); err != nil {
+			return errors.Wrap(err, "decoding push request")
+		}
+		// The entries which were rejected when they were pushed are rejected again.
+		_ = inst.replay(context.Background(), seq, &req)
+		return nil
+
+	case walRecordSeries:
+		return w.replaySeries(inst, &dec)
+
+	default:
+		return fmt.Errorf("unknown WAL record type %d", typ)
+	}
+}
+
+func (w *walWrapper) replaySeries(inst *instance, dec *encoding.Decbuf) error {
+	labels := dec.UvarintStr()
+	seq := dec.Uvarint64()
+	last := line{
+		ts:      time.Unix(0, dec.Varint64()),
+		content: dec.UvarintStr(),
+	}
+	numChunks := dec.Uvarint()
+	if err := dec.Err(); err != nil {
+		return errors.Wrap(err, "decoding series")
+	}
+
+	chunks := make([]chunkDesc, 0, numChunks)
+	for j := 0; j < numChunks; j++ {
+		closed := dec.Byte() == 1
+		synced := dec.Byte() == 1
+		lastUpdated := time.Unix(0, dec.Varint64())
+		// The chunk references its bytes, which must outlive the record.
+		chk := append([]byte(nil), dec.UvarintBytes()...)
+		head := dec.UvarintBytes()
+		if err := dec.Err(); err != nil {
+			return errors.Wrap(err, "decoding chunk")
+		}
+
+		c, err := chunkenc.MemchunkFromCheckpoint(chk, head, true, w.ingester.cfg.ChunkZstdLevel, w.ingester.cfg.BlockSize, w.ingester.cfg.TargetChunkSize)
+		if err != nil {
+			return errors.Wrap(err, "restoring chunk")
+		}
+		chunks = append(chunks, chunkDesc{
+			chunk:       c,
+			closed:      closed,
+			synced:      synced,
+			lastUpdated: lastUpdated,
+		})
+	}
+
+	lbls, err := loki_util.ToClientLabels(labels)
+	if err != nil {
+		return err
+	}
+	inst.recoverStream(lbls, chunks, last, seq)
+	return nil
+}
+
+func (w *walWrapper) updateDiskSize() {
+	size, err := fileutil.DirSize(w.wal.Dir())
+	if err != nil {
+		level.Warn(util.Logger).Log("msg", "failed to compute the size of the WAL", "err", err)
+		return
+	}
+	walDiskSize.Set(float64(size))
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
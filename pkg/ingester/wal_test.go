@@ -0,0 +1,274 @@
+package ingester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"golang.org/x/net/context"
+
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+func walTestConfig(t *testing.T, dir string) Config {
+	cfg := defaultIngesterTestConfig(t)
+	cfg.MaxTransferRetries = 0
+	cfg.WAL = WALConfig{
+		Enabled:            true,
+		Dir:                dir,
+		CheckpointDuration: time.Hour,
+	}
+	return cfg
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// crash copies the WAL as it is on disk while the ingester is running.
+func crash(t *testing.T, dir string) string {
+	dst := tempDir(t)
+	require.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0777)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), b, 0666)
+	}))
+	return dst
+}
+
+// recoverAndFlush starts an ingester on the WAL and flushes the recovered
+// chunks to the returned store.
+func recoverAndFlush(t *testing.T, dir string) *testStore {
+	cfg := walTestConfig(t, dir)
+	cfg.WAL.FlushOnShutdown = true
+	store, ing := newTestStore(t, cfg)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+	return store
+}
+
+func TestWAL_RecoverAfterCrash(t *testing.T) {
+	dir := tempDir(t)
+	_, ing := newTestStore(t, walTestConfig(t, dir))
+	testData := pushTestSamples(t, ing)
+
+	recovered := crash(t, dir)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+
+	recoverAndFlush(t, recovered).checkData(t, testData)
+}
+
+func TestWAL_Checkpoint(t *testing.T) {
+	dir := tempDir(t)
+	_, ing := newTestStore(t, walTestConfig(t, dir))
+	testData := pushTestSamples(t, ing)
+
+	require.NoError(t, ing.wal.(*walWrapper).checkpoint())
+	_, idx, err := wal.LastCheckpoint(dir)
+	require.NoError(t, err)
+	first, _, err := ing.wal.(*walWrapper).wal.Segments()
+	require.NoError(t, err)
+	require.Equal(t, idx+1, first)
+
+	// the entries pushed after the checkpoint are appended to the recovered chunks.
+	for userID, streams := range testData {
+		more := buildTestStreams(200)
+		ctx := user.InjectOrgID(context.Background(), userID)
+		_, err := ing.Push(ctx, &logproto.PushRequest{Streams: more})
+		require.NoError(t, err)
+
+		for j := range streams {
+			streams[j].Entries = append(streams[j].Entries, more[j].Entries...)
+		}
+	}
+
+	recovered := crash(t, dir)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+
+	recoverAndFlush(t, recovered).checkData(t, testData)
+}
+
+func TestWAL_CheckpointBoundary(t *testing.T) {
+	dir := tempDir(t)
+	_, ing := newTestStore(t, walTestConfig(t, dir))
+	testData := pushTestSamples(t, ing)
+
+	require.NoError(t, ing.wal.(*walWrapper).checkpoint())
+
+	// the pushes logged after the checkpoint was started but before their
+	// streams were written to it are skipped on replay.
+	for userID := range testData {
+		inst, ok := ing.getInstanceByID(userID)
+		require.True(t, ok)
+		require.NoError(t, ing.wal.Log(userID, inst.walSeq, &logproto.PushRequest{Streams: buildTestStreams(100)}))
+	}
+
+	recovered := crash(t, dir)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+
+	recoverAndFlush(t, recovered).checkData(t, testData)
+}
+
+func TestWAL_ReplayIgnoresLimits(t *testing.T) {
+	dir := tempDir(t)
+	_, ing := newTestStore(t, walTestConfig(t, dir))
+	testData := pushTestSamples(t, ing)
+
+	recovered := crash(t, dir)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+
+	// the streams were accepted when they were pushed.
+	limits := defaultLimitsTestConfig()
+	limits.MaxLocalStreamsPerUser = 1
+	cfg := walTestConfig(t, recovered)
+	cfg.WAL.FlushOnShutdown = true
+	store, ing := newTestStoreWithLimits(t, cfg, limits)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+	store.checkData(t, testData)
+}
+
+func TestWAL_LogsOnlyAcceptedStreams(t *testing.T) {
+	limits := defaultLimitsTestConfig()
+	limits.MaxLocalStreamsPerUser = 1
+	dir := tempDir(t)
+	_, ing := newTestStoreWithLimits(t, walTestConfig(t, dir), limits)
+
+	// only the first stream of each request is below the limit.
+	testData := map[string][]logproto.Stream{}
+	for i, userID := range []string{"1", "2"} {
+		streams := buildTestStreams(i)
+		ctx := user.InjectOrgID(context.Background(), userID)
+		_, err := ing.Push(ctx, &logproto.PushRequest{Streams: streams})
+		require.Error(t, err)
+		testData[userID] = streams[:1]
+	}
+
+	recovered := crash(t, dir)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+
+	// the rejected streams aren't created on replay, which ignores the limits.
+	store := recoverAndFlush(t, recovered)
+	store.checkData(t, testData)
+}
+
+func TestWAL_UnorderedWritesWindow(t *testing.T) {
+	limits := defaultLimitsTestConfig()
+	limits.UnorderedWritesWindow = time.Hour
+	dir := tempDir(t)
+	_, ing := newTestStoreWithLimits(t, walTestConfig(t, dir), limits)
+
+	// the entries of each request are pushed in reverse order.
+	reversed := func(from, to int) []logproto.Stream {
+		streams := buildTestStreams(0)
+		for i := range streams {
+			streams[i].Entries = streams[i].Entries[:0]
+			for j := to - 1; j >= from; j-- {
+				streams[i].Entries = append(streams[i].Entries, logproto.Entry{
+					Timestamp: time.Unix(int64(j), 0),
+					Line:      fmt.Sprintf("line %d", j),
+				})
+			}
+		}
+		return streams
+	}
+	userIDs := []string{"1", "2"}
+	for _, userID := range userIDs {
+		ctx := user.InjectOrgID(context.Background(), userID)
+		_, err := ing.Push(ctx, &logproto.PushRequest{Streams: reversed(100, 200)})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, ing.wal.(*walWrapper).checkpoint())
+
+	testData := map[string][]logproto.Stream{}
+	for _, userID := range userIDs {
+		// a push logged after the checkpoint was started, within the window of
+		// the recovered streams, isn't appended twice.
+		inst, ok := ing.getInstanceByID(userID)
+		require.True(t, ok)
+		require.NoError(t, ing.wal.Log(userID, inst.walSeq, &logproto.PushRequest{Streams: reversed(100, 200)}))
+
+		// the entries older than the checkpointed ones are appended on replay.
+		ctx := user.InjectOrgID(context.Background(), userID)
+		_, err := ing.Push(ctx, &logproto.PushRequest{Streams: reversed(0, 100)})
+		require.NoError(t, err)
+
+		streams := reversed(0, 200)
+		for i := range streams {
+			sort.Slice(streams[i].Entries, func(j, k int) bool {
+				return streams[i].Entries[j].Timestamp.Before(streams[i].Entries[k].Timestamp)
+			})
+		}
+		testData[userID] = streams
+	}
+
+	recovered := crash(t, dir)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+
+	cfg := walTestConfig(t, recovered)
+	cfg.WAL.FlushOnShutdown = true
+	store, ing := newTestStoreWithLimits(t, cfg, limits)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+	store.checkData(t, testData)
+}
+
+func TestWAL_Shutdown(t *testing.T) {
+	dir := tempDir(t)
+	store, ing := newTestStore(t, walTestConfig(t, dir))
+	testData := pushTestSamples(t, ing)
+
+	// the chunks are checkpointed rather than flushed.
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+	require.Empty(t, store.chunks)
+
+	recoverAndFlush(t, dir).checkData(t, testData)
+
+	// nothing is left to recover once flushed.
+	store, ing = newTestStore(t, walTestConfig(t, dir))
+	require.Empty(t, ing.getInstances())
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+	require.Empty(t, store.chunks)
+}
+
+func TestWAL_Corruption(t *testing.T) {
+	dir := tempDir(t)
+	_, ing := newTestStore(t, walTestConfig(t, dir))
+	testData := pushTestSamples(t, ing)
+
+	recovered := crash(t, dir)
+	require.NoError(t, services.StopAndAwaitTerminated(context.Background(), ing))
+
+	// a torn write follows the records of the first segment.
+	f, err := os.OpenFile(wal.SegmentName(recovered, 0), os.O_APPEND|os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	corruptions := testutil.ToFloat64(walCorruptionsTotal)
+	recoverAndFlush(t, recovered).checkData(t, testData)
+	require.Equal(t, corruptions+1, testutil.ToFloat64(walCorruptionsTotal))
+}
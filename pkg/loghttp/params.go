@@ -48,6 +48,15 @@ func shards(r *http.Request) []string {
 	return r.Form["shards"]
 }
 
+// downstream returns true for the queries sent by the query frontend to the queriers.
+func downstream(r *http.Request) (bool, error) {
+	v := r.Form.Get("downstream")
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
 func bounds(r *http.Request) (time.Time, time.Time, error) {
 	now := time.Now()
 	start, err := parseTimestamp(r.Form.Get("start"), now.Add(-defaultSince))
@@ -37,6 +37,12 @@ type QueryResponse struct {
 	Data   QueryResponseData `json:"data"`
 }
 
+// FormatQueryResponse represents the http json response to a format query.
+type FormatQueryResponse struct {
+	Status string `json:"status"`
+	Data   string `json:"data"`
+}
+
 // PushRequest models a log stream push
 type PushRequest struct {
 	Streams []*Stream `json:"streams"`
@@ -98,10 +104,12 @@ type Stream struct {
 	Entries []Entry  `json:"values"`
 }
 
-//Entry represents a log entry.  It includes a log message and the time it occurred at.
+//Entry represents a log entry.  It includes a log message, the time it occurred at and its structured metadata.
+// Its layout must stay the one of logproto.Entry, see Streams.ToProto.
 type Entry struct {
-	Timestamp time.Time
-	Line      string
+	Timestamp          time.Time
+	Line               string
+	StructuredMetadata []logproto.LabelPair
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
@@ -153,30 +161,77 @@ func (q *QueryResponseData) UnmarshalJSON(data []byte) error {
 }
 
 // MarshalJSON implements the json.Marshaler interface.
+// The structured metadata, if any, is the third element of the entry as an object.
 func (e *Entry) MarshalJSON() ([]byte, error) {
 	l, err := json.Marshal(e.Line)
 	if err != nil {
 		return nil, err
 	}
-	return []byte(fmt.Sprintf("[\"%d\",%s]", e.Timestamp.UnixNano(), l)), nil
+	if len(e.StructuredMetadata) == 0 {
+		return []byte(fmt.Sprintf("[\"%d\",%s]", e.Timestamp.UnixNano(), l)), nil
+	}
+
+	stream := json.ConfigDefault.BorrowStream(nil)
+	defer json.ConfigDefault.ReturnStream(stream)
+	stream.WriteArrayStart()
+	stream.WriteString(strconv.FormatInt(e.Timestamp.UnixNano(), 10))
+	stream.WriteMore()
+	stream.WriteRaw(string(l))
+	stream.WriteMore()
+	stream.WriteObjectStart()
+	for i, m := range e.StructuredMetadata {
+		if i > 0 {
+			stream.WriteMore()
+		}
+		stream.WriteObjectField(m.Name)
+		stream.WriteString(m.Value)
+	}
+	stream.WriteObjectEnd()
+	stream.WriteArrayEnd()
+	if stream.Error != nil {
+		return nil, stream.Error
+	}
+	return append([]byte(nil), stream.Buffer()...), nil
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (e *Entry) UnmarshalJSON(data []byte) error {
-	var unmarshal []string
+	var unmarshal []json.RawMessage
 
 	err := json.Unmarshal(data, &unmarshal)
 	if err != nil {
 		return err
 	}
+	if len(unmarshal) < 2 || len(unmarshal) > 3 {
+		return fmt.Errorf("expected an entry of 2 or 3 values, got %d", len(unmarshal))
+	}
 
-	t, err := strconv.ParseInt(unmarshal[0], 10, 64)
+	var ts string
+	if err := json.Unmarshal(unmarshal[0], &ts); err != nil {
+		return err
+	}
+	t, err := strconv.ParseInt(ts, 10, 64)
 	if err != nil {
 		return err
 	}
-
+	if err := json.Unmarshal(unmarshal[1], &e.Line); err != nil {
+		return err
+	}
 	e.Timestamp = time.Unix(0, t)
-	e.Line = unmarshal[1]
+
+	e.StructuredMetadata = nil
+	if len(unmarshal) == 3 {
+		// The pairs keep the order of the object.
+		iter := json.ConfigDefault.BorrowIterator(unmarshal[2])
+		defer json.ConfigDefault.ReturnIterator(iter)
+		iter.ReadMapCB(func(iter *json.Iterator, name string) bool {
+			e.StructuredMetadata = append(e.StructuredMetadata, logproto.LabelPair{Name: name, Value: iter.ReadString()})
+			return true
+		})
+		if iter.Error != nil {
+			return iter.Error
+		}
+	}
 
 	return nil
 }
@@ -209,6 +264,9 @@ type InstantQuery struct {
 	Ts        time.Time
 	Limit     uint32
 	Direction logproto.Direction
+	Shards    []string
+	// Downstream is true for the queries of the query frontend, see logql.ParseOptions.
+	Downstream bool
 }
 
 // ParseInstantQuery parses an InstantQuery request from an http request.
@@ -232,6 +290,13 @@ func ParseInstantQuery(r *http.Request) (*InstantQuery, error) {
 		return nil, err
 	}
 
+	request.Shards = shards(r)
+
+	request.Downstream, err = downstream(r)
+	if err != nil {
+		return nil, err
+	}
+
 	return request, nil
 }
 
@@ -245,6 +310,8 @@ type RangeQuery struct {
 	Direction logproto.Direction
 	Limit     uint32
 	Shards    []string
+	// Downstream is true for the queries of the query frontend, see logql.ParseOptions.
+	Downstream bool
 }
 
 // ParseRangeQuery parses a RangeQuery request from an http request.
@@ -283,6 +350,11 @@ func ParseRangeQuery(r *http.Request) (*RangeQuery, error) {
 
 	result.Shards = shards(r)
 
+	result.Downstream, err = downstream(r)
+	if err != nil {
+		return nil, err
+	}
+
 	// For safety, limit the number of returned points per timeseries.
 	// This is sufficient for 60s resolution for a week or 1h resolution for a year.
 	if (result.End.Sub(result.Start) / result.Step) > 11000 {
@@ -98,6 +98,30 @@ func TestParseInstantQuery(t *testing.T) {
 				Ts:        time.Date(2017, 06, 10, 21, 42, 24, 760738998, time.UTC),
 				Limit:     1000,
 			}, false},
+		{"shards",
+			&http.Request{
+				URL: mustParseURL(`?query={foo="bar"}&time=2017-06-10T21:42:24.760738998Z&limit=1000&direction=BACKWARD&shards=0_of_2&shards=1_of_2`),
+			}, &InstantQuery{
+				Query:     `{foo="bar"}`,
+				Direction: logproto.BACKWARD,
+				Ts:        time.Date(2017, 06, 10, 21, 42, 24, 760738998, time.UTC),
+				Limit:     1000,
+				Shards:    []string{"0_of_2", "1_of_2"},
+			}, false},
+		{"downstream",
+			&http.Request{
+				URL: mustParseURL(`?query={foo="bar"}&time=2017-06-10T21:42:24.760738998Z&limit=1000&direction=BACKWARD&downstream=true`),
+			}, &InstantQuery{
+				Query:      `{foo="bar"}`,
+				Direction:  logproto.BACKWARD,
+				Ts:         time.Date(2017, 06, 10, 21, 42, 24, 760738998, time.UTC),
+				Limit:      1000,
+				Downstream: true,
+			}, false},
+		{"bad downstream",
+			&http.Request{
+				URL: mustParseURL(`?query={foo="bar"}&time=2017-06-10T21:42:24.760738998Z&limit=1000&direction=BACKWARD&downstream=yes`),
+			}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -142,7 +166,7 @@ func TestStreams_ToProto(t *testing.T) {
 				Labels: map[string]string{"foo": "bar", "lvl": "error"},
 				Entries: []Entry{
 					{Timestamp: time.Unix(0, 3), Line: "3"},
-					{Timestamp: time.Unix(0, 4), Line: "4"},
+					{Timestamp: time.Unix(0, 4), Line: "4", StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "a"}}},
 				},
 			},
 		},
@@ -158,7 +182,7 @@ func TestStreams_ToProto(t *testing.T) {
 					Labels: `{foo="bar", lvl="error"}`,
 					Entries: []logproto.Entry{
 						{Timestamp: time.Unix(0, 3), Line: "3"},
-						{Timestamp: time.Unix(0, 4), Line: "4"},
+						{Timestamp: time.Unix(0, 4), Line: "4", StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "a"}}},
 					},
 				},
 			},
@@ -172,3 +196,35 @@ func TestStreams_ToProto(t *testing.T) {
 		})
 	}
 }
+
+func TestEntry_JSON(t *testing.T) {
+	for _, tc := range []struct {
+		entry Entry
+		json  string
+	}{
+		{Entry{Timestamp: time.Unix(0, 1), Line: "line"}, `["1","line"]`},
+		{
+			Entry{
+				Timestamp: time.Unix(0, 2),
+				Line:      `"quoted" line`,
+				StructuredMetadata: []logproto.LabelPair{
+					{Name: "trace_id", Value: "3c5b1a"},
+					{Name: "pod_uid", Value: "8d0e"},
+				},
+			},
+			`["2","\"quoted\" line",{"trace_id":"3c5b1a","pod_uid":"8d0e"}]`,
+		},
+	} {
+		b, err := tc.entry.MarshalJSON()
+		require.NoError(t, err)
+		require.Equal(t, tc.json, string(b))
+
+		var e Entry
+		require.NoError(t, e.UnmarshalJSON(b))
+		require.Equal(t, tc.entry, e)
+	}
+
+	var e Entry
+	require.Error(t, e.UnmarshalJSON([]byte(`["1"]`)))
+	require.Error(t, e.UnmarshalJSON([]byte(`["1","line",{"trace_id":1}]`)))
+}
@@ -17,3 +17,13 @@ type Streams []Stream
 func (xs Streams) Len() int           { return len(xs) }
 func (xs Streams) Swap(i, j int)      { xs[i], xs[j] = xs[j], xs[i] }
 func (xs Streams) Less(i, j int) bool { return xs[i].Labels <= xs[j].Labels }
+
+// MetadataSize returns the number of bytes of the names and values of the
+// structured metadata of an entry.
+func MetadataSize(metadata []LabelPair) int {
+	size := 0
+	for _, m := range metadata {
+		size += len(m.Name) + len(m.Value)
+	}
+	return size
+}
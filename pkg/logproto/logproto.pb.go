@@ -406,8 +406,9 @@ func (m *StreamAdapter) GetEntries() []EntryAdapter {
 }
 
 type EntryAdapter struct {
-	Timestamp time.Time `protobuf:"bytes,1,opt,name=timestamp,proto3,stdtime" json:"ts"`
-	Line      string    `protobuf:"bytes,2,opt,name=line,proto3" json:"line"`
+	Timestamp          time.Time   `protobuf:"bytes,1,opt,name=timestamp,proto3,stdtime" json:"ts"`
+	Line               string      `protobuf:"bytes,2,opt,name=line,proto3" json:"line"`
+	StructuredMetadata []LabelPair `protobuf:"bytes,3,rep,name=structuredMetadata,proto3" json:"structuredMetadata,omitempty"`
 }
 
 func (m *EntryAdapter) Reset()      { *m = EntryAdapter{} }
@@ -456,6 +457,13 @@ func (m *EntryAdapter) GetLine() string {
 	return ""
 }
 
+func (m *EntryAdapter) GetStructuredMetadata() []LabelPair {
+	if m != nil {
+		return m.StructuredMetadata
+	}
+	return nil
+}
+
 type TailRequest struct {
 	Query    string    `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
 	DelayFor uint32    `protobuf:"varint,3,opt,name=delayFor,proto3" json:"delayFor,omitempty"`
@@ -1073,81 +1081,82 @@ func init() {
 func init() { proto.RegisterFile("pkg/logproto/logproto.proto", fileDescriptor_c28a5f14f1f4c79a) }
 
 var fileDescriptor_c28a5f14f1f4c79a = []byte{
-	// 1169 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0xcd, 0x6e, 0xdb, 0x46,
-	0x10, 0xd6, 0x4a, 0x14, 0x25, 0x8d, 0x7e, 0x22, 0x6c, 0x1c, 0x5b, 0x55, 0x5a, 0x4a, 0x20, 0x8a,
-	0x44, 0x68, 0x5d, 0xa9, 0x75, 0xff, 0x9c, 0xf4, 0x0f, 0x96, 0xdd, 0x20, 0x76, 0x0b, 0x24, 0xa1,
-	0x0d, 0x04, 0x08, 0x50, 0x04, 0xb4, 0xb9, 0x96, 0x09, 0x4b, 0xa4, 0x42, 0xae, 0x0c, 0xf8, 0xd6,
-	0x07, 0x68, 0x81, 0xdc, 0x7a, 0xc8, 0x0b, 0x14, 0x3d, 0xf4, 0x39, 0x7c, 0x2a, 0x7c, 0x0c, 0x7a,
-	0x50, 0x6b, 0xf9, 0x52, 0xf8, 0x94, 0x47, 0x28, 0xf6, 0x87, 0xe4, 0x4a, 0xb1, 0x8b, 0x2a, 0x17,
-	0x69, 0x67, 0x76, 0x66, 0x67, 0xe6, 0x9b, 0x6f, 0x76, 0x09, 0x37, 0x87, 0x87, 0xbd, 0x4e, 0xdf,
-	0xef, 0x0d, 0x03, 0x9f, 0xfa, 0xf1, 0xa2, 0xcd, 0x7f, 0x71, 0x3e, 0x92, 0xeb, 0x8d, 0x9e, 0xef,
-	0xf7, 0xfa, 0xa4, 0xc3, 0xa5, 0xdd, 0xd1, 0x7e, 0x87, 0xba, 0x03, 0x12, 0x52, 0x7b, 0x30, 0x14,
-	0xa6, 0xf5, 0x0f, 0x7a, 0x2e, 0x3d, 0x18, 0xed, 0xb6, 0xf7, 0xfc, 0x41, 0xa7, 0xe7, 0xf7, 0xfc,
-	0xc4, 0x92, 0x49, 0xe2, 0x74, 0xb6, 0x12, 0xe6, 0xe6, 0x63, 0x28, 0x3e, 0x1c, 0x85, 0x07, 0x16,
-	0x79, 0x36, 0x22, 0x21, 0xc5, 0xf7, 0x21, 0x17, 0xd2, 0x80, 0xd8, 0x83, 0xb0, 0x86, 0x9a, 0x99,
-	0x56, 0x71, 0x65, 0xa9, 0x1d, 0xa7, 0xb2, 0xcd, 0x37, 0xd6, 0x1c, 0x7b, 0x48, 0x49, 0xd0, 0xbd,
-	0xf1, 0xe7, 0xb8, 0xa1, 0x0b, 0xd5, 0xc5, 0xb8, 0x11, 0x79, 0x59, 0xd1, 0xc2, 0xac, 0x40, 0x49,
-	0x1c, 0x1c, 0x0e, 0x7d, 0x2f, 0x24, 0xe6, 0x8b, 0x34, 0x94, 0x1e, 0x8d, 0x48, 0x70, 0x1c, 0x85,
-	0xaa, 0x43, 0x3e, 0x24, 0x7d, 0xb2, 0x47, 0xfd, 0xa0, 0x86, 0x9a, 0xa8, 0x55, 0xb0, 0x62, 0x19,
-	0x2f, 0x40, 0xb6, 0xef, 0x0e, 0x5c, 0x5a, 0x4b, 0x37, 0x51, 0xab, 0x6c, 0x09, 0x01, 0xdf, 0x85,
-	0x6c, 0x48, 0xed, 0x80, 0xd6, 0x32, 0x4d, 0xd4, 0x2a, 0xae, 0xd4, 0xdb, 0x02, 0x8b, 0x76, 0x54,
-	0x61, 0x7b, 0x27, 0xc2, 0xa2, 0x9b, 0x3f, 0x19, 0x37, 0x52, 0xcf, 0xff, 0x6a, 0x20, 0x4b, 0xb8,
-	0xe0, 0xcf, 0x20, 0x43, 0x3c, 0xa7, 0xa6, 0xcd, 0xe1, 0xc9, 0x1c, 0xf0, 0x47, 0x50, 0x70, 0xdc,
-	0x80, 0xec, 0x51, 0xd7, 0xf7, 0x6a, 0xd9, 0x26, 0x6a, 0x55, 0x56, 0xae, 0x27, 0x90, 0x6c, 0x44,
-	0x5b, 0x56, 0x62, 0x85, 0x97, 0x41, 0x0f, 0x0f, 0xec, 0xc0, 0x09, 0x6b, 0xb9, 0x66, 0xa6, 0x55,
-	0xe8, 0x2e, 0x5c, 0x8c, 0x1b, 0x55, 0xa1, 0x59, 0xf6, 0x07, 0x2e, 0x25, 0x83, 0x21, 0x3d, 0xb6,
-	0xa4, 0xcd, 0x96, 0x96, 0xd7, 0xab, 0x39, 0xd3, 0x82, 0xb2, 0x04, 0x47, 0xc0, 0x85, 0xd7, 0xfe,
-	0x77, 0x23, 0x2a, 0x27, 0xe3, 0x06, 0x4a, 0x9a, 0x91, 0x74, 0xe0, 0x77, 0x04, 0xa5, 0xef, 0xed,
-	0x5d, 0xd2, 0x8f, 0x10, 0xc7, 0xa0, 0x79, 0xf6, 0x80, 0x48, 0xb4, 0xf9, 0x1a, 0x2f, 0x82, 0x7e,
-	0x64, 0xf7, 0x47, 0x24, 0xe4, 0x50, 0xe7, 0x2d, 0x29, 0xcd, 0x8b, 0x35, 0x7a, 0x63, 0xac, 0x51,
-	0x8c, 0xb5, 0x79, 0x1b, 0xca, 0x32, 0x5f, 0x09, 0x42, 0x92, 0x1c, 0xc3, 0xa0, 0x10, 0x25, 0x67,
-	0x1e, 0x41, 0x79, 0x0a, 0x03, 0x6c, 0x82, 0xde, 0x67, 0x9e, 0xa1, 0xa8, 0xad, 0x0b, 0x17, 0xe3,
-	0x86, 0xd4, 0x58, 0xf2, 0x9f, 0x21, 0x4a, 0x3c, 0x1a, 0xb8, 0xbc, 0x54, 0x86, 0xe8, 0x62, 0x82,
-	0xe8, 0xb7, 0x1e, 0x0d, 0x8e, 0x23, 0x40, 0xaf, 0x31, 0x06, 0x30, 0x4e, 0x4b, 0x73, 0x2b, 0x5a,
-	0x98, 0x47, 0x50, 0x52, 0x2d, 0xf1, 0x7d, 0x28, 0xc4, 0xe3, 0xc7, 0x23, 0xff, 0x77, 0xb9, 0x15,
-	0x79, 0x70, 0x9a, 0x86, 0xbc, 0xe8, 0xc4, 0x19, 0xbf, 0x0d, 0x5a, 0xdf, 0xf5, 0x08, 0x6f, 0x42,
-	0xa1, 0x9b, 0xbf, 0x18, 0x37, 0xb8, 0x6c, 0xf1, 0x5f, 0xf3, 0x17, 0x04, 0xc5, 0x1d, 0xdb, 0x8d,
-	0x1b, 0xb9, 0x00, 0xd9, 0x67, 0x8c, 0x2d, 0xb2, 0x93, 0x42, 0x60, 0x03, 0xe5, 0x90, 0xbe, 0x7d,
-	0x7c, 0xcf, 0x0f, 0x78, 0xd7, 0xca, 0x56, 0x2c, 0x27, 0x03, 0xa5, 0x5d, 0x3a, 0x50, 0xd9, 0xb9,
-	0x07, 0x6a, 0x4b, 0xcb, 0xa7, 0xab, 0x19, 0xf3, 0x27, 0x04, 0x25, 0x91, 0x99, 0x6c, 0xd9, 0x17,
-	0xa0, 0x0b, 0xfe, 0x49, 0x3c, 0xae, 0xa4, 0x2d, 0x28, 0x94, 0x95, 0x2e, 0xf8, 0x1b, 0xa8, 0x38,
-	0x81, 0x3f, 0x1c, 0x12, 0x67, 0x5b, 0x72, 0x3f, 0x3d, 0xcb, 0xfd, 0x0d, 0x75, 0xdf, 0x9a, 0x31,
-	0x37, 0x5f, 0x20, 0x28, 0x6f, 0x13, 0xde, 0x34, 0x09, 0x55, 0x5c, 0x22, 0x7a, 0xe3, 0x3b, 0x23,
-	0x3d, 0xef, 0x9d, 0xb1, 0x08, 0x7a, 0x2f, 0xf0, 0x47, 0xc3, 0xb0, 0x96, 0x11, 0xb4, 0x15, 0x92,
-	0xb9, 0x05, 0x95, 0x28, 0x39, 0x89, 0xd6, 0x2a, 0xe8, 0x21, 0xd7, 0xc8, 0x21, 0xaf, 0x2b, 0x68,
-	0x71, 0xfd, 0xa6, 0x43, 0x3c, 0xea, 0xee, 0xbb, 0x24, 0xe8, 0x6a, 0x2c, 0x88, 0x25, 0xed, 0xcd,
-	0x9f, 0x11, 0x54, 0x67, 0x4d, 0xf0, 0xd7, 0xca, 0x18, 0xb0, 0xe3, 0x6e, 0x5d, 0x7d, 0x5c, 0x9b,
-	0x4f, 0x5a, 0xc8, 0xe9, 0x1c, 0x8d, 0x48, 0xfd, 0x0e, 0x14, 0x15, 0x35, 0xae, 0x42, 0xe6, 0x90,
-	0x44, 0x24, 0x63, 0x4b, 0x46, 0x23, 0x3e, 0x82, 0x82, 0xa7, 0x96, 0x10, 0xee, 0xa6, 0x57, 0x11,
-	0xa3, 0x68, 0x79, 0xaa, 0x37, 0x78, 0x15, 0xb4, 0xfd, 0xc0, 0x1f, 0xcc, 0x05, 0x3c, 0xf7, 0xc0,
-	0x9f, 0x40, 0x9a, 0xfa, 0x73, 0xc1, 0x9e, 0xa6, 0x3e, 0x43, 0x5d, 0x16, 0x9f, 0xe1, 0xc9, 0x49,
-	0xc9, 0xfc, 0x0d, 0xc1, 0x35, 0xe6, 0x23, 0x10, 0x58, 0x3f, 0x18, 0x79, 0x87, 0xb8, 0x05, 0x55,
-	0x16, 0xe9, 0xa9, 0xeb, 0xf5, 0x48, 0x48, 0x49, 0xf0, 0xd4, 0x75, 0x64, 0x99, 0x15, 0xa6, 0xdf,
-	0x94, 0xea, 0x4d, 0x07, 0x2f, 0x41, 0x6e, 0x14, 0x0a, 0x03, 0x51, 0xb3, 0xce, 0xc4, 0x4d, 0x07,
-	0xbf, 0xaf, 0x84, 0x63, 0x58, 0x2b, 0xaf, 0x02, 0xc7, 0xf0, 0xa1, 0xed, 0x06, 0xf1, 0xdd, 0x73,
-	0x1b, 0xf4, 0x3d, 0x16, 0x38, 0xac, 0x69, 0xdc, 0xf8, 0x5a, 0x62, 0xcc, 0x13, 0xb2, 0xe4, 0xb6,
-	0xf9, 0x29, 0x14, 0x62, 0xef, 0x4b, 0xef, 0xeb, 0x4b, 0x3b, 0x60, 0xde, 0x84, 0xac, 0x28, 0x0c,
-	0x83, 0xe6, 0xd8, 0xd4, 0xe6, 0x2e, 0x25, 0x8b, 0xaf, 0xcd, 0x1a, 0x2c, 0xee, 0x04, 0xb6, 0x17,
-	0xee, 0x93, 0x80, 0x1b, 0xc5, 0xf4, 0x33, 0x6f, 0xc0, 0x75, 0x36, 0xbc, 0x24, 0x08, 0xd7, 0xfd,
-	0x91, 0x47, 0xe5, 0xcc, 0x98, 0xcb, 0xb0, 0x30, 0xad, 0x96, 0x6c, 0x5d, 0x80, 0xec, 0x1e, 0x53,
-	0xf0, 0xd3, 0xcb, 0x96, 0x10, 0xde, 0xbb, 0x05, 0x85, 0xf8, 0x19, 0xc4, 0x45, 0xc8, 0xdd, 0x7b,
-	0x60, 0x3d, 0x5e, 0xb3, 0x36, 0xaa, 0x29, 0x5c, 0x82, 0x7c, 0x77, 0x6d, 0xfd, 0x3b, 0x2e, 0xa1,
-	0x95, 0x35, 0xd0, 0xd9, 0x07, 0x01, 0x09, 0xf0, 0xe7, 0xa0, 0xb1, 0x15, 0xbe, 0x91, 0xa0, 0xa0,
-	0x7c, 0x83, 0xd4, 0x17, 0x67, 0xd5, 0x32, 0xdb, 0xd4, 0xca, 0x1f, 0x69, 0xc8, 0xb1, 0x67, 0x92,
-	0x71, 0xfd, 0x4b, 0xc8, 0xf2, 0x17, 0x13, 0x2b, 0xe6, 0xea, 0xf7, 0x45, 0x7d, 0xe9, 0x35, 0x7d,
-	0x74, 0xce, 0x87, 0x88, 0x5d, 0x0b, 0x1c, 0x67, 0xd5, 0x5b, 0x7d, 0x2b, 0x55, 0xef, 0xa9, 0x37,
-	0xc9, 0x4c, 0xe1, 0x3b, 0xa0, 0x31, 0x78, 0xd4, 0xf4, 0x95, 0xcb, 0x59, 0x4d, 0x5f, 0xbd, 0x19,
-	0x79, 0xd8, 0xaf, 0x40, 0x17, 0x34, 0xc4, 0x4b, 0xb3, 0xa3, 0x19, 0xb9, 0xd7, 0x5e, 0xdf, 0x88,
-	0x23, 0x3f, 0x10, 0x97, 0x6d, 0xd4, 0x18, 0xfc, 0xce, 0x74, 0xa8, 0x99, 0x3e, 0xd6, 0x8d, 0xab,
-	0xb6, 0x63, 0x40, 0x7f, 0x80, 0x7c, 0xc4, 0x75, 0xfc, 0x08, 0x2a, 0xd3, 0x34, 0xc1, 0x6f, 0x29,
-	0xfe, 0xd3, 0x03, 0x54, 0x6f, 0x2a, 0x5b, 0x97, 0x73, 0x2b, 0xd5, 0x42, 0xdd, 0x27, 0xa7, 0x67,
-	0x46, 0xea, 0xe5, 0x99, 0x91, 0x7a, 0x75, 0x66, 0xa0, 0x1f, 0x27, 0x06, 0xfa, 0x75, 0x62, 0xa0,
-	0x93, 0x89, 0x81, 0x4e, 0x27, 0x06, 0xfa, 0x7b, 0x62, 0xa0, 0x7f, 0x26, 0x46, 0xea, 0xd5, 0xc4,
-	0x40, 0xcf, 0xcf, 0x8d, 0xd4, 0xe9, 0xb9, 0x91, 0x7a, 0x79, 0x6e, 0xa4, 0x9e, 0xbc, 0xab, 0x7e,
-	0xc1, 0x06, 0xf6, 0xbe, 0xed, 0xd9, 0x9d, 0xbe, 0x7f, 0xe8, 0x76, 0xd4, 0x2f, 0xe4, 0x5d, 0x9d,
-	0xff, 0x7d, 0xfc, 0x6f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x02, 0x6d, 0x76, 0x3a, 0x38, 0x0b, 0x00,
-	0x00,
+	// 1197 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0x4d, 0x6f, 0xdb, 0x46,
+	0x13, 0xd6, 0x4a, 0x14, 0x25, 0x8d, 0x3e, 0x22, 0x6c, 0x1c, 0x99, 0x2f, 0x93, 0x97, 0x12, 0x88,
+	0x20, 0x11, 0xda, 0x54, 0x6a, 0xdd, 0xaf, 0x24, 0xfd, 0x82, 0x95, 0x34, 0x88, 0xd3, 0x16, 0x49,
+	0x68, 0x03, 0x01, 0x02, 0x14, 0x01, 0x2d, 0xae, 0x65, 0xc2, 0x12, 0xa9, 0x2c, 0x97, 0x01, 0x7c,
+	0xeb, 0x0f, 0x68, 0x81, 0xdc, 0x7a, 0xc8, 0x1f, 0x28, 0x7a, 0xe8, 0xef, 0xf0, 0xa9, 0xf0, 0x31,
+	0xe8, 0x41, 0xad, 0xe5, 0x4b, 0xe1, 0x53, 0x7e, 0x42, 0xb1, 0xcb, 0x25, 0x45, 0xc9, 0x36, 0x5a,
+	0xe5, 0x22, 0xed, 0xcc, 0xce, 0xec, 0xce, 0x3c, 0xfb, 0x3c, 0xcb, 0x85, 0xcb, 0xe3, 0xbd, 0x41,
+	0x77, 0xe8, 0x0f, 0xc6, 0xd4, 0x67, 0x7e, 0x32, 0xe8, 0x88, 0x5f, 0x5c, 0x8c, 0x6d, 0xbd, 0x39,
+	0xf0, 0xfd, 0xc1, 0x90, 0x74, 0x85, 0xb5, 0x1d, 0xee, 0x74, 0x99, 0x3b, 0x22, 0x01, 0xb3, 0x47,
+	0xe3, 0x28, 0x54, 0x7f, 0x6f, 0xe0, 0xb2, 0xdd, 0x70, 0xbb, 0xd3, 0xf7, 0x47, 0xdd, 0x81, 0x3f,
+	0xf0, 0x67, 0x91, 0xdc, 0x8a, 0x56, 0xe7, 0xa3, 0x28, 0xdc, 0x7c, 0x02, 0xe5, 0x47, 0x61, 0xb0,
+	0x6b, 0x91, 0xe7, 0x21, 0x09, 0x18, 0xbe, 0x0f, 0x85, 0x80, 0x51, 0x62, 0x8f, 0x02, 0x0d, 0xb5,
+	0x72, 0xed, 0xf2, 0xda, 0x6a, 0x27, 0x29, 0x65, 0x53, 0x4c, 0xac, 0x3b, 0xf6, 0x98, 0x11, 0xda,
+	0xbb, 0xf4, 0xc7, 0xa4, 0xa9, 0x46, 0xae, 0x93, 0x49, 0x33, 0xce, 0xb2, 0xe2, 0x81, 0x59, 0x83,
+	0x4a, 0xb4, 0x70, 0x30, 0xf6, 0xbd, 0x80, 0x98, 0xaf, 0xb2, 0x50, 0x79, 0x1c, 0x12, 0xba, 0x1f,
+	0x6f, 0xa5, 0x43, 0x31, 0x20, 0x43, 0xd2, 0x67, 0x3e, 0xd5, 0x50, 0x0b, 0xb5, 0x4b, 0x56, 0x62,
+	0xe3, 0x15, 0xc8, 0x0f, 0xdd, 0x91, 0xcb, 0xb4, 0x6c, 0x0b, 0xb5, 0xab, 0x56, 0x64, 0xe0, 0xdb,
+	0x90, 0x0f, 0x98, 0x4d, 0x99, 0x96, 0x6b, 0xa1, 0x76, 0x79, 0x4d, 0xef, 0x44, 0x58, 0x74, 0xe2,
+	0x0e, 0x3b, 0x5b, 0x31, 0x16, 0xbd, 0xe2, 0xc1, 0xa4, 0x99, 0x79, 0xf9, 0x67, 0x13, 0x59, 0x51,
+	0x0a, 0xfe, 0x04, 0x72, 0xc4, 0x73, 0x34, 0x65, 0x89, 0x4c, 0x9e, 0x80, 0x3f, 0x80, 0x92, 0xe3,
+	0x52, 0xd2, 0x67, 0xae, 0xef, 0x69, 0xf9, 0x16, 0x6a, 0xd7, 0xd6, 0x2e, 0xce, 0x20, 0xb9, 0x1b,
+	0x4f, 0x59, 0xb3, 0x28, 0x7c, 0x03, 0xd4, 0x60, 0xd7, 0xa6, 0x4e, 0xa0, 0x15, 0x5a, 0xb9, 0x76,
+	0xa9, 0xb7, 0x72, 0x32, 0x69, 0xd6, 0x23, 0xcf, 0x0d, 0x7f, 0xe4, 0x32, 0x32, 0x1a, 0xb3, 0x7d,
+	0x4b, 0xc6, 0x3c, 0x50, 0x8a, 0x6a, 0xbd, 0x60, 0x5a, 0x50, 0x95, 0xe0, 0x44, 0x70, 0xe1, 0xf5,
+	0xff, 0x7c, 0x10, 0xb5, 0x83, 0x49, 0x13, 0xcd, 0x0e, 0x63, 0x76, 0x02, 0xbf, 0x21, 0xa8, 0x7c,
+	0x6b, 0x6f, 0x93, 0x61, 0x8c, 0x38, 0x06, 0xc5, 0xb3, 0x47, 0x44, 0xa2, 0x2d, 0xc6, 0xb8, 0x01,
+	0xea, 0x0b, 0x7b, 0x18, 0x92, 0x40, 0x40, 0x5d, 0xb4, 0xa4, 0xb5, 0x2c, 0xd6, 0xe8, 0xad, 0xb1,
+	0x46, 0x09, 0xd6, 0xe6, 0x75, 0xa8, 0xca, 0x7a, 0x25, 0x08, 0xb3, 0xe2, 0x38, 0x06, 0xa5, 0xb8,
+	0x38, 0xf3, 0x05, 0x54, 0xe7, 0x30, 0xc0, 0x26, 0xa8, 0x43, 0x9e, 0x19, 0x44, 0xbd, 0xf5, 0xe0,
+	0x64, 0xd2, 0x94, 0x1e, 0x4b, 0xfe, 0x73, 0x44, 0x89, 0xc7, 0xa8, 0x2b, 0x5a, 0xe5, 0x88, 0x36,
+	0x66, 0x88, 0x7e, 0xed, 0x31, 0xba, 0x1f, 0x03, 0x7a, 0x81, 0x33, 0x80, 0x73, 0x5a, 0x86, 0x5b,
+	0xf1, 0xc0, 0x3c, 0x42, 0x50, 0x49, 0x87, 0xe2, 0xfb, 0x50, 0x4a, 0xf4, 0xa7, 0xa1, 0x7f, 0xed,
+	0xb7, 0x26, 0x57, 0xce, 0xb2, 0x40, 0x74, 0x3d, 0x4b, 0xc6, 0x57, 0x40, 0x19, 0xba, 0x1e, 0x11,
+	0xa7, 0x50, 0xea, 0x15, 0x4f, 0x26, 0x4d, 0x61, 0x5b, 0xe2, 0x17, 0xbb, 0x80, 0x03, 0x46, 0xc3,
+	0x3e, 0x0b, 0x29, 0x71, 0xbe, 0x23, 0xcc, 0x76, 0x6c, 0x66, 0x6b, 0x39, 0xd1, 0x46, 0x8a, 0x8e,
+	0x02, 0xbd, 0x47, 0xb6, 0x4b, 0x7b, 0x57, 0xe5, 0x4e, 0x57, 0x4e, 0xa7, 0xa5, 0x38, 0x78, 0xc6,
+	0xa2, 0xe6, 0xcf, 0x08, 0xca, 0x5b, 0xb6, 0x9b, 0x90, 0x66, 0x05, 0xf2, 0xcf, 0x39, 0x33, 0x25,
+	0x6b, 0x22, 0x83, 0x8b, 0xd7, 0x21, 0x43, 0x7b, 0xff, 0x9e, 0x4f, 0x05, 0x43, 0xaa, 0x56, 0x62,
+	0xcf, 0xc4, 0xab, 0x9c, 0x29, 0xde, 0xfc, 0xd2, 0xe2, 0x7d, 0xa0, 0x14, 0xb3, 0xf5, 0x9c, 0xf9,
+	0x23, 0x82, 0x4a, 0x54, 0x99, 0xa4, 0xc7, 0x67, 0xa0, 0x46, 0x5c, 0x97, 0xd0, 0x9f, 0x2b, 0x11,
+	0x48, 0xc9, 0x43, 0xa6, 0xe0, 0xaf, 0xa0, 0xe6, 0x50, 0x7f, 0x3c, 0x26, 0xce, 0xa6, 0xd4, 0x59,
+	0x76, 0x51, 0x67, 0x77, 0xd3, 0xf3, 0xd6, 0x42, 0xb8, 0xf9, 0x0a, 0x41, 0x75, 0x93, 0x08, 0x82,
+	0x48, 0xa8, 0x92, 0x16, 0xd1, 0x5b, 0xdf, 0x4f, 0xd9, 0x65, 0xef, 0xa7, 0x06, 0xa8, 0x03, 0xea,
+	0x87, 0xe3, 0x40, 0xb0, 0xa1, 0x64, 0x49, 0xcb, 0x7c, 0x00, 0xb5, 0xb8, 0x38, 0x89, 0xd6, 0x4d,
+	0x50, 0x03, 0xe1, 0x91, 0x17, 0x8a, 0x9e, 0x42, 0x4b, 0xf8, 0x37, 0x1c, 0xe2, 0x31, 0x77, 0xc7,
+	0x25, 0xb4, 0xa7, 0xf0, 0x4d, 0x2c, 0x19, 0x6f, 0xfe, 0x84, 0xa0, 0xbe, 0x18, 0x82, 0xbf, 0x4c,
+	0x49, 0x8e, 0x2f, 0x77, 0xed, 0xfc, 0xe5, 0x22, 0x5e, 0x06, 0x42, 0x39, 0xb1, 0x1c, 0xf5, 0x5b,
+	0x50, 0x4e, 0xb9, 0x71, 0x1d, 0x72, 0x7b, 0x24, 0x26, 0x19, 0x1f, 0x72, 0x1a, 0x09, 0xb9, 0x47,
+	0x92, 0xb0, 0x22, 0xe3, 0x76, 0xf6, 0x26, 0xe2, 0x14, 0xad, 0xce, 0x9d, 0x0d, 0xbe, 0x09, 0xca,
+	0x0e, 0xf5, 0x47, 0x4b, 0x01, 0x2f, 0x32, 0xf0, 0x47, 0x90, 0x65, 0xfe, 0x52, 0xb0, 0x67, 0x99,
+	0xcf, 0x51, 0x97, 0xcd, 0xe7, 0x44, 0x71, 0xd2, 0x32, 0x7f, 0x45, 0x70, 0x81, 0xe7, 0x44, 0x08,
+	0xdc, 0xd9, 0x0d, 0xbd, 0x3d, 0xdc, 0x86, 0x3a, 0xdf, 0xe9, 0x99, 0xeb, 0x0d, 0x48, 0xc0, 0x08,
+	0x7d, 0xe6, 0x3a, 0xb2, 0xcd, 0x1a, 0xf7, 0x6f, 0x48, 0xf7, 0x86, 0x83, 0x57, 0xa1, 0x10, 0x06,
+	0x51, 0x40, 0xd4, 0xb3, 0xca, 0xcd, 0x0d, 0x07, 0xbf, 0x9b, 0xda, 0xee, 0x3c, 0xc9, 0x27, 0xf7,
+	0xdc, 0x75, 0x50, 0xfb, 0x7c, 0xe3, 0x40, 0x53, 0x44, 0xf0, 0x85, 0x59, 0xb0, 0x28, 0xc8, 0x92,
+	0xd3, 0xe6, 0xc7, 0x50, 0x4a, 0xb2, 0xcf, 0xfc, 0x36, 0x9c, 0x79, 0x02, 0xe6, 0x65, 0xc8, 0x47,
+	0x8d, 0x61, 0x50, 0xc4, 0x35, 0xc4, 0x53, 0x2a, 0x96, 0x18, 0x9b, 0x1a, 0x34, 0xb6, 0xa8, 0xed,
+	0x05, 0x3b, 0x84, 0x8a, 0xa0, 0x84, 0x7e, 0xe6, 0x25, 0xb8, 0xc8, 0xc5, 0x4b, 0x68, 0x70, 0xc7,
+	0x0f, 0x3d, 0x26, 0x35, 0x63, 0xde, 0x80, 0x95, 0x79, 0xb7, 0x64, 0xeb, 0x0a, 0xe4, 0xfb, 0xdc,
+	0x21, 0x56, 0xaf, 0x5a, 0x91, 0xf1, 0xce, 0x35, 0x28, 0x25, 0x9f, 0x5c, 0x5c, 0x86, 0xc2, 0xbd,
+	0x87, 0xd6, 0x93, 0x75, 0xeb, 0x6e, 0x3d, 0x83, 0x2b, 0x50, 0xec, 0xad, 0xdf, 0xf9, 0x46, 0x58,
+	0x68, 0x6d, 0x1d, 0x54, 0xfe, 0xf8, 0x20, 0x14, 0x7f, 0x0a, 0x0a, 0x1f, 0xe1, 0x4b, 0x33, 0x14,
+	0x52, 0xef, 0x1d, 0xbd, 0xb1, 0xe8, 0x96, 0xd5, 0x66, 0xd6, 0x7e, 0xcf, 0x42, 0x81, 0x7f, 0x92,
+	0x39, 0xd7, 0x3f, 0x87, 0xfc, 0x63, 0x71, 0xed, 0xa5, 0xc2, 0xd3, 0x6f, 0x19, 0x7d, 0xf5, 0x94,
+	0x3f, 0x5e, 0xe7, 0x7d, 0xc4, 0xaf, 0x05, 0x81, 0x73, 0x3a, 0x3b, 0xfd, 0x5d, 0xd6, 0x57, 0x4f,
+	0xf9, 0xe3, 0x6c, 0x7c, 0x0b, 0x14, 0x0e, 0x4f, 0xba, 0xfc, 0xd4, 0xe5, 0xac, 0x37, 0x16, 0xdd,
+	0xa9, 0x6d, 0xbf, 0x00, 0x35, 0xa2, 0x21, 0x5e, 0x5d, 0x94, 0x66, 0x9c, 0xae, 0x9d, 0x9e, 0x48,
+	0x76, 0x7e, 0x08, 0x95, 0xf4, 0xc1, 0xe0, 0xff, 0xcf, 0x6f, 0xb5, 0x70, 0x8e, 0xba, 0x71, 0xde,
+	0x74, 0x02, 0xe8, 0xf7, 0x50, 0x8c, 0xb9, 0x8e, 0x1f, 0x43, 0x6d, 0x9e, 0x26, 0xf8, 0x7f, 0xa9,
+	0xfc, 0x79, 0x01, 0xe9, 0xad, 0xd4, 0xd4, 0xd9, 0xdc, 0xca, 0xb4, 0x51, 0xef, 0xe9, 0xe1, 0x91,
+	0x91, 0x79, 0x7d, 0x64, 0x64, 0xde, 0x1c, 0x19, 0xe8, 0x87, 0xa9, 0x81, 0x7e, 0x99, 0x1a, 0xe8,
+	0x60, 0x6a, 0xa0, 0xc3, 0xa9, 0x81, 0xfe, 0x9a, 0x1a, 0xe8, 0xef, 0xa9, 0x91, 0x79, 0x33, 0x35,
+	0xd0, 0xcb, 0x63, 0x23, 0x73, 0x78, 0x6c, 0x64, 0x5e, 0x1f, 0x1b, 0x99, 0xa7, 0x57, 0xd3, 0xaf,
+	0x65, 0x6a, 0xef, 0xd8, 0x9e, 0xdd, 0x1d, 0xfa, 0x7b, 0x6e, 0x37, 0xfd, 0x1a, 0xdf, 0x56, 0xc5,
+	0xdf, 0x87, 0xff, 0x0c, 0x00, 0xac, 0x62, 0xf9, 0xef, 0xa4, 0x0b, 0x00, 0x00,
 }
 
 func (x Direction) String() string {
@@ -1407,6 +1416,14 @@ func (this *EntryAdapter) Equal(that interface{}) bool {
 	if this.Line != that1.Line {
 		return false
 	}
+	if len(this.StructuredMetadata) != len(that1.StructuredMetadata) {
+		return false
+	}
+	for i := range this.StructuredMetadata {
+		if !this.StructuredMetadata[i].Equal(&that1.StructuredMetadata[i]) {
+			return false
+		}
+	}
 	return true
 }
 func (this *TailRequest) Equal(that interface{}) bool {
@@ -1849,10 +1866,17 @@ func (this *EntryAdapter) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 6)
+	s := make([]string, 0, 7)
 	s = append(s, "&logproto.EntryAdapter{")
 	s = append(s, "Timestamp: "+fmt.Sprintf("%#v", this.Timestamp)+",\n")
 	s = append(s, "Line: "+fmt.Sprintf("%#v", this.Line)+",\n")
+	if this.StructuredMetadata != nil {
+		vs := make([]*LabelPair, len(this.StructuredMetadata))
+		for i := range vs {
+			vs[i] = &this.StructuredMetadata[i]
+		}
+		s = append(s, "StructuredMetadata: "+fmt.Sprintf("%#v", vs)+",\n")
+	}
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -2735,6 +2759,18 @@ func (m *EntryAdapter) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintLogproto(dAtA, i, uint64(len(m.Line)))
 		i += copy(dAtA[i:], m.Line)
 	}
+	if len(m.StructuredMetadata) > 0 {
+		for _, msg := range m.StructuredMetadata {
+			dAtA[i] = 0x1a
+			i++
+			i = encodeVarintLogproto(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
 	return i, nil
 }
 
@@ -3288,6 +3324,12 @@ func (m *EntryAdapter) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovLogproto(uint64(l))
 	}
+	if len(m.StructuredMetadata) > 0 {
+		for _, e := range m.StructuredMetadata {
+			l = e.Size()
+			n += 1 + l + sovLogproto(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -3586,6 +3628,7 @@ func (this *EntryAdapter) String() string {
 	s := strings.Join([]string{`&EntryAdapter{`,
 		`Timestamp:` + strings.Replace(strings.Replace(this.Timestamp.String(), "Timestamp", "types.Timestamp", 1), `&`, ``, 1) + `,`,
 		`Line:` + fmt.Sprintf("%v", this.Line) + `,`,
+		`StructuredMetadata:` + strings.Replace(strings.Replace(fmt.Sprintf("%v", this.StructuredMetadata), "LabelPair", "LabelPair", 1), `&`, ``, 1) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -4661,6 +4704,40 @@ func (m *EntryAdapter) Unmarshal(dAtA []byte) error {
 			}
 			m.Line = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StructuredMetadata", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowLogproto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthLogproto
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthLogproto
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.StructuredMetadata = append(m.StructuredMetadata, LabelPair{})
+			if err := m.StructuredMetadata[len(m.StructuredMetadata)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipLogproto(dAtA[iNdEx:])
@@ -13,19 +13,19 @@ var (
 	stream = Stream{
 		Labels: `{job="foobar", cluster="foo-central1", namespace="bar", container_name="buzz"}`,
 		Entries: []Entry{
-			{now, line},
-			{now.Add(1 * time.Second), line},
-			{now.Add(2 * time.Second), line},
-			{now.Add(3 * time.Second), line},
+			{Timestamp: now, Line: line},
+			{Timestamp: now.Add(1 * time.Second), Line: line},
+			{Timestamp: now.Add(2 * time.Second), Line: line, StructuredMetadata: []LabelPair{{Name: "trace_id", Value: "3c5b1a"}}},
+			{Timestamp: now.Add(3 * time.Second), Line: line},
 		},
 	}
 	streamAdapter = StreamAdapter{
 		Labels: `{job="foobar", cluster="foo-central1", namespace="bar", container_name="buzz"}`,
 		Entries: []EntryAdapter{
-			{now, line},
-			{now.Add(1 * time.Second), line},
-			{now.Add(2 * time.Second), line},
-			{now.Add(3 * time.Second), line},
+			{Timestamp: now, Line: line},
+			{Timestamp: now.Add(1 * time.Second), Line: line},
+			{Timestamp: now.Add(2 * time.Second), Line: line, StructuredMetadata: []LabelPair{{Name: "trace_id", Value: "3c5b1a"}}},
+			{Timestamp: now.Add(3 * time.Second), Line: line},
 		},
 	}
 )
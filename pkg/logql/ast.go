@@ -3,10 +3,12 @@ package logql
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
@@ -48,8 +50,11 @@ type Querier interface {
 
 // LogSelectorExpr is a LogQL expression filtering and returning logs.
 type LogSelectorExpr interface {
+	// Filter returns the line filter to apply on raw chunk data, before any pipeline stage.
 	Filter() (LineFilter, error)
 	Matchers() []*labels.Matcher
+	// Pipeline returns the stages to apply on each entry that passed the Filter.
+	Pipeline() (Pipeline, error)
 	Expr
 }
 
@@ -82,6 +87,10 @@ func (e *matchersExpr) Filter() (LineFilter, error) {
 	return nil, nil
 }
 
+func (e *matchersExpr) Pipeline() (Pipeline, error) {
+	return nil, nil
+}
+
 // impl Expr
 func (e *matchersExpr) logQLExpr() {}
 
@@ -89,6 +98,8 @@ type filterExpr struct {
 	left  LogSelectorExpr
 	ty    labels.MatchType
 	match string
+	// fn is the optional filter function applied to match, e.g ip or nocase.
+	fn string
 }
 
 // NewFilterExpr wraps an existing Expr with a next filter expression.
@@ -100,6 +111,19 @@ func NewFilterExpr(left LogSelectorExpr, ty labels.MatchType, match string) LogS
 	}
 }
 
+// mustNewFilterFuncExpr wraps an existing Expr with a filter function expression such as `|= ip("10.0.0.0/8")`.
+func mustNewFilterFuncExpr(left LogSelectorExpr, ty labels.MatchType, fn, match string) LogSelectorExpr {
+	if _, err := newFuncFilter(fn, match, ty); err != nil {
+		panic(newParseError(err.Error(), 0, 0))
+	}
+	return &filterExpr{
+		left:  left,
+		ty:    ty,
+		match: match,
+		fn:    fn,
+	}
+}
+
 func (e *filterExpr) Matchers() []*labels.Matcher {
 	return e.left.Matchers()
 }
@@ -117,12 +141,31 @@ func (e *filterExpr) String() string {
 	case labels.MatchNotEqual:
 		sb.WriteString("!=")
 	}
+	if e.fn != "" {
+		sb.WriteString(e.fn)
+		sb.WriteString("(")
+		sb.WriteString(strconv.Quote(e.match))
+		sb.WriteString(")")
+		return sb.String()
+	}
 	sb.WriteString(strconv.Quote(e.match))
 	return sb.String()
 }
 
+// lineFilter returns the line filter of this expression only.
+func (e *filterExpr) lineFilter() (LineFilter, error) {
+	if e.fn != "" {
+		return newFuncFilter(e.fn, e.match, e.ty)
+	}
+	return newFilter(e.match, e.ty)
+}
+
 func (e *filterExpr) Filter() (LineFilter, error) {
-	f, err := newFilter(e.match, e.ty)
+	// filters following a pipeline stage are applied by the pipeline.
+	if followsStage(e) {
+		return e.left.Filter()
+	}
+	f, err := e.lineFilter()
 	if err != nil {
 		return nil, err
 	}
@@ -143,9 +186,319 @@ func (e *filterExpr) Filter() (LineFilter, error) {
 	return f, nil
 }
 
+func (e *filterExpr) Pipeline() (Pipeline, error) {
+	if !followsStage(e) {
+		return nil, nil
+	}
+	p, err := e.left.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	f, err := e.lineFilter()
+	if err != nil {
+		return nil, err
+	}
+	return append(p, newLineFilterStage(f)), nil
+}
+
 // impl Expr
 func (e *filterExpr) logQLExpr() {}
 
+// followsStage returns true if a pipeline stage precedes the given expression.
+func followsStage(e LogSelectorExpr) bool {
+	for {
+		switch ex := e.(type) {
+		case *filterExpr:
+			e = ex.left
+		case stageExpr:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// stageExpr is a LogSelectorExpr adding a Stage to the pipeline of its left expression.
+type stageExpr interface {
+	LogSelectorExpr
+	stage() (Stage, error)
+}
+
+// pipelineOf returns the pipeline of the left expression of a stageExpr, with the stage appended.
+func pipelineOf(left LogSelectorExpr, e stageExpr) (Pipeline, error) {
+	p, err := left.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	s, err := e.stage()
+	if err != nil {
+		return nil, err
+	}
+	return append(p, s), nil
+}
+
+// HasMergedStages returns true if the pipeline of the log selector contains stages which must process
+// the entries of all sources merged, such as `distinct`. Such queries can't be split or sharded.
+func HasMergedStages(expr LogSelectorExpr) bool {
+	p, err := expr.Pipeline()
+	if err != nil {
+		return false
+	}
+	_, merged := p.Split()
+	return len(merged) > 0
+}
+
+type labelParserExpr struct {
+	left  LogSelectorExpr
+	op    string
+	param string
+}
+
+func mustNewLabelParserExpr(op, param string) *labelParserExpr {
+	// validates the parser parameters at parse time.
+	if _, err := newLabelParser(op, param); err != nil {
+		panic(newParseError(err.Error(), 0, 0))
+	}
+	return &labelParserExpr{
+		op:    op,
+		param: param,
+	}
+}
+
+func newLabelParserExpr(left LogSelectorExpr, p *labelParserExpr) LogSelectorExpr {
+	p.left = left
+	return p
+}
+
+func (e *labelParserExpr) Matchers() []*labels.Matcher {
+	return e.left.Matchers()
+}
+
+func (e *labelParserExpr) Filter() (LineFilter, error) {
+	return e.left.Filter()
+}
+
+func (e *labelParserExpr) Pipeline() (Pipeline, error) {
+	return pipelineOf(e.left, e)
+}
+
+func (e *labelParserExpr) stage() (Stage, error) {
+	return newLabelParser(e.op, e.param)
+}
+
+func (e *labelParserExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString(e.left.String())
+	sb.WriteString(" | ")
+	sb.WriteString(e.op)
+	if e.param != "" {
+		sb.WriteString(" ")
+		sb.WriteString(strconv.Quote(e.param))
+	}
+	return sb.String()
+}
+
+// impl Expr
+func (e *labelParserExpr) logQLExpr() {}
+
+type labelFilterExpr struct {
+	left   LogSelectorExpr
+	filter LabelFilterer
+}
+
+func newLabelFilterExpr(left LogSelectorExpr, filter LabelFilterer) LogSelectorExpr {
+	return &labelFilterExpr{
+		left:   left,
+		filter: filter,
+	}
+}
+
+func (e *labelFilterExpr) Matchers() []*labels.Matcher {
+	return e.left.Matchers()
+}
+
+func (e *labelFilterExpr) Filter() (LineFilter, error) {
+	return e.left.Filter()
+}
+
+func (e *labelFilterExpr) Pipeline() (Pipeline, error) {
+	return pipelineOf(e.left, e)
+}
+
+func (e *labelFilterExpr) stage() (Stage, error) {
+	return e.filter, nil
+}
+
+func (e *labelFilterExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString(e.left.String())
+	sb.WriteString(" | ")
+	sb.WriteString(e.filter.String())
+	return sb.String()
+}
+
+// impl Expr
+func (e *labelFilterExpr) logQLExpr() {}
+
+type lineFormatExpr struct {
+	left     LogSelectorExpr
+	template string
+}
+
+func mustNewLineFormatExpr(left LogSelectorExpr, tmpl string) LogSelectorExpr {
+	if _, err := NewLineFormatter(tmpl); err != nil {
+		panic(newParseError(err.Error(), 0, 0))
+	}
+	return &lineFormatExpr{
+		left:     left,
+		template: tmpl,
+	}
+}
+
+func (e *lineFormatExpr) Matchers() []*labels.Matcher {
+	return e.left.Matchers()
+}
+
+func (e *lineFormatExpr) Filter() (LineFilter, error) {
+	return e.left.Filter()
+}
+
+func (e *lineFormatExpr) Pipeline() (Pipeline, error) {
+	return pipelineOf(e.left, e)
+}
+
+func (e *lineFormatExpr) stage() (Stage, error) {
+	return NewLineFormatter(e.template)
+}
+
+func (e *lineFormatExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString(e.left.String())
+	sb.WriteString(" | ")
+	sb.WriteString(OpFmtLine)
+	sb.WriteString(" ")
+	sb.WriteString(strconv.Quote(e.template))
+	return sb.String()
+}
+
+// impl Expr
+func (e *lineFormatExpr) logQLExpr() {}
+
+type labelFormatExpr struct {
+	left    LogSelectorExpr
+	formats []labelFmt
+}
+
+func mustNewLabelFormatExpr(left LogSelectorExpr, fmts []labelFmt) LogSelectorExpr {
+	if _, err := NewLabelsFormatter(fmts); err != nil {
+		panic(newParseError(err.Error(), 0, 0))
+	}
+	return &labelFormatExpr{
+		left:    left,
+		formats: fmts,
+	}
+}
+
+func (e *labelFormatExpr) Matchers() []*labels.Matcher {
+	return e.left.Matchers()
+}
+
+func (e *labelFormatExpr) Filter() (LineFilter, error) {
+	return e.left.Filter()
+}
+
+func (e *labelFormatExpr) Pipeline() (Pipeline, error) {
+	return pipelineOf(e.left, e)
+}
+
+func (e *labelFormatExpr) stage() (Stage, error) {
+	return NewLabelsFormatter(e.formats)
+}
+
+func (e *labelFormatExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString(e.left.String())
+	sb.WriteString(" | ")
+	sb.WriteString(OpFmtLabel)
+	sb.WriteString(" ")
+	for i, f := range e.formats {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(f.String())
+	}
+	return sb.String()
+}
+
+// impl Expr
+func (e *labelFormatExpr) logQLExpr() {}
+
+type decolorizeExpr struct {
+	left LogSelectorExpr
+}
+
+func newDecolorizeExpr(left LogSelectorExpr) LogSelectorExpr {
+	return &decolorizeExpr{left: left}
+}
+
+func (e *decolorizeExpr) Matchers() []*labels.Matcher {
+	return e.left.Matchers()
+}
+
+func (e *decolorizeExpr) Filter() (LineFilter, error) {
+	return e.left.Filter()
+}
+
+func (e *decolorizeExpr) Pipeline() (Pipeline, error) {
+	return pipelineOf(e.left, e)
+}
+
+func (e *decolorizeExpr) stage() (Stage, error) {
+	return NewDecolorizer(), nil
+}
+
+func (e *decolorizeExpr) String() string {
+	return e.left.String() + " | " + OpDecolorize
+}
+
+// impl Expr
+func (e *decolorizeExpr) logQLExpr() {}
+
+type distinctExpr struct {
+	left  LogSelectorExpr
+	label string
+}
+
+func newDistinctExpr(left LogSelectorExpr, label string) LogSelectorExpr {
+	return &distinctExpr{
+		left:  left,
+		label: label,
+	}
+}
+
+func (e *distinctExpr) Matchers() []*labels.Matcher {
+	return e.left.Matchers()
+}
+
+func (e *distinctExpr) Filter() (LineFilter, error) {
+	return e.left.Filter()
+}
+
+func (e *distinctExpr) Pipeline() (Pipeline, error) {
+	return pipelineOf(e.left, e)
+}
+
+func (e *distinctExpr) stage() (Stage, error) {
+	return NewDistinctFilter(e.label), nil
+}
+
+func (e *distinctExpr) String() string {
+	return e.left.String() + " | " + OpDistinct + " " + e.label
+}
+
+// impl Expr
+func (e *distinctExpr) logQLExpr() {}
+
 func mustNewMatcher(t labels.MatchType, n, v string) *labels.Matcher {
 	m, err := labels.NewMatcher(t, n, v)
 	if err != nil {
@@ -154,9 +507,37 @@ func mustNewMatcher(t labels.MatchType, n, v string) *labels.Matcher {
 	return m
 }
 
+// unwrapExpr selects the label to use as sample value, with an optional conversion function.
+type unwrapExpr struct {
+	identifier string
+	operation  string
+}
+
+func mustNewUnwrapExpr(identifier, operation string) *unwrapExpr {
+	switch operation {
+	case "", OpConvDuration, OpConvBytes:
+	default:
+		panic(newParseError(fmt.Sprintf("unsupported unwrap conversion function: %s", operation), 0, 0))
+	}
+	return &unwrapExpr{
+		identifier: identifier,
+		operation:  operation,
+	}
+}
+
+// impls Stringer
+func (u unwrapExpr) String() string {
+	if u.operation != "" {
+		return fmt.Sprintf(" | %s %s(%s)", OpUnwrap, u.operation, u.identifier)
+	}
+	return fmt.Sprintf(" | %s %s", OpUnwrap, u.identifier)
+}
+
 type logRange struct {
 	left     LogSelectorExpr
 	interval time.Duration
+	unwrap   *unwrapExpr
+	offset   time.Duration
 }
 
 // impls Stringer
@@ -165,17 +546,58 @@ func (r logRange) String() string {
 	sb.WriteString("(")
 	sb.WriteString(r.left.String())
 	sb.WriteString(")")
-	sb.WriteString(fmt.Sprintf("[%v]", model.Duration(r.interval)))
+	if r.unwrap != nil {
+		sb.WriteString(r.unwrap.String())
+	}
+	sb.WriteString(fmt.Sprintf("[%s]", formatDuration(r.interval)))
+	if r.offset != 0 {
+		sb.WriteString(fmt.Sprintf(" %s %s", OpOffset, formatDuration(r.offset)))
+	}
 	return sb.String()
 }
 
-func newLogRange(left LogSelectorExpr, interval time.Duration) *logRange {
+// formatDuration formats the duration of a range or an offset. Durations which are not a whole
+// number of milliseconds, e.g. the sub-ranges of split instant queries, are written in nanoseconds.
+func formatDuration(d time.Duration) string {
+	if d%time.Millisecond != 0 {
+		return fmt.Sprintf("%dns", d.Nanoseconds())
+	}
+	return model.Duration(d).String()
+}
+
+func newLogRange(left LogSelectorExpr, interval time.Duration, u *unwrapExpr) *logRange {
+	if HasMergedStages(left) {
+		panic(newParseError(fmt.Sprintf("%s is only supported in log queries", OpDistinct), 0, 0))
+	}
 	return &logRange{
 		left:     left,
 		interval: interval,
+		unwrap:   u,
 	}
 }
 
+func addUnwrapToLogRangeExpr(left *logRange, u *unwrapExpr) *logRange {
+	if left.unwrap != nil {
+		panic(newParseError("unwrap can only be used once per range", 0, 0))
+	}
+	left.unwrap = u
+	return left
+}
+
+// mustNewOffsetExpr parses the duration of an offset modifier.
+func mustNewOffsetExpr(value string) time.Duration {
+	d, err := parseDuration(value)
+	if err != nil {
+		panic(newParseError(fmt.Sprintf("unable to parse offset: %s", err.Error()), 0, 0))
+	}
+	return d
+}
+
+func addOffsetToLogRangeExpr(left *logRange, offset time.Duration) *logRange {
+	left.offset = offset
+	return left
+}
+
 func addFilterToLogRangeExpr(left *logRange, ty labels.MatchType, match string) *logRange {
 	left.left = &filterExpr{
 		left:  left.left,
@@ -185,6 +607,60 @@ func addFilterToLogRangeExpr(left *logRange, ty labels.MatchType, match string)
 	return left
 }
 
+func addFilterFuncToLogRangeExpr(left *logRange, ty labels.MatchType, fn, match string) *logRange {
+	left.left = mustNewFilterFuncExpr(left.left, ty, fn, match)
+	return left
+}
+
+func addLabelParserToLogRangeExpr(left *logRange, p *labelParserExpr) *logRange {
+	left.left = newLabelParserExpr(left.left, p)
+	return left
+}
+
+func addLabelFilterToLogRangeExpr(left *logRange, filter LabelFilterer) *logRange {
+	left.left = newLabelFilterExpr(left.left, filter)
+	return left
+}
+
+func addLineFormatToLogRangeExpr(left *logRange, tmpl string) *logRange {
+	left.left = mustNewLineFormatExpr(left.left, tmpl)
+	return left
+}
+
+func addDecolorizeToLogRangeExpr(left *logRange) *logRange {
+	left.left = newDecolorizeExpr(left.left)
+	return left
+}
+
+func addLabelFormatToLogRangeExpr(left *logRange, fmts []labelFmt) *logRange {
+	left.left = mustNewLabelFormatExpr(left.left, fmts)
+	return left
+}
+
+func mustNewDurationLabelFilter(op, name, value string) LabelFilterer {
+	d, err := parseDuration(value)
+	if err != nil {
+		panic(newParseError(fmt.Sprintf("unable to parse duration: %s", err.Error()), 0, 0))
+	}
+	return newDurationLabelFilter(op, name, d)
+}
+
+func mustNewBytesLabelFilter(op, name, value string) LabelFilterer {
+	b, err := humanize.ParseBytes(value)
+	if err != nil {
+		panic(newParseError(fmt.Sprintf("unable to parse bytes: %s", err.Error()), 0, 0))
+	}
+	return newBytesLabelFilter(op, name, b)
+}
+
+func mustNewNumericLabelFilter(op, name, value string) LabelFilterer {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic(newParseError(fmt.Sprintf("unable to parse number: %s", err.Error()), 0, 0))
+	}
+	return newNumericLabelFilter(op, name, n)
+}
+
 const (
 	// vector ops
 	OpTypeSum     = "sum"
@@ -197,11 +673,25 @@ const (
 	OpTypeBottomK = "bottomk"
 	OpTypeTopK    = "topk"
 
+	OpTypeCountValues = "count_values"
+	OpTypeSort        = "sort"
+	OpTypeSortDesc    = "sort_desc"
+
 	// range vector ops
 	OpRangeTypeCount     = "count_over_time"
 	OpRangeTypeRate      = "rate"
 	OpRangeTypeBytes     = "bytes_over_time"
 	OpRangeTypeBytesRate = "bytes_rate"
+	OpRangeTypeSum       = "sum_over_time"
+	OpRangeTypeAvg       = "avg_over_time"
+	OpRangeTypeMax       = "max_over_time"
+	OpRangeTypeMin       = "min_over_time"
+	OpRangeTypeStddev    = "stddev_over_time"
+	OpRangeTypeStdvar    = "stdvar_over_time"
+	OpRangeTypeQuantile  = "quantile_over_time"
+	OpRangeTypeAbsent    = "absent_over_time"
+	OpRangeTypeFirst     = "first_over_time"
+	OpRangeTypeLast      = "last_over_time"
 
 	// binops - logical/set
 	OpTypeOr     = "or"
@@ -223,6 +713,45 @@ const (
 	OpTypeGTE   = ">="
 	OpTypeLT    = "<"
 	OpTypeLTE   = "<="
+
+	// line filter functions
+	OpFilterIP     = "ip"
+	OpFilterNoCase = "nocase"
+
+	// parsers
+	OpParserTypeJSON    = "json"
+	OpParserTypeLogfmt  = "logfmt"
+	OpParserTypeRegexp  = "regexp"
+	OpParserTypePattern = "pattern"
+	OpParserTypeUnpack  = "unpack"
+
+	// formatters
+	OpFmtLine    = "line_format"
+	OpFmtLabel   = "label_format"
+	OpDecolorize = "decolorize"
+
+	// distinct filter
+	OpDistinct = "distinct"
+
+	// unwrap and its conversion functions
+	OpUnwrap       = "unwrap"
+	OpConvDuration = "duration"
+	OpConvBytes    = "bytes"
+
+	// range modifiers
+	OpOffset = "offset"
+
+	// subqueries
+	OpSubquery = "subquery"
+
+	// functions
+	OpLabelReplace = "label_replace"
+
+	// vector matching
+	OpOn         = "on"
+	OpIgnoring   = "ignoring"
+	OpGroupLeft  = "group_left"
+	OpGroupRight = "group_right"
 )
 
 func IsComparisonOperator(op string) bool {
@@ -256,6 +785,8 @@ type SampleExpr interface {
 type rangeAggregationExpr struct {
 	left      *logRange
 	operation string
+
+	params *float64
 }
 
 func newRangeAggregationExpr(left *logRange, operation string) SampleExpr {
@@ -265,6 +796,47 @@ func newRangeAggregationExpr(left *logRange, operation string) SampleExpr {
 	}
 }
 
+func mustNewRangeAggregationExpr(left *logRange, operation string, params *string) SampleExpr {
+	var p *float64
+	if params != nil {
+		if operation != OpRangeTypeQuantile {
+			panic(newParseError(fmt.Sprintf("unsupported parameter for operation %s(%s,", operation, *params), 0, 0))
+		}
+		f, err := strconv.ParseFloat(*params, 64)
+		if err != nil {
+			panic(newParseError(fmt.Sprintf("invalid parameter for operation %s: %s", operation, err), 0, 0))
+		}
+		p = &f
+	} else if operation == OpRangeTypeQuantile {
+		panic(newParseError(fmt.Sprintf("parameter required for operation %s", operation), 0, 0))
+	}
+	if err := validateRangeUnwrap(operation, left.unwrap); err != nil {
+		panic(newParseError(err.Error(), 0, 0))
+	}
+	return &rangeAggregationExpr{
+		left:      left,
+		operation: operation,
+		params:    p,
+	}
+}
+
+// validateRangeUnwrap ensures unwrap is used only and always with operations aggregating sample values.
+func validateRangeUnwrap(operation string, u *unwrapExpr) error {
+	switch operation {
+	case OpRangeTypeCount, OpRangeTypeRate, OpRangeTypeBytes, OpRangeTypeBytesRate:
+		if u != nil {
+			return fmt.Errorf("invalid aggregation %s with unwrap", operation)
+		}
+	case OpRangeTypeAbsent:
+		// absent_over_time works with and without unwrap.
+	default:
+		if u == nil {
+			return fmt.Errorf("invalid aggregation %s without unwrap", operation)
+		}
+	}
+	return nil
+}
+
 func (e *rangeAggregationExpr) Selector() LogSelectorExpr {
 	return e.left.left
 }
@@ -274,6 +846,9 @@ func (e *rangeAggregationExpr) logQLExpr() {}
 
 // impls Stringer
 func (e *rangeAggregationExpr) String() string {
+	if e.params != nil {
+		return formatOperation(e.operation, nil, strconv.FormatFloat(*e.params, 'f', -1, 64), e.left.String())
+	}
 	return formatOperation(e.operation, nil, e.left.String())
 }
 
@@ -282,6 +857,100 @@ func (e *rangeAggregationExpr) Operations() []string {
 	return []string{e.operation}
 }
 
+// subqueryExpr evaluates a sample expression at a fixed resolution over a range, e.g `rate({app="foo"}[1m])[1h:1m]`.
+type subqueryExpr struct {
+	left     SampleExpr
+	interval time.Duration
+	step     time.Duration
+	offset   time.Duration
+}
+
+func newSubqueryExpr(left SampleExpr, r subqueryRange) *subqueryExpr {
+	return &subqueryExpr{
+		left:     left,
+		interval: r.interval,
+		step:     r.step,
+	}
+}
+
+func addOffsetToSubqueryExpr(left *subqueryExpr, offset time.Duration) *subqueryExpr {
+	left.offset = offset
+	return left
+}
+
+// impls Stringer
+func (e subqueryExpr) String() string {
+	var sb strings.Builder
+	if _, ok := e.left.(*binOpExpr); ok {
+		sb.WriteString("(")
+		sb.WriteString(e.left.String())
+		sb.WriteString(")")
+	} else {
+		sb.WriteString(e.left.String())
+	}
+	sb.WriteString(fmt.Sprintf("[%v:%v]", model.Duration(e.interval), model.Duration(e.step)))
+	if e.offset != 0 {
+		sb.WriteString(fmt.Sprintf(" %s %v", OpOffset, model.Duration(e.offset)))
+	}
+	return sb.String()
+}
+
+// subqueryAggregationExpr is a range aggregation over the samples of a subquery.
+type subqueryAggregationExpr struct {
+	subquery  *subqueryExpr
+	operation string
+
+	params *float64
+}
+
+func mustNewSubqueryAggregationExpr(subquery *subqueryExpr, operation string, params *string) SampleExpr {
+	if _, ok := subquery.left.(*literalExpr); ok {
+		panic(newParseError("unexpected literal for subquery", 0, 0))
+	}
+	switch operation {
+	case OpRangeTypeRate, OpRangeTypeBytes, OpRangeTypeBytesRate, OpRangeTypeAbsent:
+		panic(newParseError(fmt.Sprintf("invalid aggregation %s over a subquery", operation), 0, 0))
+	}
+	var p *float64
+	if params != nil {
+		if operation != OpRangeTypeQuantile {
+			panic(newParseError(fmt.Sprintf("unsupported parameter for operation %s(%s,", operation, *params), 0, 0))
+		}
+		f, err := strconv.ParseFloat(*params, 64)
+		if err != nil {
+			panic(newParseError(fmt.Sprintf("invalid parameter for operation %s: %s", operation, err), 0, 0))
+		}
+		p = &f
+	} else if operation == OpRangeTypeQuantile {
+		panic(newParseError(fmt.Sprintf("parameter required for operation %s", operation), 0, 0))
+	}
+	return &subqueryAggregationExpr{
+		subquery:  subquery,
+		operation: operation,
+		params:    p,
+	}
+}
+
+func (e *subqueryAggregationExpr) Selector() LogSelectorExpr {
+	return e.subquery.left.Selector()
+}
+
+// impl Expr
+func (e *subqueryAggregationExpr) logQLExpr() {}
+
+// impls Stringer
+func (e *subqueryAggregationExpr) String() string {
+	if e.params != nil {
+		return formatOperation(e.operation, nil, strconv.FormatFloat(*e.params, 'f', -1, 64), e.subquery.String())
+	}
+	return formatOperation(e.operation, nil, e.subquery.String())
+}
+
+// impl SampleExpr
+func (e *subqueryAggregationExpr) Operations() []string {
+	return append(e.subquery.left.Operations(), OpSubquery, e.operation)
+}
+
 type grouping struct {
 	groups  []string
 	without bool
@@ -296,7 +965,8 @@ func (g grouping) String() string {
 		sb.WriteString(" by")
 	}
 
-	if len(g.groups) > 0 {
+	// without() keeps every label, the parentheses can't be omitted.
+	if g.without || len(g.groups) > 0 {
 		sb.WriteString("(")
 		sb.WriteString(strings.Join(g.groups, ","))
 		sb.WriteString(")")
@@ -311,6 +981,8 @@ type vectorAggregationExpr struct {
 	grouping  *grouping
 	params    int
 	operation string
+	// label is the name of the label holding the counted values for count_values.
+	label string
 }
 
 func mustNewVectorAggregationExpr(left SampleExpr, operation string, gr *grouping, params *string) SampleExpr {
@@ -324,7 +996,13 @@ func mustNewVectorAggregationExpr(left SampleExpr, operation string, gr *groupin
 		if p, err = strconv.Atoi(*params); err != nil {
 			panic(newParseError(fmt.Sprintf("invalid parameter %s(%s,", operation, *params), 0, 0))
 		}
-
+	case OpTypeCountValues:
+		panic(newParseError(fmt.Sprintf("label name parameter required for operation %s", operation), 0, 0))
+	case OpTypeSort, OpTypeSortDesc:
+		if gr != nil {
+			panic(newParseError(fmt.Sprintf("grouping not allowed for operation %s", operation), 0, 0))
+		}
+		fallthrough
 	default:
 		if params != nil {
 			panic(newParseError(fmt.Sprintf("unsupported parameter for operation %s(%s,", operation, *params), 0, 0))
@@ -341,6 +1019,25 @@ func mustNewVectorAggregationExpr(left SampleExpr, operation string, gr *groupin
 	}
 }
 
+// mustNewLabelParamVectorAggregationExpr creates a vector aggregation taking a label name as parameter, e.g count_values.
+func mustNewLabelParamVectorAggregationExpr(left SampleExpr, operation string, gr *grouping, label string) SampleExpr {
+	if operation != OpTypeCountValues {
+		panic(newParseError(fmt.Sprintf("unsupported parameter for operation %s(%q,", operation, label), 0, 0))
+	}
+	if !model.LabelName(label).IsValid() {
+		panic(newParseError(fmt.Sprintf("invalid label name in %s: %s", operation, label), 0, 0))
+	}
+	if gr == nil {
+		gr = &grouping{}
+	}
+	return &vectorAggregationExpr{
+		left:      left,
+		operation: operation,
+		grouping:  gr,
+		label:     label,
+	}
+}
+
 func (e *vectorAggregationExpr) Selector() LogSelectorExpr {
 	return e.left.Selector()
 }
@@ -352,6 +1049,8 @@ func (e *vectorAggregationExpr) String() string {
 	var params []string
 	if e.params != 0 {
 		params = []string{fmt.Sprintf("%d", e.params), e.left.String()}
+	} else if e.operation == OpTypeCountValues {
+		params = []string{strconv.Quote(e.label), e.left.String()}
 	} else {
 		params = []string{e.left.String()}
 	}
@@ -363,8 +1062,148 @@ func (e *vectorAggregationExpr) Operations() []string {
 	return append(e.left.Operations(), e.operation)
 }
 
+// labelReplaceExpr sets the dst label to the replacement if the regex matches the value of the src label.
+type labelReplaceExpr struct {
+	left        SampleExpr
+	dst         string
+	replacement string
+	src         string
+	regex       string
+	re          *regexp.Regexp
+}
+
+func mustNewLabelReplaceExpr(left SampleExpr, dst, replacement, src, regex string) *labelReplaceExpr {
+	if _, ok := left.(*literalExpr); ok {
+		panic(newParseError(fmt.Sprintf("unexpected literal for %s", OpLabelReplace), 0, 0))
+	}
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		panic(newParseError(fmt.Sprintf("invalid regex in %s: %s", OpLabelReplace, err.Error()), 0, 0))
+	}
+	if !model.LabelName(dst).IsValid() {
+		panic(newParseError(fmt.Sprintf("invalid destination label name in %s: %s", OpLabelReplace, dst), 0, 0))
+	}
+	return &labelReplaceExpr{
+		left:        left,
+		dst:         dst,
+		replacement: replacement,
+		src:         src,
+		regex:       regex,
+		re:          re,
+	}
+}
+
+func (e *labelReplaceExpr) Selector() LogSelectorExpr {
+	return e.left.Selector()
+}
+
+// impl Expr
+func (e *labelReplaceExpr) logQLExpr() {}
+
+// impl SampleExpr
+func (e *labelReplaceExpr) Operations() []string {
+	return append(e.left.Operations(), OpLabelReplace)
+}
+
+// impls Stringer
+func (e *labelReplaceExpr) String() string {
+	return formatOperation(
+		OpLabelReplace,
+		nil,
+		e.left.String(),
+		strconv.Quote(e.dst),
+		strconv.Quote(e.replacement),
+		strconv.Quote(e.src),
+		strconv.Quote(e.regex),
+	)
+}
+
 type BinOpOptions struct {
-	ReturnBool bool
+	ReturnBool     bool
+	VectorMatching *VectorMatching
+}
+
+// VectorMatchCardinality describes the cardinality relationship
+// of two vectors in a binary operation.
+type VectorMatchCardinality int
+
+const (
+	CardOneToOne VectorMatchCardinality = iota
+	CardManyToOne
+	CardOneToMany
+	CardManyToMany
+)
+
+func (vmc VectorMatchCardinality) String() string {
+	switch vmc {
+	case CardOneToOne:
+		return "one-to-one"
+	case CardManyToOne:
+		return "many-to-one"
+	case CardOneToMany:
+		return "one-to-many"
+	case CardManyToMany:
+		return "many-to-many"
+	}
+	panic("logql.VectorMatchCardinality.String: unknown match cardinality")
+}
+
+// VectorMatching describes how elements from two vectors in a binary
+// operation are supposed to be matched.
+type VectorMatching struct {
+	// The cardinality of the two vectors.
+	Card VectorMatchCardinality
+	// MatchingLabels contains the labels which define equality of a pair of
+	// elements from the vectors.
+	MatchingLabels []string
+	// On includes the given label names from matching,
+	// rather than excluding them.
+	On bool
+	// Include contains additional labels that should be included in
+	// the result from the side with the lower cardinality.
+	Include []string
+}
+
+// impls Stringer
+func (m VectorMatching) String() string {
+	var sb strings.Builder
+	if m.On {
+		sb.WriteString(OpOn)
+	} else {
+		sb.WriteString(OpIgnoring)
+	}
+	sb.WriteString("(")
+	sb.WriteString(strings.Join(m.MatchingLabels, ","))
+	sb.WriteString(")")
+
+	switch m.Card {
+	case CardManyToOne:
+		sb.WriteString(" " + OpGroupLeft)
+	case CardOneToMany:
+		sb.WriteString(" " + OpGroupRight)
+	default:
+		return sb.String()
+	}
+	// the include list is always written so that a parenthesized right leg can't be mistaken for it.
+	sb.WriteString("(")
+	sb.WriteString(strings.Join(m.Include, ","))
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func newOnOrIgnoringModifier(opts BinOpOptions, on bool, matching []string) BinOpOptions {
+	opts.VectorMatching = &VectorMatching{
+		Card:           CardOneToOne,
+		MatchingLabels: matching,
+		On:             on,
+	}
+	return opts
+}
+
+func newGroupModifier(opts BinOpOptions, card VectorMatchCardinality, include []string) BinOpOptions {
+	opts.VectorMatching.Card = card
+	opts.VectorMatching.Include = include
+	return opts
 }
 
 type binOpExpr struct {
@@ -375,10 +1214,14 @@ type binOpExpr struct {
 }
 
 func (e *binOpExpr) String() string {
+	op := e.op
 	if e.opts.ReturnBool {
-		return fmt.Sprintf("%s %s bool %s", e.SampleExpr.String(), e.op, e.RHS.String())
+		op += " bool"
+	}
+	if e.opts.VectorMatching != nil {
+		op += " " + e.opts.VectorMatching.String()
 	}
-	return fmt.Sprintf("%s %s %s", e.SampleExpr.String(), e.op, e.RHS.String())
+	return fmt.Sprintf("%s %s %s", e.SampleExpr.String(), op, e.RHS.String())
 }
 
 // impl SampleExpr
@@ -427,6 +1270,26 @@ func mustNewBinOpExpr(op string, opts BinOpOptions, lhs, rhs Expr) SampleExpr {
 		}
 	}
 
+	if opts.VectorMatching != nil {
+		if lOk || rOk {
+			panic(newParseError(fmt.Sprintf(
+				"vector matching is only allowed between two vectors in binary operation (%s)",
+				op,
+			), 0, 0))
+		}
+		if IsLogicalBinOp(op) {
+			if opts.VectorMatching.Card != CardOneToOne {
+				panic(newParseError(fmt.Sprintf("no grouping allowed for %s operation", op), 0, 0))
+			}
+			opts.VectorMatching.Card = CardManyToMany
+		}
+		for _, l := range opts.VectorMatching.Include {
+			if opts.VectorMatching.On && contains(opts.VectorMatching.MatchingLabels, l) {
+				panic(newParseError(fmt.Sprintf("label %s must not occur in on and group clause at once", l), 0, 0))
+			}
+		}
+	}
+
 	// map expr like (1+1) -> 2
 	if lOk && rOk {
 		return reduceBinOp(op, leftLit, rightLit)
@@ -486,6 +1349,7 @@ func (e *literalExpr) Selector() LogSelectorExpr   { return e }
 func (e *literalExpr) Operations() []string        { return nil }
 func (e *literalExpr) Filter() (LineFilter, error) { return nil, nil }
 func (e *literalExpr) Matchers() []*labels.Matcher { return nil }
+func (e *literalExpr) Pipeline() (Pipeline, error) { return nil, nil }
 
 // helper used to impl Stringer for vector and range aggregations
 // nolint:interfacer
@@ -507,3 +1371,13 @@ func formatOperation(op string, grouping *grouping, params ...string) string {
 	sb.WriteString(")")
 	return sb.String()
 }
+
+// contains returns true if x is one of xs.
+func contains(xs []string, x string) bool {
+	for _, s := range xs {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
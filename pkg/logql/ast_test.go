@@ -25,6 +25,7 @@ func Test_logSelectorExpr_String(t *testing.T) {
 		{`{foo="bar", bar!="baz"} |~ ".*"`, false},
 		{`{foo="bar", bar!="baz"} |= "" |= ""`, false},
 		{`{foo="bar", bar!="baz"} |~ "" |= "" |~ ".*"`, false},
+		{`{foo="bar"} |= ip("10.0.0.0/8") != nocase("Debug")`, true},
 	}
 
 	for _, tt := range tests {
@@ -69,6 +70,41 @@ func Test_SampleExpr_String(t *testing.T) {
 		/
 			count_over_time({namespace="tns"}[5m])
 		)`,
+		`sum by (status) (count_over_time({job="nginx"} | json [5m]))`,
+		`rate({job="app"} |= "error" | logfmt |= "timeout" [1m])`,
+		`count_over_time({job="app"}[1m] | logfmt != "debug")`,
+		`{job="app"} | logfmt | status >= 500 and (duration > 1m30s or size <= 1.5MB)`,
+		`{job="app"} | json | (method="GET" or method=~"P.*") and status == 200`,
+		`sum by (status) (rate({job="app"} | logfmt | latency > 250ms |= "timeout" [1m]))`,
+		"{job=\"nginx\"} | regexp `(?P<method>\\w+) (?P<path>[^ ]+)` | method=\"GET\"",
+		`count_over_time({job="nginx"} | regexp "(?P<status>\\d{3})" | status >= 500 [5m])`,
+		`sum by (method) (count_over_time({job="nginx"} | pattern "<_> \"<method> <path> <_>\" <status> <_>" | status >= 500 [5m]))`,
+		`{job="app"} | logfmt | line_format "{{.level | ToUpper}} {{.msg}}" |= "ERROR"`,
+		`sum by (svc) (rate({job="app"} | json | label_format svc=service,env="{{.cluster}}-{{.namespace}}" [1m]))`,
+		`sum_over_time({job="app"} | logfmt | unwrap latency [5m])`,
+		`count_over_time({job="app"}[5m] offset 1d)`,
+		`label_replace(sum by (app) (rate({job="app"}[5m])), "svc", "$1", "app", "(.*)-.*")`,
+		`absent_over_time({job="app", env="prod"} |= "error" [5m])`,
+		`sum by (svc) (label_replace(absent_over_time({job="app"}[5m]), "svc", "api", "", ""))`,
+		`count_values("value", rate({job="app"}[5m])) by (app)`,
+		`count_values by (app) ("value", rate({job="app"}[5m]))`,
+		`sort_desc(sum by (app) (rate({job="app"}[5m])))`,
+		`last_over_time({job="app"} | logfmt | unwrap queue_depth [5m])`,
+		`max_over_time(first_over_time({job="app"} | logfmt | unwrap queue_depth [1m])[1h:1m])`,
+		`max_over_time(rate({job="app"} |= "error" [1m])[1h:1m])`,
+		`quantile_over_time(0.99, sum by (app) (rate({job="app"}[1m]))[1d:5m] offset 1w)`,
+		`avg_over_time((sum(rate({job="app"}[1m])) / sum(rate({job="other"}[1m])))[1h:1m])`,
+		`sum by (app, status) (rate({job="app"}[5m])) / ignoring(status) group_left sum by (app) (rate({job="app"}[5m]))`,
+		`sum by (app) (rate({job="app"}[5m])) * on(app) group_right(team) sum by (app, team) (rate({job="teams"}[5m]))`,
+		`rate({job="app"}[5m]) > bool on(app, env) rate({job="other"}[5m])`,
+		`rate({job="app"}[5m]) unless ignoring(pod) rate({job="other"}[5m])`,
+		`sum(rate({job="app"}[5m])) / sum(rate({job="app"}[5m] offset 1w))`,
+		`avg_over_time({job="app"} | logfmt | unwrap latency [5m] offset 2h)`,
+		`quantile_over_time(0.99, {job="app"} | json | status >= 500 | unwrap duration(latency) [5m])`,
+		`max by (path) (max_over_time({job="app"}[1m] | json | unwrap bytes(size)))`,
+		`stddev_over_time({job="app"} | logfmt | unwrap latency [5m]) / avg_over_time({job="app"} | logfmt | unwrap latency [5m])`,
+		`{job="app"} | decolorize | unpack | distinct trace`,
+		`count_over_time({job="app"} | decolorize | unpack [5m])`,
 	} {
 		t.Run(tc, func(t *testing.T) {
 			expr, err := ParseExpr(tc)
@@ -79,6 +115,23 @@ func Test_SampleExpr_String(t *testing.T) {
 			require.Equal(t, expr, expr2)
 		})
 	}
+
+	// expressions written by the query frontend for the downstream queries.
+	for _, tc := range []string{
+		`sum without () (count_over_time({job="app"}[5m]))`,
+		`sum by () (count_over_time({job="app"}[5m]))`,
+		`count_over_time({job="app"}[3600000000001ns] offset 3599999999999ns)`,
+	} {
+		t.Run(tc, func(t *testing.T) {
+			opts := ParseOptions{Downstream: true}
+			expr, err := ParseExprWithOptions(tc, opts)
+			require.Nil(t, err)
+
+			expr2, err := ParseExprWithOptions(expr.String(), opts)
+			require.Nil(t, err)
+			require.Equal(t, expr, expr2)
+		})
+	}
 }
 
 func Test_NilFilterDoesntPanic(t *testing.T) {
@@ -103,6 +156,77 @@ func Test_NilFilterDoesntPanic(t *testing.T) {
 
 }
 
+func Test_FilterAndPipeline(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		q string
+
+		// line to test against the pushed down filter and the pipeline
+		line           string
+		expectFilter   bool
+		expectPipeline int
+		expectKept     bool
+		expectLabels   labels.Labels
+	}{
+		{
+			`{app="foo"} | json`,
+			`{"level":"error"}`,
+			false, 1, true,
+			labels.Labels{{Name: "app", Value: "foo"}, {Name: "level", Value: "error"}},
+		},
+		{
+			`{app="foo"} |= "error" | logfmt`,
+			`level=error msg="foo bar"`,
+			true, 1, true,
+			labels.Labels{{Name: "app", Value: "foo"}, {Name: "level", Value: "error"}, {Name: "msg", Value: "foo bar"}},
+		},
+		{
+			`{app="foo"} |= "error" | logfmt != "bar"`,
+			`level=error msg="foo bar"`,
+			true, 2, false,
+			nil,
+		},
+		{
+			`{app="foo"} | logfmt |= "foo" | json`,
+			`level=error msg="foo bar"`,
+			false, 3, true,
+			labels.Labels{{Name: ErrorLabel, Value: errJSON}, {Name: "app", Value: "foo"}, {Name: "level", Value: "error"}, {Name: "msg", Value: "foo bar"}},
+		},
+		{
+			`{app="foo"} | logfmt | label_format lvl=level | line_format "{{.lvl}}: {{.msg}}" |= "error: foo"`,
+			`level=error msg="foo bar"`,
+			false, 4, true,
+			labels.Labels{{Name: "app", Value: "foo"}, {Name: "lvl", Value: "error"}, {Name: "msg", Value: "foo bar"}},
+		},
+	} {
+		tt := tt
+		t.Run(tt.q, func(t *testing.T) {
+			t.Parallel()
+			expr, err := ParseLogSelector(tt.q)
+			require.NoError(t, err)
+
+			f, err := expr.Filter()
+			require.NoError(t, err)
+			require.Equal(t, tt.expectFilter, f != nil)
+			if f != nil {
+				require.True(t, f.Filter([]byte(tt.line)))
+			}
+
+			p, err := expr.Pipeline()
+			require.NoError(t, err)
+			require.Len(t, p, tt.expectPipeline)
+
+			b := NewLabelsBuilder()
+			b.Reset(labels.Labels{{Name: "app", Value: "foo"}})
+			_, ok := p.Process([]byte(tt.line), b)
+			require.Equal(t, tt.expectKept, ok)
+			if ok {
+				require.Equal(t, tt.expectLabels, b.Labels())
+			}
+		})
+	}
+}
+
 type linecheck struct {
 	l string
 	e bool
@@ -169,6 +293,13 @@ func Test_FilterMatcher(t *testing.T) {
 			},
 			[]linecheck{{"foo", true}, {"bar", false}, {"foobar", true}},
 		},
+		{
+			`{app="foo"} |= ip("10.0.0.0/8") != nocase("debug")`,
+			[]*labels.Matcher{
+				mustNewMatcher(labels.MatchEqual, "app", "foo"),
+			},
+			[]linecheck{{"level=info client=10.1.2.3", true}, {"level=DEBUG client=10.1.2.3", false}, {"client=192.168.0.1", false}},
+		},
 	} {
 		tt := tt
 		t.Run(tt.q, func(t *testing.T) {
@@ -92,12 +92,18 @@ func NewEngine(opts EngineOpts, q Querier) *Engine {
 
 // Query creates a new LogQL query. Instant/Range type is derived from the parameters.
 func (ng *Engine) Query(params Params) Query {
+	return ng.QueryWithOptions(params, ParseOptions{})
+}
+
+// QueryWithOptions creates a new LogQL query whose expression is parsed with the given options,
+// e.g. the downstream queries of the query frontend.
+func (ng *Engine) QueryWithOptions(params Params, opts ParseOptions) Query {
 	return &query{
 		timeout:   ng.timeout,
 		params:    params,
 		evaluator: ng.evaluator,
 		parse: func(_ context.Context, query string) (Expr, error) {
-			return ParseExpr(query)
+			return ParseExprWithOptions(query, opts)
 		},
 	}
 }
@@ -193,7 +199,13 @@ func (q *query) evalSample(ctx context.Context, expr SampleExpr) (parser.Value,
 
 	next, ts, vec := stepEvaluator.Next()
 	if GetRangeType(q.params) == InstantType {
-		sort.Slice(vec, func(i, j int) bool { return labels.Compare(vec[i].Metric, vec[j].Metric) < 0 })
+		if err := stepEvaluator.Error(); err != nil {
+			return nil, err
+		}
+		// sort and sort_desc results are returned in the order of their values.
+		if !isSortExpr(expr) {
+			sort.Slice(vec, func(i, j int) bool { return labels.Compare(vec[i].Metric, vec[j].Metric) < 0 })
+		}
 		return vec, nil
 	}
 
@@ -221,6 +233,10 @@ func (q *query) evalSample(ctx context.Context, expr SampleExpr) (parser.Value,
 		next, ts, vec = stepEvaluator.Next()
 	}
 
+	if err := stepEvaluator.Error(); err != nil {
+		return nil, err
+	}
+
 	series := make([]promql.Series, 0, len(seriesIndex))
 	for _, s := range seriesIndex {
 		series = append(series, *s)
@@ -230,6 +246,14 @@ func (q *query) evalSample(ctx context.Context, expr SampleExpr) (parser.Value,
 	return result, nil
 }
 
+// isSortExpr returns true if the expression orders its result by value.
+func isSortExpr(expr SampleExpr) bool {
+	if e, ok := expr.(*vectorAggregationExpr); ok {
+		return e.operation == OpTypeSort || e.operation == OpTypeSortDesc
+	}
+	return false
+}
+
 func (q *query) evalLiteral(_ context.Context, expr *literalExpr) (parser.Value, error) {
 	s := promql.Scalar{
 		T: q.params.Start().UnixNano() / int64(time.Millisecond),
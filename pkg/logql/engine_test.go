@@ -87,6 +87,111 @@ func TestEngine_InstantQuery(t *testing.T) {
 			},
 			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 6}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}}},
 		},
+		{
+			`sum_over_time({app="foo"} | regexp "(?P<v>\\d+)" | unwrap v [1m])`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, identity, `{app="foo"}`)}, // 1, 2 .. 60
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"} | regexp "(?P<v>\\d+)"`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 1830}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}}},
+		},
+		{
+			`quantile_over_time(0.5, {app="foo"} | regexp "(?P<v>\\d+)" | unwrap v [1m])`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, identity, `{app="foo"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"} | regexp "(?P<v>\\d+)"`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 30.5}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}}},
+		},
+		{
+			`last_over_time({app="foo"} | regexp "(?P<v>\\d+)" | unwrap v [1m])`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, identity, `{app="foo"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"} | regexp "(?P<v>\\d+)"`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 60}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}}},
+		},
+		{
+			`first_over_time({app="foo"} | regexp "(?P<v>\\d+)" | unwrap v [1m])`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, identity, `{app="foo"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"} | regexp "(?P<v>\\d+)"`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 1}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}}},
+		},
+		{
+			`max_over_time({app="foo"}[1m] | logfmt | unwrap v)`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, func(i int64) logproto.Entry {
+					return logproto.Entry{Timestamp: time.Unix(i, 0), Line: fmt.Sprintf("v=%d", i)}
+				}), `{app="foo"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"} | logfmt`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 60}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}}},
+		},
+		{
+			`count_over_time({app="foo"}[1m] offset 1m)`, time.Unix(2*60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, identity), `{app="foo"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"}`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 120 * 1000, V: 6}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}}},
+		},
+		{
+			`sum by (app, status) (count_over_time({app="foo"}[1m])) / ignoring(status) group_left sum by (app) (count_over_time({app="foo"}[1m]))`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, identity, `{app="foo", status="200"}`), newStream(testSize, factor(10, identity), `{app="foo", status="500"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"}`}},
+			},
+			promql.Vector{
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 60. / 66.}, Metric: labels.Labels{{Name: "app", Value: "foo"}, {Name: "status", Value: "200"}}},
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 6. / 66.}, Metric: labels.Labels{{Name: "app", Value: "foo"}, {Name: "status", Value: "500"}}},
+			},
+		},
+		{
+			`sum_over_time(count_over_time({app="foo"}[30s])[2m:30s])`, time.Unix(120, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, identity), `{app="foo"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(120, 0), Limit: 0, Selector: `{app="foo"}`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 120 * 1000, V: 12}, Metric: labels.Labels{{Name: "app", Value: "foo"}}}},
+		},
+		{
+			`label_replace(count_over_time({app="foo-api"}[1m]), "svc", "$1", "app", "foo-(.*)")`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, identity), `{app="foo-api"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo-api"}`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 6}, Metric: labels.Labels{{Name: "app", Value: "foo-api"}, {Name: "svc", Value: "api"}}}},
+		},
+		{
+			`absent_over_time({app="foo"}[1m])`, time.Unix(60, 0), logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app="foo"}`}},
+			},
+			promql.Vector{promql.Sample{Point: promql.Point{T: 60 * 1000, V: 1}, Metric: labels.Labels{{Name: "app", Value: "foo"}}}},
+		},
 		{
 			`count_over_time(({app="foo"} |~".+bar")[5m])`, time.Unix(5*60, 0), logproto.BACKWARD, 10,
 			[][]logproto.Stream{
@@ -220,6 +325,50 @@ func TestEngine_InstantQuery(t *testing.T) {
 				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 0.1}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}},
 			},
 		},
+		{
+			`sort_desc(rate({app=~"foo|bar|buzz"}[1m]))`, time.Unix(60, 0), logproto.FORWARD, 100,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, identity), `{app="foo"}`), newStream(testSize, offset(46, identity), `{app="bar"}`),
+					newStream(testSize, identity, `{app="buzz"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app=~"foo|bar|buzz"}`}},
+			},
+			promql.Vector{
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 1}, Metric: labels.Labels{labels.Label{Name: "app", Value: "buzz"}}},
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 0.25}, Metric: labels.Labels{labels.Label{Name: "app", Value: "bar"}}},
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 0.1}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}},
+			},
+		},
+		{
+			`sort(rate({app=~"foo|bar|buzz"}[1m]))`, time.Unix(60, 0), logproto.FORWARD, 100,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, identity), `{app="foo"}`), newStream(testSize, offset(46, identity), `{app="bar"}`),
+					newStream(testSize, identity, `{app="buzz"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app=~"foo|bar|buzz"}`}},
+			},
+			promql.Vector{
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 0.1}, Metric: labels.Labels{labels.Label{Name: "app", Value: "foo"}}},
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 0.25}, Metric: labels.Labels{labels.Label{Name: "app", Value: "bar"}}},
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 1}, Metric: labels.Labels{labels.Label{Name: "app", Value: "buzz"}}},
+			},
+		},
+		{
+			`count_values("rate", rate({app=~"foo|bar|buzz"}[1m]))`, time.Unix(60, 0), logproto.FORWARD, 100,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, identity), `{app="foo"}`), newStream(testSize, factor(10, identity), `{app="bar"}`),
+					newStream(testSize, identity, `{app="buzz"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(60, 0), Limit: 0, Selector: `{app=~"foo|bar|buzz"}`}},
+			},
+			promql.Vector{
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 2}, Metric: labels.Labels{labels.Label{Name: "rate", Value: "0.1"}}},
+				promql.Sample{Point: promql.Point{T: 60 * 1000, V: 1}, Metric: labels.Labels{labels.Label{Name: "rate", Value: "1"}}},
+			},
+		},
 		{
 			`topk(2,rate(({app=~"foo|bar"} |~".+bar")[1m]))`, time.Unix(60, 0), logproto.FORWARD, 100,
 			[][]logproto.Stream{
@@ -507,6 +656,61 @@ func TestEngine_RangeQuery(t *testing.T) {
 			},
 			Streams([]logproto.Stream{newBackwardIntervalStream(testSize, 30, 3*time.Second, identity, `{app="barf"}`)}),
 		},
+		{
+			`{app=~"foo|bar"} | logfmt | distinct trace`, time.Unix(0, 0), time.Unix(30, 0), time.Second, 0, logproto.FORWARD, 2,
+			[][]logproto.Stream{
+				{
+					logproto.Stream{
+						Labels: `{app="foo"}`,
+						Entries: []logproto.Entry{
+							{Timestamp: time.Unix(1, 0), Line: "trace=a"},
+							{Timestamp: time.Unix(3, 0), Line: "trace=a"},
+							{Timestamp: time.Unix(5, 0), Line: "trace=b"},
+						},
+					},
+					logproto.Stream{
+						Labels: `{app="bar"}`,
+						Entries: []logproto.Entry{
+							{Timestamp: time.Unix(2, 0), Line: "trace=a"},
+							{Timestamp: time.Unix(4, 0), Line: "trace=b"},
+							{Timestamp: time.Unix(6, 0), Line: "trace=c"},
+						},
+					},
+				},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(30, 0), Limit: 2 * mergedStagesPageFactor, Selector: `{app=~"foo|bar"} | logfmt | distinct trace`}},
+			},
+			Streams([]logproto.Stream{
+				{Labels: `{app="bar", trace="b"}`, Entries: []logproto.Entry{{Timestamp: time.Unix(4, 0), Line: "trace=b"}}},
+				{Labels: `{app="foo", trace="a"}`, Entries: []logproto.Entry{{Timestamp: time.Unix(1, 0), Line: "trace=a"}}},
+			}),
+		},
+		{
+			`{app="foo"} | trace_id="a"`, time.Unix(0, 0), time.Unix(30, 0), time.Second, 0, logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{
+					logproto.Stream{
+						Labels: `{app="foo"}`,
+						Entries: []logproto.Entry{
+							{Timestamp: time.Unix(1, 0), Line: "1", StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "a"}}},
+							{Timestamp: time.Unix(2, 0), Line: "2", StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "b"}}},
+							{Timestamp: time.Unix(3, 0), Line: "3"},
+							{Timestamp: time.Unix(4, 0), Line: "4", StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "a"}}},
+						},
+					},
+				},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(30, 0), Limit: 10, Selector: `{app="foo"} | trace_id="a"`}},
+			},
+			Streams([]logproto.Stream{
+				{Labels: `{app="foo"}`, Entries: []logproto.Entry{
+					{Timestamp: time.Unix(1, 0), Line: "1", StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "a"}}},
+					{Timestamp: time.Unix(4, 0), Line: "4", StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "a"}}},
+				}},
+			}),
+		},
 		{
 			`rate({app="foo"} |~".+bar" [1m])`, time.Unix(60, 0), time.Unix(120, 0), time.Minute, 0, logproto.BACKWARD, 10,
 			[][]logproto.Stream{
@@ -741,6 +945,40 @@ func TestEngine_RangeQuery(t *testing.T) {
 				},
 			},
 		},
+		{
+			`first_over_time({app="foo"} | regexp "(?P<v>\\d+)" | unwrap v [30s])`, time.Unix(60, 0), time.Unix(120, 0), 30 * time.Second, 0, logproto.FORWARD, 100,
+			[][]logproto.Stream{
+				{newStream(testSize, identity, `{app="foo"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(30, 0), End: time.Unix(120, 0), Limit: 0, Selector: `{app="foo"} | regexp "(?P<v>\\d+)"`}},
+			},
+			promql.Matrix{
+				promql.Series{
+					Metric: labels.Labels{{Name: "app", Value: "foo"}},
+					Points: []promql.Point{{T: 60 * 1000, V: 31}, {T: 90 * 1000, V: 61}, {T: 120 * 1000, V: 91}},
+				},
+			},
+		},
+		{
+			`count_values("rate", rate({app=~"foo|bar"}[1m])) by (app)`, time.Unix(60, 0), time.Unix(120, 0), 30 * time.Second, 0, logproto.FORWARD, 100,
+			[][]logproto.Stream{
+				{newStream(testSize, factor(10, identity), `{app="foo"}`), newStream(testSize, identity, `{app="bar"}`)},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(120, 0), Limit: 0, Selector: `{app=~"foo|bar"}`}},
+			},
+			promql.Matrix{
+				promql.Series{
+					Metric: labels.Labels{{Name: "app", Value: "bar"}, {Name: "rate", Value: "1"}},
+					Points: []promql.Point{{T: 60 * 1000, V: 1}, {T: 90 * 1000, V: 1}, {T: 120 * 1000, V: 1}},
+				},
+				promql.Series{
+					Metric: labels.Labels{{Name: "app", Value: "foo"}, {Name: "rate", Value: "0.1"}},
+					Points: []promql.Point{{T: 60 * 1000, V: 1}, {T: 90 * 1000, V: 1}, {T: 120 * 1000, V: 1}},
+				},
+			},
+		},
 		{
 			`topk(2,rate(({app=~"foo|bar"} |~".+bar")[1m]))`, time.Unix(60, 0), time.Unix(180, 0), 30 * time.Second, 0, logproto.FORWARD, 100,
 			[][]logproto.Stream{
@@ -1328,6 +1566,77 @@ func TestEngine_RangeQuery(t *testing.T) {
 				},
 			},
 		},
+		{
+			`max_over_time(count_over_time({app="foo"}[30s])[1m:30s])`, time.Unix(60, 0), time.Unix(120, 0), 30 * time.Second, 0, logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{logproto.Stream{
+					Labels: `{app="foo"}`,
+					Entries: []logproto.Entry{
+						{Timestamp: time.Unix(15, 0)},
+						{Timestamp: time.Unix(20, 0)},
+						{Timestamp: time.Unix(45, 0)},
+						{Timestamp: time.Unix(50, 0)},
+						{Timestamp: time.Unix(55, 0)},
+						{Timestamp: time.Unix(100, 0)},
+					},
+				}},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(0, 0), End: time.Unix(120, 0), Limit: 0, Selector: `{app="foo"}`}},
+			},
+			promql.Matrix{
+				promql.Series{
+					Metric: labels.Labels{{Name: "app", Value: "foo"}},
+					Points: []promql.Point{{T: 60 * 1000, V: 3}, {T: 90 * 1000, V: 3}, {T: 120 * 1000, V: 1}},
+				},
+			},
+		},
+		{
+			// subquery steps are aligned on the absolute time, the query start doesn't change the result.
+			`max_over_time(count_over_time({app="foo"}[30s])[1m:30s])`, time.Unix(90, 0), time.Unix(120, 0), 30 * time.Second, 0, logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{logproto.Stream{
+					Labels: `{app="foo"}`,
+					Entries: []logproto.Entry{
+						{Timestamp: time.Unix(45, 0)},
+						{Timestamp: time.Unix(50, 0)},
+						{Timestamp: time.Unix(55, 0)},
+						{Timestamp: time.Unix(100, 0)},
+					},
+				}},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(30, 0), End: time.Unix(120, 0), Limit: 0, Selector: `{app="foo"}`}},
+			},
+			promql.Matrix{
+				promql.Series{
+					Metric: labels.Labels{{Name: "app", Value: "foo"}},
+					Points: []promql.Point{{T: 90 * 1000, V: 3}, {T: 120 * 1000, V: 1}},
+				},
+			},
+		},
+		{
+			`absent_over_time({app="foo", env=~"p.*"}[15s])`, time.Unix(60, 0), time.Unix(120, 0), 15 * time.Second, 0, logproto.FORWARD, 10,
+			[][]logproto.Stream{
+				{logproto.Stream{
+					Labels: `{app="foo", env="prod"}`,
+					Entries: []logproto.Entry{
+						{Timestamp: time.Unix(45, 0)},
+						{Timestamp: time.Unix(60, 0)},
+						{Timestamp: time.Unix(105, 0)},
+					},
+				}},
+			},
+			[]SelectParams{
+				{&logproto.QueryRequest{Direction: logproto.FORWARD, Start: time.Unix(45, 0), End: time.Unix(120, 0), Limit: 0, Selector: `{app="foo",env=~"p.*"}`}},
+			},
+			promql.Matrix{
+				promql.Series{
+					Metric: labels.Labels{{Name: "app", Value: "foo"}},
+					Points: []promql.Point{{T: 75 * 1000, V: 1}, {T: 90 * 1000, V: 1}, {T: 120 * 1000, V: 1}},
+				},
+			},
+		},
 		{
 			`bytes_over_time({app="foo"}[30s]) > 1`, time.Unix(60, 0), time.Unix(120, 0), 15 * time.Second, 0, logproto.FORWARD, 10,
 			[][]logproto.Stream{
@@ -1427,6 +1736,26 @@ func TestEngine_Stats(t *testing.T) {
 }
 
 // go test -mod=vendor ./pkg/logql/ -bench=.  -benchmem -memprofile memprofile.out -cpuprofile cpuprofile.out
+func TestEngine_VectorMatchingError(t *testing.T) {
+	eng := NewEngine(EngineOpts{}, QuerierFunc(func(ctx context.Context, sp SelectParams) (iter.EntryIterator, error) {
+		return iter.NewStreamsIterator(ctx, []logproto.Stream{
+			newStream(testSize, identity, `{app="foo", status="200"}`),
+			newStream(testSize, identity, `{app="foo", status="500"}`),
+		}, sp.Direction), nil
+	}))
+
+	q := eng.Query(LiteralParams{
+		qs:        `count_over_time({app="foo"}[1m]) / on(app) count_over_time({app="foo"}[1m])`,
+		start:     time.Unix(60, 0),
+		end:       time.Unix(120, 0),
+		step:      30 * time.Second,
+		direction: logproto.FORWARD,
+		limit:     1000,
+	})
+	_, err := q.Exec(context.Background())
+	require.EqualError(t, err, "found duplicate series for the match group {app=\"foo\"} on the right hand-side of the operation: many-to-many matching not allowed: matching labels must be unique on one side")
+}
+
 func BenchmarkRangeQuery100000(b *testing.B) {
 	benchmarkRangeQuery(int64(100000), b)
 }
@@ -1537,9 +1866,18 @@ func (q *querierRecorder) Select(ctx context.Context, p SelectParams) (iter.Entr
 	if !ok {
 		return nil, fmt.Errorf("no streams found for id: %s has: %+v", recordID, q.source)
 	}
+	expr, err := p.LogSelector()
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := expr.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	pipeline, _ = pipeline.Split()
 	iters := make([]iter.EntryIterator, 0, len(streams))
 	for _, s := range streams {
-		iters = append(iters, iter.NewStreamIterator(s))
+		iters = append(iters, NewPipelineIterator(iter.NewStreamIterator(s), pipeline))
 	}
 	return iter.NewHeapIterator(ctx, iters, p.Direction), nil
 }
@@ -5,6 +5,7 @@ import (
 	"context"
 	"math"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
@@ -143,8 +144,24 @@ func (ev *DefaultEvaluator) Iterator(ctx context.Context, expr LogSelectorExpr,
 		params.Start = params.Start.Add(-ev.maxLookBackPeriod)
 	}
 
-	return ev.querier.Select(ctx, params)
+	pipeline, err := expr.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	// stages such as distinct are applied once the entries of all sources are merged,
+	// sources can't apply the limit since entries may still be dropped. They are selected
+	// by pages of a multiple of the limit until enough entries are kept.
+	_, merged := pipeline.Split()
+	if len(merged) > 0 && params.Limit > 0 {
+		params.Limit *= mergedStagesPageFactor
+		return NewPipelineIterator(newPagedIterator(ctx, ev.querier, *params.QueryRequest), merged), nil
+	}
 
+	it, err := ev.querier.Select(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return NewPipelineIterator(it, merged), nil
 }
 
 func (ev *DefaultEvaluator) StepEvaluator(
@@ -159,8 +176,8 @@ func (ev *DefaultEvaluator) StepEvaluator(
 	case *rangeAggregationExpr:
 		entryIter, err := ev.querier.Select(ctx, SelectParams{
 			&logproto.QueryRequest{
-				Start:     q.Start().Add(-e.left.interval),
-				End:       q.End(),
+				Start:     q.Start().Add(-e.left.interval).Add(-e.left.offset),
+				End:       q.End().Add(-e.left.offset),
 				Limit:     0,
 				Direction: logproto.FORWARD,
 				Selector:  expr.Selector().String(),
@@ -171,8 +188,12 @@ func (ev *DefaultEvaluator) StepEvaluator(
 			return nil, err
 		}
 		return rangeAggEvaluator(entryIter, e, q)
+	case *subqueryAggregationExpr:
+		return subqueryAggEvaluator(ctx, nextEv, e, q)
 	case *binOpExpr:
 		return binOpStepEvaluator(ctx, nextEv, e, q)
+	case *labelReplaceExpr:
+		return labelReplaceEvaluator(ctx, nextEv, e, q)
 	default:
 		return nil, EvaluatorUnsupportedType(e, ev)
 	}
@@ -195,6 +216,10 @@ func vectorAggEvaluator(
 		if !next {
 			return false, 0, promql.Vector{}
 		}
+		if expr.operation == OpTypeSort || expr.operation == OpTypeSortDesc {
+			sortByValue(vec, expr.operation == OpTypeSortDesc)
+			return next, ts, vec
+		}
 		result := map[uint64]*groupedAggregation{}
 		if expr.operation == OpTypeTopK || expr.operation == OpTypeBottomK {
 			if expr.params < 1 {
@@ -202,16 +227,23 @@ func vectorAggEvaluator(
 			}
 
 		}
+		groups := expr.grouping.groups
+		if expr.operation == OpTypeCountValues && !expr.grouping.without {
+			groups = append(groups[:len(groups):len(groups)], expr.label)
+		}
 		for _, s := range vec {
 			metric := s.Metric
+			if expr.operation == OpTypeCountValues {
+				metric = labels.NewBuilder(metric).Set(expr.label, strconv.FormatFloat(s.V, 'f', -1, 64)).Labels()
+			}
 
 			var (
 				groupingKey uint64
 			)
 			if expr.grouping.without {
-				groupingKey, _ = metric.HashWithoutLabels(make([]byte, 0, 1024), expr.grouping.groups...)
+				groupingKey, _ = metric.HashWithoutLabels(make([]byte, 0, 1024), groups...)
 			} else {
-				groupingKey, _ = metric.HashForLabels(make([]byte, 0, 1024), expr.grouping.groups...)
+				groupingKey, _ = metric.HashForLabels(make([]byte, 0, 1024), groups...)
 			}
 			group, ok := result[groupingKey]
 			// Add a new group if it doesn't exist.
@@ -220,13 +252,13 @@ func vectorAggEvaluator(
 
 				if expr.grouping.without {
 					lb := labels.NewBuilder(metric)
-					lb.Del(expr.grouping.groups...)
+					lb.Del(groups...)
 					lb.Del(labels.MetricName)
 					m = lb.Labels()
 				} else {
-					m = make(labels.Labels, 0, len(expr.grouping.groups))
+					m = make(labels.Labels, 0, len(groups))
 					for _, l := range metric {
-						for _, n := range expr.grouping.groups {
+						for _, n := range groups {
 							if l.Name == n {
 								m = append(m, l)
 								break
@@ -282,7 +314,7 @@ func vectorAggEvaluator(
 					group.value = s.V
 				}
 
-			case OpTypeCount:
+			case OpTypeCount, OpTypeCountValues:
 				group.groupCount++
 
 			case OpTypeStddev, OpTypeStdvar:
@@ -322,7 +354,7 @@ func vectorAggEvaluator(
 			case OpTypeAvg:
 				aggr.value = aggr.mean
 
-			case OpTypeCount:
+			case OpTypeCount, OpTypeCountValues:
 				aggr.value = float64(aggr.groupCount)
 
 			case OpTypeStddev:
@@ -370,7 +402,28 @@ func vectorAggEvaluator(
 		}
 		return next, ts, vec
 
-	}, nextEvaluator.Close)
+	}, nextEvaluator.Close, nextEvaluator.Error)
+}
+
+// sortByValue sorts a vector by sample value, NaN values are always last.
+// Samples with the same value are sorted by labels so that the order is stable.
+func sortByValue(vec promql.Vector, desc bool) {
+	sort.Slice(vec, func(i, j int) bool {
+		vi, vj := vec[i].V, vec[j].V
+		switch {
+		case math.IsNaN(vi) || math.IsNaN(vj):
+			if math.IsNaN(vi) && math.IsNaN(vj) {
+				return labels.Compare(vec[i].Metric, vec[j].Metric) < 0
+			}
+			return math.IsNaN(vj)
+		case vi == vj:
+			return labels.Compare(vec[i].Metric, vec[j].Metric) < 0
+		case desc:
+			return vi > vj
+		default:
+			return vi < vj
+		}
+	})
 }
 
 func rangeAggEvaluator(
@@ -379,25 +432,125 @@ func rangeAggEvaluator(
 	q Params,
 ) (StepEvaluator, error) {
 
-	agg, err := expr.aggregator()
+	extractor, err := expr.extractor()
 	if err != nil {
 		return nil, err
 	}
-	extractor, err := expr.extractor()
+	iter := newRangeVectorIterator(
+		newSeriesIterator(entryIter, extractor),
+		expr.left.interval.Nanoseconds(),
+		q.Step().Nanoseconds(),
+		q.Start().UnixNano(), q.End().UnixNano(), expr.left.offset.Nanoseconds(),
+	)
+	if expr.operation == OpRangeTypeAbsent {
+		return &absentRangeVectorEvaluator{
+			iter: iter,
+			lbs:  absentLabels(expr),
+		}, nil
+	}
+	agg, err := expr.aggregator()
 	if err != nil {
 		return nil, err
 	}
 	return rangeVectorEvaluator{
-		iter: newRangeVectorIterator(
-			newSeriesIterator(entryIter, extractor),
-			expr.left.interval.Nanoseconds(),
-			q.Step().Nanoseconds(),
-			q.Start().UnixNano(), q.End().UnixNano(),
-		),
-		agg: agg,
+		iter: iter,
+		agg:  agg,
 	}, nil
 }
 
+// subqueryAggEvaluator evaluates the subquery expression at its own step over the whole query range
+// and aggregates the resulting samples over the subquery range at each step of the query.
+func subqueryAggEvaluator(
+	ctx context.Context,
+	ev Evaluator,
+	expr *subqueryAggregationExpr,
+	q Params,
+) (StepEvaluator, error) {
+	agg, err := expr.aggregator()
+	if err != nil {
+		return nil, err
+	}
+	sub := expr.subquery
+	step := sub.step.Nanoseconds()
+	// Subquery steps are aligned on the absolute time, not on the query start, so that the samples
+	// of a given step don't depend on how the query range was split. The lower bound is not inclusive.
+	start := q.Start().UnixNano() - sub.offset.Nanoseconds() - sub.interval.Nanoseconds()
+	start = start - start%step + step
+	end := q.End().UnixNano() - sub.offset.Nanoseconds()
+	end = end - end%step
+
+	var it SeriesIterator = emptySeriesIterator{}
+	var inner StepEvaluator
+	if start <= end {
+		params := NewLiteralParams(
+			sub.left.String(),
+			time.Unix(0, start), time.Unix(0, end),
+			sub.step, q.Interval(), q.Direction(), q.Limit(), q.Shards(),
+		)
+		inner, err = ev.StepEvaluator(ctx, ev, sub.left, params)
+		if err != nil {
+			return nil, err
+		}
+		it = &stepSeriesIterator{ev: inner}
+	}
+	rangeIter := newRangeVectorIterator(
+		it,
+		sub.interval.Nanoseconds(),
+		q.Step().Nanoseconds(),
+		q.Start().UnixNano(), q.End().UnixNano(), sub.offset.Nanoseconds(),
+	)
+	return newStepEvaluator(func() (bool, int64, promql.Vector) {
+		if !rangeIter.Next() {
+			return false, 0, promql.Vector{}
+		}
+		ts, vec := rangeIter.At(agg)
+		return true, ts, vec
+	}, rangeIter.Close, func() error {
+		if inner == nil {
+			return nil
+		}
+		return inner.Error()
+	})
+}
+
+// stepSeriesIterator is a SeriesIterator over the samples of all the steps of a StepEvaluator.
+type stepSeriesIterator struct {
+	ev  StepEvaluator
+	buf []Sample
+}
+
+func (s *stepSeriesIterator) Peek() (Sample, bool) {
+	for len(s.buf) == 0 {
+		next, _, vec := s.ev.Next()
+		if !next {
+			return Sample{}, false
+		}
+		for _, sample := range vec {
+			s.buf = append(s.buf, Sample{
+				Labels:        sample.Metric.String(),
+				Value:         sample.V,
+				TimestampNano: sample.T * 1e+6,
+			})
+		}
+	}
+	return s.buf[0], true
+}
+
+func (s *stepSeriesIterator) Next() bool {
+	if len(s.buf) > 0 {
+		s.buf = s.buf[1:]
+	}
+	return true
+}
+
+func (s *stepSeriesIterator) Close() error { return s.ev.Close() }
+
+type emptySeriesIterator struct{}
+
+func (emptySeriesIterator) Peek() (Sample, bool) { return Sample{}, false }
+func (emptySeriesIterator) Next() bool           { return false }
+func (emptySeriesIterator) Close() error         { return nil }
+
 type rangeVectorEvaluator struct {
 	agg  RangeVectorAggregator
 	iter RangeVectorIterator
@@ -414,6 +567,56 @@ func (r rangeVectorEvaluator) Next() (bool, int64, promql.Vector) {
 
 func (r rangeVectorEvaluator) Close() error { return r.iter.Close() }
 
+func (r rangeVectorEvaluator) Error() error { return nil }
+
+// absentRangeVectorEvaluator returns a single sample with a value of 1 for the steps
+// where the range has no samples, and nothing otherwise.
+type absentRangeVectorEvaluator struct {
+	iter RangeVectorIterator
+	lbs  labels.Labels
+}
+
+func (r absentRangeVectorEvaluator) Next() (bool, int64, promql.Vector) {
+	next := r.iter.Next()
+	if !next {
+		return false, 0, promql.Vector{}
+	}
+	ts, vec := r.iter.At(countOverTime)
+	if len(vec) > 0 {
+		return next, ts, promql.Vector{}
+	}
+	return next, ts, promql.Vector{
+		promql.Sample{
+			Point:  promql.Point{T: ts, V: 1},
+			Metric: r.lbs,
+		},
+	}
+}
+
+func (r absentRangeVectorEvaluator) Close() error { return r.iter.Close() }
+
+func (r absentRangeVectorEvaluator) Error() error { return nil }
+
+// absentLabels returns the labels of the absent_over_time result, which are the
+// equality matchers of the stream selector. Labels used in multiple matchers are dropped.
+func absentLabels(expr SampleExpr) labels.Labels {
+	var (
+		lb      = labels.NewBuilder(nil)
+		seen    = map[string]struct{}{}
+		dropped []string
+	)
+	for _, m := range expr.Selector().Matchers() {
+		if _, ok := seen[m.Name]; ok || m.Type != labels.MatchEqual {
+			dropped = append(dropped, m.Name)
+		} else {
+			lb.Set(m.Name, m.Value)
+		}
+		seen[m.Name] = struct{}{}
+	}
+	lb.Del(dropped...)
+	return lb.Labels()
+}
+
 // binOpExpr explicitly does not handle when both legs are literals as
 // it makes the type system simpler and these are reduced in mustNewBinOpExpr
 func binOpStepEvaluator(
@@ -464,11 +667,12 @@ func binOpStepEvaluator(
 		return nil, err
 	}
 
+	var lastErr error
 	return newStepEvaluator(func() (bool, int64, promql.Vector) {
-		pairs := map[uint64][2]*promql.Sample{}
-		var ts int64
-
-		// populate pairs
+		var (
+			ts   int64
+			vecs [2]promql.Vector
+		)
 		for i, eval := range []StepEvaluator{lhs, rhs} {
 			next, timestamp, vec := eval.Next()
 
@@ -478,7 +682,21 @@ func binOpStepEvaluator(
 			if !next {
 				return next, ts, nil
 			}
+			vecs[i] = vec
+		}
+
+		if expr.opts.VectorMatching != nil {
+			results, err := vectorBinop(expr.op, expr.opts, vecs[0], vecs[1])
+			if err != nil {
+				lastErr = err
+				return false, ts, nil
+			}
+			return true, ts, results
+		}
 
+		// populate pairs
+		pairs := map[uint64][2]*promql.Sample{}
+		for i, vec := range vecs {
 			for _, sample := range vec {
 				// TODO(owen-d): this seems wildly inefficient: we're calculating
 				// the hash on each sample & step per evaluator.
@@ -510,9 +728,176 @@ func binOpStepEvaluator(
 			}
 		}
 		return lastError
+	}, func() error {
+		if lastErr != nil {
+			return lastErr
+		}
+		for _, ev := range []StepEvaluator{lhs, rhs} {
+			if err := ev.Error(); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
+// vectorBinop evaluates a binary operation between two vectors matched using the vector matching options.
+// It follows the semantic of PromQL vector matching.
+func vectorBinop(op string, opts BinOpOptions, lhs, rhs promql.Vector) (promql.Vector, error) {
+	matching := opts.VectorMatching
+	sigf := signatureFunc(matching.On, matching.MatchingLabels...)
+
+	if matching.Card == CardManyToMany {
+		return setBinop(op, sigf, lhs, rhs), nil
+	}
+
+	oneSide, manySide, oneSideName := rhs, lhs, "right"
+	if matching.Card == CardOneToMany {
+		oneSide, manySide, oneSideName = lhs, rhs, "left"
+	}
+
+	// samples of the "one" side must have unique signatures.
+	oneSigs := make(map[uint64]*promql.Sample, len(oneSide))
+	for i := range oneSide {
+		sig := sigf(oneSide[i].Metric)
+		if _, ok := oneSigs[sig]; ok {
+			return nil, errors.Errorf(
+				"found duplicate series for the match group %s on the %s hand-side of the operation: many-to-many matching not allowed: matching labels must be unique on one side",
+				oneSide[i].Metric.MatchLabels(matching.On, matching.MatchingLabels...),
+				oneSideName,
+			)
+		}
+		oneSigs[sig] = &oneSide[i]
+	}
+
+	var (
+		// signatures already matched, only used for one-to-one matching.
+		matchedSigs = map[uint64]struct{}{}
+		// result metrics already produced, only used for many-to-one and one-to-many matching.
+		resultSigs = map[uint64]struct{}{}
+		results    = make(promql.Vector, 0, len(manySide))
+	)
+	for i := range manySide {
+		many := &manySide[i]
+		sig := sigf(many.Metric)
+		one, ok := oneSigs[sig]
+		if !ok {
+			continue
+		}
+		if matching.Card == CardOneToOne {
+			if _, ok := matchedSigs[sig]; ok {
+				return nil, errors.New("multiple matches for labels: many-to-one matching must be explicit (group_left/group_right)")
+			}
+			matchedSigs[sig] = struct{}{}
+		}
+
+		// the operation is always applied in the order of the expression.
+		left, right := many, one
+		if matching.Card == CardOneToMany {
+			left, right = one, many
+		}
+		merged := mergeBinOp(op, left, right, !opts.ReturnBool, IsComparisonOperator(op))
+		if merged == nil {
+			continue
+		}
+
+		metric := resultMetric(many.Metric, one.Metric, matching)
+		if matching.Card != CardOneToOne {
+			hash := metric.Hash()
+			if _, ok := resultSigs[hash]; ok {
+				return nil, errors.New("multiple matches for labels: grouping labels must ensure unique matches")
+			}
+			resultSigs[hash] = struct{}{}
+		}
+		results = append(results, promql.Sample{
+			Metric: metric,
+			Point:  merged.Point,
+		})
+	}
+	return results, nil
+}
+
+// setBinop evaluates the logical/set binary operations (and, or, unless) between two vectors.
+// Samples are returned unchanged.
+func setBinop(op string, sigf func(labels.Labels) uint64, lhs, rhs promql.Vector) promql.Vector {
+	switch op {
+	case OpTypeAnd:
+		rightSigs := signatures(sigf, rhs)
+		results := make(promql.Vector, 0, len(lhs))
+		for _, s := range lhs {
+			if _, ok := rightSigs[sigf(s.Metric)]; ok {
+				results = append(results, s)
+			}
+		}
+		return results
+	case OpTypeOr:
+		leftSigs := signatures(sigf, lhs)
+		results := make(promql.Vector, 0, len(lhs)+len(rhs))
+		results = append(results, lhs...)
+		for _, s := range rhs {
+			if _, ok := leftSigs[sigf(s.Metric)]; !ok {
+				results = append(results, s)
+			}
+		}
+		return results
+	case OpTypeUnless:
+		rightSigs := signatures(sigf, rhs)
+		results := make(promql.Vector, 0, len(lhs))
+		for _, s := range lhs {
+			if _, ok := rightSigs[sigf(s.Metric)]; !ok {
+				results = append(results, s)
+			}
+		}
+		return results
+	default:
+		panic(errors.Errorf("should never happen: unexpected set operation: (%s)", op))
+	}
+}
+
+func signatures(sigf func(labels.Labels) uint64, vec promql.Vector) map[uint64]struct{} {
+	sigs := make(map[uint64]struct{}, len(vec))
+	for _, s := range vec {
+		sigs[sigf(s.Metric)] = struct{}{}
+	}
+	return sigs
+}
+
+// signatureFunc returns a function that calculates the signature of a metric,
+// using or ignoring the given label names.
+func signatureFunc(on bool, names ...string) func(labels.Labels) uint64 {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	if on {
+		return func(lset labels.Labels) uint64 {
+			h, _ := lset.HashForLabels(make([]byte, 0, 1024), sorted...)
+			return h
+		}
+	}
+	return func(lset labels.Labels) uint64 {
+		h, _ := lset.HashWithoutLabels(make([]byte, 0, 1024), sorted...)
+		return h
+	}
+}
+
+// resultMetric returns the metric of the result of a vector matching operation
+// between a sample of the "many" side and a sample of the "one" side.
+func resultMetric(many, one labels.Labels, matching *VectorMatching) labels.Labels {
+	if matching.Card == CardOneToOne {
+		return many.MatchLabels(matching.On, matching.MatchingLabels...)
+	}
+	lb := labels.NewBuilder(many)
+	for _, name := range matching.Include {
+		// included labels are taken from the "one" side.
+		if v := one.Get(name); v != "" {
+			lb.Set(name, v)
+		} else {
+			lb.Del(name)
+		}
+	}
+	return lb.Labels()
+}
+
 func mergeBinOp(op string, left, right *promql.Sample, filter, isVectorComparison bool) *promql.Sample {
 	var merger func(left, right *promql.Sample) *promql.Sample
 
@@ -795,6 +1180,66 @@ func mergeBinOp(op string, left, right *promql.Sample, filter, isVectorCompariso
 
 }
 
+// labelReplaceEvaluator applies label_replace to each sample of the nested StepEvaluator.
+func labelReplaceEvaluator(
+	ctx context.Context,
+	ev Evaluator,
+	expr *labelReplaceExpr,
+	q Params,
+) (StepEvaluator, error) {
+	nextEvaluator, err := ev.StepEvaluator(ctx, ev, expr.left, q)
+	if err != nil {
+		return nil, err
+	}
+	// the result of the replacement is the same for all the steps of a series.
+	cache := map[uint64]labels.Labels{}
+	var lastErr error
+	return newStepEvaluator(func() (bool, int64, promql.Vector) {
+		next, ts, vec := nextEvaluator.Next()
+		if !next {
+			return false, 0, promql.Vector{}
+		}
+		seen := make(map[uint64]struct{}, len(vec))
+		for i, s := range vec {
+			hash := s.Metric.Hash()
+			metric, ok := cache[hash]
+			if !ok {
+				metric = expr.replace(s.Metric)
+				cache[hash] = metric
+			}
+			resultHash := metric.Hash()
+			if _, ok := seen[resultHash]; ok {
+				lastErr = errors.Errorf("vector cannot contain metrics with the same labelset: %s", metric)
+				return false, ts, nil
+			}
+			seen[resultHash] = struct{}{}
+			vec[i].Metric = metric
+		}
+		return next, ts, vec
+	}, nextEvaluator.Close, func() error {
+		if lastErr != nil {
+			return lastErr
+		}
+		return nextEvaluator.Error()
+	})
+}
+
+// replace returns the labels with the dst label set to the expanded replacement when the
+// value of the src label matches the regex. An empty replacement removes the dst label.
+func (e *labelReplaceExpr) replace(lbs labels.Labels) labels.Labels {
+	src := lbs.Get(e.src)
+	indexes := e.re.FindStringSubmatchIndex(src)
+	if indexes == nil {
+		return lbs
+	}
+	res := e.re.ExpandString([]byte{}, e.replacement, src, indexes)
+	lb := labels.NewBuilder(lbs).Del(e.dst)
+	if len(res) > 0 {
+		lb.Set(e.dst, string(res))
+	}
+	return lb.Labels()
+}
+
 // literalStepEvaluator merges a literal with a StepEvaluator. Since order matters in
 // non commutative operations, inverted should be true when the literalExpr is not the left argument.
 func literalStepEvaluator(
@@ -834,5 +1279,6 @@ func literalStepEvaluator(
 			return ok, ts, results
 		},
 		eval.Close,
+		eval.Error,
 	)
 }
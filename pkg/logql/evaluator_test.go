@@ -4,6 +4,7 @@ import (
 	"math"
 	"testing"
 
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/stretchr/testify/require"
 )
@@ -248,3 +249,128 @@ func Test_MergeBinOpVectors_Filter(t *testing.T) {
 		Point: promql.Point{V: 2},
 	}, res)
 }
+
+func Test_VectorBinop(t *testing.T) {
+	sample := func(v float64, lbs ...string) promql.Sample {
+		return promql.Sample{Metric: labels.FromStrings(lbs...), Point: promql.Point{V: v}}
+	}
+	for _, tc := range []struct {
+		name     string
+		op       string
+		matching VectorMatching
+		lhs, rhs promql.Vector
+		expected promql.Vector
+		err      string
+	}{
+		{
+			"one-to-one ignoring",
+			OpTypeDiv,
+			VectorMatching{Card: CardOneToOne, MatchingLabels: []string{"status"}},
+			promql.Vector{sample(10, "app", "a", "status", "200")},
+			promql.Vector{sample(5, "app", "a")},
+			promql.Vector{sample(2, "app", "a")},
+			"",
+		},
+		{
+			"one-to-one on",
+			OpTypeDiv,
+			VectorMatching{Card: CardOneToOne, On: true, MatchingLabels: []string{"app"}},
+			promql.Vector{sample(10, "app", "a", "status", "200"), sample(4, "app", "b", "status", "200")},
+			promql.Vector{sample(5, "app", "a", "x", "1")},
+			promql.Vector{sample(2, "app", "a")},
+			"",
+		},
+		{
+			"many-to-one ignoring",
+			OpTypeDiv,
+			VectorMatching{Card: CardManyToOne, MatchingLabels: []string{"status"}},
+			promql.Vector{sample(9, "app", "a", "status", "200"), sample(1, "app", "a", "status", "500")},
+			promql.Vector{sample(10, "app", "a")},
+			promql.Vector{sample(0.9, "app", "a", "status", "200"), sample(0.1, "app", "a", "status", "500")},
+			"",
+		},
+		{
+			"many-to-one with include",
+			OpTypeMul,
+			VectorMatching{Card: CardManyToOne, On: true, MatchingLabels: []string{"app"}, Include: []string{"team"}},
+			promql.Vector{sample(1, "app", "a", "status", "200")},
+			promql.Vector{sample(2, "app", "a", "team", "t1")},
+			promql.Vector{sample(2, "app", "a", "status", "200", "team", "t1")},
+			"",
+		},
+		{
+			"one-to-many keeps the order of the operation",
+			OpTypeSub,
+			VectorMatching{Card: CardOneToMany, On: true, MatchingLabels: []string{"app"}},
+			promql.Vector{sample(10, "app", "a")},
+			promql.Vector{sample(2, "app", "a", "status", "200"), sample(5, "app", "a", "status", "500")},
+			promql.Vector{sample(8, "app", "a", "status", "200"), sample(5, "app", "a", "status", "500")},
+			"",
+		},
+		{
+			"and on",
+			OpTypeAnd,
+			VectorMatching{Card: CardManyToMany, On: true, MatchingLabels: []string{"app"}},
+			promql.Vector{sample(1, "app", "a", "s", "1"), sample(2, "app", "b", "s", "1")},
+			promql.Vector{sample(0, "app", "a", "x", "1")},
+			promql.Vector{sample(1, "app", "a", "s", "1")},
+			"",
+		},
+		{
+			"or on",
+			OpTypeOr,
+			VectorMatching{Card: CardManyToMany, On: true, MatchingLabels: []string{"app"}},
+			promql.Vector{sample(1, "app", "a", "s", "1")},
+			promql.Vector{sample(2, "app", "a", "x", "1"), sample(3, "app", "b", "x", "1")},
+			promql.Vector{sample(1, "app", "a", "s", "1"), sample(3, "app", "b", "x", "1")},
+			"",
+		},
+		{
+			"unless ignoring",
+			OpTypeUnless,
+			VectorMatching{Card: CardManyToMany, MatchingLabels: []string{"s", "x"}},
+			promql.Vector{sample(1, "app", "a", "s", "1"), sample(2, "app", "b", "s", "1")},
+			promql.Vector{sample(0, "app", "a", "x", "1")},
+			promql.Vector{sample(2, "app", "b", "s", "1")},
+			"",
+		},
+		{
+			"one-to-one with many matches",
+			OpTypeDiv,
+			VectorMatching{Card: CardOneToOne, On: true, MatchingLabels: []string{"app"}},
+			promql.Vector{sample(9, "app", "a", "status", "200"), sample(1, "app", "a", "status", "500")},
+			promql.Vector{sample(10, "app", "a")},
+			nil,
+			"multiple matches for labels: many-to-one matching must be explicit (group_left/group_right)",
+		},
+		{
+			"duplicate on the one side",
+			OpTypeDiv,
+			VectorMatching{Card: CardManyToOne, On: true, MatchingLabels: []string{"app"}},
+			promql.Vector{sample(9, "app", "a", "status", "200")},
+			promql.Vector{sample(10, "app", "a", "x", "1"), sample(10, "app", "a", "x", "2")},
+			nil,
+			`found duplicate series for the match group {app="a"} on the right hand-side of the operation: many-to-many matching not allowed: matching labels must be unique on one side`,
+		},
+		{
+			"include overriding labels",
+			OpTypeDiv,
+			VectorMatching{Card: CardManyToOne, On: true, MatchingLabels: []string{"app"}, Include: []string{"team"}},
+			promql.Vector{sample(1, "app", "a", "team", "x"), sample(2, "app", "a", "team", "y")},
+			promql.Vector{sample(10, "app", "a", "team", "z")},
+			nil,
+			"multiple matches for labels: grouping labels must ensure unique matches",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := vectorBinop(tc.op, BinOpOptions{VectorMatching: &tc.matching}, tc.lhs, tc.rhs)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Package explain records the execution plan built by the query frontend for a query.
+The plan is passed through the query context.
+To start recording the plan of a query use:
+
+	ctx := explain.NewContext(ctx, root, analyze)
+
+Each step of the query path can then add its own node to the plan and pass it to the next steps:
+
+	node := explain.FromContext(ctx).AddChild(&explain.Node{Type: explain.TypeSharding})
+	ctx = explain.WithNode(ctx, node)
+
+All functions and methods are no-ops when the query isn't explained.
+Finally to get a consistent copy of the plan use:
+
+	root.Snapshot()
+*/
+package explain
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/loki/pkg/logql/stats"
+)
+
+type ctxKeyType string
+
+const (
+	nodeKey    ctxKeyType = "node"
+	analyzeKey ctxKeyType = "analyze"
+)
+
+// Types of the nodes of a plan.
+const (
+	TypeQuery           = "query"             // the query as received by the frontend.
+	TypeSplitByInterval = "split_by_interval" // the query is split by time.
+	TypeInterval        = "interval"          // a time-based partition of a split query.
+	TypeSharding        = "sharding"          // the query is mapped into sharded downstream queries.
+	TypeDownstream      = "downstream"        // a query sent to the queriers.
+)
+
+// Sources of the data of a downstream query.
+const (
+	SourceIngesters = "ingesters"
+	SourceStore     = "store"
+)
+
+// Node is a step of the plan of a query.
+type Node struct {
+	Type  string    `json:"type"`
+	Query string    `json:"query,omitempty"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Shards of the index queried by a downstream query.
+	Shards []string `json:"shards,omitempty"`
+	// Sources queried by a downstream query.
+	Sources []string `json:"sources,omitempty"`
+	// Stats are only set when the query is analyzed.
+	// Stats of a node are the sum of the stats of its children.
+	Stats    *stats.Result `json:"stats,omitempty"`
+	Children []*Node       `json:"children,omitempty"`
+
+	mtx sync.Mutex
+}
+
+// NewContext returns a context recording the plan of a query in root.
+// If analyze is false the query is only planned and must not be executed.
+func NewContext(ctx context.Context, root *Node, analyze bool) context.Context {
+	ctx = context.WithValue(ctx, nodeKey, root)
+	return context.WithValue(ctx, analyzeKey, analyze)
+}
+
+// FromContext returns the current node of the plan, or nil if the query isn't explained.
+func FromContext(ctx context.Context) *Node {
+	n, ok := ctx.Value(nodeKey).(*Node)
+	if !ok {
+		return nil
+	}
+	return n
+}
+
+// WithNode returns a context where n is the current node of the plan.
+// The context is returned unchanged if n is nil.
+func WithNode(ctx context.Context, n *Node) context.Context {
+	if n == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, nodeKey, n)
+}
+
+// DryRun returns true if the query is only planned and must not be executed.
+func DryRun(ctx context.Context) bool {
+	if FromContext(ctx) == nil {
+		return false
+	}
+	analyze, _ := ctx.Value(analyzeKey).(bool)
+	return !analyze
+}
+
+// AddChild adds a child to n and returns it, or nil if n is nil.
+func (n *Node) AddChild(child *Node) *Node {
+	if n == nil {
+		return nil
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// SetQuery sets the query of n.
+func (n *Node) SetQuery(query string) {
+	if n == nil {
+		return
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.Query = query
+}
+
+// SetStats sets the statistics of n.
+func (n *Node) SetStats(s stats.Result) {
+	if n == nil {
+		return
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.Stats = &s
+}
+
+// Snapshot returns a copy of the plan rooted at n.
+// Children are sorted by time and shards, and stats of nodes with children are the sum of their children stats.
+func (n *Node) Snapshot() *Node {
+	if n == nil {
+		return nil
+	}
+	n.mtx.Lock()
+	res := &Node{
+		Type:    n.Type,
+		Query:   n.Query,
+		Start:   n.Start,
+		End:     n.End,
+		Shards:  n.Shards,
+		Sources: n.Sources,
+		Stats:   n.Stats,
+	}
+	children := make([]*Node, len(n.Children))
+	copy(children, n.Children)
+	n.mtx.Unlock()
+
+	if len(children) == 0 {
+		return res
+	}
+	res.Stats = nil
+	res.Children = make([]*Node, 0, len(children))
+	for _, c := range children {
+		c := c.Snapshot()
+		res.Children = append(res.Children, c)
+		if c.Stats != nil {
+			if res.Stats == nil {
+				res.Stats = &stats.Result{}
+			}
+			res.Stats.Merge(*c.Stats)
+		}
+	}
+	sort.SliceStable(res.Children, func(i, j int) bool {
+		a, b := res.Children[i], res.Children[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return shardsLess(a.Shards, b.Shards)
+	})
+	return res
+}
+
+// shardsLess orders shards such as `2_of_16` before `10_of_16`.
+func shardsLess(a, b []string) bool {
+	x, y := strings.Join(a, ","), strings.Join(b, ",")
+	if len(x) != len(y) {
+		return len(x) < len(y)
+	}
+	return x < y
+}
@@ -0,0 +1,68 @@
+package explain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/logql/stats"
+)
+
+func TestNoPlan(t *testing.T) {
+	ctx := context.Background()
+	require.Nil(t, FromContext(ctx))
+	require.False(t, DryRun(ctx))
+
+	n := FromContext(ctx).AddChild(&Node{Type: TypeSharding})
+	require.Nil(t, n)
+	n.SetQuery("foo")
+	n.SetStats(stats.Result{})
+	require.Equal(t, ctx, WithNode(ctx, n))
+	require.Nil(t, n.Snapshot())
+}
+
+func TestDryRun(t *testing.T) {
+	require.True(t, DryRun(NewContext(context.Background(), &Node{}, false)))
+	require.False(t, DryRun(NewContext(context.Background(), &Node{}, true)))
+}
+
+func TestNode_Snapshot(t *testing.T) {
+	now := time.Unix(0, 0)
+	root := &Node{Type: TypeQuery}
+	ctx := NewContext(context.Background(), root, true)
+
+	sharding := FromContext(ctx).AddChild(&Node{Type: TypeSharding, Start: now})
+	ctx = WithNode(ctx, sharding)
+	require.Equal(t, sharding, FromContext(ctx))
+	for _, shard := range []string{"10_of_16", "2_of_16", "1_of_16"} {
+		FromContext(ctx).AddChild(&Node{
+			Type:   TypeDownstream,
+			Start:  now,
+			Shards: []string{shard},
+		}).SetStats(stats.Result{
+			Store: stats.Store{TotalChunksRef: 1},
+		})
+	}
+	root.AddChild(&Node{Type: TypeDownstream, Start: now.Add(-time.Hour)})
+
+	plan := root.Snapshot()
+	require.Len(t, plan.Children, 2)
+	require.Equal(t, TypeDownstream, plan.Children[0].Type)
+	require.Nil(t, plan.Children[0].Stats)
+
+	sharded := plan.Children[1]
+	require.Equal(t, TypeSharding, sharded.Type)
+	require.Equal(t, int64(3), sharded.Stats.Store.TotalChunksRef)
+	require.Equal(t, int64(3), plan.Stats.Store.TotalChunksRef)
+	var shards []string
+	for _, c := range sharded.Children {
+		shards = append(shards, c.Shards...)
+	}
+	require.Equal(t, []string{"1_of_16", "2_of_16", "10_of_16"}, shards)
+
+	// the snapshot is a copy.
+	sharding.SetQuery("foo")
+	require.Empty(t, sharded.Query)
+}
@@ -28,6 +28,14 @@ import (
   duration                time.Duration
   LiteralExpr             *literalExpr
   BinOpModifier           BinOpOptions
+  LabelParser             *labelParserExpr
+  LabelFilter             LabelFilterer
+  LabelFormat             labelFmt
+  LabelsFormat            []labelFmt
+  UnwrapExpr              *unwrapExpr
+  LabelReplaceExpr        SampleExpr
+  subqueryRange           subqueryRange
+  SubqueryExpr            *subqueryExpr
 }
 
 %start root
@@ -49,14 +57,36 @@ import (
 %type <BinOpExpr>             binOpExpr
 %type <LiteralExpr>           literalExpr
 %type <BinOpModifier>         binOpModifier
+%type <BinOpModifier>         boolModifier
+%type <BinOpModifier>         onOrIgnoringModifier
+%type <LabelParser>           labelParser
+%type <LabelFilter>           labelFilter
+%type <binOp>                 comparison
+%type <LabelFormat>           labelFormat
+%type <LabelsFormat>          labelsFormat
+%type <UnwrapExpr>            unwrapExpr
+%type <duration>              offsetExpr
+%type <LabelReplaceExpr>      labelReplaceExpr
+%type <SubqueryExpr>          subqueryExpr
 
-%token <str>      IDENTIFIER STRING NUMBER
-%token <duration> DURATION
-%token <val>      MATCHERS LABELS EQ RE NRE OPEN_BRACE CLOSE_BRACE OPEN_BRACKET CLOSE_BRACKET COMMA DOT PIPE_MATCH PIPE_EXACT
-                  OPEN_PARENTHESIS CLOSE_PARENTHESIS BY WITHOUT COUNT_OVER_TIME RATE SUM AVG MAX MIN COUNT STDDEV STDVAR BOTTOMK TOPK
-                  BYTES_OVER_TIME BYTES_RATE BOOL
+%token <str>      IDENTIFIER STRING NUMBER DURATION BYTES
+%token <duration> RANGE
+%token <subqueryRange> SUBQUERY_RANGE
+%token <val>      MATCHERS LABELS EQ RE NRE OPEN_BRACE CLOSE_BRACE OPEN_BRACKET CLOSE_BRACKET DOT PIPE_MATCH PIPE_EXACT
+                  CLOSE_PARENTHESIS BY WITHOUT COUNT_OVER_TIME RATE SUM AVG MAX MIN COUNT STDDEV STDVAR BOTTOMK TOPK
+                  BYTES_OVER_TIME BYTES_RATE BOOL JSON LOGFMT REGEXP PATTERN UNPACK LINE_FMT DECOLORIZE DISTINCT UNWRAP OFFSET ON IGNORING LABEL_REPLACE
+                  SUM_OVER_TIME AVG_OVER_TIME MAX_OVER_TIME MIN_OVER_TIME STDDEV_OVER_TIME STDVAR_OVER_TIME QUANTILE_OVER_TIME ABSENT_OVER_TIME
+                  FIRST_OVER_TIME LAST_OVER_TIME COUNT_VALUES SORT SORT_DESC
 
 // Operators are listed with increasing precedence.
+// PIPE has the lowest precedence so that `and`/`or` following a label filter are part of it.
+%left <val>   PIPE
+// A parenthesis following group_left/group_right is always its include list.
+%nonassoc <val> GROUP_LEFT GROUP_RIGHT
+%nonassoc <val> OPEN_PARENTHESIS
+// A comma following a label_format list is always part of it.
+%nonassoc <val> LABEL_FMT
+%nonassoc <val> COMMA
 %left <binOp> OR
 %left <binOp> AND UNLESS
 %left <binOp> CMP_EQ NEQ LT LTE GT GTE
@@ -78,26 +108,63 @@ metricExpr:
     | vectorAggregationExpr                         { $$ = $1 }
     | binOpExpr                                     { $$ = $1 }
     | literalExpr                                   { $$ = $1 }
+    | labelReplaceExpr                              { $$ = $1 }
     | OPEN_PARENTHESIS metricExpr CLOSE_PARENTHESIS { $$ = $2 }
     ;
 
 logExpr:
       selector                                    { $$ = newMatcherExpr($1)}
     | logExpr filter STRING                       { $$ = NewFilterExpr( $1, $2, $3 ) }
+    | logExpr filter IDENTIFIER OPEN_PARENTHESIS STRING CLOSE_PARENTHESIS { $$ = mustNewFilterFuncExpr( $1, $2, $3, $5 ) }
+    | logExpr PIPE labelParser                    { $$ = newLabelParserExpr( $1, $3 ) }
+    | logExpr PIPE labelFilter                    { $$ = newLabelFilterExpr( $1, $3 ) }
+    | logExpr PIPE LINE_FMT STRING                { $$ = mustNewLineFormatExpr( $1, $4 ) }
+    | logExpr PIPE LABEL_FMT labelsFormat         { $$ = mustNewLabelFormatExpr( $1, $4 ) }
+    | logExpr PIPE DECOLORIZE                     { $$ = newDecolorizeExpr( $1 ) }
+    | logExpr PIPE DISTINCT IDENTIFIER            { $$ = newDistinctExpr( $1, $4 ) }
     | OPEN_PARENTHESIS logExpr CLOSE_PARENTHESIS  { $$ = $2 }
     | logExpr filter error
     | logExpr error
     ;
 
 logRangeExpr:
-      logExpr DURATION { $$ = newLogRange($1, $2) } // <selector> <filters> <range>
+      logExpr RANGE { $$ = newLogRange($1, $2, nil) } // <selector> <filters> <range>
+    | logExpr RANGE offsetExpr                         { $$ = addOffsetToLogRangeExpr(newLogRange($1, $2, nil), $3) }
+    | logExpr unwrapExpr RANGE                         { $$ = newLogRange($1, $3, $2) }
+    | logExpr unwrapExpr RANGE offsetExpr              { $$ = addOffsetToLogRangeExpr(newLogRange($1, $3, $2), $4) }
     | logRangeExpr filter STRING                       { $$ = addFilterToLogRangeExpr( $1, $2, $3 ) }
+    | logRangeExpr filter IDENTIFIER OPEN_PARENTHESIS STRING CLOSE_PARENTHESIS { $$ = addFilterFuncToLogRangeExpr( $1, $2, $3, $5 ) }
+    | logRangeExpr PIPE labelParser                    { $$ = addLabelParserToLogRangeExpr( $1, $3 ) }
+    | logRangeExpr PIPE labelFilter                    { $$ = addLabelFilterToLogRangeExpr( $1, $3 ) }
+    | logRangeExpr PIPE LINE_FMT STRING                { $$ = addLineFormatToLogRangeExpr( $1, $4 ) }
+    | logRangeExpr PIPE LABEL_FMT labelsFormat         { $$ = addLabelFormatToLogRangeExpr( $1, $4 ) }
+    | logRangeExpr PIPE DECOLORIZE                     { $$ = addDecolorizeToLogRangeExpr( $1 ) }
+    | logRangeExpr unwrapExpr                          { $$ = addUnwrapToLogRangeExpr( $1, $2 ) }
     | OPEN_PARENTHESIS logRangeExpr CLOSE_PARENTHESIS  { $$ = $2 }
     | logRangeExpr filter error
     | logRangeExpr error
     ;
 
-rangeAggregationExpr: rangeOp OPEN_PARENTHESIS logRangeExpr CLOSE_PARENTHESIS { $$ = newRangeAggregationExpr($3,$1) };
+unwrapExpr:
+      PIPE UNWRAP IDENTIFIER                                              { $$ = mustNewUnwrapExpr($3, "") }
+    | PIPE UNWRAP IDENTIFIER OPEN_PARENTHESIS IDENTIFIER CLOSE_PARENTHESIS { $$ = mustNewUnwrapExpr($5, $3) }
+    ;
+
+offsetExpr:
+      OFFSET DURATION { $$ = mustNewOffsetExpr($2) }
+    ;
+
+rangeAggregationExpr:
+      rangeOp OPEN_PARENTHESIS logRangeExpr CLOSE_PARENTHESIS                  { $$ = mustNewRangeAggregationExpr($3, $1, nil) }
+    | rangeOp OPEN_PARENTHESIS NUMBER COMMA logRangeExpr CLOSE_PARENTHESIS     { $$ = mustNewRangeAggregationExpr($5, $1, &$3) }
+    | rangeOp OPEN_PARENTHESIS subqueryExpr CLOSE_PARENTHESIS                  { $$ = mustNewSubqueryAggregationExpr($3, $1, nil) }
+    | rangeOp OPEN_PARENTHESIS NUMBER COMMA subqueryExpr CLOSE_PARENTHESIS     { $$ = mustNewSubqueryAggregationExpr($5, $1, &$3) }
+    ;
+
+subqueryExpr:
+      metricExpr SUBQUERY_RANGE            { $$ = newSubqueryExpr($1, $2) }
+    | metricExpr SUBQUERY_RANGE offsetExpr { $$ = addOffsetToSubqueryExpr(newSubqueryExpr($1, $2), $3) }
+    ;
 
 vectorAggregationExpr:
     // Aggregations with 1 argument.
@@ -107,6 +174,10 @@ vectorAggregationExpr:
     // Aggregations with 2 arguments.
     | vectorOp OPEN_PARENTHESIS NUMBER COMMA metricExpr CLOSE_PARENTHESIS                 { $$ = mustNewVectorAggregationExpr($5, $1, nil, &$3) }
     | vectorOp OPEN_PARENTHESIS NUMBER COMMA metricExpr CLOSE_PARENTHESIS grouping        { $$ = mustNewVectorAggregationExpr($5, $1, $7, &$3) }
+    // Aggregations with a label name argument.
+    | vectorOp OPEN_PARENTHESIS STRING COMMA metricExpr CLOSE_PARENTHESIS                 { $$ = mustNewLabelParamVectorAggregationExpr($5, $1, nil, $3) }
+    | vectorOp OPEN_PARENTHESIS STRING COMMA metricExpr CLOSE_PARENTHESIS grouping        { $$ = mustNewLabelParamVectorAggregationExpr($5, $1, $7, $3) }
+    | vectorOp grouping OPEN_PARENTHESIS STRING COMMA metricExpr CLOSE_PARENTHESIS        { $$ = mustNewLabelParamVectorAggregationExpr($6, $1, $2, $4) }
     ;
 
 filter:
@@ -116,6 +187,44 @@ filter:
     | NEQ                              { $$ = labels.MatchNotEqual }
     ;
 
+labelParser:
+      JSON                             { $$ = mustNewLabelParserExpr(OpParserTypeJSON, "") }
+    | LOGFMT                           { $$ = mustNewLabelParserExpr(OpParserTypeLogfmt, "") }
+    | REGEXP STRING                    { $$ = mustNewLabelParserExpr(OpParserTypeRegexp, $2) }
+    | PATTERN STRING                   { $$ = mustNewLabelParserExpr(OpParserTypePattern, $2) }
+    | UNPACK                           { $$ = mustNewLabelParserExpr(OpParserTypeUnpack, "") }
+    ;
+
+labelFilter:
+      matcher                                          { $$ = newStringLabelFilter($1) }
+    | IDENTIFIER comparison DURATION                   { $$ = mustNewDurationLabelFilter($2, $1, $3) }
+    | IDENTIFIER comparison BYTES                      { $$ = mustNewBytesLabelFilter($2, $1, $3) }
+    | IDENTIFIER comparison NUMBER                     { $$ = mustNewNumericLabelFilter($2, $1, $3) }
+    | OPEN_PARENTHESIS labelFilter CLOSE_PARENTHESIS   { $$ = $2 }
+    | labelFilter AND labelFilter                      { $$ = newAndLabelFilter($1, $3) }
+    | labelFilter OR labelFilter                       { $$ = newOrLabelFilter($1, $3) }
+    ;
+
+labelsFormat:
+      labelFormat                      { $$ = []labelFmt{ $1 } }
+    | labelsFormat COMMA labelFormat   { $$ = append($1, $3) }
+    ;
+
+labelFormat:
+      IDENTIFIER EQ IDENTIFIER         { $$ = newRenameLabelFmt($1, $3) }
+    | IDENTIFIER EQ STRING             { $$ = newTemplateLabelFmt($1, $3) }
+    ;
+
+comparison:
+      GT                               { $$ = OpTypeGT }
+    | GTE                              { $$ = OpTypeGTE }
+    | LT                               { $$ = OpTypeLT }
+    | LTE                              { $$ = OpTypeLTE }
+    | NEQ                              { $$ = OpTypeNEQ }
+    | EQ                               { $$ = OpTypeCmpEQ }
+    | CMP_EQ                           { $$ = OpTypeCmpEQ }
+    ;
+
 selector:
       OPEN_BRACE matchers CLOSE_BRACE  { $$ = $2 }
     | OPEN_BRACE matchers error        { $$ = $2 }
@@ -134,7 +243,11 @@ matcher:
     | IDENTIFIER NRE STRING            { $$ = mustNewMatcher(labels.MatchNotRegexp, $1, $3) }
     ;
 
-// TODO(owen-d): add (on,ignoring) clauses to binOpExpr
+labelReplaceExpr:
+    LABEL_REPLACE OPEN_PARENTHESIS metricExpr COMMA STRING COMMA STRING COMMA STRING COMMA STRING CLOSE_PARENTHESIS
+      { $$ = mustNewLabelReplaceExpr($3, $5, $7, $9, $11)}
+    ;
+
 // Operator precedence only works if each of these is listed separately.
 binOpExpr:
          expr OR binOpModifier expr          { $$ = mustNewBinOpExpr("or", $3, $1, $4) }
@@ -155,10 +268,28 @@ binOpExpr:
          ;
 
 binOpModifier:
+           boolModifier                                                                 { $$ = $1 }
+           | onOrIgnoringModifier                                                       { $$ = $1 }
+           | onOrIgnoringModifier GROUP_LEFT                                            { $$ = newGroupModifier($1, CardManyToOne, nil) }
+           | onOrIgnoringModifier GROUP_LEFT OPEN_PARENTHESIS CLOSE_PARENTHESIS         { $$ = newGroupModifier($1, CardManyToOne, nil) }
+           | onOrIgnoringModifier GROUP_LEFT OPEN_PARENTHESIS labels CLOSE_PARENTHESIS  { $$ = newGroupModifier($1, CardManyToOne, $4) }
+           | onOrIgnoringModifier GROUP_RIGHT                                           { $$ = newGroupModifier($1, CardOneToMany, nil) }
+           | onOrIgnoringModifier GROUP_RIGHT OPEN_PARENTHESIS CLOSE_PARENTHESIS        { $$ = newGroupModifier($1, CardOneToMany, nil) }
+           | onOrIgnoringModifier GROUP_RIGHT OPEN_PARENTHESIS labels CLOSE_PARENTHESIS { $$ = newGroupModifier($1, CardOneToMany, $4) }
+           ;
+
+boolModifier:
            { $$ = BinOpOptions{} }
            | BOOL { $$ = BinOpOptions{ ReturnBool: true } }
            ;
 
+onOrIgnoringModifier:
+           boolModifier ON OPEN_PARENTHESIS CLOSE_PARENTHESIS                { $$ = newOnOrIgnoringModifier($1, true, nil) }
+           | boolModifier ON OPEN_PARENTHESIS labels CLOSE_PARENTHESIS       { $$ = newOnOrIgnoringModifier($1, true, $4) }
+           | boolModifier IGNORING OPEN_PARENTHESIS CLOSE_PARENTHESIS        { $$ = newOnOrIgnoringModifier($1, false, nil) }
+           | boolModifier IGNORING OPEN_PARENTHESIS labels CLOSE_PARENTHESIS { $$ = newOnOrIgnoringModifier($1, false, $4) }
+           ;
+
 literalExpr:
            NUMBER         { $$ = mustNewLiteralExpr( $1, false ) }
            | ADD NUMBER   { $$ = mustNewLiteralExpr( $2, false ) }
@@ -175,13 +306,26 @@ vectorOp:
       | STDVAR  { $$ = OpTypeStdvar }
       | BOTTOMK { $$ = OpTypeBottomK }
       | TOPK    { $$ = OpTypeTopK }
+      | COUNT_VALUES { $$ = OpTypeCountValues }
+      | SORT         { $$ = OpTypeSort }
+      | SORT_DESC    { $$ = OpTypeSortDesc }
       ;
 
 rangeOp:
-      COUNT_OVER_TIME { $$ = OpRangeTypeCount }
-    | RATE            { $$ = OpRangeTypeRate }
-    | BYTES_OVER_TIME { $$ = OpRangeTypeBytes }
-    | BYTES_RATE      { $$ = OpRangeTypeBytesRate }
+      COUNT_OVER_TIME    { $$ = OpRangeTypeCount }
+    | RATE               { $$ = OpRangeTypeRate }
+    | BYTES_OVER_TIME    { $$ = OpRangeTypeBytes }
+    | BYTES_RATE         { $$ = OpRangeTypeBytesRate }
+    | SUM_OVER_TIME      { $$ = OpRangeTypeSum }
+    | AVG_OVER_TIME      { $$ = OpRangeTypeAvg }
+    | MAX_OVER_TIME      { $$ = OpRangeTypeMax }
+    | MIN_OVER_TIME      { $$ = OpRangeTypeMin }
+    | STDDEV_OVER_TIME   { $$ = OpRangeTypeStddev }
+    | STDVAR_OVER_TIME   { $$ = OpRangeTypeStdvar }
+    | QUANTILE_OVER_TIME { $$ = OpRangeTypeQuantile }
+    | ABSENT_OVER_TIME   { $$ = OpRangeTypeAbsent }
+    | FIRST_OVER_TIME    { $$ = OpRangeTypeFirst }
+    | LAST_OVER_TIME     { $$ = OpRangeTypeLast }
     ;
 
 
@@ -193,5 +337,7 @@ labels:
 grouping:
       BY OPEN_PARENTHESIS labels CLOSE_PARENTHESIS        { $$ = &grouping{ without: false , groups: $3 } }
     | WITHOUT OPEN_PARENTHESIS labels CLOSE_PARENTHESIS   { $$ = &grouping{ without: true , groups: $3 } }
+    | BY OPEN_PARENTHESIS CLOSE_PARENTHESIS               { exprlex.(*lexer).emptyGrouping(); $$ = &grouping{ without: false , groups: nil } }
+    | WITHOUT OPEN_PARENTHESIS CLOSE_PARENTHESIS          { exprlex.(*lexer).emptyGrouping(); $$ = &grouping{ without: true , groups: nil } }
     ;
 %%
@@ -1,10 +1,8 @@
-// Code generated by goyacc -p expr -o pkg/logql/expr.y.go pkg/logql/expr.y. DO NOT EDIT.
-
+// Code generated by goyacc -l -p expr -o expr.y.go expr.y. DO NOT EDIT.
 package logql
 
 import __yyfmt__ "fmt"
 
-
 import (
 	"github.com/prometheus/prometheus/pkg/labels"
 	"time"
@@ -32,58 +30,99 @@ type exprSymType struct {
 	duration              time.Duration
 	LiteralExpr           *literalExpr
 	BinOpModifier         BinOpOptions
+	LabelParser           *labelParserExpr
+	LabelFilter           LabelFilterer
+	LabelFormat           labelFmt
+	LabelsFormat          []labelFmt
+	UnwrapExpr            *unwrapExpr
+	LabelReplaceExpr      SampleExpr
+	subqueryRange         subqueryRange
+	SubqueryExpr          *subqueryExpr
 }
 
 const IDENTIFIER = 57346
 const STRING = 57347
 const NUMBER = 57348
 const DURATION = 57349
-const MATCHERS = 57350
-const LABELS = 57351
-const EQ = 57352
-const RE = 57353
-const NRE = 57354
-const OPEN_BRACE = 57355
-const CLOSE_BRACE = 57356
-const OPEN_BRACKET = 57357
-const CLOSE_BRACKET = 57358
-const COMMA = 57359
-const DOT = 57360
-const PIPE_MATCH = 57361
-const PIPE_EXACT = 57362
-const OPEN_PARENTHESIS = 57363
-const CLOSE_PARENTHESIS = 57364
-const BY = 57365
-const WITHOUT = 57366
-const COUNT_OVER_TIME = 57367
-const RATE = 57368
-const SUM = 57369
-const AVG = 57370
-const MAX = 57371
-const MIN = 57372
-const COUNT = 57373
-const STDDEV = 57374
-const STDVAR = 57375
-const BOTTOMK = 57376
-const TOPK = 57377
-const BYTES_OVER_TIME = 57378
-const BYTES_RATE = 57379
-const BOOL = 57380
-const OR = 57381
-const AND = 57382
-const UNLESS = 57383
-const CMP_EQ = 57384
-const NEQ = 57385
-const LT = 57386
-const LTE = 57387
-const GT = 57388
-const GTE = 57389
-const ADD = 57390
-const SUB = 57391
-const MUL = 57392
-const DIV = 57393
-const MOD = 57394
-const POW = 57395
+const BYTES = 57350
+const RANGE = 57351
+const SUBQUERY_RANGE = 57352
+const MATCHERS = 57353
+const LABELS = 57354
+const EQ = 57355
+const RE = 57356
+const NRE = 57357
+const OPEN_BRACE = 57358
+const CLOSE_BRACE = 57359
+const OPEN_BRACKET = 57360
+const CLOSE_BRACKET = 57361
+const DOT = 57362
+const PIPE_MATCH = 57363
+const PIPE_EXACT = 57364
+const CLOSE_PARENTHESIS = 57365
+const BY = 57366
+const WITHOUT = 57367
+const COUNT_OVER_TIME = 57368
+const RATE = 57369
+const SUM = 57370
+const AVG = 57371
+const MAX = 57372
+const MIN = 57373
+const COUNT = 57374
+const STDDEV = 57375
+const STDVAR = 57376
+const BOTTOMK = 57377
+const TOPK = 57378
+const BYTES_OVER_TIME = 57379
+const BYTES_RATE = 57380
+const BOOL = 57381
+const JSON = 57382
+const LOGFMT = 57383
+const REGEXP = 57384
+const PATTERN = 57385
+const UNPACK = 57386
+const LINE_FMT = 57387
+const DECOLORIZE = 57388
+const DISTINCT = 57389
+const UNWRAP = 57390
+const OFFSET = 57391
+const ON = 57392
+const IGNORING = 57393
+const LABEL_REPLACE = 57394
+const SUM_OVER_TIME = 57395
+const AVG_OVER_TIME = 57396
+const MAX_OVER_TIME = 57397
+const MIN_OVER_TIME = 57398
+const STDDEV_OVER_TIME = 57399
+const STDVAR_OVER_TIME = 57400
+const QUANTILE_OVER_TIME = 57401
+const ABSENT_OVER_TIME = 57402
+const FIRST_OVER_TIME = 57403
+const LAST_OVER_TIME = 57404
+const COUNT_VALUES = 57405
+const SORT = 57406
+const SORT_DESC = 57407
+const PIPE = 57408
+const GROUP_LEFT = 57409
+const GROUP_RIGHT = 57410
+const OPEN_PARENTHESIS = 57411
+const LABEL_FMT = 57412
+const COMMA = 57413
+const OR = 57414
+const AND = 57415
+const UNLESS = 57416
+const CMP_EQ = 57417
+const NEQ = 57418
+const LT = 57419
+const LTE = 57420
+const GT = 57421
+const GTE = 57422
+const ADD = 57423
+const SUB = 57424
+const MUL = 57425
+const DIV = 57426
+const MOD = 57427
+const POW = 57428
 
 var exprToknames = [...]string{
 	"$end",
@@ -93,6 +132,9 @@ var exprToknames = [...]string{
 	"STRING",
 	"NUMBER",
 	"DURATION",
+	"BYTES",
+	"RANGE",
+	"SUBQUERY_RANGE",
 	"MATCHERS",
 	"LABELS",
 	"EQ",
@@ -102,11 +144,9 @@ var exprToknames = [...]string{
 	"CLOSE_BRACE",
 	"OPEN_BRACKET",
 	"CLOSE_BRACKET",
-	"COMMA",
 	"DOT",
 	"PIPE_MATCH",
 	"PIPE_EXACT",
-	"OPEN_PARENTHESIS",
 	"CLOSE_PARENTHESIS",
 	"BY",
 	"WITHOUT",
@@ -124,6 +164,38 @@ var exprToknames = [...]string{
 	"BYTES_OVER_TIME",
 	"BYTES_RATE",
 	"BOOL",
+	"JSON",
+	"LOGFMT",
+	"REGEXP",
+	"PATTERN",
+	"UNPACK",
+	"LINE_FMT",
+	"DECOLORIZE",
+	"DISTINCT",
+	"UNWRAP",
+	"OFFSET",
+	"ON",
+	"IGNORING",
+	"LABEL_REPLACE",
+	"SUM_OVER_TIME",
+	"AVG_OVER_TIME",
+	"MAX_OVER_TIME",
+	"MIN_OVER_TIME",
+	"STDDEV_OVER_TIME",
+	"STDVAR_OVER_TIME",
+	"QUANTILE_OVER_TIME",
+	"ABSENT_OVER_TIME",
+	"FIRST_OVER_TIME",
+	"LAST_OVER_TIME",
+	"COUNT_VALUES",
+	"SORT",
+	"SORT_DESC",
+	"PIPE",
+	"GROUP_LEFT",
+	"GROUP_RIGHT",
+	"OPEN_PARENTHESIS",
+	"LABEL_FMT",
+	"COMMA",
 	"OR",
 	"AND",
 	"UNLESS",
@@ -140,183 +212,312 @@ var exprToknames = [...]string{
 	"MOD",
 	"POW",
 }
+
 var exprStatenames = [...]string{}
 
 const exprEofCode = 1
 const exprErrCode = 2
 const exprInitialStackSize = 16
 
-
-var exprExca = [...]int{
+var exprExca = [...]int16{
 	-1, 1,
 	1, -1,
 	-2, 0,
 	-1, 3,
 	1, 2,
-	22, 2,
-	39, 2,
-	40, 2,
-	41, 2,
-	42, 2,
-	44, 2,
-	45, 2,
-	46, 2,
-	47, 2,
-	48, 2,
-	49, 2,
-	50, 2,
-	51, 2,
-	52, 2,
-	53, 2,
+	10, 2,
+	23, 2,
+	71, 2,
+	72, 2,
+	73, 2,
+	74, 2,
+	75, 2,
+	77, 2,
+	78, 2,
+	79, 2,
+	80, 2,
+	81, 2,
+	82, 2,
+	83, 2,
+	84, 2,
+	85, 2,
+	86, 2,
+	-2, 0,
+	-1, 68,
+	72, 2,
+	73, 2,
+	74, 2,
+	75, 2,
+	77, 2,
+	78, 2,
+	79, 2,
+	80, 2,
+	81, 2,
+	82, 2,
+	83, 2,
+	84, 2,
+	85, 2,
+	86, 2,
 	-2, 0,
-	-1, 52,
-	39, 2,
-	40, 2,
-	41, 2,
-	42, 2,
-	44, 2,
-	45, 2,
-	46, 2,
-	47, 2,
-	48, 2,
-	49, 2,
-	50, 2,
-	51, 2,
-	52, 2,
-	53, 2,
+	-1, 130,
+	72, 2,
+	73, 2,
+	74, 2,
+	75, 2,
+	77, 2,
+	78, 2,
+	79, 2,
+	80, 2,
+	81, 2,
+	82, 2,
+	83, 2,
+	84, 2,
+	85, 2,
+	86, 2,
+	-2, 0,
+	-1, 193,
+	72, 2,
+	73, 2,
+	74, 2,
+	75, 2,
+	77, 2,
+	78, 2,
+	79, 2,
+	80, 2,
+	81, 2,
+	82, 2,
+	83, 2,
+	84, 2,
+	85, 2,
+	86, 2,
 	-2, 0,
 }
 
 const exprPrivate = 57344
 
-const exprLast = 279
-
-var exprAct = [...]int{
-
-	60, 4, 45, 130, 56, 3, 93, 38, 51, 53,
-	2, 66, 52, 30, 31, 32, 39, 40, 43, 44,
-	41, 42, 33, 34, 35, 36, 37, 38, 14, 33,
-	34, 35, 36, 37, 38, 11, 35, 36, 37, 38,
-	89, 91, 92, 6, 61, 62, 142, 17, 18, 21,
-	22, 24, 25, 23, 26, 27, 28, 29, 19, 20,
-	59, 96, 61, 62, 94, 138, 127, 139, 11, 139,
-	15, 16, 141, 90, 140, 101, 95, 102, 103, 104,
-	105, 106, 107, 108, 109, 110, 111, 112, 113, 114,
-	115, 83, 116, 100, 99, 98, 121, 58, 128, 88,
-	129, 125, 126, 64, 132, 31, 32, 39, 40, 43,
-	44, 41, 42, 33, 34, 35, 36, 37, 38, 134,
-	63, 120, 133, 86, 46, 97, 10, 143, 136, 121,
-	137, 119, 11, 82, 49, 85, 81, 118, 87, 117,
-	6, 47, 48, 144, 17, 18, 21, 22, 24, 25,
-	23, 26, 27, 28, 29, 19, 20, 65, 55, 131,
-	57, 57, 9, 13, 8, 50, 5, 15, 16, 39,
-	40, 43, 44, 41, 42, 33, 34, 35, 36, 37,
-	38, 12, 7, 54, 1, 0, 0, 0, 0, 67,
-	68, 69, 70, 71, 72, 73, 74, 75, 76, 77,
-	78, 79, 80, 46, 0, 0, 0, 122, 124, 0,
-	0, 0, 122, 49, 0, 0, 0, 49, 0, 0,
-	47, 48, 49, 84, 47, 48, 0, 135, 46, 47,
-	48, 0, 123, 124, 0, 0, 0, 46, 49, 0,
-	0, 0, 0, 0, 50, 47, 48, 49, 50, 0,
-	0, 0, 0, 50, 47, 48, 0, 84, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 50,
-	0, 0, 0, 0, 0, 0, 0, 0, 50,
+const exprLast = 608
+
+var exprAct = [...]int16{
+	76, 4, 231, 163, 164, 201, 129, 60, 67, 185,
+	114, 104, 69, 2, 53, 3, 127, 50, 51, 52,
+	53, 103, 68, 72, 45, 46, 47, 54, 55, 58,
+	59, 56, 57, 48, 49, 50, 51, 52, 53, 46,
+	47, 54, 55, 58, 59, 56, 57, 48, 49, 50,
+	51, 52, 53, 54, 55, 58, 59, 56, 57, 48,
+	49, 50, 51, 52, 53, 48, 49, 50, 51, 52,
+	53, 219, 62, 161, 160, 160, 132, 133, 273, 191,
+	285, 282, 272, 139, 214, 65, 271, 170, 125, 126,
+	130, 63, 64, 118, 270, 140, 123, 125, 126, 145,
+	146, 147, 148, 149, 150, 151, 152, 153, 154, 155,
+	156, 157, 158, 269, 120, 240, 206, 243, 242, 275,
+	161, 160, 198, 197, 188, 257, 241, 115, 177, 119,
+	241, 210, 178, 67, 241, 183, 190, 209, 199, 232,
+	192, 208, 241, 186, 204, 207, 66, 193, 194, 176,
+	171, 174, 175, 172, 173, 159, 65, 186, 138, 124,
+	137, 62, 63, 64, 261, 241, 241, 136, 191, 81,
+	65, 74, 212, 213, 65, 85, 63, 64, 234, 287,
+	63, 64, 203, 121, 77, 78, 143, 144, 141, 142,
+	132, 203, 116, 77, 78, 230, 224, 236, 235, 237,
+	238, 251, 183, 192, 130, 229, 223, 184, 203, 203,
+	249, 283, 280, 246, 248, 250, 252, 66, 278, 254,
+	203, 184, 200, 15, 266, 190, 265, 247, 245, 75,
+	259, 66, 262, 12, 253, 66, 264, 183, 203, 205,
+	122, 239, 267, 19, 20, 33, 34, 36, 37, 35,
+	38, 39, 40, 41, 21, 22, 196, 202, 189, 117,
+	215, 218, 216, 217, 195, 233, 276, 277, 263, 18,
+	23, 24, 25, 26, 27, 28, 29, 30, 31, 32,
+	42, 43, 44, 135, 134, 115, 6, 222, 80, 221,
+	220, 255, 256, 102, 12, 101, 100, 286, 16, 17,
+	79, 284, 279, 274, 19, 20, 33, 34, 36, 37,
+	35, 38, 39, 40, 41, 21, 22, 258, 244, 180,
+	179, 109, 110, 111, 112, 113, 105, 107, 108, 228,
+	18, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+	32, 42, 43, 44, 15, 115, 281, 6, 211, 182,
+	116, 106, 181, 168, 12, 167, 162, 268, 260, 16,
+	17, 71, 165, 73, 19, 20, 33, 34, 36, 37,
+	35, 38, 39, 40, 41, 21, 22, 73, 166, 11,
+	169, 109, 110, 111, 112, 113, 225, 227, 84, 228,
+	18, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+	32, 42, 43, 44, 15, 115, 83, 6, 10, 9,
+	116, 226, 14, 8, 12, 5, 13, 7, 70, 16,
+	17, 1, 0, 0, 19, 20, 33, 34, 36, 37,
+	35, 38, 39, 40, 41, 21, 22, 0, 0, 0,
+	0, 109, 110, 111, 112, 113, 105, 107, 108, 0,
+	18, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+	32, 42, 43, 44, 128, 0, 0, 131, 0, 0,
+	116, 106, 0, 0, 12, 0, 0, 0, 0, 16,
+	17, 0, 0, 0, 19, 20, 33, 34, 36, 37,
+	35, 38, 39, 40, 41, 21, 22, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 82, 0, 0,
+	18, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+	32, 42, 43, 44, 186, 0, 0, 131, 62, 0,
+	0, 0, 0, 62, 0, 0, 0, 65, 0, 16,
+	17, 65, 0, 63, 64, 187, 65, 63, 64, 118,
+	0, 0, 63, 64, 86, 87, 88, 89, 90, 91,
+	92, 93, 94, 95, 96, 97, 98, 99, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 184, 0,
+	0, 0, 61, 0, 0, 0, 0, 61, 66, 0,
+	0, 0, 66, 0, 0, 0, 0, 66,
 }
-var exprPact = [...]int{
 
-	22, -1000, -26, 122, -1000, -1000, 22, -1000, -1000, -1000,
-	-1000, 156, 76, 39, -1000, 114, 97, -1000, -1000, -1000,
+var exprPact = [...]int16{
+	338, -1000, -48, 531, -1000, -1000, 338, -1000, -1000, -1000,
+	-1000, -1000, 359, 102, 160, -1000, 294, 282, 100, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
 	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
-	-27, -27, -27, -27, -27, -27, -27, -27, -27, -27,
-	-27, -27, -27, -27, -27, 131, -1000, -1000, -1000, -1000,
-	-1000, 69, 235, -26, 121, 85, -1000, 30, 55, 119,
-	74, 73, 72, -1000, -1000, 22, -1000, 22, 22, 22,
-	22, 22, 22, 22, 22, 22, 22, 22, 22, 22,
-	22, -1000, -1000, -1000, -1000, -1000, -1000, 157, -1000, 134,
-	132, 126, 116, 210, 226, 55, 44, 81, 22, 155,
-	155, 65, 127, 127, -14, -14, -46, -46, -46, -46,
-	-19, -19, -19, -19, -19, -19, -1000, -1000, -1000, -1000,
-	-1000, 117, -1000, -1000, -1000, 201, 205, 21, 22, 43,
-	52, -1000, 50, -1000, -1000, -1000, -1000, 24, -1000, 123,
-	-1000, -1000, 21, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, 136, 136, 136, 136, 136,
+	136, 136, 136, 136, 136, 136, 136, 136, 136, 136,
+	291, 401, -1000, -1000, -1000, -1000, -1000, 236, 526, -48,
+	112, 223, -1000, 83, 458, 278, 98, 91, 89, -1000,
+	-1000, 338, 338, 138, 119, -1000, 338, 338, 338, 338,
+	338, 338, 338, 338, 338, 338, 338, 338, 338, 338,
+	-1000, 86, -1000, -1000, 1, 351, 358, -1000, 374, -1000,
+	-1000, 350, 348, -1000, -1000, 74, 123, -1000, -1000, -1000,
+	-1000, 373, -1000, 315, 314, 347, 344, 522, 53, 235,
+	159, 398, 254, 233, 52, 51, 217, 234, 216, 45,
+	-34, 76, 72, 68, 62, -22, -22, -66, -66, -72,
+	-72, -72, -72, -16, -16, -16, -16, -16, -16, 343,
+	123, 123, -1000, 13, -1000, 247, -1000, -1000, -1000, 255,
+	315, 314, -1000, -1000, -1000, -1000, -1000, 48, -1000, -1000,
+	-1000, -1000, -1000, 285, 341, -1000, -1000, -1000, 398, -1000,
+	281, 90, 256, 70, 155, 90, 169, 338, 338, 218,
+	44, 95, -1000, -1000, 94, -1000, 313, 205, 204, 187,
+	178, 211, -1000, 2, 358, 287, -1000, -1000, -1000, -1000,
+	-1000, 56, -1000, -1000, 1, 312, 358, -1000, 354, 141,
+	209, -1000, 261, 90, -1000, -1000, -1000, 203, 201, -1000,
+	338, 353, -1000, -1000, 42, -1000, 71, -1000, 63, -1000,
+	59, -1000, 55, -1000, -1000, -1000, -1000, 298, -1000, 13,
+	50, -1000, -1000, -1000, -1000, 169, 169, 195, -1000, 297,
+	-1000, -1000, -1000, -1000, 189, 342, -1000, -1000, -1000, 10,
+	-1000, 188, 296, -1000, 9, 292, 156, -1000,
 }
-var exprPgo = [...]int{
 
-	0, 184, 9, 2, 0, 3, 5, 1, 6, 4,
-	183, 182, 181, 166, 164, 163, 162, 126, 157,
+var exprPgo = [...]int16{
+	0, 421, 12, 7, 0, 5, 15, 1, 16, 10,
+	418, 417, 416, 415, 413, 412, 409, 408, 507, 406,
+	388, 21, 11, 380, 4, 3, 9, 2, 379, 6,
 }
-var exprR1 = [...]int{
-
-	0, 1, 2, 2, 7, 7, 7, 7, 7, 6,
-	6, 6, 6, 6, 8, 8, 8, 8, 8, 11,
-	14, 14, 14, 14, 14, 3, 3, 3, 3, 13,
-	13, 13, 10, 10, 9, 9, 9, 9, 16, 16,
-	16, 16, 16, 16, 16, 16, 16, 16, 16, 16,
-	16, 16, 16, 18, 18, 17, 17, 17, 15, 15,
-	15, 15, 15, 15, 15, 15, 15, 12, 12, 12,
-	12, 5, 5, 4, 4,
+
+var exprR1 = [...]int8{
+	0, 1, 2, 2, 7, 7, 7, 7, 7, 7,
+	6, 6, 6, 6, 6, 6, 6, 6, 6, 6,
+	6, 6, 8, 8, 8, 8, 8, 8, 8, 8,
+	8, 8, 8, 8, 8, 8, 8, 26, 26, 27,
+	11, 11, 11, 11, 29, 29, 14, 14, 14, 14,
+	14, 14, 14, 14, 3, 3, 3, 3, 21, 21,
+	21, 21, 21, 22, 22, 22, 22, 22, 22, 22,
+	25, 25, 24, 24, 23, 23, 23, 23, 23, 23,
+	23, 13, 13, 13, 10, 10, 9, 9, 9, 9,
+	28, 16, 16, 16, 16, 16, 16, 16, 16, 16,
+	16, 16, 16, 16, 16, 16, 18, 18, 18, 18,
+	18, 18, 18, 18, 19, 19, 20, 20, 20, 20,
+	17, 17, 17, 15, 15, 15, 15, 15, 15, 15,
+	15, 15, 15, 15, 15, 12, 12, 12, 12, 12,
+	12, 12, 12, 12, 12, 12, 12, 12, 12, 5,
+	5, 4, 4, 4, 4,
 }
-var exprR2 = [...]int{
-
-	0, 1, 1, 1, 1, 1, 1, 1, 3, 1,
-	3, 3, 3, 2, 2, 3, 3, 3, 2, 4,
-	4, 5, 5, 6, 7, 1, 1, 1, 1, 3,
-	3, 3, 1, 3, 3, 3, 3, 3, 4, 4,
-	4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
-	4, 4, 4, 0, 1, 1, 2, 2, 1, 1,
+
+var exprR2 = [...]int8{
+	0, 1, 1, 1, 1, 1, 1, 1, 1, 3,
+	1, 3, 6, 3, 3, 4, 4, 3, 4, 3,
+	3, 2, 2, 3, 3, 4, 3, 6, 3, 3,
+	4, 4, 3, 2, 3, 3, 2, 3, 6, 2,
+	4, 6, 4, 6, 2, 3, 4, 5, 5, 6,
+	7, 6, 7, 7, 1, 1, 1, 1, 1, 1,
+	2, 2, 1, 1, 3, 3, 3, 3, 3, 3,
+	1, 3, 3, 3, 1, 1, 1, 1, 1, 1,
+	1, 3, 3, 3, 1, 3, 3, 3, 3, 3,
+	12, 4, 4, 4, 4, 4, 4, 4, 4, 4,
+	4, 4, 4, 4, 4, 4, 1, 1, 2, 4,
+	5, 2, 4, 5, 0, 1, 4, 5, 4, 5,
+	1, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
 	1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
-	1, 1, 3, 4, 4,
+	3, 4, 4, 3, 3,
 }
-var exprChk = [...]int{
-
-	-1000, -1, -2, -6, -7, -13, 21, -11, -14, -16,
-	-17, 13, -12, -15, 6, 48, 49, 25, 26, 36,
-	37, 27, 28, 31, 29, 30, 32, 33, 34, 35,
-	39, 40, 41, 48, 49, 50, 51, 52, 53, 42,
-	43, 46, 47, 44, 45, -3, 2, 19, 20, 12,
-	43, -7, -6, -2, -10, 2, -9, 4, 21, 21,
-	-4, 23, 24, 6, 6, -18, 38, -18, -18, -18,
+
+var exprChk = [...]int16{
+	-1000, -1, -2, -6, -7, -13, 69, -11, -14, -16,
+	-17, -28, 16, -12, -15, 6, 81, 82, 52, 26,
+	27, 37, 38, 53, 54, 55, 56, 57, 58, 59,
+	60, 61, 62, 28, 29, 32, 30, 31, 33, 34,
+	35, 36, 63, 64, 65, 72, 73, 74, 81, 82,
+	83, 84, 85, 86, 75, 76, 79, 80, 77, 78,
+	-3, 66, 2, 21, 22, 15, 76, -7, -6, -2,
+	-10, 2, -9, 4, 69, 69, -4, 24, 25, 6,
+	6, 69, -18, -19, -20, 39, -18, -18, -18, -18,
 	-18, -18, -18, -18, -18, -18, -18, -18, -18, -18,
-	-18, 5, 2, 22, 22, 14, 2, 17, 14, 10,
-	43, 11, 12, -8, -6, 21, -7, 6, 21, 21,
-	21, -2, -2, -2, -2, -2, -2, -2, -2, -2,
-	-2, -2, -2, -2, -2, -2, -9, 5, 5, 5,
-	5, -3, 2, 22, 7, -6, -8, 22, 17, -7,
-	-5, 4, -5, 5, 2, 22, -4, -7, 22, 17,
-	22, 22, 22, 4, -4,
+	5, 4, 2, -21, -22, 45, 70, 46, 47, 40,
+	41, 42, 43, 44, -9, 4, 69, 23, 23, 17,
+	2, 71, 17, 13, 76, 14, 15, -8, 6, -29,
+	-6, 69, -7, -7, 6, 5, 69, 69, 69, -7,
+	-2, 50, 51, 67, 68, -2, -2, -2, -2, -2,
+	-2, -2, -2, -2, -2, -2, -2, -2, -2, 69,
+	73, 72, 5, -25, -24, 4, 4, 5, 5, -23,
+	13, 76, 79, 80, 77, 78, 75, -22, -9, 5,
+	5, 5, 5, -3, 66, -26, 2, 23, 71, 23,
+	66, 9, -26, -6, -8, 10, 23, 71, 71, -7,
+	5, -5, 23, 4, -5, 23, 71, 69, 69, 69,
+	69, 5, -22, -22, 71, 13, 7, 8, 6, 23,
+	5, 4, 2, -21, -22, 45, 70, 46, 48, -8,
+	-29, -27, 49, 9, 23, -27, -4, -7, -7, 23,
+	71, 71, 23, 23, 5, 23, -5, 23, -5, 23,
+	-5, 23, -5, 23, -24, 4, 5, 69, 5, -25,
+	4, 23, 23, 7, -27, 23, 23, -7, 4, 71,
+	23, 23, 23, 23, 5, 69, -4, -4, 23, 5,
+	23, 4, 71, 23, 5, 71, 5, 23,
 }
-var exprDef = [...]int{
-
-	0, -2, 1, -2, 3, 9, 0, 4, 5, 6,
-	7, 0, 0, 0, 55, 0, 0, 67, 68, 69,
-	70, 58, 59, 60, 61, 62, 63, 64, 65, 66,
-	53, 53, 53, 53, 53, 53, 53, 53, 53, 53,
-	53, 53, 53, 53, 53, 0, 13, 25, 26, 27,
-	28, 3, -2, 0, 0, 0, 32, 0, 0, 0,
-	0, 0, 0, 56, 57, 0, 54, 0, 0, 0,
+
+var exprDef = [...]int16{
+	0, -2, 1, -2, 3, 10, 0, 4, 5, 6,
+	7, 8, 0, 0, 0, 120, 0, 0, 0, 135,
+	136, 137, 138, 139, 140, 141, 142, 143, 144, 145,
+	146, 147, 148, 123, 124, 125, 126, 127, 128, 129,
+	130, 131, 132, 133, 134, 114, 114, 114, 114, 114,
+	114, 114, 114, 114, 114, 114, 114, 114, 114, 114,
+	0, 0, 21, 54, 55, 56, 57, 3, -2, 0,
+	0, 0, 84, 0, 0, 0, 0, 0, 0, 121,
+	122, 0, 0, 106, 107, 115, 0, 0, 0, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 10, 12, 8, 11, 29, 30, 0, 31, 0,
-	0, 0, 0, 0, 0, 0, 3, 55, 0, 0,
-	0, 38, 39, 40, 41, 42, 43, 44, 45, 46,
-	47, 48, 49, 50, 51, 52, 33, 34, 35, 36,
-	37, 0, 18, 19, 14, 0, 0, 20, 0, 3,
-	0, 71, 0, 15, 17, 16, 22, 3, 21, 0,
-	73, 74, 23, 72, 24,
+	11, 0, 20, 13, 14, 0, 0, 17, 0, 58,
+	59, 0, 0, 62, 63, 0, 0, 9, 19, 81,
+	82, 0, 83, 0, 0, 0, 0, 0, 120, 0,
+	-2, 0, 3, 3, 120, 0, 0, 0, 0, 3,
+	91, 0, 0, 108, 111, 92, 93, 94, 95, 96,
+	97, 98, 99, 100, 101, 102, 103, 104, 105, 0,
+	0, 0, 15, 16, 70, 0, 18, 60, 61, 0,
+	79, 78, 74, 75, 76, 77, 80, 0, 85, 86,
+	87, 88, 89, 0, 0, 33, 36, 40, 0, 42,
+	0, 22, 0, -2, 0, 44, 46, 0, 0, 3,
+	0, 0, 153, 149, 0, 154, 0, 0, 0, 0,
+	0, 0, 68, 69, 0, 0, 64, 65, 66, 67,
+	26, 0, 35, 28, 29, 0, 0, 32, 0, 0,
+	0, 23, 0, 24, 34, 45, 48, 3, 3, 47,
+	0, 0, 151, 152, 0, 116, 0, 118, 0, 109,
+	0, 112, 0, 12, 71, 72, 73, 0, 30, 31,
+	37, 41, 43, 39, 25, 49, 51, 3, 150, 0,
+	117, 119, 110, 113, 0, 0, 50, 52, 53, 0,
+	27, 0, 0, 38, 0, 0, 0, 90,
 }
-var exprTok1 = [...]int{
 
+var exprTok1 = [...]int8{
 	1,
 }
-var exprTok2 = [...]int{
 
+var exprTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
 	32, 33, 34, 35, 36, 37, 38, 39, 40, 41,
 	42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
-	52, 53,
+	52, 53, 54, 55, 56, 57, 58, 59, 60, 61,
+	62, 63, 64, 65, 66, 67, 68, 69, 70, 71,
+	72, 73, 74, 75, 76, 77, 78, 79, 80, 81,
+	82, 83, 84, 85, 86,
 }
-var exprTok3 = [...]int{
+
+var exprTok3 = [...]int8{
 	0,
 }
 
@@ -326,7 +527,6 @@ var exprErrorMessages = [...]struct {
 	msg   string
 }{}
 
-
 /*	parser for yacc output	*/
 
 var (
@@ -397,9 +597,9 @@ func exprErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := exprPact[state]
+	base := int(exprPact[state])
 	for tok := TOKSTART; tok-1 < len(exprToknames); tok++ {
-		if n := base + tok; n >= 0 && n < exprLast && exprChk[exprAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < exprLast && int(exprChk[int(exprAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -409,13 +609,13 @@ func exprErrorMessage(state, lookAhead int) string {
 
 	if exprDef[state] == -2 {
 		i := 0
-		for exprExca[i] != -1 || exprExca[i+1] != state {
+		for exprExca[i] != -1 || int(exprExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; exprExca[i] >= 0; i += 2 {
-			tok := exprExca[i]
+			tok := int(exprExca[i])
 			if tok < TOKSTART || exprExca[i+1] == 0 {
 				continue
 			}
@@ -446,30 +646,30 @@ func exprlex1(lex exprLexer, lval *exprSymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = exprTok1[0]
+		token = int(exprTok1[0])
 		goto out
 	}
 	if char < len(exprTok1) {
-		token = exprTok1[char]
+		token = int(exprTok1[char])
 		goto out
 	}
 	if char >= exprPrivate {
 		if char < exprPrivate+len(exprTok2) {
-			token = exprTok2[char-exprPrivate]
+			token = int(exprTok2[char-exprPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(exprTok3); i += 2 {
-		token = exprTok3[i+0]
+		token = int(exprTok3[i+0])
 		if token == char {
-			token = exprTok3[i+1]
+			token = int(exprTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = exprTok2[1] /* unknown char */
+		token = int(exprTok2[1]) /* unknown char */
 	}
 	if exprDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", exprTokname(token), uint(char))
@@ -524,7 +724,7 @@ exprstack:
 	exprS[exprp].yys = exprstate
 
 exprnewstate:
-	exprn = exprPact[exprstate]
+	exprn = int(exprPact[exprstate])
 	if exprn <= exprFlag {
 		goto exprdefault /* simple state */
 	}
@@ -535,8 +735,8 @@ exprnewstate:
 	if exprn < 0 || exprn >= exprLast {
 		goto exprdefault
 	}
-	exprn = exprAct[exprn]
-	if exprChk[exprn] == exprtoken { /* valid shift */
+	exprn = int(exprAct[exprn])
+	if int(exprChk[exprn]) == exprtoken { /* valid shift */
 		exprrcvr.char = -1
 		exprtoken = -1
 		exprVAL = exprrcvr.lval
@@ -549,7 +749,7 @@ exprnewstate:
 
 exprdefault:
 	/* default state action */
-	exprn = exprDef[exprstate]
+	exprn = int(exprDef[exprstate])
 	if exprn == -2 {
 		if exprrcvr.char < 0 {
 			exprrcvr.char, exprtoken = exprlex1(exprlex, &exprrcvr.lval)
@@ -558,18 +758,18 @@ exprdefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if exprExca[xi+0] == -1 && exprExca[xi+1] == exprstate {
+			if exprExca[xi+0] == -1 && int(exprExca[xi+1]) == exprstate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			exprn = exprExca[xi+0]
+			exprn = int(exprExca[xi+0])
 			if exprn < 0 || exprn == exprtoken {
 				break
 			}
 		}
-		exprn = exprExca[xi+1]
+		exprn = int(exprExca[xi+1])
 		if exprn < 0 {
 			goto ret0
 		}
@@ -591,10 +791,10 @@ exprdefault:
 
 			/* find a state where "error" is a legal shift action */
 			for exprp >= 0 {
-				exprn = exprPact[exprS[exprp].yys] + exprErrCode
+				exprn = int(exprPact[exprS[exprp].yys]) + exprErrCode
 				if exprn >= 0 && exprn < exprLast {
-					exprstate = exprAct[exprn] /* simulate a shift of "error" */
-					if exprChk[exprstate] == exprErrCode {
+					exprstate = int(exprAct[exprn]) /* simulate a shift of "error" */
+					if int(exprChk[exprstate]) == exprErrCode {
 						goto exprstack
 					}
 				}
@@ -630,7 +830,7 @@ exprdefault:
 	exprpt := exprp
 	_ = exprpt // guard against "declared and not used"
 
-	exprp -= exprR2[exprn]
+	exprp -= int(exprR2[exprn])
 	// exprp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if exprp+1 >= len(exprS) {
@@ -641,16 +841,16 @@ exprdefault:
 	exprVAL = exprS[exprp+1]
 
 	/* consult goto table to find next state */
-	exprn = exprR1[exprn]
-	exprg := exprPgo[exprn]
+	exprn = int(exprR1[exprn])
+	exprg := int(exprPgo[exprn])
 	exprj := exprg + exprS[exprp].yys + 1
 
 	if exprj >= exprLast {
-		exprstate = exprAct[exprg]
+		exprstate = int(exprAct[exprg])
 	} else {
-		exprstate = exprAct[exprj]
-		if exprChk[exprstate] != -exprn {
-			exprstate = exprAct[exprg]
+		exprstate = int(exprAct[exprj])
+		if int(exprChk[exprstate]) != -exprn {
+			exprstate = int(exprAct[exprg])
 		}
 	}
 	// dummy call; replaced with literal code
@@ -692,319 +892,721 @@ exprdefault:
 			exprVAL.MetricExpr = exprDollar[1].LiteralExpr
 		}
 	case 8:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.MetricExpr = exprDollar[1].LabelReplaceExpr
+		}
+	case 9:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.MetricExpr = exprDollar[2].MetricExpr
 		}
-	case 9:
+	case 10:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.LogExpr = newMatcherExpr(exprDollar[1].Selector)
 		}
-	case 10:
+	case 11:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.LogExpr = NewFilterExpr(exprDollar[1].LogExpr, exprDollar[2].Filter, exprDollar[3].str)
 		}
-	case 11:
+	case 12:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+		{
+			exprVAL.LogExpr = mustNewFilterFuncExpr(exprDollar[1].LogExpr, exprDollar[2].Filter, exprDollar[3].str, exprDollar[5].str)
+		}
+	case 13:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
-			exprVAL.LogExpr = exprDollar[2].LogExpr
+			exprVAL.LogExpr = newLabelParserExpr(exprDollar[1].LogExpr, exprDollar[3].LabelParser)
 		}
 	case 14:
-		exprDollar = exprS[exprpt-2 : exprpt+1]
+		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
-			exprVAL.LogRangeExpr = newLogRange(exprDollar[1].LogExpr, exprDollar[2].duration)
+			exprVAL.LogExpr = newLabelFilterExpr(exprDollar[1].LogExpr, exprDollar[3].LabelFilter)
 		}
 	case 15:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.LogExpr = mustNewLineFormatExpr(exprDollar[1].LogExpr, exprDollar[4].str)
+		}
+	case 16:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.LogExpr = mustNewLabelFormatExpr(exprDollar[1].LogExpr, exprDollar[4].LabelsFormat)
+		}
+	case 17:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LogExpr = newDecolorizeExpr(exprDollar[1].LogExpr)
+		}
+	case 18:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.LogExpr = newDistinctExpr(exprDollar[1].LogExpr, exprDollar[4].str)
+		}
+	case 19:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LogExpr = exprDollar[2].LogExpr
+		}
+	case 22:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = newLogRange(exprDollar[1].LogExpr, exprDollar[2].duration, nil)
+		}
+	case 23:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addOffsetToLogRangeExpr(newLogRange(exprDollar[1].LogExpr, exprDollar[2].duration, nil), exprDollar[3].duration)
+		}
+	case 24:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = newLogRange(exprDollar[1].LogExpr, exprDollar[3].duration, exprDollar[2].UnwrapExpr)
+		}
+	case 25:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addOffsetToLogRangeExpr(newLogRange(exprDollar[1].LogExpr, exprDollar[3].duration, exprDollar[2].UnwrapExpr), exprDollar[4].duration)
+		}
+	case 26:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.LogRangeExpr = addFilterToLogRangeExpr(exprDollar[1].LogRangeExpr, exprDollar[2].Filter, exprDollar[3].str)
 		}
-	case 16:
+	case 27:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addFilterFuncToLogRangeExpr(exprDollar[1].LogRangeExpr, exprDollar[2].Filter, exprDollar[3].str, exprDollar[5].str)
+		}
+	case 28:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addLabelParserToLogRangeExpr(exprDollar[1].LogRangeExpr, exprDollar[3].LabelParser)
+		}
+	case 29:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addLabelFilterToLogRangeExpr(exprDollar[1].LogRangeExpr, exprDollar[3].LabelFilter)
+		}
+	case 30:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addLineFormatToLogRangeExpr(exprDollar[1].LogRangeExpr, exprDollar[4].str)
+		}
+	case 31:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addLabelFormatToLogRangeExpr(exprDollar[1].LogRangeExpr, exprDollar[4].LabelsFormat)
+		}
+	case 32:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addDecolorizeToLogRangeExpr(exprDollar[1].LogRangeExpr)
+		}
+	case 33:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.LogRangeExpr = addUnwrapToLogRangeExpr(exprDollar[1].LogRangeExpr, exprDollar[2].UnwrapExpr)
+		}
+	case 34:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.LogRangeExpr = exprDollar[2].LogRangeExpr
 		}
-	case 19:
+	case 37:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.UnwrapExpr = mustNewUnwrapExpr(exprDollar[3].str, "")
+		}
+	case 38:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+		{
+			exprVAL.UnwrapExpr = mustNewUnwrapExpr(exprDollar[5].str, exprDollar[3].str)
+		}
+	case 39:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.duration = mustNewOffsetExpr(exprDollar[2].str)
+		}
+	case 40:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
-			exprVAL.RangeAggregationExpr = newRangeAggregationExpr(exprDollar[3].LogRangeExpr, exprDollar[1].RangeOp)
+			exprVAL.RangeAggregationExpr = mustNewRangeAggregationExpr(exprDollar[3].LogRangeExpr, exprDollar[1].RangeOp, nil)
+		}
+	case 41:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+		{
+			exprVAL.RangeAggregationExpr = mustNewRangeAggregationExpr(exprDollar[5].LogRangeExpr, exprDollar[1].RangeOp, &exprDollar[3].str)
 		}
-	case 20:
+	case 42:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.RangeAggregationExpr = mustNewSubqueryAggregationExpr(exprDollar[3].SubqueryExpr, exprDollar[1].RangeOp, nil)
+		}
+	case 43:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+		{
+			exprVAL.RangeAggregationExpr = mustNewSubqueryAggregationExpr(exprDollar[5].SubqueryExpr, exprDollar[1].RangeOp, &exprDollar[3].str)
+		}
+	case 44:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.SubqueryExpr = newSubqueryExpr(exprDollar[1].MetricExpr, exprDollar[2].subqueryRange)
+		}
+	case 45:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.SubqueryExpr = addOffsetToSubqueryExpr(newSubqueryExpr(exprDollar[1].MetricExpr, exprDollar[2].subqueryRange), exprDollar[3].duration)
+		}
+	case 46:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.VectorAggregationExpr = mustNewVectorAggregationExpr(exprDollar[3].MetricExpr, exprDollar[1].VectorOp, nil, nil)
 		}
-	case 21:
+	case 47:
 		exprDollar = exprS[exprpt-5 : exprpt+1]
 		{
 			exprVAL.VectorAggregationExpr = mustNewVectorAggregationExpr(exprDollar[4].MetricExpr, exprDollar[1].VectorOp, exprDollar[2].Grouping, nil)
 		}
-	case 22:
+	case 48:
 		exprDollar = exprS[exprpt-5 : exprpt+1]
 		{
 			exprVAL.VectorAggregationExpr = mustNewVectorAggregationExpr(exprDollar[3].MetricExpr, exprDollar[1].VectorOp, exprDollar[5].Grouping, nil)
 		}
-	case 23:
+	case 49:
 		exprDollar = exprS[exprpt-6 : exprpt+1]
 		{
 			exprVAL.VectorAggregationExpr = mustNewVectorAggregationExpr(exprDollar[5].MetricExpr, exprDollar[1].VectorOp, nil, &exprDollar[3].str)
 		}
-	case 24:
+	case 50:
 		exprDollar = exprS[exprpt-7 : exprpt+1]
 		{
 			exprVAL.VectorAggregationExpr = mustNewVectorAggregationExpr(exprDollar[5].MetricExpr, exprDollar[1].VectorOp, exprDollar[7].Grouping, &exprDollar[3].str)
 		}
-	case 25:
+	case 51:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+		{
+			exprVAL.VectorAggregationExpr = mustNewLabelParamVectorAggregationExpr(exprDollar[5].MetricExpr, exprDollar[1].VectorOp, nil, exprDollar[3].str)
+		}
+	case 52:
+		exprDollar = exprS[exprpt-7 : exprpt+1]
+		{
+			exprVAL.VectorAggregationExpr = mustNewLabelParamVectorAggregationExpr(exprDollar[5].MetricExpr, exprDollar[1].VectorOp, exprDollar[7].Grouping, exprDollar[3].str)
+		}
+	case 53:
+		exprDollar = exprS[exprpt-7 : exprpt+1]
+		{
+			exprVAL.VectorAggregationExpr = mustNewLabelParamVectorAggregationExpr(exprDollar[6].MetricExpr, exprDollar[1].VectorOp, exprDollar[2].Grouping, exprDollar[4].str)
+		}
+	case 54:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.Filter = labels.MatchRegexp
 		}
-	case 26:
+	case 55:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.Filter = labels.MatchEqual
 		}
-	case 27:
+	case 56:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.Filter = labels.MatchNotRegexp
 		}
-	case 28:
+	case 57:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.Filter = labels.MatchNotEqual
 		}
-	case 29:
+	case 58:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.LabelParser = mustNewLabelParserExpr(OpParserTypeJSON, "")
+		}
+	case 59:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.LabelParser = mustNewLabelParserExpr(OpParserTypeLogfmt, "")
+		}
+	case 60:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.LabelParser = mustNewLabelParserExpr(OpParserTypeRegexp, exprDollar[2].str)
+		}
+	case 61:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.LabelParser = mustNewLabelParserExpr(OpParserTypePattern, exprDollar[2].str)
+		}
+	case 62:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.LabelParser = mustNewLabelParserExpr(OpParserTypeUnpack, "")
+		}
+	case 63:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.LabelFilter = newStringLabelFilter(exprDollar[1].Matcher)
+		}
+	case 64:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFilter = mustNewDurationLabelFilter(exprDollar[2].binOp, exprDollar[1].str, exprDollar[3].str)
+		}
+	case 65:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFilter = mustNewBytesLabelFilter(exprDollar[2].binOp, exprDollar[1].str, exprDollar[3].str)
+		}
+	case 66:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFilter = mustNewNumericLabelFilter(exprDollar[2].binOp, exprDollar[1].str, exprDollar[3].str)
+		}
+	case 67:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFilter = exprDollar[2].LabelFilter
+		}
+	case 68:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFilter = newAndLabelFilter(exprDollar[1].LabelFilter, exprDollar[3].LabelFilter)
+		}
+	case 69:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFilter = newOrLabelFilter(exprDollar[1].LabelFilter, exprDollar[3].LabelFilter)
+		}
+	case 70:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.LabelsFormat = []labelFmt{exprDollar[1].LabelFormat}
+		}
+	case 71:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelsFormat = append(exprDollar[1].LabelsFormat, exprDollar[3].LabelFormat)
+		}
+	case 72:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFormat = newRenameLabelFmt(exprDollar[1].str, exprDollar[3].str)
+		}
+	case 73:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprVAL.LabelFormat = newTemplateLabelFmt(exprDollar[1].str, exprDollar[3].str)
+		}
+	case 74:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.binOp = OpTypeGT
+		}
+	case 75:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.binOp = OpTypeGTE
+		}
+	case 76:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.binOp = OpTypeLT
+		}
+	case 77:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.binOp = OpTypeLTE
+		}
+	case 78:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.binOp = OpTypeNEQ
+		}
+	case 79:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.binOp = OpTypeCmpEQ
+		}
+	case 80:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.binOp = OpTypeCmpEQ
+		}
+	case 81:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Selector = exprDollar[2].Matchers
 		}
-	case 30:
+	case 82:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Selector = exprDollar[2].Matchers
 		}
-	case 31:
+	case 83:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 		}
-	case 32:
+	case 84:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.Matchers = []*labels.Matcher{exprDollar[1].Matcher}
 		}
-	case 33:
+	case 85:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Matchers = append(exprDollar[1].Matchers, exprDollar[3].Matcher)
 		}
-	case 34:
+	case 86:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Matcher = mustNewMatcher(labels.MatchEqual, exprDollar[1].str, exprDollar[3].str)
 		}
-	case 35:
+	case 87:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Matcher = mustNewMatcher(labels.MatchNotEqual, exprDollar[1].str, exprDollar[3].str)
 		}
-	case 36:
+	case 88:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Matcher = mustNewMatcher(labels.MatchRegexp, exprDollar[1].str, exprDollar[3].str)
 		}
-	case 37:
+	case 89:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Matcher = mustNewMatcher(labels.MatchNotRegexp, exprDollar[1].str, exprDollar[3].str)
 		}
-	case 38:
+	case 90:
+		exprDollar = exprS[exprpt-12 : exprpt+1]
+		{
+			exprVAL.LabelReplaceExpr = mustNewLabelReplaceExpr(exprDollar[3].MetricExpr, exprDollar[5].str, exprDollar[7].str, exprDollar[9].str, exprDollar[11].str)
+		}
+	case 91:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("or", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 39:
+	case 92:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("and", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 40:
+	case 93:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("unless", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 41:
+	case 94:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("+", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 42:
+	case 95:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("-", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 43:
+	case 96:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("*", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 44:
+	case 97:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("/", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 45:
+	case 98:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("%", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 46:
+	case 99:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("^", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 47:
+	case 100:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("==", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 48:
+	case 101:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("!=", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 49:
+	case 102:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr(">", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 50:
+	case 103:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr(">=", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 51:
+	case 104:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("<", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 52:
+	case 105:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.BinOpExpr = mustNewBinOpExpr("<=", exprDollar[3].BinOpModifier, exprDollar[1].Expr, exprDollar[4].Expr)
 		}
-	case 53:
+	case 106:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = exprDollar[1].BinOpModifier
+		}
+	case 107:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = exprDollar[1].BinOpModifier
+		}
+	case 108:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newGroupModifier(exprDollar[1].BinOpModifier, CardManyToOne, nil)
+		}
+	case 109:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newGroupModifier(exprDollar[1].BinOpModifier, CardManyToOne, nil)
+		}
+	case 110:
+		exprDollar = exprS[exprpt-5 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newGroupModifier(exprDollar[1].BinOpModifier, CardManyToOne, exprDollar[4].Labels)
+		}
+	case 111:
+		exprDollar = exprS[exprpt-2 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newGroupModifier(exprDollar[1].BinOpModifier, CardOneToMany, nil)
+		}
+	case 112:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newGroupModifier(exprDollar[1].BinOpModifier, CardOneToMany, nil)
+		}
+	case 113:
+		exprDollar = exprS[exprpt-5 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newGroupModifier(exprDollar[1].BinOpModifier, CardOneToMany, exprDollar[4].Labels)
+		}
+	case 114:
 		exprDollar = exprS[exprpt-0 : exprpt+1]
 		{
 			exprVAL.BinOpModifier = BinOpOptions{}
 		}
-	case 54:
+	case 115:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.BinOpModifier = BinOpOptions{ReturnBool: true}
 		}
-	case 55:
+	case 116:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newOnOrIgnoringModifier(exprDollar[1].BinOpModifier, true, nil)
+		}
+	case 117:
+		exprDollar = exprS[exprpt-5 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newOnOrIgnoringModifier(exprDollar[1].BinOpModifier, true, exprDollar[4].Labels)
+		}
+	case 118:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newOnOrIgnoringModifier(exprDollar[1].BinOpModifier, false, nil)
+		}
+	case 119:
+		exprDollar = exprS[exprpt-5 : exprpt+1]
+		{
+			exprVAL.BinOpModifier = newOnOrIgnoringModifier(exprDollar[1].BinOpModifier, false, exprDollar[4].Labels)
+		}
+	case 120:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.LiteralExpr = mustNewLiteralExpr(exprDollar[1].str, false)
 		}
-	case 56:
+	case 121:
 		exprDollar = exprS[exprpt-2 : exprpt+1]
 		{
 			exprVAL.LiteralExpr = mustNewLiteralExpr(exprDollar[2].str, false)
 		}
-	case 57:
+	case 122:
 		exprDollar = exprS[exprpt-2 : exprpt+1]
 		{
 			exprVAL.LiteralExpr = mustNewLiteralExpr(exprDollar[2].str, true)
 		}
-	case 58:
+	case 123:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeSum
 		}
-	case 59:
+	case 124:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeAvg
 		}
-	case 60:
+	case 125:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeCount
 		}
-	case 61:
+	case 126:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeMax
 		}
-	case 62:
+	case 127:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeMin
 		}
-	case 63:
+	case 128:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeStddev
 		}
-	case 64:
+	case 129:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeStdvar
 		}
-	case 65:
+	case 130:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeBottomK
 		}
-	case 66:
+	case 131:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.VectorOp = OpTypeTopK
 		}
-	case 67:
+	case 132:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.VectorOp = OpTypeCountValues
+		}
+	case 133:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.VectorOp = OpTypeSort
+		}
+	case 134:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.VectorOp = OpTypeSortDesc
+		}
+	case 135:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.RangeOp = OpRangeTypeCount
 		}
-	case 68:
+	case 136:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.RangeOp = OpRangeTypeRate
 		}
-	case 69:
+	case 137:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.RangeOp = OpRangeTypeBytes
 		}
-	case 70:
+	case 138:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.RangeOp = OpRangeTypeBytesRate
 		}
-	case 71:
+	case 139:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeSum
+		}
+	case 140:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeAvg
+		}
+	case 141:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeMax
+		}
+	case 142:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeMin
+		}
+	case 143:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeStddev
+		}
+	case 144:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeStdvar
+		}
+	case 145:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeQuantile
+		}
+	case 146:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeAbsent
+		}
+	case 147:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeFirst
+		}
+	case 148:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+		{
+			exprVAL.RangeOp = OpRangeTypeLast
+		}
+	case 149:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
 		{
 			exprVAL.Labels = []string{exprDollar[1].str}
 		}
-	case 72:
+	case 150:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
 		{
 			exprVAL.Labels = append(exprDollar[1].Labels, exprDollar[3].str)
 		}
-	case 73:
+	case 151:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.Grouping = &grouping{without: false, groups: exprDollar[3].Labels}
 		}
-	case 74:
+	case 152:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
 		{
 			exprVAL.Grouping = &grouping{without: true, groups: exprDollar[3].Labels}
 		}
+	case 153:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprlex.(*lexer).emptyGrouping()
+			exprVAL.Grouping = &grouping{without: false, groups: nil}
+		}
+	case 154:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+		{
+			exprlex.(*lexer).emptyGrouping()
+			exprVAL.Grouping = &grouping{without: true, groups: nil}
+		}
 	}
 	goto exprstack /* stack new state and value */
 }
@@ -3,8 +3,12 @@ package logql
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"regexp"
 	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/prometheus/prometheus/pkg/labels"
 )
@@ -134,15 +138,76 @@ type containsFilter struct {
 
 func (l containsFilter) Filter(line []byte) bool {
 	if l.caseInsensitive {
-		line = bytes.ToLower(line)
+		return containsLower(line, l.match)
 	}
 	return bytes.Contains(line, l.match)
 }
 
+// containsLower reports whether the lowercased line contains the lowercase substr.
+// Unlike bytes.Contains(bytes.ToLower(line), substr) it doesn't allocate a copy of the line.
+func containsLower(line, substr []byte) bool {
+	if len(substr) == 0 {
+		return true
+	}
+	for i := 0; i < len(line); {
+		if hasPrefixLower(line[i:], substr) {
+			return true
+		}
+		if line[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRune(line[i:])
+		i += size
+	}
+	return false
+}
+
+// hasPrefixLower reports whether the lowercased line begins with the lowercase prefix.
+func hasPrefixLower(line, prefix []byte) bool {
+	for len(prefix) > 0 {
+		if len(line) == 0 {
+			return false
+		}
+		// ASCII fast path.
+		if c, p := line[0], prefix[0]; c < utf8.RuneSelf && p < utf8.RuneSelf {
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != p {
+				return false
+			}
+			line, prefix = line[1:], prefix[1:]
+			continue
+		}
+		r, size := utf8.DecodeRune(line)
+		p, psize := utf8.DecodeRune(prefix)
+		if unicode.ToLower(r) != p {
+			return false
+		}
+		line, prefix = line[size:], prefix[psize:]
+	}
+	return true
+}
+
 func (l containsFilter) String() string {
 	return string(l.match)
 }
 
+// RequiredLiterals returns the strings contained by all the lines matching the
+// filter, from its case sensitive contains filters.
+func RequiredLiterals(f LineFilter) [][]byte {
+	switch f := f.(type) {
+	case containsFilter:
+		if !f.caseInsensitive {
+			return [][]byte{f.match}
+		}
+	case andFilter:
+		return append(RequiredLiterals(f.left), RequiredLiterals(f.right)...)
+	}
+	return nil
+}
+
 func newContainsFilter(match []byte, caseInsensitive bool) LineFilter {
 	if len(match) == 0 {
 		return TrueFilter
@@ -172,6 +237,112 @@ func newFilter(match string, mt labels.MatchType) (LineFilter, error) {
 	}
 }
 
+// newFuncFilter creates a new line filter from a filter function such as `ip("10.0.0.0/8")` and its match type.
+func newFuncFilter(fn, match string, mt labels.MatchType) (LineFilter, error) {
+	var f LineFilter
+	switch fn {
+	case OpFilterIP:
+		ipf, err := newIPFilter(match)
+		if err != nil {
+			return nil, err
+		}
+		f = ipf
+	case OpFilterNoCase:
+		f = newContainsFilter([]byte(match), true)
+	default:
+		return nil, fmt.Errorf("unsupported line filter function: %s", fn)
+	}
+	switch mt {
+	case labels.MatchEqual:
+		return f, nil
+	case labels.MatchNotEqual:
+		return newNotFilter(f), nil
+	default:
+		return nil, fmt.Errorf("line filter function %s can only be used with |= and !=", fn)
+	}
+}
+
+// ipFilter matches lines containing an IP address equal to ip or within the network.
+type ipFilter struct {
+	pattern string
+	ip      net.IP
+	network *net.IPNet
+	// v4 is the dotted form of ip when it's an IPv4 address.
+	v4 []byte
+}
+
+// newIPFilter creates a new line filter for an IPv4/IPv6 address or a CIDR range.
+func newIPFilter(pattern string) (ipFilter, error) {
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return ipFilter{}, fmt.Errorf("invalid ip filter %q: %s", pattern, err)
+		}
+		return ipFilter{pattern: pattern, network: network}, nil
+	}
+	ip := net.ParseIP(pattern)
+	if ip == nil {
+		return ipFilter{}, fmt.Errorf("invalid ip filter %q: not an IP address", pattern)
+	}
+	f := ipFilter{pattern: pattern, ip: ip}
+	if v4 := ip.To4(); v4 != nil {
+		f.v4 = []byte(v4.String())
+	}
+	return f, nil
+}
+
+func (f ipFilter) Filter(line []byte) bool {
+	// an IPv4 address is always written the same way, lines without it can be skipped quickly.
+	if f.v4 != nil && !bytes.Contains(line, f.v4) {
+		return false
+	}
+	for len(line) > 0 {
+		start := bytes.IndexFunc(line, isIPRune)
+		if start < 0 {
+			return false
+		}
+		line = line[start:]
+		end := bytes.IndexFunc(line, func(r rune) bool { return !isIPRune(r) })
+		if end < 0 {
+			end = len(line)
+		}
+		if f.matchToken(line[:end]) {
+			return true
+		}
+		line = line[end:]
+	}
+	return false
+}
+
+// matchToken tests a sequence of IP characters. IPv4 addresses followed by a port
+// or punctuation (e.g `10.0.0.1:8080` or `10.0.0.1.`) are matched too, as well as
+// the ones next to hex letters (e.g `c10.0.0.1`) which can't be part of them.
+func (f ipFilter) matchToken(token []byte) bool {
+	if f.match(net.ParseIP(string(token))) {
+		return true
+	}
+	for _, part := range bytes.Split(token, []byte(":")) {
+		if part = bytes.Trim(part, ".abcdefABCDEF"); bytes.IndexByte(part, '.') >= 0 && f.match(net.ParseIP(string(part))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ipFilter) match(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if f.network != nil {
+		return f.network.Contains(ip)
+	}
+	return f.ip.Equal(ip)
+}
+
+func isIPRune(r rune) bool {
+	return r == '.' || r == ':' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'f') || ('A' <= r && r <= 'F')
+}
+
 // parseRegexpFilter parses a regexp and attempt to simplify it with only literal filters.
 // If not possible it will returns the original regexp filter.
 func parseRegexpFilter(re string, match bool) (LineFilter, error) {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/stretchr/testify/require"
 )
 
@@ -126,6 +127,84 @@ func Test_TrueFilter(t *testing.T) {
 	}
 }
 
+func Test_ContainsFilterCaseInsensitive(t *testing.T) {
+	f := newContainsFilter([]byte("ERRor"), true)
+	for _, test := range []struct {
+		line  string
+		match bool
+	}{
+		{"level=error msg=foo", true},
+		{"level=ERROR msg=foo", true},
+		{"level=Error", true},
+		{"level=err", false},
+		{"", false},
+		{"ÉRROR is not ERRor", true},
+		{"Érror", false},
+	} {
+		t.Run(test.line, func(t *testing.T) {
+			require.Equal(t, test.match, f.Filter([]byte(test.line)))
+		})
+	}
+	require.True(t, newContainsFilter([]byte("ÉTÉ"), true).Filter([]byte("un été chaud")))
+}
+
+func Test_IPFilter(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		line    string
+		match   bool
+	}{
+		{"10.0.0.1", "connection from 10.0.0.1 closed", true},
+		{"10.0.0.1", "connection from 10.0.0.10 closed", false},
+		{"10.0.0.1", "connection from 110.0.0.1 closed", false},
+		{"10.0.0.1", "addr=10.0.0.1:8080", true},
+		{"10.0.0.1", "connection from 10.0.0.1.", true},
+		{"10.0.0.1", "id=abc10.0.0.1", true},
+		{"10.0.0.1", "id=abc10.0.0.10", false},
+		{"::ffff:10.0.0.1", "connection from 10.0.0.1 closed", true},
+		{"::ffff:10.0.0.1", "connection from 10.0.0.2 closed", false},
+		{"10.0.0.1", "connection from ::ffff:10.0.0.1 closed", true},
+		{"10.0.0.0/8", "client=10.12.3.4 status=200", true},
+		{"10.0.0.0/8", "client=192.168.1.1 status=200", false},
+		{"10.0.0.0/8", "ts=2020-02-22T14:57:59.398312973Z", false},
+		{"10.0.0.0/8", "no address", false},
+		{"2001:db8::/32", "from 2001:0db8:0000:0000:0000:0000:0000:0001 ok", true},
+		{"2001:db8::1", "from [2001:db8::1]:443 ok", true},
+		{"2001:db8::1", "from 2001:db8::2 ok", false},
+		{"::1", "listening on ::1", true},
+	} {
+		t.Run(test.pattern+" "+test.line, func(t *testing.T) {
+			f, err := newIPFilter(test.pattern)
+			require.NoError(t, err)
+			require.Equal(t, test.match, f.Filter([]byte(test.line)))
+		})
+	}
+}
+
+func Test_NewFuncFilter(t *testing.T) {
+	f, err := newFuncFilter(OpFilterIP, "192.168.0.0/16", labels.MatchNotEqual)
+	require.NoError(t, err)
+	require.False(t, f.Filter([]byte("from 192.168.1.1")))
+	require.True(t, f.Filter([]byte("from 10.0.0.1")))
+
+	f, err = newFuncFilter(OpFilterNoCase, "timeout", labels.MatchEqual)
+	require.NoError(t, err)
+	require.True(t, f.Filter([]byte("Request TIMEOUT")))
+
+	for _, test := range []struct {
+		fn, match string
+		mt        labels.MatchType
+	}{
+		{OpFilterIP, "10.0.0.0/33", labels.MatchEqual},
+		{OpFilterIP, "localhost", labels.MatchEqual},
+		{OpFilterIP, "10.0.0.1", labels.MatchRegexp},
+		{"upper", "foo", labels.MatchEqual},
+	} {
+		_, err := newFuncFilter(test.fn, test.match, test.mt)
+		require.Error(t, err)
+	}
+}
+
 func Benchmark_LineFilter(b *testing.B) {
 	b.ReportAllocs()
 	logline := `level=bar ts=2020-02-22T14:57:59.398312973Z caller=logging.go:44 traceID=2107b6b551458908 msg="GET /buzz (200) 4.599635ms`
@@ -134,6 +213,7 @@ func Benchmark_LineFilter(b *testing.B) {
 	}{
 		{"foo.*"},
 		{"(?i)foo"},
+		{"(?i)BUZZ"},
 		{".*foo.*"},
 		{".*foo"},
 		{"foo|bar"},
@@ -177,3 +257,32 @@ func benchmarkRegex(b *testing.B, re, line string, match bool) {
 	})
 	res = m
 }
+
+func Test_RequiredLiterals(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		literals []string
+	}{
+		{`{app="foo"}`, nil},
+		{`{app="foo"} |= "bar"`, []string{"bar"}},
+		{`{app="foo"} |= "bar" |= "buzz"`, []string{"bar", "buzz"}},
+		{`{app="foo"} |~ "bar"`, []string{"bar"}},
+		{`{app="foo"} |~ "(?i)bar"`, nil},
+		{`{app="foo"} != "bar"`, nil},
+		{`{app="foo"} |~ "bar|buzz"`, nil},
+		{`{app="foo"} |= "bar" != "buzz"`, []string{"bar"}},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			expr, err := ParseLogSelector(test.query)
+			require.NoError(t, err)
+			f, err := expr.Filter()
+			require.NoError(t, err)
+
+			var literals []string
+			for _, l := range RequiredLiterals(f) {
+				literals = append(literals, string(l))
+			}
+			require.Equal(t, test.literals, literals)
+		})
+	}
+}
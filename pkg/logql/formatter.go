@@ -0,0 +1,202 @@
+package logql
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// errTemplateFormat is the value of the `__error__` label when a template can't be executed.
+const errTemplateFormat = "TemplateFormatErr"
+
+// functionMap is the set of functions available in line_format and label_format templates.
+// It is the same as the one of the promtail template stage.
+var functionMap = template.FuncMap{
+	"ToLower":    strings.ToLower,
+	"ToUpper":    strings.ToUpper,
+	"Replace":    strings.Replace,
+	"Trim":       strings.Trim,
+	"TrimLeft":   strings.TrimLeft,
+	"TrimRight":  strings.TrimRight,
+	"TrimPrefix": strings.TrimPrefix,
+	"TrimSuffix": strings.TrimSuffix,
+	"TrimSpace":  strings.TrimSpace,
+}
+
+// LineFormatter replaces the log line with a template rendered using the labels of the entry.
+type LineFormatter struct {
+	*template.Template
+}
+
+// NewLineFormatter creates a new LineFormatter from a Go template.
+func NewLineFormatter(tmpl string) (*LineFormatter, error) {
+	t, err := template.New(OpFmtLine).Option("missingkey=zero").Funcs(functionMap).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid line template: %s", err)
+	}
+	return &LineFormatter{Template: t}, nil
+}
+
+// Process implements Stage. If the template fails to execute the line is kept unchanged.
+func (lf *LineFormatter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	var buf bytes.Buffer
+	if err := lf.Execute(&buf, lbs.Labels().Map()); err != nil {
+		lbs.SetErr(errTemplateFormat)
+		return line, true
+	}
+	return buf.Bytes(), true
+}
+
+// Decolorizer removes ANSI escape sequences, such as colors, from the log line.
+type Decolorizer struct{}
+
+// NewDecolorizer creates a new Decolorizer.
+func NewDecolorizer() *Decolorizer {
+	return &Decolorizer{}
+}
+
+// Process implements Stage.
+func (d *Decolorizer) Process(line []byte, _ *LabelsBuilder) ([]byte, bool) {
+	i := bytes.IndexByte(line, escape)
+	if i < 0 {
+		return line, true
+	}
+	res := make([]byte, 0, len(line))
+	for i >= 0 {
+		res = append(res, line[:i]...)
+		line = line[i+escapeSequenceLen(line[i:]):]
+		i = bytes.IndexByte(line, escape)
+	}
+	return append(res, line...), true
+}
+
+const escape = 0x1b
+
+// escapeSequenceLen returns the length of the ANSI escape sequence at the start of b.
+// Incomplete sequences extend to the end of b.
+func escapeSequenceLen(b []byte) int {
+	if len(b) < 2 {
+		return len(b)
+	}
+	i := 2
+	switch b[1] {
+	case '[':
+		// CSI: parameter bytes, intermediate bytes and a final byte.
+		for i < len(b) && b[i] >= 0x20 && b[i] <= 0x3f {
+			i++
+		}
+		if i < len(b) && b[i] >= 0x40 && b[i] <= 0x7e {
+			i++
+		}
+		return i
+	case ']':
+		// OSC: terminated by BEL or ESC \.
+		for ; i < len(b); i++ {
+			if b[i] == 0x07 {
+				return i + 1
+			}
+			if b[i] == escape && i+1 < len(b) && b[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return i
+	default:
+		// other sequences: optional intermediate bytes and a final byte.
+		i = 1
+		for i < len(b) && b[i] >= 0x20 && b[i] <= 0x2f {
+			i++
+		}
+		if i < len(b) && b[i] >= 0x30 && b[i] <= 0x7e {
+			i++
+		}
+		return i
+	}
+}
+
+// labelFmt is a single label_format operation, either renaming a label or rendering a template.
+type labelFmt struct {
+	name string
+
+	value  string
+	rename bool
+}
+
+func newRenameLabelFmt(dst, src string) labelFmt {
+	return labelFmt{name: dst, value: src, rename: true}
+}
+
+func newTemplateLabelFmt(dst, tmpl string) labelFmt {
+	return labelFmt{name: dst, value: tmpl}
+}
+
+// impls Stringer
+func (f labelFmt) String() string {
+	if f.rename {
+		return fmt.Sprintf("%s=%s", f.name, f.value)
+	}
+	return fmt.Sprintf("%s=%s", f.name, strconv.Quote(f.value))
+}
+
+type labelFormatter struct {
+	labelFmt
+	tmpl *template.Template
+}
+
+// LabelsFormatter renames labels or sets them to a template rendered using the labels of the entry.
+// All operations use the labels as they were before the stage.
+type LabelsFormatter struct {
+	formats []labelFormatter
+}
+
+// NewLabelsFormatter creates a new LabelsFormatter. A label can't be the destination of multiple operations.
+func NewLabelsFormatter(fmts []labelFmt) (*LabelsFormatter, error) {
+	if len(fmts) == 0 {
+		return nil, fmt.Errorf("at least one label format is required")
+	}
+	res := &LabelsFormatter{formats: make([]labelFormatter, 0, len(fmts))}
+	seen := map[string]struct{}{}
+	for _, f := range fmts {
+		if _, ok := seen[f.name]; ok {
+			return nil, fmt.Errorf("multiple label name '%s' not allowed in a single format operation", f.name)
+		}
+		seen[f.name] = struct{}{}
+		formatter := labelFormatter{labelFmt: f}
+		if !f.rename {
+			t, err := template.New(OpFmtLabel).Option("missingkey=zero").Funcs(functionMap).Parse(f.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for label '%s': %s", f.name, err)
+			}
+			formatter.tmpl = t
+		}
+		res.formats = append(res.formats, formatter)
+	}
+	return res, nil
+}
+
+// Process implements Stage.
+func (lf *LabelsFormatter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	data := lbs.Labels().Map()
+	// renamed labels are removed first so that labels can be swapped.
+	for _, f := range lf.formats {
+		if f.rename {
+			lbs.Del(f.value)
+		}
+	}
+	for _, f := range lf.formats {
+		if f.rename {
+			if v, ok := data[f.value]; ok {
+				lbs.Set(f.name, v)
+			}
+			continue
+		}
+		var buf bytes.Buffer
+		if err := f.tmpl.Execute(&buf, data); err != nil {
+			lbs.SetErr(errTemplateFormat)
+			continue
+		}
+		lbs.Set(f.name, buf.String())
+	}
+	return line, true
+}
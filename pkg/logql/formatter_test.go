@@ -0,0 +1,158 @@
+package logql
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lineFormatter_Format(t *testing.T) {
+	tests := []struct {
+		name  string
+		fmter *LineFormatter
+		lbs   labels.Labels
+
+		want    []byte
+		wantLbs labels.Labels
+	}{
+		{
+			"combining",
+			mustNewLineFormatter("foo{{.foo}}buzz{{  .bar  }}"),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+			[]byte("fooblipbuzzblop"),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+		},
+		{
+			"functions",
+			mustNewLineFormatter(`foo{{.foo | ToUpper }}buzz{{ Replace .bar "o" "e" -1 }}`),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+			[]byte("fooBLIPbuzzblep"),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+		},
+		{
+			"missing",
+			mustNewLineFormatter("foo {{.foo}}buzz{{  .bar  }}"),
+			labels.Labels{{Name: "bar", Value: "blop"}},
+			[]byte("foo buzzblop"),
+			labels.Labels{{Name: "bar", Value: "blop"}},
+		},
+		{
+			"execution error",
+			mustNewLineFormatter(`foo {{ index .bar 10 }}`),
+			labels.Labels{{Name: "bar", Value: "blop"}},
+			[]byte("line"),
+			labels.Labels{{Name: ErrorLabel, Value: errTemplateFormat}, {Name: "bar", Value: "blop"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLabelsBuilder()
+			b.Reset(tt.lbs)
+			line, ok := tt.fmter.Process([]byte("line"), b)
+			require.True(t, ok)
+			require.Equal(t, tt.want, line)
+			require.Equal(t, tt.wantLbs, b.Labels())
+		})
+	}
+}
+
+func Test_labelsFormatter_Format(t *testing.T) {
+	tests := []struct {
+		name  string
+		fmter *LabelsFormatter
+
+		in   labels.Labels
+		want labels.Labels
+	}{
+		{
+			"combined with template",
+			mustNewLabelsFormatter([]labelFmt{newTemplateLabelFmt("foo", "{{.foo}} and {{.bar}}")}),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+			labels.Labels{{Name: "foo", Value: "blip and blop"}, {Name: "bar", Value: "blop"}},
+		},
+		{
+			"rename",
+			mustNewLabelsFormatter([]labelFmt{newRenameLabelFmt("baz", "foo")}),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "baz", Value: "blip"}},
+		},
+		{
+			"swap",
+			mustNewLabelsFormatter([]labelFmt{newRenameLabelFmt("bar", "foo"), newRenameLabelFmt("foo", "bar")}),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+			labels.Labels{{Name: "bar", Value: "blip"}, {Name: "foo", Value: "blop"}},
+		},
+		{
+			"rename and template use the original labels",
+			mustNewLabelsFormatter([]labelFmt{newRenameLabelFmt("baz", "foo"), newTemplateLabelFmt("bar", "{{ .foo | ToUpper }}")}),
+			labels.Labels{{Name: "bar", Value: "blop"}, {Name: "foo", Value: "blip"}},
+			labels.Labels{{Name: "bar", Value: "BLIP"}, {Name: "baz", Value: "blip"}},
+		},
+		{
+			"rename missing label",
+			mustNewLabelsFormatter([]labelFmt{newRenameLabelFmt("baz", "buzz")}),
+			labels.Labels{{Name: "foo", Value: "blip"}},
+			labels.Labels{{Name: "foo", Value: "blip"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLabelsBuilder()
+			b.Reset(tt.in)
+			_, ok := tt.fmter.Process(nil, b)
+			require.True(t, ok)
+			sort.Sort(tt.want)
+			require.Equal(t, tt.want, b.Labels())
+		})
+	}
+}
+
+func TestNewLabelsFormatter(t *testing.T) {
+	_, err := NewLabelsFormatter([]labelFmt{newRenameLabelFmt("foo", "bar"), newTemplateLabelFmt("foo", "{{.buzz}}")})
+	require.Error(t, err)
+
+	_, err = NewLabelsFormatter([]labelFmt{newTemplateLabelFmt("foo", "{{.buzz")})
+	require.Error(t, err)
+}
+
+func Test_decolorizer_Process(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want string
+	}{
+		{"no colors", "no colors"},
+		{"\x1b[31mred\x1b[0m text", "red text"},
+		{"\x1b[1;38;5;208mbold orange\x1b[m", "bold orange"},
+		{"level=\x1b[33mwarn\x1b[39;49m msg=foo", "level=warn msg=foo"},
+		{"\x1b]0;title\x07line", "line"},
+		{"\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1b(Bcharset", "charset"},
+		{"\x1b7saved\x1b8", "saved"},
+		{"incomplete\x1b[31", "incomplete"},
+		{"trailing\x1b", "trailing"},
+	} {
+		t.Run(tt.want, func(t *testing.T) {
+			line, ok := NewDecolorizer().Process([]byte(tt.line), NewLabelsBuilder())
+			require.True(t, ok)
+			require.Equal(t, tt.want, string(line))
+		})
+	}
+}
+
+func mustNewLineFormatter(tmpl string) *LineFormatter {
+	l, err := NewLineFormatter(tmpl)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func mustNewLabelsFormatter(fmts []labelFmt) *LabelsFormatter {
+	l, err := NewLabelsFormatter(fmts)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
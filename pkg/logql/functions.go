@@ -2,6 +2,8 @@ package logql
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/prometheus/prometheus/promql"
@@ -10,8 +12,11 @@ import (
 const unsupportedErr = "unsupported range vector aggregation operation: %s"
 
 func (r rangeAggregationExpr) extractor() (SampleExtractor, error) {
+	if r.left.unwrap != nil {
+		return newLabelSampleExtractor(r.left.unwrap.identifier, r.left.unwrap.operation), nil
+	}
 	switch r.operation {
-	case OpRangeTypeRate, OpRangeTypeCount:
+	case OpRangeTypeRate, OpRangeTypeCount, OpRangeTypeAbsent:
 		return extractCount, nil
 	case OpRangeTypeBytes, OpRangeTypeBytesRate:
 		return extractBytes, nil
@@ -24,14 +29,47 @@ func (r rangeAggregationExpr) aggregator() (RangeVectorAggregator, error) {
 	switch r.operation {
 	case OpRangeTypeRate:
 		return rateLogs(r.left.interval), nil
-	case OpRangeTypeCount:
-		return countOverTime, nil
 	case OpRangeTypeBytesRate:
 		return rateLogBytes(r.left.interval), nil
 	case OpRangeTypeBytes:
 		return sumOverTime, nil
 	default:
-		return nil, fmt.Errorf(unsupportedErr, r.operation)
+		return overTimeAggregator(r.operation, r.params)
+	}
+}
+
+func (r subqueryAggregationExpr) aggregator() (RangeVectorAggregator, error) {
+	return overTimeAggregator(r.operation, r.params)
+}
+
+// overTimeAggregator returns the aggregator of the `_over_time` operations working on sample values.
+func overTimeAggregator(operation string, params *float64) (RangeVectorAggregator, error) {
+	switch operation {
+	case OpRangeTypeCount:
+		return countOverTime, nil
+	case OpRangeTypeSum:
+		return sumOverTime, nil
+	case OpRangeTypeAvg:
+		return avgOverTime, nil
+	case OpRangeTypeMax:
+		return maxOverTime, nil
+	case OpRangeTypeMin:
+		return minOverTime, nil
+	case OpRangeTypeStddev:
+		return stddevOverTime, nil
+	case OpRangeTypeStdvar:
+		return stdvarOverTime, nil
+	case OpRangeTypeFirst:
+		return firstOverTime, nil
+	case OpRangeTypeLast:
+		return lastOverTime, nil
+	case OpRangeTypeQuantile:
+		if params == nil {
+			return nil, fmt.Errorf("missing parameter for operation %s", operation)
+		}
+		return quantileOverTime(*params), nil
+	default:
+		return nil, fmt.Errorf(unsupportedErr, operation)
 	}
 }
 
@@ -61,3 +99,87 @@ func sumOverTime(samples []promql.Point) float64 {
 	}
 	return sum
 }
+
+func avgOverTime(samples []promql.Point) float64 {
+	return sumOverTime(samples) / float64(len(samples))
+}
+
+func maxOverTime(samples []promql.Point) float64 {
+	max := samples[0].V
+	for _, v := range samples {
+		if v.V > max || math.IsNaN(max) {
+			max = v.V
+		}
+	}
+	return max
+}
+
+func minOverTime(samples []promql.Point) float64 {
+	min := samples[0].V
+	for _, v := range samples {
+		if v.V < min || math.IsNaN(min) {
+			min = v.V
+		}
+	}
+	return min
+}
+
+// firstOverTime returns the value of the oldest sample.
+func firstOverTime(samples []promql.Point) float64 {
+	return samples[0].V
+}
+
+// lastOverTime returns the value of the most recent sample.
+func lastOverTime(samples []promql.Point) float64 {
+	return samples[len(samples)-1].V
+}
+
+// stdvarOverTime calculates the population variance using Welford's online algorithm.
+func stdvarOverTime(samples []promql.Point) float64 {
+	var aux, count, mean float64
+	for _, v := range samples {
+		count++
+		delta := v.V - mean
+		mean += delta / count
+		aux += delta * (v.V - mean)
+	}
+	return aux / count
+}
+
+func stddevOverTime(samples []promql.Point) float64 {
+	return math.Sqrt(stdvarOverTime(samples))
+}
+
+// quantileOverTime calculates the φ-quantile (0 ≤ φ ≤ 1) of the values, interpolating linearly between the two closest values.
+// It returns -Inf for φ < 0 and +Inf for φ > 1, like Prometheus.
+func quantileOverTime(q float64) func(samples []promql.Point) float64 {
+	return func(samples []promql.Point) float64 {
+		values := make([]float64, 0, len(samples))
+		for _, v := range samples {
+			values = append(values, v.V)
+		}
+		return quantile(q, values)
+	}
+}
+
+func quantile(q float64, values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	if q < 0 {
+		return math.Inf(-1)
+	}
+	if q > 1 {
+		return math.Inf(+1)
+	}
+	sort.Float64s(values)
+
+	n := float64(len(values))
+	rank := q * (n - 1)
+
+	lowerIndex := math.Max(0, math.Floor(rank))
+	upperIndex := math.Min(n-1, lowerIndex+1)
+
+	weight := rank - math.Floor(rank)
+	return values[int(lowerIndex)]*(1-weight) + values[int(upperIndex)]*weight
+}
@@ -0,0 +1,226 @@
+package logql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// errLabelFilter is the value of the `__error__` label when a label value can't be converted for a comparison.
+const errLabelFilter = "LabelFilterErr"
+
+// LabelFilterer is a Stage filtering log entries using their labels.
+type LabelFilterer interface {
+	Stage
+	fmt.Stringer
+}
+
+type binaryLabelFilter struct {
+	left  LabelFilterer
+	right LabelFilterer
+	and   bool
+}
+
+// newAndLabelFilter creates a new LabelFilterer which keeps entries matching both left and right.
+func newAndLabelFilter(left, right LabelFilterer) LabelFilterer {
+	return &binaryLabelFilter{left: left, right: right, and: true}
+}
+
+// newOrLabelFilter creates a new LabelFilterer which keeps entries matching left or right.
+func newOrLabelFilter(left, right LabelFilterer) LabelFilterer {
+	return &binaryLabelFilter{left: left, right: right}
+}
+
+func (b *binaryLabelFilter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	line, lok := b.left.Process(line, lbs)
+	if b.and && !lok {
+		return line, false
+	}
+	if !b.and && lok {
+		return line, true
+	}
+	return b.right.Process(line, lbs)
+}
+
+func (b *binaryLabelFilter) String() string {
+	if !b.and {
+		return b.left.String() + " or " + b.right.String()
+	}
+	var sb strings.Builder
+	for i, f := range []LabelFilterer{b.left, b.right} {
+		if i > 0 {
+			sb.WriteString(" and ")
+		}
+		// `and` has precedence over `or`.
+		if bf, ok := f.(*binaryLabelFilter); ok && !bf.and {
+			sb.WriteString("(" + bf.String() + ")")
+			continue
+		}
+		sb.WriteString(f.String())
+	}
+	return sb.String()
+}
+
+type stringLabelFilter struct {
+	*labels.Matcher
+}
+
+// newStringLabelFilter creates a new LabelFilterer matching the label value with a string matcher.
+func newStringLabelFilter(m *labels.Matcher) LabelFilterer {
+	return &stringLabelFilter{Matcher: m}
+}
+
+func (s *stringLabelFilter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	v, _ := lbs.Get(s.Name)
+	return line, s.Matches(v)
+}
+
+type numericLabelFilter struct {
+	name  string
+	op    string
+	value float64
+}
+
+// newNumericLabelFilter creates a new LabelFilterer comparing the label value as a float.
+func newNumericLabelFilter(op, name string, value float64) LabelFilterer {
+	return &numericLabelFilter{name: name, op: op, value: value}
+}
+
+func (n *numericLabelFilter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	return processTypedLabelFilter(line, lbs, n.name, func(v string) (bool, error) {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return false, err
+		}
+		return compareLabelValue(n.op, f, n.value), nil
+	})
+}
+
+func (n *numericLabelFilter) String() string {
+	return fmt.Sprintf("%s%s%s", n.name, n.op, strconv.FormatFloat(n.value, 'f', -1, 64))
+}
+
+type durationLabelFilter struct {
+	name  string
+	op    string
+	value time.Duration
+}
+
+// newDurationLabelFilter creates a new LabelFilterer comparing the label value as a duration.
+func newDurationLabelFilter(op, name string, value time.Duration) LabelFilterer {
+	return &durationLabelFilter{name: name, op: op, value: value}
+}
+
+func (d *durationLabelFilter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	return processTypedLabelFilter(line, lbs, d.name, func(v string) (bool, error) {
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return false, err
+		}
+		return compareLabelValue(d.op, float64(dur), float64(d.value)), nil
+	})
+}
+
+func (d *durationLabelFilter) String() string {
+	return fmt.Sprintf("%s%s%s", d.name, d.op, d.value)
+}
+
+type bytesLabelFilter struct {
+	name  string
+	op    string
+	value uint64
+}
+
+// newBytesLabelFilter creates a new LabelFilterer comparing the label value as a size in bytes.
+func newBytesLabelFilter(op, name string, value uint64) LabelFilterer {
+	return &bytesLabelFilter{name: name, op: op, value: value}
+}
+
+func (b *bytesLabelFilter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	return processTypedLabelFilter(line, lbs, b.name, func(v string) (bool, error) {
+		size, err := humanize.ParseBytes(v)
+		if err != nil {
+			return false, err
+		}
+		return compareLabelValue(b.op, float64(size), float64(b.value)), nil
+	})
+}
+
+func (b *bytesLabelFilter) String() string {
+	// sizes are printed in bytes to avoid losing precision.
+	return fmt.Sprintf("%s%s%dB", b.name, b.op, b.value)
+}
+
+// processTypedLabelFilter applies a comparison requiring a conversion of the label value.
+// Entries without the label are dropped, while entries for which the conversion fails are kept
+// and flagged with the `__error__` label.
+func processTypedLabelFilter(line []byte, lbs *LabelsBuilder, name string, cmp func(string) (bool, error)) ([]byte, bool) {
+	// entries which already failed a stage can only be filtered out by string matchers.
+	if lbs.HasErr() {
+		return line, true
+	}
+	v, ok := lbs.Get(name)
+	if !ok {
+		return line, false
+	}
+	match, err := cmp(v)
+	if err != nil {
+		lbs.SetErr(errLabelFilter)
+		return line, true
+	}
+	return line, match
+}
+
+func compareLabelValue(op string, v, ref float64) bool {
+	switch op {
+	case OpTypeCmpEQ:
+		return v == ref
+	case OpTypeNEQ:
+		return v != ref
+	case OpTypeGT:
+		return v > ref
+	case OpTypeGTE:
+		return v >= ref
+	case OpTypeLT:
+		return v < ref
+	case OpTypeLTE:
+		return v <= ref
+	default:
+		return false
+	}
+}
+
+// DistinctFilter keeps only the first entry for each value of a label, entries without the label are kept.
+// It is stateful and must see the entries of all streams merged in the query direction.
+type DistinctFilter struct {
+	label string
+	seen  map[string]struct{}
+}
+
+// NewDistinctFilter creates a new DistinctFilter for the given label.
+func NewDistinctFilter(label string) *DistinctFilter {
+	return &DistinctFilter{
+		label: label,
+		seen:  map[string]struct{}{},
+	}
+}
+
+// Process implements Stage.
+func (d *DistinctFilter) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	v, ok := lbs.Get(d.label)
+	if !ok {
+		return line, true
+	}
+	if _, ok := d.seen[v]; ok {
+		return line, false
+	}
+	d.seen[v] = struct{}{}
+	return line, true
+}
+
+// merged implements mergedStage.
+func (d *DistinctFilter) merged() {}
@@ -0,0 +1,173 @@
+package logql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelFilter_Process(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		filter LabelFilterer
+		lbs    labels.Labels
+
+		wantOk  bool
+		wantLbs labels.Labels
+	}{
+		{
+			"numeric gt",
+			newNumericLabelFilter(OpTypeGTE, "status", 500),
+			labels.Labels{{Name: "status", Value: "502"}},
+			true,
+			labels.Labels{{Name: "status", Value: "502"}},
+		},
+		{
+			"numeric lt",
+			newNumericLabelFilter(OpTypeGTE, "status", 500),
+			labels.Labels{{Name: "status", Value: "200"}},
+			false,
+			nil,
+		},
+		{
+			"missing label",
+			newNumericLabelFilter(OpTypeGTE, "status", 500),
+			labels.Labels{{Name: "app", Value: "foo"}},
+			false,
+			nil,
+		},
+		{
+			"conversion error",
+			newNumericLabelFilter(OpTypeGTE, "status", 500),
+			labels.Labels{{Name: "status", Value: "bad"}},
+			true,
+			labels.Labels{{Name: ErrorLabel, Value: errLabelFilter}, {Name: "status", Value: "bad"}},
+		},
+		{
+			"duration",
+			newDurationLabelFilter(OpTypeGT, "duration", time.Second),
+			labels.Labels{{Name: "duration", Value: "1.5s"}},
+			true,
+			labels.Labels{{Name: "duration", Value: "1.5s"}},
+		},
+		{
+			"duration not matching",
+			newDurationLabelFilter(OpTypeGT, "duration", time.Second),
+			labels.Labels{{Name: "duration", Value: "100ms"}},
+			false,
+			nil,
+		},
+		{
+			"bytes",
+			newBytesLabelFilter(OpTypeLTE, "size", 20000),
+			labels.Labels{{Name: "size", Value: "20kB"}},
+			true,
+			labels.Labels{{Name: "size", Value: "20kB"}},
+		},
+		{
+			"string",
+			newStringLabelFilter(mustNewMatcher(labels.MatchRegexp, "method", "GET|POST")),
+			labels.Labels{{Name: "method", Value: "POST"}},
+			true,
+			labels.Labels{{Name: "method", Value: "POST"}},
+		},
+		{
+			"and",
+			newAndLabelFilter(
+				newNumericLabelFilter(OpTypeGTE, "status", 500),
+				newStringLabelFilter(mustNewMatcher(labels.MatchEqual, "method", "GET")),
+			),
+			labels.Labels{{Name: "method", Value: "POST"}, {Name: "status", Value: "500"}},
+			false,
+			nil,
+		},
+		{
+			"or",
+			newOrLabelFilter(
+				newNumericLabelFilter(OpTypeGTE, "status", 500),
+				newStringLabelFilter(mustNewMatcher(labels.MatchEqual, "method", "POST")),
+			),
+			labels.Labels{{Name: "method", Value: "POST"}, {Name: "status", Value: "200"}},
+			true,
+			labels.Labels{{Name: "method", Value: "POST"}, {Name: "status", Value: "200"}},
+		},
+		{
+			"filter out errors",
+			newAndLabelFilter(
+				newNumericLabelFilter(OpTypeGTE, "status", 500),
+				newStringLabelFilter(mustNewMatcher(labels.MatchEqual, ErrorLabel, "")),
+			),
+			labels.Labels{{Name: "status", Value: "bad"}},
+			false,
+			nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLabelsBuilder()
+			b.Reset(tt.lbs)
+			_, ok := tt.filter.Process([]byte("line"), b)
+			require.Equal(t, tt.wantOk, ok)
+			if ok {
+				require.Equal(t, tt.wantLbs, b.Labels())
+			}
+		})
+	}
+}
+
+func TestDistinctFilter_Process(t *testing.T) {
+	f := NewDistinctFilter("trace")
+	for _, tt := range []struct {
+		lbs    labels.Labels
+		wantOk bool
+	}{
+		{labels.Labels{{Name: "trace", Value: "a"}}, true},
+		{labels.Labels{{Name: "trace", Value: "b"}}, true},
+		{labels.Labels{{Name: "trace", Value: "a"}}, false},
+		{labels.Labels{{Name: "app", Value: "foo"}}, true},
+		{labels.Labels{{Name: "app", Value: "foo"}}, true},
+		{labels.Labels{{Name: "trace", Value: ""}}, true},
+		{labels.Labels{{Name: "trace", Value: "b"}}, false},
+	} {
+		b := NewLabelsBuilder()
+		b.Reset(tt.lbs)
+		_, ok := f.Process([]byte("line"), b)
+		require.Equal(t, tt.wantOk, ok, "%s", tt.lbs)
+	}
+}
+
+func TestPipeline_Split(t *testing.T) {
+	expr, err := ParseLogSelector(`{app="foo"} | logfmt | distinct trace | line_format "{{.trace}}"`)
+	require.NoError(t, err)
+	p, err := expr.Pipeline()
+	require.NoError(t, err)
+	source, merged := p.Split()
+	require.Len(t, source, 1)
+	require.Len(t, merged, 2)
+	require.IsType(t, &DistinctFilter{}, merged[0])
+	require.True(t, HasMergedStages(expr))
+
+	expr, err = ParseLogSelector(`{app="foo"} | logfmt | line_format "{{.trace}}"`)
+	require.NoError(t, err)
+	p, err = expr.Pipeline()
+	require.NoError(t, err)
+	source, merged = p.Split()
+	require.Len(t, source, 2)
+	require.Len(t, merged, 0)
+	require.False(t, HasMergedStages(expr))
+}
+
+func TestLabelsBuilder_ErrorLabel(t *testing.T) {
+	// entries processed by a source pipeline carry their error as a label.
+	b := NewLabelsBuilder()
+	b.Reset(labels.Labels{{Name: ErrorLabel, Value: errJSON}, {Name: "app", Value: "foo"}})
+	require.True(t, b.HasErr())
+	v, ok := b.Get(ErrorLabel)
+	require.True(t, ok)
+	require.Equal(t, errJSON, v)
+	require.Equal(t, labels.Labels{{Name: ErrorLabel, Value: errJSON}, {Name: "app", Value: "foo"}}, b.Labels())
+
+	b.Reset(labels.Labels{{Name: "app", Value: "foo"}})
+	require.False(t, b.HasErr())
+}
@@ -0,0 +1,378 @@
+package logql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-logfmt/logfmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// Values of the `__error__` label when a parser fails.
+	errJSON   = "JSONParserErr"
+	errLogfmt = "LogfmtParserErr"
+
+	// packedEntryKey is the property holding the original log line of a packed entry.
+	packedEntryKey = "_entry"
+
+	// duplicateSuffix is appended to extracted label names colliding with stream labels.
+	duplicateSuffix = "_extracted"
+)
+
+// newLabelParser creates a new Stage extracting labels from log lines for the given parser type and parameter.
+func newLabelParser(op, param string) (Stage, error) {
+	switch op {
+	case OpParserTypeJSON:
+		return NewJSONParser(), nil
+	case OpParserTypeLogfmt:
+		return NewLogfmtParser(), nil
+	case OpParserTypeRegexp:
+		return NewRegexpParser(param)
+	case OpParserTypePattern:
+		return NewPatternParser(param)
+	case OpParserTypeUnpack:
+		return NewUnpackParser(), nil
+	default:
+		return nil, fmt.Errorf("unknown parser: %s", op)
+	}
+}
+
+// JSONParser extracts all properties of a JSON log line as labels.
+// Nested objects are flattened using `_` as separator and arrays are ignored.
+type JSONParser struct{}
+
+// NewJSONParser creates a new JSONParser.
+func NewJSONParser() *JSONParser {
+	return &JSONParser{}
+}
+
+// Process implements Stage.
+func (j *JSONParser) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	it := jsoniter.ConfigFastest.BorrowIterator(line)
+	defer jsoniter.ConfigFastest.ReturnIterator(it)
+
+	if it.WhatIsNext() != jsoniter.ObjectValue {
+		lbs.SetErr(errJSON)
+		return line, true
+	}
+	if !j.readObject(it, "", lbs) || (it.Error != nil && it.Error != io.EOF) {
+		lbs.SetErr(errJSON)
+	}
+	return line, true
+}
+
+func (j *JSONParser) readObject(it *jsoniter.Iterator, prefix string, lbs *LabelsBuilder) bool {
+	return it.ReadMapCB(func(it *jsoniter.Iterator, field string) bool {
+		key := sanitizeLabelKey(field, prefix == "")
+		if key == "" {
+			it.Skip()
+			return it.Error == nil
+		}
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch it.WhatIsNext() {
+		case jsoniter.StringValue:
+			addExtractedLabel(lbs, key, it.ReadString())
+		case jsoniter.NumberValue:
+			addExtractedLabel(lbs, key, it.ReadNumber().String())
+		case jsoniter.BoolValue:
+			addExtractedLabel(lbs, key, strconv.FormatBool(it.ReadBool()))
+		case jsoniter.ObjectValue:
+			return j.readObject(it, key, lbs)
+		default:
+			it.Skip()
+		}
+		return it.Error == nil
+	})
+}
+
+// UnpackParser reverses the packing of labels into a JSON log line, as done by the promtail `pack` stage.
+// All string properties of the JSON object are extracted as labels, except `_entry` which replaces
+// the log line.
+type UnpackParser struct{}
+
+// NewUnpackParser creates a new UnpackParser.
+func NewUnpackParser() *UnpackParser {
+	return &UnpackParser{}
+}
+
+// Process implements Stage. Lines without an `_entry` property are kept unchanged.
+func (u *UnpackParser) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	it := jsoniter.ConfigFastest.BorrowIterator(line)
+	defer jsoniter.ConfigFastest.ReturnIterator(it)
+
+	if it.WhatIsNext() != jsoniter.ObjectValue {
+		lbs.SetErr(errJSON)
+		return line, true
+	}
+	var entry []byte
+	ok := it.ReadMapCB(func(it *jsoniter.Iterator, field string) bool {
+		if it.WhatIsNext() != jsoniter.StringValue {
+			it.Skip()
+			return it.Error == nil
+		}
+		if field == packedEntryKey {
+			entry = []byte(it.ReadString())
+			return it.Error == nil
+		}
+		key := sanitizeLabelKey(field, true)
+		if key == "" {
+			it.Skip()
+			return it.Error == nil
+		}
+		addExtractedLabel(lbs, key, it.ReadString())
+		return it.Error == nil
+	})
+	if !ok || (it.Error != nil && it.Error != io.EOF) {
+		lbs.SetErr(errJSON)
+		return line, true
+	}
+	if entry != nil {
+		return entry, true
+	}
+	return line, true
+}
+
+// RegexpParser extracts the named capture groups of a regular expression as labels.
+type RegexpParser struct {
+	regex     *regexp.Regexp
+	nameIndex map[int]string
+}
+
+// NewRegexpParser creates a new RegexpParser from a regular expression.
+// The regular expression must contain at least one named capture group,
+// and each name must be a valid label name.
+func NewRegexpParser(re string) (*RegexpParser, error) {
+	regex, err := regexp.Compile(re)
+	if err != nil {
+		return nil, err
+	}
+	nameIndex := map[int]string{}
+	seen := map[string]struct{}{}
+	for i, n := range regex.SubexpNames() {
+		if n == "" {
+			continue
+		}
+		if !model.LabelName(n).IsValid() {
+			return nil, fmt.Errorf("invalid extracted label name '%s'", n)
+		}
+		if _, ok := seen[n]; ok {
+			return nil, fmt.Errorf("duplicate extracted label name '%s'", n)
+		}
+		seen[n] = struct{}{}
+		nameIndex[i] = n
+	}
+	if len(nameIndex) == 0 {
+		return nil, errors.New("at least one named capture must be supplied")
+	}
+	return &RegexpParser{
+		regex:     regex,
+		nameIndex: nameIndex,
+	}, nil
+}
+
+// Process implements Stage. Lines not matching the regular expression are kept without extracted labels.
+func (r *RegexpParser) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	for i, match := range r.regex.FindSubmatch(line) {
+		if name, ok := r.nameIndex[i]; ok {
+			addExtractedLabel(lbs, name, string(match))
+		}
+	}
+	return line, true
+}
+
+// PatternParser extracts labels using a pattern made of literals and `<name>` captures,
+// e.g. `<ip> - <user> [<_>] "<method> <path> <_>"`. The unnamed capture `<_>` matches
+// text without extracting it. Matching doesn't use regular expressions: each capture
+// consumes the line up to the next occurrence of the literal that follows it, and the
+// last capture consumes the rest of the line.
+type PatternParser struct {
+	nodes []patternNode
+}
+
+// patternNode is either a literal or a capture. Captures have an empty literal.
+type patternNode struct {
+	literal []byte
+	name    string
+}
+
+func (n patternNode) isCapture() bool {
+	return n.literal == nil
+}
+
+// NewPatternParser creates a new PatternParser.
+// The pattern must contain at least one named capture, captures must be separated by
+// a literal and each name must be unique.
+func NewPatternParser(pattern string) (*PatternParser, error) {
+	nodes, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	for i, n := range nodes {
+		if !n.isCapture() {
+			continue
+		}
+		if i > 0 && nodes[i-1].isCapture() {
+			return nil, fmt.Errorf("invalid pattern '%s': consecutive captures must be separated by a literal", pattern)
+		}
+		if n.name == "_" {
+			continue
+		}
+		if _, ok := seen[n.name]; ok {
+			return nil, fmt.Errorf("duplicate capture name '%s' in pattern", n.name)
+		}
+		seen[n.name] = struct{}{}
+	}
+	if len(seen) == 0 {
+		return nil, errors.New("at least one named capture must be supplied in pattern")
+	}
+	return &PatternParser{nodes: nodes}, nil
+}
+
+// parsePattern splits a pattern into literals and captures. A `<` which doesn't start
+// a valid `<name>` capture is part of the literal.
+func parsePattern(pattern string) ([]patternNode, error) {
+	var (
+		nodes   []patternNode
+		literal []byte
+	)
+	for i := 0; i < len(pattern); {
+		if pattern[i] == '<' {
+			if end := strings.IndexByte(pattern[i+1:], '>'); end > 0 {
+				name := pattern[i+1 : i+1+end]
+				if isPatternCaptureName(name) {
+					if len(literal) > 0 {
+						nodes = append(nodes, patternNode{literal: literal})
+						literal = nil
+					}
+					nodes = append(nodes, patternNode{name: name})
+					i += end + 2
+					continue
+				}
+			}
+		}
+		literal = append(literal, pattern[i])
+		i++
+	}
+	if len(literal) > 0 {
+		nodes = append(nodes, patternNode{literal: literal})
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+	return nodes, nil
+}
+
+func isPatternCaptureName(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Process implements Stage. Lines not matching the pattern are kept without extracted labels.
+func (p *PatternParser) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	// captures holds the start and end offsets of each capture, in order.
+	var buf [16]int
+	captures := buf[:0]
+	rest := 0
+	for i, n := range p.nodes {
+		if !n.isCapture() {
+			// a literal not preceded by a capture must be found at the current position.
+			if i > 0 {
+				continue
+			}
+			if !bytes.HasPrefix(line, n.literal) {
+				return line, true
+			}
+			rest = len(n.literal)
+			continue
+		}
+		if i == len(p.nodes)-1 {
+			captures = append(captures, rest, len(line))
+			rest = len(line)
+			break
+		}
+		next := p.nodes[i+1].literal
+		idx := bytes.Index(line[rest:], next)
+		if idx < 0 {
+			return line, true
+		}
+		captures = append(captures, rest, rest+idx)
+		rest += idx + len(next)
+	}
+	j := 0
+	for _, n := range p.nodes {
+		if !n.isCapture() {
+			continue
+		}
+		if n.name != "_" {
+			addExtractedLabel(lbs, n.name, string(line[captures[j]:captures[j+1]]))
+		}
+		j += 2
+	}
+	return line, true
+}
+
+// LogfmtParser extracts all key/value pairs of a logfmt log line as labels.
+type LogfmtParser struct{}
+
+// NewLogfmtParser creates a new LogfmtParser.
+func NewLogfmtParser() *LogfmtParser {
+	return &LogfmtParser{}
+}
+
+// Process implements Stage.
+func (l *LogfmtParser) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	dec := logfmt.NewDecoder(bytes.NewReader(line))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			key := sanitizeLabelKey(string(dec.Key()), true)
+			if key == "" {
+				continue
+			}
+			addExtractedLabel(lbs, key, string(dec.Value()))
+		}
+	}
+	if dec.Err() != nil {
+		lbs.SetErr(errLogfmt)
+	}
+	return line, true
+}
+
+// addExtractedLabel adds a label extracted from the log line, suffixing its name
+// if a stream label with the same name already exists.
+func addExtractedLabel(lbs *LabelsBuilder, name, value string) {
+	if lbs.BaseHas(name) {
+		name = name + duplicateSuffix
+	}
+	lbs.Set(name, value)
+}
+
+// sanitizeLabelKey replaces all characters not allowed in a label name with `_`.
+// If isPrefix is true, a leading digit is prefixed with `_` to make the name valid.
+func sanitizeLabelKey(key string, isPrefix bool) string {
+	if len(key) == 0 {
+		return key
+	}
+	if isPrefix && key[0] >= '0' && key[0] <= '9' {
+		key = "_" + key
+	}
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+}
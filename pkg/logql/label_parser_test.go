@@ -0,0 +1,447 @@
+package logql
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_jsonParser_Parse(t *testing.T) {
+	tests := []struct {
+		name string
+		line []byte
+		lbs  labels.Labels
+		want labels.Labels
+	}{
+		{
+			"multi depth",
+			[]byte(`{"app":"foo","namespace":"prod","pod":{"uuid":"foo","deployment":{"ref":"foobar"}}}`),
+			labels.Labels{},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "foo"},
+				labels.Label{Name: "namespace", Value: "prod"},
+				labels.Label{Name: "pod_uuid", Value: "foo"},
+				labels.Label{Name: "pod_deployment_ref", Value: "foobar"},
+			},
+		},
+		{
+			"numeric and bool",
+			[]byte(`{"counter":1, "price": {"_net_":5.56909}, "ok":true}`),
+			labels.Labels{},
+			labels.Labels{
+				labels.Label{Name: "counter", Value: "1"},
+				labels.Label{Name: "price__net_", Value: "5.56909"},
+				labels.Label{Name: "ok", Value: "true"},
+			},
+		},
+		{
+			"skip arrays and null",
+			[]byte(`{"counter":1, "price": {"net_":["10","20"]}, "user": null}`),
+			labels.Labels{},
+			labels.Labels{
+				labels.Label{Name: "counter", Value: "1"},
+			},
+		},
+		{
+			"bad key replaced",
+			[]byte(`{"cou-nter":1}`),
+			labels.Labels{},
+			labels.Labels{
+				labels.Label{Name: "cou_nter", Value: "1"},
+			},
+		},
+		{
+			"duplicate stream label",
+			[]byte(`{"app":"bar"}`),
+			labels.Labels{labels.Label{Name: "app", Value: "foo"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "foo"},
+				labels.Label{Name: "app_extracted", Value: "bar"},
+			},
+		},
+		{
+			"errors",
+			[]byte(`{n}`),
+			labels.Labels{},
+			labels.Labels{
+				labels.Label{Name: ErrorLabel, Value: errJSON},
+			},
+		},
+		{
+			"not an object",
+			[]byte(`"foo"`),
+			labels.Labels{},
+			labels.Labels{
+				labels.Label{Name: ErrorLabel, Value: errJSON},
+			},
+		},
+	}
+	for _, tt := range tests {
+		j := NewJSONParser()
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLabelsBuilder()
+			b.Reset(tt.lbs)
+			_, ok := j.Process(tt.line, b)
+			require.True(t, ok)
+			sort.Sort(tt.want)
+			require.Equal(t, tt.want, b.Labels())
+		})
+	}
+}
+
+func Test_logfmtParser_Parse(t *testing.T) {
+	tests := []struct {
+		name string
+		line []byte
+		lbs  labels.Labels
+		want labels.Labels
+	}{
+		{
+			"not logfmt",
+			[]byte("foobar====wqe=sdad1r"),
+			labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "foo", Value: "bar"},
+				labels.Label{Name: ErrorLabel, Value: errLogfmt},
+			},
+		},
+		{
+			"key alone logfmt",
+			[]byte("buzz bar=foo"),
+			labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "foo", Value: "bar"},
+				labels.Label{Name: "bar", Value: "foo"},
+				labels.Label{Name: "buzz", Value: ""},
+			},
+		},
+		{
+			"quoted logfmt",
+			[]byte(`foobar="foo bar"`),
+			labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "foo", Value: "bar"},
+				labels.Label{Name: "foobar", Value: "foo bar"},
+			},
+		},
+		{
+			"double property logfmt",
+			[]byte(`foobar="foo bar" latency=10ms`),
+			labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "foo", Value: "bar"},
+				labels.Label{Name: "foobar", Value: "foo bar"},
+				labels.Label{Name: "latency", Value: "10ms"},
+			},
+		},
+		{
+			"duplicate from line property",
+			[]byte(`foobar="foo bar" foobar=10ms`),
+			labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "foo", Value: "bar"},
+				labels.Label{Name: "foobar", Value: "10ms"},
+			},
+		},
+		{
+			"duplicate property",
+			[]byte(`foo="foo bar" foobar=10ms`),
+			labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "foo", Value: "bar"},
+				labels.Label{Name: "foo_extracted", Value: "foo bar"},
+				labels.Label{Name: "foobar", Value: "10ms"},
+			},
+		},
+		{
+			"invalid key names",
+			[]byte(`foo="foo bar" foo.bar=10ms test-dash=foo`),
+			labels.Labels{labels.Label{Name: "foo", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "foo", Value: "bar"},
+				labels.Label{Name: "foo_extracted", Value: "foo bar"},
+				labels.Label{Name: "foo_bar", Value: "10ms"},
+				labels.Label{Name: "test_dash", Value: "foo"},
+			},
+		},
+	}
+	p := NewLogfmtParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLabelsBuilder()
+			b.Reset(tt.lbs)
+			_, ok := p.Process(tt.line, b)
+			require.True(t, ok)
+			sort.Sort(tt.want)
+			require.Equal(t, tt.want, b.Labels())
+		})
+	}
+}
+
+func TestNewRegexpParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		re      string
+		wantErr bool
+	}{
+		{"no sub", "w.*", true},
+		{"sub but not named", "f(.*) (foo|bar|buzz)", true},
+		{"named and unamed", "blah (.*) (?P<foo>)", false},
+		{"named", "blah (.*) (?P<foo>foo)(?P<bar>barr)", false},
+		{"invalid name", "blah (.*) (?P<foo$>foo)", true},
+		{"duplicate", "blah (.*) (?P<foo>foo)(?P<foo>bar)", true},
+		{"invalid regexp", "blah (?P<foo>foo", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRegexpParser(tt.re)
+			require.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_regexpParser_Parse(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser *RegexpParser
+		line   []byte
+		lbs    labels.Labels
+		want   labels.Labels
+	}{
+		{
+			"no matches",
+			mustNewRegexParser("(?P<foo>foo|bar)buzz"),
+			[]byte("blah"),
+			labels.Labels{labels.Label{Name: "app", Value: "foo"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "foo"},
+			},
+		},
+		{
+			"double matches",
+			mustNewRegexParser("(?P<foo>.*)buzz"),
+			[]byte("matchebuzz barbuzz"),
+			labels.Labels{labels.Label{Name: "app", Value: "bar"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "bar"},
+				labels.Label{Name: "foo", Value: "matchebuzz bar"},
+			},
+		},
+		{
+			"duplicate labels",
+			mustNewRegexParser("(?P<bar>bar)buzz"),
+			[]byte("barbuzz"),
+			labels.Labels{labels.Label{Name: "bar", Value: "foo"}},
+			labels.Labels{
+				labels.Label{Name: "bar", Value: "foo"},
+				labels.Label{Name: "bar_extracted", Value: "bar"},
+			},
+		},
+		{
+			"multiple labels extracted",
+			mustNewRegexParser(`^(?P<ip>\S+) \S+ \S+ \[[^\]]+\] "(?P<method>\S+) (?P<path>\S+)[^"]*" (?P<status>\d+)`),
+			[]byte(`10.0.0.1 - - [10/Oct/2020:13:55:36 -0700] "GET /api/v1/push HTTP/1.1" 204 0`),
+			labels.Labels{labels.Label{Name: "app", Value: "nginx"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "nginx"},
+				labels.Label{Name: "ip", Value: "10.0.0.1"},
+				labels.Label{Name: "method", Value: "GET"},
+				labels.Label{Name: "path", Value: "/api/v1/push"},
+				labels.Label{Name: "status", Value: "204"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLabelsBuilder()
+			b.Reset(tt.lbs)
+			_, ok := tt.parser.Process(tt.line, b)
+			require.True(t, ok)
+			sort.Sort(tt.want)
+			require.Equal(t, tt.want, b.Labels())
+		})
+	}
+}
+
+func mustNewRegexParser(re string) *RegexpParser {
+	r, err := NewRegexpParser(re)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func TestNewPatternParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{"empty", "", true},
+		{"no capture", "foo bar", true},
+		{"only unnamed", "<_> foo", true},
+		{"consecutive captures", "<foo><bar>", true},
+		{"duplicate", "<foo> <foo>", true},
+		{"named", "<foo> - <bar>", false},
+		{"named and unnamed", "<_> - <bar> <_>", false},
+		{"invalid name is literal", "<foo> <bar baz>", false},
+		{"digit in name", "<f00> <1bar>", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPatternParser(tt.pattern)
+			require.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_patternParser_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		line    []byte
+		lbs     labels.Labels
+		want    labels.Labels
+	}{
+		{
+			"no matches",
+			`<ip> - "<method> <path>"`,
+			[]byte("blah"),
+			labels.Labels{labels.Label{Name: "app", Value: "foo"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "foo"},
+			},
+		},
+		{
+			"leading literal must match",
+			`ts=<ts> msg=<msg>`,
+			[]byte("level=info ts=1 msg=foo"),
+			labels.Labels{labels.Label{Name: "app", Value: "foo"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "foo"},
+			},
+		},
+		{
+			"nginx",
+			`<ip> - <user> [<_>] "<method> <path> <_>" <status> <size>`,
+			[]byte(`10.0.0.1 - frank [10/Oct/2020:13:55:36 -0700] "GET /api/v1/push HTTP/1.1" 204 0`),
+			labels.Labels{labels.Label{Name: "app", Value: "nginx"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "nginx"},
+				labels.Label{Name: "ip", Value: "10.0.0.1"},
+				labels.Label{Name: "method", Value: "GET"},
+				labels.Label{Name: "path", Value: "/api/v1/push"},
+				labels.Label{Name: "size", Value: "0"},
+				labels.Label{Name: "status", Value: "204"},
+				labels.Label{Name: "user", Value: "frank"},
+			},
+		},
+		{
+			"trailing literal",
+			`level=<level> <_> (<duration>)`,
+			[]byte(`level=debug request done (12ms) (extra)`),
+			nil,
+			labels.Labels{
+				labels.Label{Name: "duration", Value: "12ms"},
+				labels.Label{Name: "level", Value: "debug"},
+			},
+		},
+		{
+			"empty capture",
+			`<a>,<b>,<c>`,
+			[]byte(`1,,3`),
+			nil,
+			labels.Labels{
+				labels.Label{Name: "a", Value: "1"},
+				labels.Label{Name: "b", Value: ""},
+				labels.Label{Name: "c", Value: "3"},
+			},
+		},
+		{
+			"duplicate labels",
+			`<app> <msg>`,
+			[]byte("bar hello world"),
+			labels.Labels{labels.Label{Name: "app", Value: "foo"}},
+			labels.Labels{
+				labels.Label{Name: "app", Value: "foo"},
+				labels.Label{Name: "app_extracted", Value: "bar"},
+				labels.Label{Name: "msg", Value: "hello world"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPatternParser(tt.pattern)
+			require.NoError(t, err)
+			b := NewLabelsBuilder()
+			b.Reset(tt.lbs)
+			_, ok := p.Process(tt.line, b)
+			require.True(t, ok)
+			sort.Sort(tt.want)
+			require.Equal(t, tt.want, b.Labels())
+		})
+	}
+}
+
+func Test_unpackParser_Parse(t *testing.T) {
+	tests := []struct {
+		name string
+		line []byte
+		lbs  labels.Labels
+
+		want     labels.Labels
+		wantLine []byte
+	}{
+		{
+			"packed",
+			[]byte(`{"_entry":"level=info msg=hello","pod":"foo-1","container":"app"}`),
+			labels.Labels{{Name: "cluster", Value: "us-1"}},
+			labels.Labels{
+				{Name: "cluster", Value: "us-1"},
+				{Name: "container", Value: "app"},
+				{Name: "pod", Value: "foo-1"},
+			},
+			[]byte("level=info msg=hello"),
+		},
+		{
+			"non string values and duplicates",
+			[]byte(`{"cluster":"us-2","count":1,"nested":{"a":"b"},"_entry":"line"}`),
+			labels.Labels{{Name: "cluster", Value: "us-1"}},
+			labels.Labels{
+				{Name: "cluster", Value: "us-1"},
+				{Name: "cluster_extracted", Value: "us-2"},
+			},
+			[]byte("line"),
+		},
+		{
+			"no entry",
+			[]byte(`{"pod":"foo-1"}`),
+			nil,
+			labels.Labels{{Name: "pod", Value: "foo-1"}},
+			[]byte(`{"pod":"foo-1"}`),
+		},
+		{
+			"not json",
+			[]byte(`level=info`),
+			labels.Labels{{Name: "cluster", Value: "us-1"}},
+			labels.Labels{
+				{Name: "cluster", Value: "us-1"},
+				{Name: ErrorLabel, Value: errJSON},
+			},
+			[]byte(`level=info`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewLabelsBuilder()
+			b.Reset(tt.lbs)
+			line, ok := NewUnpackParser().Process(tt.line, b)
+			require.True(t, ok)
+			sort.Sort(tt.want)
+			require.Equal(t, tt.want, b.Labels())
+			require.Equal(t, tt.wantLine, line)
+		})
+	}
+}
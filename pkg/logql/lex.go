@@ -1,10 +1,14 @@
 package logql
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 	"text/scanner"
 	"time"
+	"unicode"
 
+	"github.com/dustin/go-humanize"
 	"github.com/prometheus/common/model"
 )
 
@@ -19,6 +23,7 @@ var tokens = map[string]int{
 	"!~":                 NRE,
 	"|=":                 PIPE_EXACT,
 	"|~":                 PIPE_MATCH,
+	"|":                  PIPE,
 	"(":                  OPEN_PARENTHESIS,
 	")":                  CLOSE_PARENTHESIS,
 	"by":                 BY,
@@ -30,6 +35,16 @@ var tokens = map[string]int{
 	OpRangeTypeCount:     COUNT_OVER_TIME,
 	OpRangeTypeBytesRate: BYTES_RATE,
 	OpRangeTypeBytes:     BYTES_OVER_TIME,
+	OpRangeTypeSum:       SUM_OVER_TIME,
+	OpRangeTypeAvg:       AVG_OVER_TIME,
+	OpRangeTypeMax:       MAX_OVER_TIME,
+	OpRangeTypeMin:       MIN_OVER_TIME,
+	OpRangeTypeStddev:    STDDEV_OVER_TIME,
+	OpRangeTypeStdvar:    STDVAR_OVER_TIME,
+	OpRangeTypeQuantile:  QUANTILE_OVER_TIME,
+	OpRangeTypeAbsent:    ABSENT_OVER_TIME,
+	OpRangeTypeFirst:     FIRST_OVER_TIME,
+	OpRangeTypeLast:      LAST_OVER_TIME,
 	OpTypeSum:            SUM,
 	OpTypeAvg:            AVG,
 	OpTypeMax:            MAX,
@@ -39,6 +54,33 @@ var tokens = map[string]int{
 	OpTypeStdvar:         STDVAR,
 	OpTypeBottomK:        BOTTOMK,
 	OpTypeTopK:           TOPK,
+	OpTypeCountValues:    COUNT_VALUES,
+	OpTypeSort:           SORT,
+	OpTypeSortDesc:       SORT_DESC,
+	OpLabelReplace:       LABEL_REPLACE,
+
+	// parsers
+	OpParserTypeJSON:    JSON,
+	OpParserTypeLogfmt:  LOGFMT,
+	OpParserTypeRegexp:  REGEXP,
+	OpParserTypePattern: PATTERN,
+	OpParserTypeUnpack:  UNPACK,
+
+	// formatters
+	OpFmtLine:    LINE_FMT,
+	OpFmtLabel:   LABEL_FMT,
+	OpDecolorize: DECOLORIZE,
+
+	OpDistinct: DISTINCT,
+
+	OpUnwrap: UNWRAP,
+	OpOffset: OFFSET,
+
+	// vector matching
+	OpOn:         ON,
+	OpIgnoring:   IGNORING,
+	OpGroupLeft:  GROUP_LEFT,
+	OpGroupRight: GROUP_RIGHT,
 
 	// binops
 	OpTypeOr:     OR,
@@ -63,6 +105,7 @@ type lexer struct {
 	errs   []ParseError
 	expr   Expr
 	parser *exprParserImpl
+	opts   ParseOptions
 }
 
 func (l *lexer) Lex(lval *exprSymType) int {
@@ -72,7 +115,24 @@ func (l *lexer) Lex(lval *exprSymType) int {
 		return 0
 
 	case scanner.Int, scanner.Float:
-		lval.str = l.TokenText()
+		numberText := l.TokenText()
+		// numbers directly followed by a unit are durations or bytes literals, e.g 1m30s or 20KB.
+		if unicode.IsLetter(l.Peek()) {
+			unit := ""
+			for r := l.Peek(); unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.'; r = l.Peek() {
+				unit += string(l.Next())
+			}
+			lval.str = numberText + unit
+			if _, err := parseDuration(lval.str); err == nil {
+				return DURATION
+			}
+			if _, err := humanize.ParseBytes(lval.str); err == nil {
+				return BYTES
+			}
+			l.Error(fmt.Sprintf("invalid duration or bytes literal: %s", lval.str))
+			return 0
+		}
+		lval.str = numberText
 		return NUMBER
 
 	case scanner.String, scanner.RawString:
@@ -90,13 +150,16 @@ func (l *lexer) Lex(lval *exprSymType) int {
 		d := ""
 		for r := l.Next(); r != scanner.EOF; r = l.Next() {
 			if string(r) == "]" {
-				i, err := model.ParseDuration(d)
+				if strings.Contains(d, ":") {
+					return l.lexSubqueryRange(d, lval)
+				}
+				i, err := parseRange(d, l.opts.Downstream)
 				if err != nil {
 					l.Error(err.Error())
 					return 0
 				}
-				lval.duration = time.Duration(i)
-				return DURATION
+				lval.duration = i
+				return RANGE
 			}
 			d += string(r)
 		}
@@ -117,6 +180,78 @@ func (l *lexer) Lex(lval *exprSymType) int {
 	return IDENTIFIER
 }
 
+// lexSubqueryRange lexes the `<range>:<step>` content of a subquery brackets.
+func (l *lexer) lexSubqueryRange(d string, lval *exprSymType) int {
+	parts := strings.SplitN(d, ":", 2)
+	if parts[1] == "" {
+		l.Error("missing step in subquery range")
+		return 0
+	}
+	interval, err := model.ParseDuration(parts[0])
+	if err != nil {
+		l.Error(err.Error())
+		return 0
+	}
+	step, err := model.ParseDuration(parts[1])
+	if err != nil {
+		l.Error(err.Error())
+		return 0
+	}
+	if step == 0 {
+		l.Error("zero step in subquery range")
+		return 0
+	}
+	lval.subqueryRange = subqueryRange{
+		interval: time.Duration(interval),
+		step:     time.Duration(step),
+	}
+	return SUBQUERY_RANGE
+}
+
 func (l *lexer) Error(msg string) {
 	l.errs = append(l.errs, newParseError(msg, l.Line, l.Column))
 }
+
+// parseDuration parses a duration literal. On top of the Go format, single unit
+// Prometheus durations are accepted so that days, weeks and years can be used (e.g 1d).
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		return d, nil
+	}
+	if md, merr := model.ParseDuration(s); merr == nil {
+		return time.Duration(md), nil
+	}
+	return 0, err
+}
+
+// parseRange parses the duration of a range. On top of Prometheus durations, positive Go durations are
+// accepted in downstream queries for ranges which are not a whole number of milliseconds, e.g. the
+// sub-ranges of split instant queries.
+func parseRange(s string, downstream bool) (time.Duration, error) {
+	d, err := model.ParseDuration(s)
+	if err == nil {
+		return time.Duration(d), nil
+	}
+	if !downstream {
+		return 0, err
+	}
+	if gd, gerr := time.ParseDuration(s); gerr == nil && gd >= 0 {
+		return gd, nil
+	}
+	return 0, err
+}
+
+// emptyGrouping checks that an empty grouping label list is allowed, it is only written
+// by the query frontend in downstream queries.
+func (l *lexer) emptyGrouping() {
+	if !l.opts.Downstream {
+		l.Error("syntax error: unexpected ), expecting IDENTIFIER")
+	}
+}
+
+// subqueryRange is the range and the resolution of a subquery.
+type subqueryRange struct {
+	interval time.Duration
+	step     time.Duration
+}
@@ -48,6 +48,17 @@ func WriteLabelResponseJSON(l logproto.LabelResponse, w io.Writer) error {
 	return json.NewEncoder(w).Encode(v1Response)
 }
 
+// WriteFormatQueryResponseJSON marshals a formatted query to v1 loghttp JSON and then
+// writes it to the provided io.Writer.
+func WriteFormatQueryResponseJSON(query string, w io.Writer) error {
+	v1Response := loghttp.FormatQueryResponse{
+		Status: "success",
+		Data:   query,
+	}
+
+	return json.NewEncoder(w).Encode(v1Response)
+}
+
 // WriteTailResponseJSON marshals the legacy.TailResponse to v1 loghttp JSON and
 // then writes it to the provided connection.
 func WriteTailResponseJSON(r legacy.TailResponse, c *websocket.Conn) error {
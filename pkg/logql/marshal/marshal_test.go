@@ -31,6 +31,11 @@ var queryTests = []struct {
 						Timestamp: time.Unix(0, 123456789012345),
 						Line:      "super line",
 					},
+					{
+						Timestamp:          time.Unix(0, 123456789012346),
+						Line:               "super line with metadata",
+						StructuredMetadata: []logproto.LabelPair{{Name: "trace_id", Value: "3c5b1a"}},
+					},
 				},
 				Labels: `{test="test"}`,
 			},
@@ -45,7 +50,8 @@ var queryTests = []struct {
 							"test": "test"
 						},
 						"values":[
-							[ "123456789012345", "super line" ]
+							[ "123456789012345", "super line" ],
+							[ "123456789012346", "super line with metadata", { "trace_id": "3c5b1a" } ]
 						]
 					}
 				],
@@ -371,6 +377,28 @@ func Test_WriteQueryResponseJSON(t *testing.T) {
 	}
 }
 
+func Test_WriteQueryResponseJSON_VectorOrder(t *testing.T) {
+	// results of sort and sort_desc are ordered by value, the order must be kept in the response.
+	vector := promql.Vector{
+		{Point: promql.Point{T: 1568404331324, V: 3}, Metric: labels.Labels{{Name: "app", Value: "c"}}},
+		{Point: promql.Point{T: 1568404331324, V: 2}, Metric: labels.Labels{{Name: "app", Value: "a"}}},
+		{Point: promql.Point{T: 1568404331324, V: 1}, Metric: labels.Labels{{Name: "app", Value: "b"}}},
+	}
+	var b bytes.Buffer
+	err := WriteQueryResponseJSON(logql.Result{Data: vector}, &b)
+	require.NoError(t, err)
+
+	var res loghttp.QueryResponse
+	require.NoError(t, json.Unmarshal(b.Bytes(), &res))
+	result, ok := res.Data.Result.(loghttp.Vector)
+	require.True(t, ok)
+	require.Len(t, result, len(vector))
+	for i, s := range vector {
+		require.Equal(t, s.Metric.Get("app"), string(result[i].Metric["app"]))
+		require.Equal(t, s.V, float64(result[i].Value))
+	}
+}
+
 func Test_WriteLabelResponseJSON(t *testing.T) {
 	for i, labelTest := range labelTests {
 		var b bytes.Buffer
@@ -381,6 +409,14 @@ func Test_WriteLabelResponseJSON(t *testing.T) {
 	}
 }
 
+func Test_WriteFormatQueryResponseJSON(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteFormatQueryResponseJSON("sum(rate({app=\"foo\"}[1m]))", &b)
+	require.NoError(t, err)
+
+	testJSONBytesEqual(t, []byte(`{"status":"success","data":"sum(rate({app=\"foo\"}[1m]))"}`), b.Bytes(), "Format query test failed")
+}
+
 func Test_MarshalTailResponse(t *testing.T) {
 	for i, tailTest := range tailTests {
 		// convert logproto to model objects
@@ -102,8 +102,9 @@ func NewStream(s logproto.Stream) (loghttp.Stream, error) {
 // NewEntry constructs an Entry from a logproto.Entry
 func NewEntry(e logproto.Entry) loghttp.Entry {
 	return loghttp.Entry{
-		Timestamp: e.Timestamp,
-		Line:      e.Line,
+		Timestamp:          e.Timestamp,
+		Line:               e.Line,
+		StructuredMetadata: e.StructuredMetadata,
 	}
 }
 
@@ -14,7 +14,9 @@ import (
 // However, we still need to ensure that it can be merged effectively
 // with another leg that may match series.
 // Therefore, we determine our steps from the parameters
-// and not the underlying Matrix.
+// and not the underlying Matrix. Like the steps of the query,
+// they include the end. The series without a point at a step
+// are absent from its vector.
 type MatrixStepper struct {
 	start, end, ts time.Time
 	step           time.Duration
@@ -33,7 +35,7 @@ func NewMatrixStepper(start, end time.Time, step time.Duration, m promql.Matrix)
 
 func (m *MatrixStepper) Next() (bool, int64, promql.Vector) {
 	m.ts = m.ts.Add(m.step)
-	if !m.ts.Before(m.end) {
+	if m.ts.After(m.end) {
 		return false, 0, nil
 	}
 
@@ -44,13 +46,6 @@ func (m *MatrixStepper) Next() (bool, int64, promql.Vector) {
 		ln := len(series.Points)
 
 		if ln == 0 || series.Points[0].T != ts {
-			vec = append(vec, promql.Sample{
-				Point: promql.Point{
-					T: ts,
-					V: 0,
-				},
-				Metric: series.Metric,
-			})
 			continue
 		}
 
@@ -65,3 +60,5 @@ func (m *MatrixStepper) Next() (bool, int64, promql.Vector) {
 }
 
 func (m *MatrixStepper) Close() error { return nil }
+
+func (m *MatrixStepper) Error() error { return nil }
@@ -0,0 +1,138 @@
+package logql
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/loki/pkg/iter"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// mergedStagesPageFactor is the number of entries fetched per entry of the limit by each page of the
+// queries with merged stages, which drop entries once the sources have applied their limit.
+const mergedStagesPageFactor = 10
+
+// pagedIterator selects the entries of a query by pages of at most limit entries, the next page is
+// only selected once the entries of the current one are consumed. Each source returns at most limit
+// entries, only the first limit entries of the merged sources are complete and consumed.
+// The next page starts at the timestamp of the last entry consumed, the entries of this timestamp already
+// consumed are skipped. A full page of entries sharing the same timestamp may not contain all of them, the
+// next page is then selected with twice the limit until it does.
+type pagedIterator struct {
+	ctx     context.Context
+	querier Querier
+	params  logproto.QueryRequest
+
+	it       iter.EntryIterator
+	limit    uint32
+	consumed uint32
+	firstTs  time.Time
+	added    bool
+	done     bool
+	err      error
+
+	// the entries consumed with the timestamp of the last entry, by labels and line.
+	lastTs time.Time
+	seen   map[string]struct{}
+	prev   map[string]struct{}
+}
+
+func newPagedIterator(ctx context.Context, querier Querier, params logproto.QueryRequest) *pagedIterator {
+	return &pagedIterator{
+		ctx:     ctx,
+		querier: querier,
+		params:  params,
+		limit:   params.Limit,
+		seen:    map[string]struct{}{},
+	}
+}
+
+func (p *pagedIterator) Next() bool {
+	for !p.done {
+		if p.it == nil {
+			if p.err = p.nextPage(); p.err != nil {
+				return false
+			}
+		}
+		if p.consumed < p.limit && p.it.Next() {
+			p.consumed++
+			e := p.it.Entry()
+			if p.consumed == 1 {
+				p.firstTs = e.Timestamp
+			}
+			key := p.it.Labels() + "\x00" + e.Line
+			if e.Timestamp.Equal(p.lastTs) {
+				if _, ok := p.prev[key]; ok {
+					continue
+				}
+			} else {
+				p.lastTs = e.Timestamp
+				p.seen = map[string]struct{}{}
+				p.prev = nil
+			}
+			p.seen[key] = struct{}{}
+			p.added = true
+			return true
+		}
+		if p.err = p.it.Error(); p.err != nil {
+			return false
+		}
+		// a full page with a single timestamp may miss entries of this timestamp.
+		full := p.consumed == p.limit
+		if full && p.firstTs.Equal(p.lastTs) {
+			p.limit *= 2
+		} else {
+			// a page with less than limit entries was the last one, as a page without new entries.
+			p.done = !full || !p.added
+			p.limit = p.params.Limit
+		}
+		if p.err = p.it.Close(); p.err != nil {
+			return false
+		}
+		p.it = nil
+	}
+	return false
+}
+
+// nextPage selects the page after the last entry consumed.
+func (p *pagedIterator) nextPage() error {
+	if p.consumed > 0 {
+		if p.params.Direction == logproto.FORWARD {
+			p.params.Start = p.lastTs
+		} else {
+			// the end is excluded.
+			p.params.End = p.lastTs.Add(time.Nanosecond)
+		}
+	}
+	p.prev = p.seen
+	p.consumed, p.added = 0, false
+
+	params := p.params
+	params.Limit = p.limit
+	it, err := p.querier.Select(p.ctx, SelectParams{QueryRequest: &params})
+	if err != nil {
+		return err
+	}
+	p.it = it
+	return nil
+}
+
+func (p *pagedIterator) Entry() logproto.Entry {
+	return p.it.Entry()
+}
+
+func (p *pagedIterator) Labels() string {
+	return p.it.Labels()
+}
+
+func (p *pagedIterator) Error() error {
+	return p.err
+}
+
+func (p *pagedIterator) Close() error {
+	p.done = true
+	if p.it != nil {
+		return p.it.Close()
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package logql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/iter"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// limitedQuerier returns at most limit entries of each stream, like each ingester and the store do.
+type limitedQuerier struct {
+	streams []logproto.Stream
+	selects int
+}
+
+func (q *limitedQuerier) Select(_ context.Context, p SelectParams) (iter.EntryIterator, error) {
+	q.selects++
+	its := make([]iter.EntryIterator, 0, len(q.streams))
+	for _, s := range q.streams {
+		var entries []logproto.Entry
+		for _, e := range s.Entries {
+			if !e.Timestamp.Before(p.Start) && e.Timestamp.Before(p.End) {
+				entries = append(entries, e)
+			}
+		}
+		if p.Direction == logproto.BACKWARD {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		if len(entries) > int(p.Limit) {
+			entries = entries[:p.Limit]
+		}
+		its = append(its, iter.NewStreamIterator(logproto.Stream{Labels: s.Labels, Entries: entries}))
+	}
+	return iter.NewHeapIterator(context.Background(), its, p.Direction), nil
+}
+
+func TestPagedIterator(t *testing.T) {
+	var streams []logproto.Stream
+	for i, labels := range []string{`{app="foo"}`, `{app="bar"}`} {
+		s := logproto.Stream{Labels: labels}
+		for j := 0; j < 50; j++ {
+			// some entries of both streams share their timestamp.
+			ts := time.Unix(int64(j/3), 0)
+			s.Entries = append(s.Entries, logproto.Entry{Timestamp: ts, Line: fmt.Sprintf("%d %d", i, j)})
+		}
+		streams = append(streams, s)
+	}
+
+	for _, direction := range []logproto.Direction{logproto.FORWARD, logproto.BACKWARD} {
+		t.Run(direction.String(), func(t *testing.T) {
+			params := logproto.QueryRequest{Start: time.Unix(0, 0), End: time.Unix(100, 0), Limit: 1e3, Direction: direction}
+			all := &limitedQuerier{streams: streams}
+			it, err := all.Select(context.Background(), SelectParams{QueryRequest: &params})
+			require.NoError(t, err)
+			expected := readEntries(t, it)
+			require.Len(t, expected, 100)
+
+			q := &limitedQuerier{streams: streams}
+			params.Limit = 7
+			it = newPagedIterator(context.Background(), q, params)
+			require.Equal(t, expected, readEntries(t, it))
+			require.Greater(t, q.selects, 10)
+		})
+	}
+}
+
+func TestPagedIterator_SameTimestamp(t *testing.T) {
+	var streams []logproto.Stream
+	for i, labels := range []string{`{app="foo"}`, `{app="bar"}`} {
+		s := logproto.Stream{Labels: labels}
+		for j := 0; j < 30; j++ {
+			// more than limit entries share the timestamp of the second.
+			ts := time.Unix(1, 0)
+			if j == 0 || j == 29 {
+				ts = time.Unix(int64(j), 0)
+			}
+			s.Entries = append(s.Entries, logproto.Entry{Timestamp: ts, Line: fmt.Sprintf("%d %d", i, j)})
+		}
+		streams = append(streams, s)
+	}
+
+	for _, direction := range []logproto.Direction{logproto.FORWARD, logproto.BACKWARD} {
+		t.Run(direction.String(), func(t *testing.T) {
+			params := logproto.QueryRequest{Start: time.Unix(0, 0), End: time.Unix(100, 0), Limit: 1e3, Direction: direction}
+			all := &limitedQuerier{streams: streams}
+			it, err := all.Select(context.Background(), SelectParams{QueryRequest: &params})
+			require.NoError(t, err)
+			expected := readEntries(t, it)
+			require.Len(t, expected, 60)
+
+			params.Limit = 7
+			it = newPagedIterator(context.Background(), &limitedQuerier{streams: streams}, params)
+			require.Equal(t, expected, readEntries(t, it))
+		})
+	}
+}
+
+func readEntries(t *testing.T, it iter.EntryIterator) []string {
+	var entries []string
+	for it.Next() {
+		entries = append(entries, fmt.Sprintf("%d %s %s", it.Entry().Timestamp.Unix(), it.Labels(), it.Entry().Line))
+	}
+	require.NoError(t, it.Error())
+	require.NoError(t, it.Close())
+	return entries
+}
@@ -17,8 +17,21 @@ func init() {
 	}
 }
 
+// ParseOptions are the options of the parser.
+type ParseOptions struct {
+	// Downstream accepts the syntax of the expressions written by the query frontend
+	// for the downstream queries, which isn't part of the user grammar: ranges can be
+	// Go durations (e.g. [3600000000001ns]) and grouping labels can be empty (e.g. by ()).
+	Downstream bool
+}
+
 // ParseExpr parses a string and returns an Expr.
-func ParseExpr(input string) (expr Expr, err error) {
+func ParseExpr(input string) (Expr, error) {
+	return ParseExprWithOptions(input, ParseOptions{})
+}
+
+// ParseExprWithOptions parses a string with the given options and returns an Expr.
+func ParseExprWithOptions(input string, opts ParseOptions) (expr Expr, err error) {
 	defer func() {
 		r := recover()
 		if r != nil {
@@ -33,6 +46,7 @@ func ParseExpr(input string) (expr Expr, err error) {
 	}()
 	l := lexer{
 		parser: exprNewParser().(*exprParserImpl),
+		opts:   opts,
 	}
 	l.Init(strings.NewReader(input))
 	l.Scanner.Error = func(_ *scanner.Scanner, msg string) {
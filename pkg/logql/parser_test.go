@@ -39,6 +39,47 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
+		{
+			in: `{app="foo"} |= ip("10.0.0.0/8") != nocase("Debug")`,
+			exp: &filterExpr{
+				ty:    labels.MatchNotEqual,
+				match: "Debug",
+				fn:    OpFilterNoCase,
+				left: &filterExpr{
+					ty:    labels.MatchEqual,
+					match: "10.0.0.0/8",
+					fn:    OpFilterIP,
+					left:  &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m] |= ip("::1"))`,
+			exp: &rangeAggregationExpr{
+				operation: "count_over_time",
+				left: &logRange{
+					left: &filterExpr{
+						ty:    labels.MatchEqual,
+						match: "::1",
+						fn:    OpFilterIP,
+						left:  &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+					},
+					interval: 5 * time.Minute,
+				},
+			},
+		},
+		{
+			in:  `{app="foo"} |~ ip("10.0.0.1")`,
+			err: ParseError{msg: "line filter function ip can only be used with |= and !="},
+		},
+		{
+			in:  `{app="foo"} |= ip("10.0.0.0/33")`,
+			err: ParseError{msg: `invalid ip filter "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`},
+		},
+		{
+			in:  `{app="foo"} |= upper("foo")`,
+			err: ParseError{msg: "unsupported line filter function: upper"},
+		},
 		{
 			// test [12h] before filter expr
 			in: `count_over_time({foo="bar"}[12h] |= "error")`,
@@ -177,6 +218,31 @@ func TestParse(t *testing.T) {
 				operation: "rate",
 			}, "sum", nil, nil),
 		},
+		{
+			// Go durations and empty groupings are only accepted in downstream queries.
+			in: `sum(rate({ foo !~ "bar" }[1.5h]))`,
+			err: ParseError{
+				msg:  `not a valid duration string: "1.5h"`,
+				line: 0,
+				col:  26,
+			},
+		},
+		{
+			in: `sum by () (count_over_time({ foo !~ "bar" }[5h]))`,
+			err: ParseError{
+				msg:  "syntax error: unexpected ), expecting IDENTIFIER",
+				line: 1,
+				col:  9,
+			},
+		},
+		{
+			in: `sum without () (count_over_time({ foo !~ "bar" }[5h]))`,
+			err: ParseError{
+				msg:  "syntax error: unexpected ), expecting IDENTIFIER",
+				line: 1,
+				col:  14,
+			},
+		},
 		{
 			in: `avg(count_over_time({ foo !~ "bar" }[5h])) by (bar,foo)`,
 			exp: mustNewVectorAggregationExpr(&rangeAggregationExpr{
@@ -273,7 +339,7 @@ func TestParse(t *testing.T) {
 		{
 			in: `min({ foo !~ "bar" }[5m])`,
 			err: ParseError{
-				msg:  "syntax error: unexpected DURATION",
+				msg:  "syntax error: unexpected RANGE",
 				line: 0,
 				col:  21,
 			},
@@ -665,7 +731,7 @@ func TestParse(t *testing.T) {
 		{
 			in: `{foo="bar"} |~`,
 			err: ParseError{
-				msg:  "syntax error: unexpected $end, expecting STRING",
+				msg:  "syntax error: unexpected $end, expecting IDENTIFIER or STRING",
 				line: 1,
 				col:  15,
 			},
@@ -1153,6 +1219,594 @@ func TestParse(t *testing.T) {
 			in:  `1 > 1 > bool 1`,
 			exp: &literalExpr{value: 0},
 		},
+		{
+			in: `{app="foo"} |= "bar" | json`,
+			exp: &labelParserExpr{
+				op: OpParserTypeJSON,
+				left: &filterExpr{
+					ty:    labels.MatchEqual,
+					match: "bar",
+					left:  &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+				},
+			},
+		},
+		{
+			in: `{app="foo"} | logfmt != "bar"`,
+			exp: &filterExpr{
+				ty:    labels.MatchNotEqual,
+				match: "bar",
+				left: &labelParserExpr{
+					op:   OpParserTypeLogfmt,
+					left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m] | json)`,
+			exp: &rangeAggregationExpr{
+				operation: OpRangeTypeCount,
+				left: &logRange{
+					left: &labelParserExpr{
+						op:   OpParserTypeJSON,
+						left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+					},
+					interval: 5 * time.Minute,
+				},
+			},
+		},
+		{
+			in: `{app="foo"} | logfmt | status >= 500 and duration > 1s`,
+			exp: &labelFilterExpr{
+				filter: newAndLabelFilter(
+					newNumericLabelFilter(OpTypeGTE, "status", 500),
+					newDurationLabelFilter(OpTypeGT, "duration", time.Second),
+				),
+				left: &labelParserExpr{
+					op:   OpParserTypeLogfmt,
+					left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+				},
+			},
+		},
+		{
+			in: `{app="foo"} | json | (method="GET" or size < 20KB) and level=~"err.*"`,
+			exp: &labelFilterExpr{
+				filter: newAndLabelFilter(
+					newOrLabelFilter(
+						newStringLabelFilter(mustNewMatcher(labels.MatchEqual, "method", "GET")),
+						newBytesLabelFilter(OpTypeLT, "size", 20000),
+					),
+					newStringLabelFilter(mustNewMatcher(labels.MatchRegexp, "level", "err.*")),
+				),
+				left: &labelParserExpr{
+					op:   OpParserTypeJSON,
+					left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+				},
+			},
+		},
+		{
+			in: `{app="foo"} | json | a == 1 or b != 2 and c = 3`,
+			exp: &labelFilterExpr{
+				filter: newOrLabelFilter(
+					newNumericLabelFilter(OpTypeCmpEQ, "a", 1),
+					newAndLabelFilter(
+						newNumericLabelFilter(OpTypeNEQ, "b", 2),
+						newNumericLabelFilter(OpTypeCmpEQ, "c", 3),
+					),
+				),
+				left: &labelParserExpr{
+					op:   OpParserTypeJSON,
+					left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+				},
+			},
+		},
+		{
+			in: `sum by (status) (count_over_time({app="foo"}[5m] | logfmt | latency >= 250ms |= "error"))`,
+			exp: mustNewVectorAggregationExpr(
+				&rangeAggregationExpr{
+					operation: OpRangeTypeCount,
+					left: &logRange{
+						left: &filterExpr{
+							ty:    labels.MatchEqual,
+							match: "error",
+							left: &labelFilterExpr{
+								filter: newDurationLabelFilter(OpTypeGTE, "latency", 250*time.Millisecond),
+								left: &labelParserExpr{
+									op:   OpParserTypeLogfmt,
+									left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+								},
+							},
+						},
+						interval: 5 * time.Minute,
+					},
+				},
+				OpTypeSum,
+				&grouping{groups: []string{"status"}},
+				nil,
+			),
+		},
+		{
+			in: `{app="foo"} | logfmt | latency > 10foo`,
+			err: ParseError{
+				msg:  "invalid duration or bytes literal: 10foo",
+				line: 0,
+				col:  34,
+			},
+		},
+		{
+			in: `{app="foo"} | regexp "(?P<method>\\w+) (?P<path>\\S+)"`,
+			exp: &labelParserExpr{
+				op:    OpParserTypeRegexp,
+				param: `(?P<method>\w+) (?P<path>\S+)`,
+				left:  &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+			},
+		},
+		{
+			in: `{app="foo"} | regexp "(\\w+)"`,
+			err: ParseError{
+				msg:  "at least one named capture must be supplied",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: "{app=\"foo\"} | pattern `<ip> - - [<_>] \"<method> <path>`",
+			exp: &labelParserExpr{
+				op:    OpParserTypePattern,
+				param: `<ip> - - [<_>] "<method> <path>`,
+				left:  &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+			},
+		},
+		{
+			in: `{app="foo"} | decolorize | unpack | distinct trace`,
+			exp: &distinctExpr{
+				label: "trace",
+				left: &labelParserExpr{
+					op: OpParserTypeUnpack,
+					left: &decolorizeExpr{
+						left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+					},
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"} | logfmt | distinct trace [5m])`,
+			err: ParseError{
+				msg:  "distinct is only supported in log queries",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `{app="foo"} | distinct "trace"`,
+			err: ParseError{
+				msg:  "syntax error: unexpected STRING, expecting IDENTIFIER",
+				line: 1,
+				col:  24,
+			},
+		},
+		{
+			in: `{app="foo"} | pattern "<ip><port>"`,
+			err: ParseError{
+				msg:  "invalid pattern '<ip><port>': consecutive captures must be separated by a literal",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `{app="foo"} | line_format "{{.foo}}" | label_format bar=foo,buzz="{{.foo}}-{{.bar}}"`,
+			exp: &labelFormatExpr{
+				formats: []labelFmt{newRenameLabelFmt("bar", "foo"), newTemplateLabelFmt("buzz", "{{.foo}}-{{.bar}}")},
+				left: &lineFormatExpr{
+					template: "{{.foo}}",
+					left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+				},
+			},
+		},
+		{
+			in: `{app="foo"} | line_format "{{.foo"`,
+			err: ParseError{
+				msg:  "invalid line template: template: line_format:1: unclosed action",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `{app="foo"} | label_format foo=bar,foo="{{.buzz}}"`,
+			err: ParseError{
+				msg:  "multiple label name 'foo' not allowed in a single format operation",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `quantile_over_time(0.99, {app="foo"} | logfmt | unwrap duration(latency) [5m])`,
+			exp: &rangeAggregationExpr{
+				operation: OpRangeTypeQuantile,
+				params:    func() *float64 { f := 0.99; return &f }(),
+				left: &logRange{
+					left: &labelParserExpr{
+						op:   OpParserTypeLogfmt,
+						left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+					},
+					interval: 5 * time.Minute,
+					unwrap:   &unwrapExpr{identifier: "latency", operation: OpConvDuration},
+				},
+			},
+		},
+		{
+			in: `sum_over_time({app="foo"}[5m] | json | unwrap size)`,
+			exp: &rangeAggregationExpr{
+				operation: OpRangeTypeSum,
+				left: &logRange{
+					left: &labelParserExpr{
+						op:   OpParserTypeJSON,
+						left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+					},
+					interval: 5 * time.Minute,
+					unwrap:   &unwrapExpr{identifier: "size"},
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m] offset 1d)`,
+			exp: &rangeAggregationExpr{
+				operation: OpRangeTypeCount,
+				left: &logRange{
+					left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+					interval: 5 * time.Minute,
+					offset:   24 * time.Hour,
+				},
+			},
+		},
+		{
+			in: `sum_over_time({app="foo"} | json | unwrap size [5m] offset 1h30m)`,
+			exp: &rangeAggregationExpr{
+				operation: OpRangeTypeSum,
+				left: &logRange{
+					left: &labelParserExpr{
+						op:   OpParserTypeJSON,
+						left: &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+					},
+					interval: 5 * time.Minute,
+					unwrap:   &unwrapExpr{identifier: "size"},
+					offset:   90 * time.Minute,
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m] offset 1)`,
+			err: ParseError{
+				msg:  "syntax error: unexpected NUMBER, expecting DURATION",
+				line: 1,
+				col:  40,
+			},
+		},
+		{
+			in: `sum_over_time({app="foo"} | json [5m])`,
+			err: ParseError{
+				msg:  "invalid aggregation sum_over_time without unwrap",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `rate({app="foo"} | json | unwrap size [5m])`,
+			err: ParseError{
+				msg:  "invalid aggregation rate with unwrap",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `quantile_over_time({app="foo"} | json | unwrap size [5m])`,
+			err: ParseError{
+				msg:  "parameter required for operation quantile_over_time",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `sum_over_time({app="foo"} | json | unwrap foo(size) [5m])`,
+			err: ParseError{
+				msg:  "unsupported unwrap conversion function: foo",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m]) / ignoring(status) group_left count_over_time({app="bar"}[5m])`,
+			exp: &binOpExpr{
+				op: OpTypeDiv,
+				opts: BinOpOptions{
+					VectorMatching: &VectorMatching{Card: CardManyToOne, MatchingLabels: []string{"status"}},
+				},
+				SampleExpr: &rangeAggregationExpr{
+					operation: OpRangeTypeCount,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+						interval: 5 * time.Minute,
+					},
+				},
+				RHS: &rangeAggregationExpr{
+					operation: OpRangeTypeCount,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "bar")}},
+						interval: 5 * time.Minute,
+					},
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m]) > bool on(app, env) group_right(team) count_over_time({app="bar"}[5m])`,
+			exp: &binOpExpr{
+				op: OpTypeGT,
+				opts: BinOpOptions{
+					ReturnBool:     true,
+					VectorMatching: &VectorMatching{Card: CardOneToMany, On: true, MatchingLabels: []string{"app", "env"}, Include: []string{"team"}},
+				},
+				SampleExpr: &rangeAggregationExpr{
+					operation: OpRangeTypeCount,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+						interval: 5 * time.Minute,
+					},
+				},
+				RHS: &rangeAggregationExpr{
+					operation: OpRangeTypeCount,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "bar")}},
+						interval: 5 * time.Minute,
+					},
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m]) or on() count_over_time({app="bar"}[5m])`,
+			exp: &binOpExpr{
+				op: OpTypeOr,
+				opts: BinOpOptions{
+					VectorMatching: &VectorMatching{Card: CardManyToMany, On: true},
+				},
+				SampleExpr: &rangeAggregationExpr{
+					operation: OpRangeTypeCount,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+						interval: 5 * time.Minute,
+					},
+				},
+				RHS: &rangeAggregationExpr{
+					operation: OpRangeTypeCount,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "bar")}},
+						interval: 5 * time.Minute,
+					},
+				},
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m]) and on(app) group_left count_over_time({app="bar"}[5m])`,
+			err: ParseError{
+				msg:  "no grouping allowed for and operation",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m]) / on(app) group_left(app) count_over_time({app="bar"}[5m])`,
+			err: ParseError{
+				msg:  "label app must not occur in on and group clause at once",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `count_over_time({app="foo"}[5m]) * ignoring(app) 2`,
+			err: ParseError{
+				msg:  "vector matching is only allowed between two vectors in binary operation (*)",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `label_replace(rate({app="foo"}[5m]), "svc", "$1", "app", "(.*)-.*")`,
+			exp: mustNewLabelReplaceExpr(
+				&rangeAggregationExpr{
+					operation: OpRangeTypeRate,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+						interval: 5 * time.Minute,
+					},
+				},
+				"svc", "$1", "app", "(.*)-.*",
+			),
+		},
+		{
+			in: `absent_over_time({app="foo"} |= "error" [5m])`,
+			exp: &rangeAggregationExpr{
+				operation: OpRangeTypeAbsent,
+				left: &logRange{
+					left: &filterExpr{
+						left:  &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+						ty:    labels.MatchEqual,
+						match: "error",
+					},
+					interval: 5 * time.Minute,
+				},
+			},
+		},
+		{
+			in: `label_replace(rate({app="foo"}[5m]), "svc", "$1", "app", "(.*")`,
+			err: ParseError{
+				msg:  "invalid regex in label_replace: error parsing regexp: missing closing ): `^(?:(.*)$`",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `label_replace(rate({app="foo"}[5m]), "1svc", "$1", "app", "(.*)")`,
+			err: ParseError{
+				msg:  "invalid destination label name in label_replace: 1svc",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `label_replace(1, "svc", "$1", "app", "(.*)")`,
+			err: ParseError{
+				msg:  "unexpected literal for label_replace",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `max_over_time(rate({app="foo"} |= "error" [1m])[1h:1m])`,
+			exp: &subqueryAggregationExpr{
+				operation: OpRangeTypeMax,
+				subquery: &subqueryExpr{
+					left: &rangeAggregationExpr{
+						operation: OpRangeTypeRate,
+						left: &logRange{
+							left: &filterExpr{
+								left:  &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+								ty:    labels.MatchEqual,
+								match: "error",
+							},
+							interval: time.Minute,
+						},
+					},
+					interval: time.Hour,
+					step:     time.Minute,
+				},
+			},
+		},
+		{
+			in: `quantile_over_time(0.99, sum(rate({app="foo"}[1m]))[1h:5m] offset 1d)`,
+			exp: mustNewSubqueryAggregationExpr(
+				&subqueryExpr{
+					left: mustNewVectorAggregationExpr(
+						&rangeAggregationExpr{
+							operation: OpRangeTypeRate,
+							left: &logRange{
+								left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+								interval: time.Minute,
+							},
+						},
+						OpTypeSum, nil, nil,
+					),
+					interval: time.Hour,
+					step:     5 * time.Minute,
+					offset:   24 * time.Hour,
+				},
+				OpRangeTypeQuantile, newString("0.99"),
+			),
+		},
+		{
+			in: `rate(rate({app="foo"}[1m])[1h:1m])`,
+			err: ParseError{
+				msg:  "invalid aggregation rate over a subquery",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `max_over_time(1[1h:1m])`,
+			err: ParseError{
+				msg:  "unexpected literal for subquery",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `max_over_time(rate({app="foo"}[1m])[1h:])`,
+			err: ParseError{
+				msg:  "missing step in subquery range",
+				line: 0,
+				col:  36,
+			},
+		},
+		{
+			in: `max_over_time({app="foo"}[1h:1m])`,
+			err: ParseError{
+				msg:  "syntax error: unexpected SUBQUERY_RANGE",
+				line: 0,
+				col:  26,
+			},
+		},
+		{
+			in: `count_values("value", rate({app="foo"}[5m])) by (app)`,
+			exp: mustNewLabelParamVectorAggregationExpr(
+				&rangeAggregationExpr{
+					operation: OpRangeTypeRate,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+						interval: 5 * time.Minute,
+					},
+				},
+				OpTypeCountValues, &grouping{groups: []string{"app"}}, "value",
+			),
+		},
+		{
+			in: `sort_desc(last_over_time({app="foo"} | unwrap depth [5m]))`,
+			exp: mustNewVectorAggregationExpr(
+				&rangeAggregationExpr{
+					operation: OpRangeTypeLast,
+					left: &logRange{
+						left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "app", "foo")}},
+						interval: 5 * time.Minute,
+						unwrap:   &unwrapExpr{identifier: "depth"},
+					},
+				},
+				OpTypeSortDesc, nil, nil,
+			),
+		},
+		{
+			in: `count_values(5, rate({app="foo"}[5m]))`,
+			err: ParseError{
+				msg:  "label name parameter required for operation count_values",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `count_values("1value", rate({app="foo"}[5m]))`,
+			err: ParseError{
+				msg:  "invalid label name in count_values: 1value",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `topk("value", rate({app="foo"}[5m]))`,
+			err: ParseError{
+				msg:  `unsupported parameter for operation topk("value",`,
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `sort by (app) (rate({app="foo"}[5m]))`,
+			err: ParseError{
+				msg:  "grouping not allowed for operation sort",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `first_over_time({app="foo"}[5m])`,
+			err: ParseError{
+				msg:  "invalid aggregation first_over_time without unwrap",
+				line: 0,
+				col:  0,
+			},
+		},
+		{
+			in: `{app="foo"} | xml`,
+			err: ParseError{
+				msg:  "syntax error: unexpected $end",
+				line: 1,
+				col:  18,
+			},
+		},
 		{
 			// cannot lead with bool modifier
 			in: `bool 1 > 1 > bool 1`,
@@ -1171,6 +1825,69 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseDownstream(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		exp Expr
+		err error
+	}{
+		{
+			in: `sum(rate({ foo !~ "bar" }[1.5h]))`,
+			exp: mustNewVectorAggregationExpr(&rangeAggregationExpr{
+				left: &logRange{
+					left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchNotRegexp, "foo", "bar")}},
+					interval: 90 * time.Minute,
+				},
+				operation: "rate",
+			}, "sum", nil, nil),
+		},
+		{
+			in: `count_over_time({ foo !~ "bar" }[3600000000001ns])`,
+			exp: &rangeAggregationExpr{
+				left: &logRange{
+					left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchNotRegexp, "foo", "bar")}},
+					interval: time.Hour + time.Nanosecond,
+				},
+				operation: "count_over_time",
+			},
+		},
+		{
+			in: `rate({ foo !~ "bar" }[-1h])`,
+			err: ParseError{
+				msg:  `not a valid duration string: "-1h"`,
+				line: 0,
+				col:  22,
+			},
+		},
+		{
+			in: `sum by () (count_over_time({ foo !~ "bar" }[5h]))`,
+			exp: mustNewVectorAggregationExpr(&rangeAggregationExpr{
+				left: &logRange{
+					left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchNotRegexp, "foo", "bar")}},
+					interval: 5 * time.Hour,
+				},
+				operation: "count_over_time",
+			}, "sum", &grouping{without: false}, nil),
+		},
+		{
+			in: `sum without () (count_over_time({ foo !~ "bar" }[5h]))`,
+			exp: mustNewVectorAggregationExpr(&rangeAggregationExpr{
+				left: &logRange{
+					left:     &matchersExpr{matchers: []*labels.Matcher{mustNewMatcher(labels.MatchNotRegexp, "foo", "bar")}},
+					interval: 5 * time.Hour,
+				},
+				operation: "count_over_time",
+			}, "sum", &grouping{without: true}, nil),
+		},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			ast, err := ParseExprWithOptions(tc.in, ParseOptions{Downstream: true})
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.exp, ast)
+		})
+	}
+}
+
 func TestParseMatchers(t *testing.T) {
 
 	tests := []struct {
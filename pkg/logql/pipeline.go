@@ -0,0 +1,272 @@
+package logql
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/loki/pkg/iter"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// ErrorLabel is the name of the label added to entries that failed to be processed by a Stage.
+const ErrorLabel = "__error__"
+
+// Stage is a single step of a Pipeline. It receives a log line and the labels of the entry,
+// which it can modify, and returns the resulting line and whether the entry should be kept.
+type Stage interface {
+	Process(line []byte, lbs *LabelsBuilder) ([]byte, bool)
+}
+
+// StageFunc is a syntax sugar for creating a Stage from a function.
+type StageFunc func(line []byte, lbs *LabelsBuilder) ([]byte, bool)
+
+// Process implements Stage.
+func (f StageFunc) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	return f(line, lbs)
+}
+
+// Pipeline is a list of stages applied in order to each log entry.
+// A nil Pipeline keeps all entries untouched.
+type Pipeline []Stage
+
+// Process runs all stages of the pipeline on a line, stopping at the first stage which drops the entry.
+func (p Pipeline) Process(line []byte, lbs *LabelsBuilder) ([]byte, bool) {
+	var ok bool
+	for _, s := range p {
+		line, ok = s.Process(line, lbs)
+		if !ok {
+			return nil, false
+		}
+	}
+	return line, true
+}
+
+// mergedStage is implemented by stages which must process the entries of all streams merged in
+// the query direction, such as `distinct`. They can't be applied independently by each source of entries.
+type mergedStage interface {
+	Stage
+	merged()
+}
+
+// Split splits the pipeline before its first merged stage. The source part is applied by the ingesters
+// and the store to the entries they return, the merged part by the querier once entries of all sources
+// have been merged.
+func (p Pipeline) Split() (source, merged Pipeline) {
+	for i, s := range p {
+		if _, ok := s.(mergedStage); ok {
+			return p[:i], p[i:]
+		}
+	}
+	return p, nil
+}
+
+// newLineFilterStage creates a Stage dropping lines not matching the filter.
+func newLineFilterStage(f LineFilter) Stage {
+	return StageFunc(func(line []byte, _ *LabelsBuilder) ([]byte, bool) {
+		return line, f.Filter(line)
+	})
+}
+
+// LabelsBuilder is the labels builder used by pipeline stages.
+// It is reset with the stream labels for every entry and tracks labels added or removed by each stage.
+// The structured metadata of the entry can be read like labels but isn't part of the resulting labels.
+type LabelsBuilder struct {
+	base     labels.Labels
+	metadata []logproto.LabelPair
+	del      []string
+	add      labels.Labels
+	err      string
+}
+
+// NewLabelsBuilder creates a new LabelsBuilder.
+func NewLabelsBuilder() *LabelsBuilder {
+	return &LabelsBuilder{
+		del: make([]string, 0, 5),
+		add: make(labels.Labels, 0, 16),
+	}
+}
+
+// Reset clears all changes and sets the base labels.
+func (b *LabelsBuilder) Reset(base labels.Labels) {
+	b.base = base
+	b.metadata = nil
+	b.del = b.del[:0]
+	b.add = b.add[:0]
+	// entries already processed by a source pipeline carry their error as a label.
+	b.err = base.Get(ErrorLabel)
+}
+
+// SetMetadata sets the structured metadata of the current entry.
+func (b *LabelsBuilder) SetMetadata(metadata []logproto.LabelPair) *LabelsBuilder {
+	b.metadata = metadata
+	return b
+}
+
+// BaseHas returns true if the original labels of the stream contain the label name.
+func (b *LabelsBuilder) BaseHas(name string) bool {
+	return b.base.Has(name)
+}
+
+// Get returns the current value of a label, or else of the structured metadata of the entry.
+func (b *LabelsBuilder) Get(name string) (string, bool) {
+	if name == ErrorLabel {
+		return b.err, b.err != ""
+	}
+	for _, a := range b.add {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	for _, d := range b.del {
+		if d == name {
+			return "", false
+		}
+	}
+	for _, m := range b.metadata {
+		if m.Name == name {
+			return m.Value, true
+		}
+	}
+	for _, l := range b.base {
+		if l.Name == name {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+// Del deletes the labels of the given names.
+func (b *LabelsBuilder) Del(ns ...string) *LabelsBuilder {
+	for _, n := range ns {
+		for i, a := range b.add {
+			if a.Name == n {
+				b.add = append(b.add[:i], b.add[i+1:]...)
+				break
+			}
+		}
+		b.del = append(b.del, n)
+	}
+	return b
+}
+
+// Set the name/value pair as a label.
+func (b *LabelsBuilder) Set(n, v string) *LabelsBuilder {
+	for i, a := range b.add {
+		if a.Name == n {
+			b.add[i].Value = v
+			return b
+		}
+	}
+	b.add = append(b.add, labels.Label{Name: n, Value: v})
+	return b
+}
+
+// SetErr sets the error of the current entry. It is reported as the `__error__` label.
+func (b *LabelsBuilder) SetErr(err string) *LabelsBuilder {
+	b.err = err
+	return b
+}
+
+// HasErr returns true if a stage has failed processing the current entry.
+func (b *LabelsBuilder) HasErr() bool {
+	return b.err != ""
+}
+
+// Modified returns true if the labels differ from the base labels.
+func (b *LabelsBuilder) Modified() bool {
+	return len(b.del) > 0 || len(b.add) > 0 || b.err != ""
+}
+
+// Labels returns the resulting labels.
+func (b *LabelsBuilder) Labels() labels.Labels {
+	if !b.Modified() {
+		return b.base
+	}
+	res := make(labels.Labels, 0, len(b.base)+len(b.add)+1)
+Outer:
+	for _, l := range b.base {
+		if l.Name == ErrorLabel {
+			continue
+		}
+		for _, n := range b.del {
+			if l.Name == n {
+				continue Outer
+			}
+		}
+		for _, la := range b.add {
+			if l.Name == la.Name {
+				continue Outer
+			}
+		}
+		res = append(res, l)
+	}
+	res = append(res, b.add...)
+	if b.err != "" {
+		res = append(res, labels.Label{Name: ErrorLabel, Value: b.err})
+	}
+	sort.Sort(res)
+	return res
+}
+
+type pipelineIterator struct {
+	iter.EntryIterator
+	pipeline Pipeline
+	builder  *LabelsBuilder
+	streams  map[string]labels.Labels
+
+	cur       logproto.Entry
+	curLabels string
+}
+
+// NewPipelineIterator returns an iterator which applies a Pipeline to every entry of the underlying iterator.
+// Entries dropped by the pipeline are skipped and labels are replaced by the ones computed by the pipeline.
+func NewPipelineIterator(it iter.EntryIterator, p Pipeline) iter.EntryIterator {
+	if len(p) == 0 {
+		return it
+	}
+	return &pipelineIterator{
+		EntryIterator: it,
+		pipeline:      p,
+		builder:       NewLabelsBuilder(),
+		streams:       map[string]labels.Labels{},
+	}
+}
+
+func (p *pipelineIterator) Next() bool {
+	for p.EntryIterator.Next() {
+		lbs := p.EntryIterator.Labels()
+		base, ok := p.streams[lbs]
+		if !ok {
+			var err error
+			base, err = parser.ParseMetric(lbs)
+			if err != nil {
+				continue
+			}
+			p.streams[lbs] = base
+		}
+		p.builder.Reset(base)
+		entry := p.EntryIterator.Entry()
+		p.builder.SetMetadata(entry.StructuredMetadata)
+		line, ok := p.pipeline.Process([]byte(entry.Line), p.builder)
+		if !ok {
+			continue
+		}
+		p.cur = logproto.Entry{Timestamp: entry.Timestamp, Line: string(line), StructuredMetadata: entry.StructuredMetadata}
+		p.curLabels = lbs
+		if p.builder.Modified() {
+			p.curLabels = p.builder.Labels().String()
+		}
+		return true
+	}
+	return false
+}
+
+func (p *pipelineIterator) Entry() logproto.Entry {
+	return p.cur
+}
+
+func (p *pipelineIterator) Labels() string {
+	return p.curLabels
+}
@@ -0,0 +1,396 @@
+package logql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+const (
+	// maxLineLength is the length above which an expression is split over multiple lines.
+	maxLineLength = 100
+	prettyIndent  = "  "
+)
+
+// FormatQuery parses a query and returns its pretty-printed form.
+func FormatQuery(query string) (string, error) {
+	expr, err := ParseExpr(query)
+	if err != nil {
+		return "", err
+	}
+	return Prettify(expr), nil
+}
+
+// Prettify returns a readable, multi-line form of an expression. Expressions fitting in a line are kept
+// on a single line, the others are split with their arguments and pipeline stages indented.
+// Parsing the result yields an expression equal to the original one.
+func Prettify(e Expr) string {
+	return prettify(e, 0)
+}
+
+func prettify(e Expr, level int) string {
+	indent := strings.Repeat(prettyIndent, level)
+	flat := formatFlat(e)
+	if len(indent)+len(flat) <= maxLineLength {
+		return indent + flat
+	}
+	switch e := e.(type) {
+	case LogSelectorExpr:
+		if _, ok := e.(*literalExpr); !ok {
+			return prettifyLogSelector(e, level)
+		}
+	case *rangeAggregationExpr:
+		args := []string{}
+		if e.params != nil {
+			args = append(args, indent+prettyIndent+strconv.FormatFloat(*e.params, 'f', -1, 64))
+		}
+		args = append(args, prettifyLogRange(e.left, level+1))
+		return indent + e.operation + "(\n" + strings.Join(args, ",\n") + "\n" + indent + ")"
+	case *subqueryAggregationExpr:
+		args := []string{}
+		if e.params != nil {
+			args = append(args, indent+prettyIndent+strconv.FormatFloat(*e.params, 'f', -1, 64))
+		}
+		args = append(args, prettifySubquery(e.subquery, level+1))
+		return indent + e.operation + "(\n" + strings.Join(args, ",\n") + "\n" + indent + ")"
+	case *vectorAggregationExpr:
+		return prettifyVectorAggregation(e, level)
+	case *labelReplaceExpr:
+		args := []string{
+			prettify(e.left, level+1),
+			indent + prettyIndent + strconv.Quote(e.dst),
+			indent + prettyIndent + strconv.Quote(e.replacement),
+			indent + prettyIndent + strconv.Quote(e.src),
+			indent + prettyIndent + strconv.Quote(e.regex),
+		}
+		return indent + OpLabelReplace + "(\n" + strings.Join(args, ",\n") + "\n" + indent + ")"
+	case *binOpExpr:
+		return prettifyBinOpLeg(e, e.SampleExpr, false, level) + "\n" +
+			indent + formatBinOpOperator(e) + "\n" +
+			prettifyBinOpLeg(e, e.RHS, true, level)
+	}
+	return indent + flat
+}
+
+// prettifyLogSelector writes the stream selector on the first line and each pipeline stage on its own line.
+func prettifyLogSelector(e LogSelectorExpr, level int) string {
+	indent := strings.Repeat(prettyIndent, level)
+	selector, stages := splitLogSelector(e)
+	var sb strings.Builder
+	sb.WriteString(indent)
+	sb.WriteString(selector)
+	for _, s := range stages {
+		sb.WriteString("\n")
+		sb.WriteString(indent)
+		sb.WriteString(prettyIndent)
+		sb.WriteString(s)
+	}
+	return sb.String()
+}
+
+func prettifyLogRange(r *logRange, level int) string {
+	indent := strings.Repeat(prettyIndent, level)
+	flat := formatLogRange(r)
+	if len(indent)+len(flat) <= maxLineLength {
+		return indent + flat
+	}
+	var sb strings.Builder
+	sb.WriteString(prettifyLogSelector(r.left, level))
+	if r.unwrap != nil {
+		sb.WriteString("\n")
+		sb.WriteString(indent + prettyIndent)
+		sb.WriteString(formatUnwrap(r.unwrap))
+	}
+	sb.WriteString("\n")
+	sb.WriteString(indent + prettyIndent)
+	sb.WriteString(formatRange(r.interval, r.offset))
+	return sb.String()
+}
+
+func prettifySubquery(s *subqueryExpr, level int) string {
+	indent := strings.Repeat(prettyIndent, level)
+	flat := formatSubquery(s)
+	if len(indent)+len(flat) <= maxLineLength {
+		return indent + flat
+	}
+	if _, ok := s.left.(*binOpExpr); ok {
+		return indent + "(\n" + prettify(s.left, level+1) + "\n" + indent + ")" + formatSubqueryRange(s)
+	}
+	return prettify(s.left, level) + "\n" + indent + prettyIndent + formatSubqueryRange(s)
+}
+
+func prettifyVectorAggregation(e *vectorAggregationExpr, level int) string {
+	indent := strings.Repeat(prettyIndent, level)
+	var sb strings.Builder
+	sb.WriteString(indent)
+	sb.WriteString(e.operation)
+	switch {
+	case e.params != 0:
+		// the grammar only accepts the grouping after the arguments of aggregations with a number parameter.
+		sb.WriteString("(\n")
+		sb.WriteString(indent + prettyIndent + strconv.Itoa(e.params) + ",\n")
+		sb.WriteString(prettify(e.left, level+1))
+		sb.WriteString("\n" + indent + ")")
+		if g := formatGrouping(e.grouping); g != "" {
+			sb.WriteString(" " + g)
+		}
+		return sb.String()
+	case e.operation == OpTypeCountValues:
+		if g := formatGrouping(e.grouping); g != "" {
+			sb.WriteString(" " + g + " ")
+		}
+		sb.WriteString("(\n")
+		sb.WriteString(indent + prettyIndent + strconv.Quote(e.label) + ",\n")
+	default:
+		if g := formatGrouping(e.grouping); g != "" {
+			sb.WriteString(" " + g + " ")
+		}
+		sb.WriteString("(\n")
+	}
+	sb.WriteString(prettify(e.left, level+1))
+	sb.WriteString("\n" + indent + ")")
+	return sb.String()
+}
+
+func prettifyBinOpLeg(parent *binOpExpr, leg SampleExpr, right bool, level int) string {
+	if !binOpLegNeedsParens(parent, leg, right) {
+		return prettify(leg, level)
+	}
+	indent := strings.Repeat(prettyIndent, level)
+	flat := "(" + formatFlat(leg) + ")"
+	if len(indent)+len(flat) <= maxLineLength {
+		return indent + flat
+	}
+	return indent + "(\n" + prettify(leg, level+1) + "\n" + indent + ")"
+}
+
+// formatFlat returns the single line form of an expression.
+func formatFlat(e Expr) string {
+	switch e := e.(type) {
+	case *literalExpr:
+		return strconv.FormatFloat(e.value, 'f', -1, 64)
+	case LogSelectorExpr:
+		selector, stages := splitLogSelector(e)
+		if len(stages) == 0 {
+			return selector
+		}
+		return selector + " " + strings.Join(stages, " ")
+	case *rangeAggregationExpr:
+		if e.params != nil {
+			return e.operation + "(" + strconv.FormatFloat(*e.params, 'f', -1, 64) + ", " + formatLogRange(e.left) + ")"
+		}
+		return e.operation + "(" + formatLogRange(e.left) + ")"
+	case *subqueryAggregationExpr:
+		if e.params != nil {
+			return e.operation + "(" + strconv.FormatFloat(*e.params, 'f', -1, 64) + ", " + formatSubquery(e.subquery) + ")"
+		}
+		return e.operation + "(" + formatSubquery(e.subquery) + ")"
+	case *vectorAggregationExpr:
+		g := formatGrouping(e.grouping)
+		switch {
+		case e.params != 0:
+			s := fmt.Sprintf("%s(%d, %s)", e.operation, e.params, formatFlat(e.left))
+			if g != "" {
+				s += " " + g
+			}
+			return s
+		case e.operation == OpTypeCountValues:
+			if g != "" {
+				g = " " + g + " "
+			}
+			return fmt.Sprintf("%s%s(%s, %s)", e.operation, g, strconv.Quote(e.label), formatFlat(e.left))
+		default:
+			if g != "" {
+				g = " " + g + " "
+			}
+			return fmt.Sprintf("%s%s(%s)", e.operation, g, formatFlat(e.left))
+		}
+	case *labelReplaceExpr:
+		return fmt.Sprintf("%s(%s, %s, %s, %s, %s)", OpLabelReplace, formatFlat(e.left),
+			strconv.Quote(e.dst), strconv.Quote(e.replacement), strconv.Quote(e.src), strconv.Quote(e.regex))
+	case *binOpExpr:
+		lhs, rhs := formatFlat(e.SampleExpr), formatFlat(e.RHS)
+		if binOpLegNeedsParens(e, e.SampleExpr, false) {
+			lhs = "(" + lhs + ")"
+		}
+		if binOpLegNeedsParens(e, e.RHS, true) {
+			rhs = "(" + rhs + ")"
+		}
+		return lhs + " " + formatBinOpOperator(e) + " " + rhs
+	default:
+		return e.String()
+	}
+}
+
+// splitLogSelector returns the stream selector and the pipeline stages of a log selector, in order.
+func splitLogSelector(e LogSelectorExpr) (string, []string) {
+	var stages []string
+	for {
+		var stage string
+		switch s := e.(type) {
+		case *filterExpr:
+			stage, e = formatLineFilter(s), s.left
+		case *labelParserExpr:
+			stage, e = "| "+s.op, s.left
+			if s.param != "" {
+				stage += " " + strconv.Quote(s.param)
+			}
+		case *labelFilterExpr:
+			stage, e = "| "+s.filter.String(), s.left
+		case *lineFormatExpr:
+			stage, e = "| "+OpFmtLine+" "+strconv.Quote(s.template), s.left
+		case *labelFormatExpr:
+			fmts := make([]string, 0, len(s.formats))
+			for _, f := range s.formats {
+				fmts = append(fmts, f.String())
+			}
+			stage, e = "| "+OpFmtLabel+" "+strings.Join(fmts, ", "), s.left
+		case *decolorizeExpr:
+			stage, e = "| "+OpDecolorize, s.left
+		case *distinctExpr:
+			stage, e = "| "+OpDistinct+" "+s.label, s.left
+		case *matchersExpr:
+			ms := make([]string, 0, len(s.matchers))
+			for _, m := range s.matchers {
+				ms = append(ms, m.String())
+			}
+			// stages were collected from the last one.
+			for i, j := 0, len(stages)-1; i < j; i, j = i+1, j-1 {
+				stages[i], stages[j] = stages[j], stages[i]
+			}
+			return "{" + strings.Join(ms, ", ") + "}", stages
+		default:
+			return e.String(), nil
+		}
+		stages = append(stages, stage)
+	}
+}
+
+func formatLineFilter(e *filterExpr) string {
+	var op string
+	switch e.ty {
+	case labels.MatchRegexp:
+		op = "|~"
+	case labels.MatchNotRegexp:
+		op = "!~"
+	case labels.MatchEqual:
+		op = "|="
+	case labels.MatchNotEqual:
+		op = "!="
+	}
+	if e.fn != "" {
+		return op + " " + e.fn + "(" + strconv.Quote(e.match) + ")"
+	}
+	return op + " " + strconv.Quote(e.match)
+}
+
+func formatLogRange(r *logRange) string {
+	var sb strings.Builder
+	sb.WriteString(formatFlat(r.left))
+	if r.unwrap != nil {
+		sb.WriteString(" ")
+		sb.WriteString(formatUnwrap(r.unwrap))
+	}
+	if _, ok := r.left.(*matchersExpr); !ok || r.unwrap != nil {
+		sb.WriteString(" ")
+	}
+	sb.WriteString(formatRange(r.interval, r.offset))
+	return sb.String()
+}
+
+func formatUnwrap(u *unwrapExpr) string {
+	if u.operation != "" {
+		return fmt.Sprintf("| %s %s(%s)", OpUnwrap, u.operation, u.identifier)
+	}
+	return fmt.Sprintf("| %s %s", OpUnwrap, u.identifier)
+}
+
+func formatRange(interval, offset time.Duration) string {
+	s := fmt.Sprintf("[%s]", formatDuration(interval))
+	if offset != 0 {
+		s += fmt.Sprintf(" %s %s", OpOffset, formatDuration(offset))
+	}
+	return s
+}
+
+func formatSubquery(s *subqueryExpr) string {
+	left := formatFlat(s.left)
+	if _, ok := s.left.(*binOpExpr); ok {
+		left = "(" + left + ")"
+	}
+	return left + formatSubqueryRange(s)
+}
+
+func formatSubqueryRange(s *subqueryExpr) string {
+	r := fmt.Sprintf("[%v:%v]", model.Duration(s.interval), model.Duration(s.step))
+	if s.offset != 0 {
+		r += fmt.Sprintf(" %s %v", OpOffset, model.Duration(s.offset))
+	}
+	return r
+}
+
+func formatGrouping(g *grouping) string {
+	if g == nil {
+		return ""
+	}
+	switch {
+	case g.without:
+		return "without (" + strings.Join(g.groups, ", ") + ")"
+	case g.groups != nil:
+		return "by (" + strings.Join(g.groups, ", ") + ")"
+	default:
+		return ""
+	}
+}
+
+func formatBinOpOperator(e *binOpExpr) string {
+	op := e.op
+	if e.opts.ReturnBool {
+		op += " bool"
+	}
+	if e.opts.VectorMatching != nil {
+		op += " " + e.opts.VectorMatching.String()
+	}
+	return op
+}
+
+// binOpLegNeedsParens returns true if a leg of a binary operation must be parenthesized to keep
+// the precedence of the parsed expression.
+func binOpLegNeedsParens(parent *binOpExpr, leg SampleExpr, right bool) bool {
+	child, ok := leg.(*binOpExpr)
+	if !ok {
+		return false
+	}
+	pp, cp := binOpPrecedence(parent.op), binOpPrecedence(child.op)
+	if cp != pp {
+		return cp < pp
+	}
+	// all operators are left associative, except `^`.
+	if parent.op == OpTypePow {
+		return !right
+	}
+	return right
+}
+
+func binOpPrecedence(op string) int {
+	switch op {
+	case OpTypeOr:
+		return 1
+	case OpTypeAnd, OpTypeUnless:
+		return 2
+	case OpTypeCmpEQ, OpTypeNEQ, OpTypeGT, OpTypeGTE, OpTypeLT, OpTypeLTE:
+		return 3
+	case OpTypeAdd, OpTypeSub:
+		return 4
+	case OpTypeMul, OpTypeDiv, OpTypeMod:
+		return 5
+	case OpTypePow:
+		return 6
+	default:
+		return 0
+	}
+}
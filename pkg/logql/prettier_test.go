@@ -0,0 +1,113 @@
+package logql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrettify(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		out string
+	}{
+		{
+			`{app="foo",env=~"prod|dev"}|="error"!~"timeout"`,
+			`{app="foo", env=~"prod|dev"} |= "error" !~ "timeout"`,
+		},
+		{
+			`sum by(app)(rate({app="foo"}[5m]))/sum by(app)(rate({app="foo"}|logfmt|level="error"[5m]))`,
+			`sum by (app) (rate({app="foo"}[5m]))
+/
+sum by (app) (rate({app="foo"} | logfmt | level="error" [5m]))`,
+		},
+		{
+			`(1 + rate({app="foo"}[5m])) * 2 ^ 3 ^ 2`,
+			`(1 + rate({app="foo"}[5m])) * 512`,
+		},
+		{
+			`topk(3, sum(count_over_time({app="foo"}[1m])) by (path))`,
+			`topk(3, sum by (path) (count_over_time({app="foo"}[1m])))`,
+		},
+		{
+			`{job="nginx"} | pattern "<ip> - <_> [<_>] \"<method> <path> <_>\" <status> <_>" | status >= 500 | line_format "{{.method}} {{.path}}" | label_format code=status`,
+			`{job="nginx"}
+  | pattern "<ip> - <_> [<_>] \"<method> <path> <_>\" <status> <_>"
+  | status>=500
+  | line_format "{{.method}} {{.path}}"
+  | label_format code=status`,
+		},
+		{
+			`sum by (namespace, app) (rate({namespace="prod", app=~"api|web"} |= "error" | json | level="error" | status >= 500 | duration > 10s [5m])) / ignoring(level) group_left sum by (namespace, app) (rate({namespace="prod", app=~"api|web"}[5m]))`,
+			`sum by (namespace, app) (
+  rate(
+    {namespace="prod", app=~"api|web"}
+      |= "error"
+      | json
+      | level="error"
+      | status>=500
+      | duration>10s
+      [5m]
+  )
+)
+/ ignoring(level) group_left()
+sum by (namespace, app) (rate({namespace="prod", app=~"api|web"}[5m]))`,
+		},
+		{
+			`quantile_over_time(0.99, {cluster="us-central1", namespace="loki-prod", container="query-frontend"} | logfmt | unwrap duration(latency) [5m] offset 1h)`,
+			`quantile_over_time(
+  0.99,
+  {cluster="us-central1", namespace="loki-prod", container="query-frontend"}
+    | logfmt
+    | unwrap duration(latency)
+    [5m] offset 1h
+)`,
+		},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			expr, err := ParseExpr(tc.in)
+			require.NoError(t, err)
+			require.Equal(t, tc.out, Prettify(expr))
+		})
+	}
+}
+
+func TestPrettify_RoundTrip(t *testing.T) {
+	for _, q := range []string{
+		`{app="foo"}`,
+		`{app="foo"} |= ip("10.0.0.0/8") != nocase("debug") |~ "a.+b" !~ "c"`,
+		`{app="foo"} | decolorize | unpack | logfmt | regexp "(?P<foo>\\w+)" | distinct foo`,
+		`{app="foo"} | json | (status >= 500 or status < 200) and duration > 1s and size < 2MB | label_format a=b,c="{{.d}}"`,
+		`count_over_time({app="foo"}[5m] offset 1h)`,
+		`avg_over_time({app="foo"} | logfmt | unwrap bytes(size) [5m])`,
+		`sum(rate({app="foo"}[5m])) by (a) - on(a) group_right(b) sum(rate({app="bar"}[5m])) by (a, b)`,
+		`rate({app="foo"}[5m]) > bool 10 or rate({app="bar"}[5m]) unless ignoring(x) rate({app="buzz"}[5m])`,
+		`(rate({app="foo"}[5m]) or rate({app="bar"}[5m])) and rate({app="buzz"}[5m])`,
+		`rate({app="foo"}[5m]) - (rate({app="bar"}[5m]) - rate({app="buzz"}[5m]))`,
+		`(rate({app="foo"}[5m]) ^ rate({app="bar"}[5m])) ^ rate({app="buzz"}[5m])`,
+		`rate({app="foo"}[5m]) ^ rate({app="bar"}[5m]) ^ rate({app="buzz"}[5m])`,
+		`rate({app="foo"}[5m]) * -1`,
+		`label_replace(rate({app="foo"}[5m]), "dst", "$1", "src", "(.*)")`,
+		`max_over_time((sum(rate({app="foo"}[1m])) / sum(rate({app="bar"}[1m])))[1h:1m] offset 1d)`,
+		`quantile_over_time(0.9, sum by (a) (rate({app="foo"}[1m]))[1h:5m])`,
+		`count_values("value", sum(rate({app="foo"}[1m])) by (a)) by (a)`,
+		`sort_desc(bottomk(2, sum without (a) (rate({app="foo"}[1m]))))`,
+		`absent_over_time({app="foo"}[5m])`,
+		`sum by (namespace, app, pod, container) (count_over_time({namespace="prod", app=~"api|web|worker", container!="istio-proxy"} |= "error" != "timeout" | logfmt | level=~"error|critical" [5m])) / on(namespace, app) group_left(team) sum by (namespace, app, team) (count_over_time({namespace="prod", app=~"api|web|worker"}[5m]))`,
+		`max_over_time((sum by (namespace, app) (rate({namespace="prod", app=~"api|web|worker"} |= "error" | logfmt [5m])) / sum by (namespace, app) (rate({namespace="prod", app=~"api|web|worker"}[5m])))[1h:1m])`,
+		`label_replace(topk(10, sum by (namespace, app, pod) (rate({namespace="prod", app=~"api|web|worker"} |= "error" | json [5m]))), "service", "$1", "app", "(.*)")`,
+		`count_values by (app) ("level", sum by (app, namespace, cluster, pod, container) (count_over_time({namespace="prod", app=~"api|web|worker"}[5m])))`,
+	} {
+		t.Run(q, func(t *testing.T) {
+			expr, err := ParseExpr(q)
+			require.NoError(t, err)
+
+			pretty := Prettify(expr)
+			parsed, err := ParseExpr(pretty)
+			require.NoError(t, err, pretty)
+			require.Equal(t, expr, parsed, pretty)
+			// formatting is idempotent.
+			require.Equal(t, pretty, Prettify(parsed))
+		})
+	}
+}
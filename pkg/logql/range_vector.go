@@ -22,15 +22,15 @@ type RangeVectorIterator interface {
 }
 
 type rangeVectorIterator struct {
-	iter                         SeriesIterator
-	selRange, step, end, current int64
-	window                       map[string]*promql.Series
-	metrics                      map[string]labels.Labels
+	iter                                 SeriesIterator
+	selRange, step, end, current, offset int64
+	window                               map[string]*promql.Series
+	metrics                              map[string]labels.Labels
 }
 
 func newRangeVectorIterator(
 	it SeriesIterator,
-	selRange, step, start, end int64) *rangeVectorIterator {
+	selRange, step, start, end, offset int64) *rangeVectorIterator {
 	// forces at least one step.
 	if step == 0 {
 		step = 1
@@ -40,6 +40,7 @@ func newRangeVectorIterator(
 		step:     step,
 		end:      end,
 		selRange: selRange,
+		offset:   offset,
 		current:  start - step, // first loop iteration will set it to start
 		window:   map[string]*promql.Series{},
 		metrics:  map[string]labels.Labels{},
@@ -52,8 +53,9 @@ func (r *rangeVectorIterator) Next() bool {
 	if r.current > r.end {
 		return false
 	}
-	rangeEnd := r.current
-	rangeStart := r.current - r.selRange
+	// the window is shifted back by the offset but samples are still reported at the current step.
+	rangeEnd := r.current - r.offset
+	rangeStart := rangeEnd - r.selRange
 	// load samples
 	r.popBack(rangeStart)
 	r.load(rangeStart, rangeEnd)
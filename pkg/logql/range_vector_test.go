@@ -62,6 +62,7 @@ func Test_RangeVectorIterator(t *testing.T) {
 		expectedVectors []promql.Vector
 		expectedTs      []time.Time
 		start, end      time.Time
+		offset          int64
 	}{
 		{
 			(5 * time.Second).Nanoseconds(), // no overlap
@@ -82,7 +83,7 @@ func Test_RangeVectorIterator(t *testing.T) {
 				},
 			},
 			[]time.Time{time.Unix(10, 0), time.Unix(40, 0), time.Unix(70, 0), time.Unix(100, 0)},
-			time.Unix(10, 0), time.Unix(100, 0),
+			time.Unix(10, 0), time.Unix(100, 0), 0,
 		},
 		{
 			(35 * time.Second).Nanoseconds(), // will overlap by 5 sec
@@ -106,7 +107,7 @@ func Test_RangeVectorIterator(t *testing.T) {
 				},
 			},
 			[]time.Time{time.Unix(10, 0), time.Unix(40, 0), time.Unix(70, 0), time.Unix(100, 0)},
-			time.Unix(10, 0), time.Unix(100, 0),
+			time.Unix(10, 0), time.Unix(100, 0), 0,
 		},
 		{
 			(30 * time.Second).Nanoseconds(), // same range
@@ -127,7 +128,7 @@ func Test_RangeVectorIterator(t *testing.T) {
 				},
 			},
 			[]time.Time{time.Unix(10, 0), time.Unix(40, 0), time.Unix(70, 0), time.Unix(100, 0)},
-			time.Unix(10, 0), time.Unix(100, 0),
+			time.Unix(10, 0), time.Unix(100, 0), 0,
 		},
 		{
 			(50 * time.Second).Nanoseconds(), // all step are overlapping
@@ -143,7 +144,28 @@ func Test_RangeVectorIterator(t *testing.T) {
 				},
 			},
 			[]time.Time{time.Unix(110, 0), time.Unix(120, 0)},
-			time.Unix(110, 0), time.Unix(120, 0),
+			time.Unix(110, 0), time.Unix(120, 0), 0,
+		},
+		{
+			(5 * time.Second).Nanoseconds(), // no overlap with an offset
+			(30 * time.Second).Nanoseconds(),
+			[]promql.Vector{
+				[]promql.Sample{
+					{Point: newPoint(time.Unix(70, 0), 2), Metric: labelBar},
+					{Point: newPoint(time.Unix(70, 0), 2), Metric: labelFoo},
+				},
+				[]promql.Sample{
+					{Point: newPoint(time.Unix(100, 0), 2), Metric: labelBar},
+					{Point: newPoint(time.Unix(100, 0), 2), Metric: labelFoo},
+				},
+				{},
+				[]promql.Sample{
+					{Point: newPoint(time.Unix(160, 0), 1), Metric: labelBar},
+					{Point: newPoint(time.Unix(160, 0), 1), Metric: labelFoo},
+				},
+			},
+			[]time.Time{time.Unix(70, 0), time.Unix(100, 0), time.Unix(130, 0), time.Unix(160, 0)},
+			time.Unix(70, 0), time.Unix(160, 0), (time.Minute).Nanoseconds(),
 		},
 	}
 
@@ -152,7 +174,7 @@ func Test_RangeVectorIterator(t *testing.T) {
 			fmt.Sprintf("logs[%s] - step: %s", time.Duration(tt.selRange), time.Duration(tt.step)),
 			func(t *testing.T) {
 				it := newRangeVectorIterator(newfakeSeriesIterator(), tt.selRange,
-					tt.step, tt.start.UnixNano(), tt.end.UnixNano())
+					tt.step, tt.start.UnixNano(), tt.end.UnixNano(), tt.offset)
 
 				i := 0
 				for it.Next() {
@@ -1,10 +1,20 @@
 package logql
 
 import (
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
 	"github.com/grafana/loki/pkg/iter"
 	"github.com/grafana/loki/pkg/logproto"
 )
 
+// errSampleExtraction is the value of the `__error__` label when a label value can't be converted to a sample value.
+const errSampleExtraction = "SampleExtractionErr"
+
 var (
 	extractBytes = bytesSampleExtractor{}
 	extractCount = countSampleExtractor{}
@@ -97,3 +107,80 @@ func (bytesSampleExtractor) From(lbs string, entry logproto.Entry) (Sample, bool
 		Value:         float64(len(entry.Line)),
 	}, true
 }
+
+type labelSampleExtractor struct {
+	labelName  string
+	conversion string
+
+	// samples caches the labels and value of the samples by labels of the entries.
+	samples map[string]labelSample
+}
+
+type labelSample struct {
+	labels string
+	value  float64
+	ok     bool
+}
+
+// newLabelSampleExtractor creates a SampleExtractor using the value of a label as sample value.
+// The label is removed from the sample labels. Entries without the label are skipped, while entries
+// for which the value can't be converted produce a sample with the `__error__` label.
+func newLabelSampleExtractor(labelName, conversion string) SampleExtractor {
+	return &labelSampleExtractor{
+		labelName:  labelName,
+		conversion: conversion,
+		samples:    map[string]labelSample{},
+	}
+}
+
+func (l *labelSampleExtractor) From(lbs string, entry logproto.Entry) (Sample, bool) {
+	s, ok := l.samples[lbs]
+	if !ok {
+		s = l.sample(lbs)
+		l.samples[lbs] = s
+	}
+	if !s.ok {
+		return Sample{}, false
+	}
+	return Sample{
+		Labels:        s.labels,
+		TimestampNano: entry.Timestamp.UnixNano(),
+		Value:         s.value,
+	}, true
+}
+
+// sample returns the labels and value of the samples of the entries with the labels.
+func (l *labelSampleExtractor) sample(lbs string) labelSample {
+	ls, err := parser.ParseMetric(lbs)
+	if err != nil {
+		return labelSample{}
+	}
+	if !ls.Has(l.labelName) {
+		return labelSample{}
+	}
+	b := labels.NewBuilder(ls).Del(l.labelName)
+	v, err := l.convert(ls.Get(l.labelName))
+	if err != nil {
+		b.Set(ErrorLabel, errSampleExtraction)
+	}
+	return labelSample{labels: b.Labels().String(), value: v, ok: true}
+}
+
+func (l *labelSampleExtractor) convert(v string) (float64, error) {
+	switch l.conversion {
+	case OpConvDuration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, err
+		}
+		return d.Seconds(), nil
+	case OpConvBytes:
+		b, err := humanize.ParseBytes(v)
+		if err != nil {
+			return 0, err
+		}
+		return float64(b), nil
+	default:
+		return strconv.ParseFloat(v, 64)
+	}
+}
@@ -120,6 +120,59 @@ func Test_seriesIterator_Peek(t *testing.T) {
 				{false, Sample{}},
 			},
 		},
+		{
+			"unwrap conversion error",
+			newSeriesIterator(
+				iter.NewStreamIterator(logproto.Stream{
+					Labels: `{app="foo"}`,
+					Entries: []logproto.Entry{
+						{Timestamp: time.Unix(0, 0), Line: "0"},
+						{Timestamp: time.Unix(1, 0), Line: "1"},
+						{Timestamp: time.Unix(2, 0), Line: "2"},
+					},
+				}),
+				newLabelSampleExtractor("app", ""),
+			),
+			[]expectation{
+				{true, Sample{Labels: `{__error__="SampleExtractionErr"}`, TimestampNano: 0, Value: 0}},
+				{true, Sample{Labels: `{__error__="SampleExtractionErr"}`, TimestampNano: time.Unix(1, 0).UnixNano(), Value: 0}},
+				{true, Sample{Labels: `{__error__="SampleExtractionErr"}`, TimestampNano: time.Unix(2, 0).UnixNano(), Value: 0}},
+				{false, Sample{}},
+			},
+		},
+		{
+			"unwrap duration skip missing",
+			newSeriesIterator(
+				iter.NewStreamsIterator(context.Background(),
+					[]logproto.Stream{
+						{Labels: `{app="foo",latency="250ms"}`, Entries: []logproto.Entry{{Timestamp: time.Unix(0, 0)}}},
+						{Labels: `{app="foo"}`, Entries: []logproto.Entry{{Timestamp: time.Unix(1, 0)}}},
+						{Labels: `{app="foo",latency="1m"}`, Entries: []logproto.Entry{{Timestamp: time.Unix(2, 0)}}},
+					},
+					logproto.FORWARD,
+				),
+				newLabelSampleExtractor("latency", OpConvDuration),
+			),
+			[]expectation{
+				{true, Sample{Labels: `{app="foo"}`, TimestampNano: 0, Value: 0.25}},
+				{true, Sample{Labels: `{app="foo"}`, TimestampNano: time.Unix(2, 0).UnixNano(), Value: 60}},
+				{false, Sample{}},
+			},
+		},
+		{
+			"unwrap bytes",
+			newSeriesIterator(
+				iter.NewStreamIterator(logproto.Stream{
+					Labels:  `{app="foo",size="1.5KB"}`,
+					Entries: []logproto.Entry{{Timestamp: time.Unix(0, 0)}},
+				}),
+				newLabelSampleExtractor("size", OpConvBytes),
+			),
+			[]expectation{
+				{true, Sample{Labels: `{app="foo"}`, TimestampNano: 0, Value: 1500}},
+				{false, Sample{}},
+			},
+		},
 		{
 			"skip first",
 			newSeriesIterator(iter.NewStreamIterator(newStream(2, identity, `{app="foo"}`)), fakeSampler{}),
@@ -13,6 +13,7 @@ import (
 	"github.com/prometheus/prometheus/promql"
 
 	"github.com/grafana/loki/pkg/iter"
+	"github.com/grafana/loki/pkg/logql/explain"
 	"github.com/grafana/loki/pkg/logql/stats"
 )
 
@@ -48,13 +49,27 @@ func NewShardedEngine(opts EngineOpts, downstreamable Downstreamable, metrics *S
 
 // Query constructs a Query
 func (ng *ShardedEngine) Query(p Params, shards int) Query {
+	return ng.query(p, func() (ShardMapper, error) {
+		return NewShardMapper(shards, ng.metrics)
+	})
+}
+
+// InstantQuery constructs a Query for an instant query, splitting the ranges larger than splitRange
+// and sharding the expressions whose data is at least minShardingOffset older than the time of the query.
+func (ng *ShardedEngine) InstantQuery(p Params, shards int, splitRange, minShardingOffset time.Duration) Query {
+	return ng.query(p, func() (ShardMapper, error) {
+		return NewInstantShardMapper(shards, splitRange, minShardingOffset, ng.metrics)
+	})
+}
+
+func (ng *ShardedEngine) query(p Params, newMapper func() (ShardMapper, error)) Query {
 	return &query{
 		timeout:   ng.timeout,
 		params:    p,
 		evaluator: NewDownstreamEvaluator(ng.downstreamable.Downstreamer()),
 		parse: func(ctx context.Context, query string) (Expr, error) {
 			logger := spanlogger.FromContext(ctx)
-			mapper, err := NewShardMapper(shards, ng.metrics)
+			mapper, err := newMapper()
 			if err != nil {
 				return nil, err
 			}
@@ -65,6 +80,7 @@ func (ng *ShardedEngine) Query(p Params, shards int) Query {
 			}
 
 			level.Debug(logger).Log("no-op", noop, "mapped", parsed.String())
+			explain.FromContext(ctx).SetQuery(parsed.String())
 			return parsed, nil
 		},
 	}
@@ -77,6 +93,9 @@ type DownstreamSampleExpr struct {
 }
 
 func (d DownstreamSampleExpr) String() string {
+	if d.shard == nil {
+		return fmt.Sprintf("downstream<%s>", d.SampleExpr.String())
+	}
 	return fmt.Sprintf("downstream<%s, shard=%s>", d.SampleExpr.String(), d.shard)
 }
 
@@ -87,6 +106,9 @@ type DownstreamLogSelectorExpr struct {
 }
 
 func (d DownstreamLogSelectorExpr) String() string {
+	if d.shard == nil {
+		return fmt.Sprintf("downstream<%s>", d.LogSelectorExpr.String())
+	}
 	return fmt.Sprintf("downstream<%s, shard=%s>", d.LogSelectorExpr.String(), d.shard)
 }
 
@@ -318,10 +340,13 @@ func (ev *DownstreamEvaluator) Iterator(
 					"err", err,
 					"expr", queries[i].Expr.String(),
 				)
+				return nil, err
 			}
 			xs = append(xs, iter)
 		}
 
+		// each shard returns at most limit entries in the direction of the query,
+		// the engine reads the first limit entries of the merged shards.
 		return iter.NewHeapIterator(ctx, xs, params.Direction()), nil
 
 	default:
@@ -350,6 +375,14 @@ func ConcatEvaluator(evaluators []StepEvaluator) (StepEvaluator, error) {
 			}
 			return lastErr
 		},
+		func() error {
+			for _, eval := range evaluators {
+				if err := eval.Error(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
 	)
 }
 
@@ -370,7 +403,7 @@ func ResultStepEvaluator(res Result, params Params) (StepEvaluator, error) {
 				return true, start.UnixNano() / int64(time.Millisecond), data
 			}
 			return false, 0, nil
-		}, nil)
+		}, nil, nil)
 	case promql.Matrix:
 		return NewMatrixStepper(start, end, step, data), nil
 	default:
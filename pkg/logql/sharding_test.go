@@ -2,10 +2,13 @@ package logql
 
 import (
 	"context"
+	"fmt"
 	"math"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/stretchr/testify/require"
 
@@ -35,6 +38,9 @@ func TestMappingEquivalence(t *testing.T) {
 		{`1 + 1`, false},
 		{`{a="1"}`, false},
 		{`{a="1"} |= "number: 10"`, false},
+		{`{a="1"} | logfmt`, false},
+		{`{a=~".*"} | logfmt | b != "1" and index < 30`, false},
+		{`{a=~".*"} | label_format x=b | line_format "{{.x}} {{.index}}"`, false},
 		{`rate({a=~".*"}[1s])`, false},
 		{`sum by (a) (rate({a=~".*"}[1s]))`, false},
 		{`max without (a) (rate({a=~".*"}[1s]))`, false},
@@ -44,6 +50,25 @@ func TestMappingEquivalence(t *testing.T) {
 		{`sum(max(rate({a=~".*"}[1s])))`, false},
 		{`max(count(rate({a=~".*"}[1s])))`, false},
 		{`max(sum by (cluster) (rate({a=~".*"}[1s]))) / count(rate({a=~".*"}[1s]))`, false},
+		{`sum by (a, line) (rate({a=~".*"} | logfmt [1s]))`, false},
+		{`sum by (a) (rate({a=~".*"} | logfmt | b="1" or c="2" [1s]))`, false},
+		{`sum_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`avg_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`min_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`max_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`stddev_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`stdvar_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`quantile_over_time(0.99, {a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`sum by (a) (max_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s]))`, false},
+		{`first_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`last_over_time({a=~".*"} | regexp "line number: (?P<n>[0-9]+)" | unwrap n [2s])`, false},
+		{`absent_over_time({a="1"} |= "number: 3" [1s])`, false},
+		{`absent_over_time({a="nonexistent"}[1s])`, false},
+		{`count_over_time({a=~".*"} |= "number: 3" [1s])`, false},
+		{`sum by (a, b) (rate({a=~".*"} | logfmt [1s])) / ignoring(b) group_left sum by (a) (rate({a=~".*"}[1s]))`, false},
+		{`sum by (x) (label_replace(rate({a=~".*"}[1s]), "x", "a$1", "a", "(.*)"))`, false},
+		{`label_replace(sum by (a) (rate({a=~".*"}[1s])), "a", "", "a", "1")`, false},
+		{`max_over_time(sum by (a) (rate({a=~".*"}[1s]))[5s:1s])`, false},
 		// topk prefers already-seen values in tiebreakers. Since the test data generates
 		// the same log lines for each series & the resulting promql.Vectors aren't deterministically
 		// sorted by labels, we don't expect this to pass.
@@ -88,6 +113,87 @@ func TestMappingEquivalence(t *testing.T) {
 	}
 }
 
+func TestShardedLogQueries(t *testing.T) {
+	var (
+		shards  = 3
+		rounds  = 20
+		streams = randomStreams(60, rounds, shards, []string{"a", "b", "c", "d"})
+		start   = time.Unix(0, 0)
+		end     = time.Unix(0, int64(time.Second*time.Duration(rounds)))
+	)
+	regular := NewEngine(EngineOpts{}, NewMockQuerier(shards, streams))
+	sharded := NewShardedEngine(EngineOpts{}, MockDownstreamer{regular}, nilMetrics)
+
+	for _, query := range []string{`{a=~".*"}`, `{a=~".*"} |= "number: 1"`} {
+		for _, direction := range []logproto.Direction{logproto.FORWARD, logproto.BACKWARD} {
+			for _, limit := range []uint32{1, 7, 100, 5000} {
+				t.Run(fmt.Sprintf("%s %s %d", query, direction, limit), func(t *testing.T) {
+					params := NewLiteralParams(query, start, end, 0, 0, direction, limit, nil)
+
+					res, err := regular.Query(params).Exec(context.Background())
+					require.Nil(t, err)
+					shardedRes, err := sharded.Query(params, shards).Exec(context.Background())
+					require.Nil(t, err)
+
+					require.Equal(t, res.Data, shardedRes.Data)
+				})
+			}
+		}
+	}
+}
+
+func TestInstantMappingEquivalence(t *testing.T) {
+	var (
+		shards  = 3
+		rounds  = 20
+		streams = randomStreams(60, rounds, shards, []string{"a", "b", "c", "d"})
+		ts      = time.Unix(0, int64(time.Second*time.Duration(rounds-1)))
+	)
+	regular := NewEngine(EngineOpts{}, NewMockQuerier(shards, streams))
+	sharded := NewShardedEngine(EngineOpts{}, MockDownstreamer{regular}, nilMetrics)
+
+	for _, query := range []string{
+		`count_over_time({a=~".*"}[17s])`,
+		`count_over_time({a=~".*"}[2s])`,
+		`sum by (a) (count_over_time({a=~".*"} |= "number: 1" [17s]))`,
+		`sum(count_over_time({a=~".*"}[17s]))`,
+		`rate({a=~".*"}[10s] offset 2s)`,
+		`sum without (b) (rate({a=~".*"}[17s]))`,
+		`bytes_over_time({a=~".*"}[17s])`,
+		`sum by (a) (bytes_rate({a=~".*"}[17s]))`,
+		`sum_over_time({a=~".*"} | regexp "number: (?P<n>[0-9]+)" | unwrap n [17s])`,
+		`max by (a) (max_over_time({a=~".*"} | regexp "number: (?P<n>[0-9]+)" | unwrap n [17s]))`,
+		`min_over_time({a=~".*"} | regexp "number: (?P<n>[0-9]+)" | unwrap n [17s] offset 1s)`,
+		`avg_over_time({a=~".*"} | regexp "number: (?P<n>[0-9]+)" | unwrap n [17s])`,
+		`topk(3, sum by (a) (count_over_time({a=~".*"}[17s])))`,
+		`sum(count_over_time({a=~".*"}[17s])) / count(rate({a=~".*"}[17s]))`,
+		`absent_over_time({a="missing"}[17s])`,
+	} {
+		for _, split := range []time.Duration{0, 3 * time.Second, 5 * time.Second} {
+			for _, minShardingOffset := range []time.Duration{0, 5 * time.Second} {
+				t.Run(fmt.Sprintf("%s %s %s", query, split, minShardingOffset), func(t *testing.T) {
+					params := NewLiteralParams(query, ts, ts, 0, 0, logproto.FORWARD, 100, nil)
+
+					res, err := regular.Query(params).Exec(context.Background())
+					require.Nil(t, err)
+					shardedRes, err := sharded.InstantQuery(params, shards, split, minShardingOffset).Exec(context.Background())
+					require.Nil(t, err)
+
+					// rates are summed in a different order, up to 6 decimals precision is expected.
+					expected, actual := res.Data.(promql.Vector), shardedRes.Data.(promql.Vector)
+					for _, vec := range []promql.Vector{expected, actual} {
+						sort.Slice(vec, func(i, j int) bool { return labels.Compare(vec[i].Metric, vec[j].Metric) < 0 })
+						for i := range vec {
+							vec[i].V = math.Round(vec[i].V*1e6) / 1e6
+						}
+					}
+					require.Equal(t, expected, actual)
+				})
+			}
+		}
+	}
+}
+
 // approximatelyEquals ensures two responses are approximately equal, up to 6 decimals precision per sample
 func approximatelyEquals(t *testing.T, as, bs promql.Matrix) {
 	require.Equal(t, len(as), len(bs))
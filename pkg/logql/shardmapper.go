@@ -2,6 +2,8 @@ package logql
 
 import (
 	"fmt"
+	"math"
+	"time"
 
 	"github.com/cortexproject/cortex/pkg/querier/astmapper"
 	"github.com/cortexproject/cortex/pkg/util"
@@ -92,9 +94,29 @@ func NewShardMapper(shards int, metrics *ShardingMetrics) (ShardMapper, error) {
 	}, nil
 }
 
+// NewInstantShardMapper creates a ShardMapper for instant queries. On top of sharding, range aggregations
+// over more than splitRange are split into sub-ranges whose results are combined.
+// Expressions whose most recent data is less than minShardingOffset before the time of the query are not
+// sharded: this data may still be in the ingesters, which don't support shards.
+func NewInstantShardMapper(shards int, splitRange, minShardingOffset time.Duration, metrics *ShardingMetrics) (ShardMapper, error) {
+	if shards < 2 && splitRange <= 0 {
+		return ShardMapper{}, fmt.Errorf("Cannot create ShardMapper with <2 shards and no split range. Received %d", shards)
+	}
+	return ShardMapper{
+		shards:            shards,
+		splitRange:        splitRange,
+		minShardingOffset: minShardingOffset,
+		metrics:           metrics,
+	}, nil
+}
+
 type ShardMapper struct {
 	shards  int
 	metrics *ShardingMetrics
+
+	// only used for instant queries.
+	splitRange        time.Duration
+	minShardingOffset time.Duration
 }
 
 func (m ShardMapper) Parse(query string) (noop bool, expr Expr, err error) {
@@ -129,12 +151,34 @@ func (m ShardMapper) Map(expr Expr, r *shardRecorder) (Expr, error) {
 	switch e := expr.(type) {
 	case *literalExpr:
 		return e, nil
-	case *matchersExpr, *filterExpr:
+	case *matchersExpr, *filterExpr, stageExpr:
 		return m.mapLogSelectorExpr(e.(LogSelectorExpr), r), nil
 	case *vectorAggregationExpr:
 		return m.mapVectorAggregationExpr(e, r)
 	case *rangeAggregationExpr:
 		return m.mapRangeAggregationExpr(e, r), nil
+	case *labelReplaceExpr:
+		mapped, err := m.Map(e.left, r)
+		if err != nil {
+			return nil, err
+		}
+		sampleExpr, ok := mapped.(SampleExpr)
+		if !ok {
+			return nil, badASTMapping("SampleExpr", mapped)
+		}
+		e.left = sampleExpr
+		return e, nil
+	case *subqueryAggregationExpr:
+		mapped, err := m.Map(e.subquery.left, r)
+		if err != nil {
+			return nil, err
+		}
+		sampleExpr, ok := mapped.(SampleExpr)
+		if !ok {
+			return nil, badASTMapping("SampleExpr", mapped)
+		}
+		e.subquery.left = sampleExpr
+		return e, nil
 	case *binOpExpr:
 		lhsMapped, err := m.Map(e.SampleExpr, r)
 		if err != nil {
@@ -160,7 +204,17 @@ func (m ShardMapper) Map(expr Expr, r *shardRecorder) (Expr, error) {
 	}
 }
 
+// shardable returns true if an expression whose ranges end offset before the time of the query can be sharded.
+// The end of a range is excluded, its most recent data is a nanosecond before.
+func (m ShardMapper) shardable(offset time.Duration) bool {
+	return m.shards >= 2 && offset >= m.minShardingOffset-time.Nanosecond
+}
+
 func (m ShardMapper) mapLogSelectorExpr(expr LogSelectorExpr, r *shardRecorder) LogSelectorExpr {
+	// stages such as distinct need the entries of all shards.
+	if HasMergedStages(expr) || !m.shardable(0) {
+		return DownstreamLogSelectorExpr{LogSelectorExpr: expr}
+	}
 	var head *ConcatLogSelectorExpr
 	for i := m.shards - 1; i >= 0; i-- {
 		head = &ConcatLogSelectorExpr{
@@ -180,6 +234,9 @@ func (m ShardMapper) mapLogSelectorExpr(expr LogSelectorExpr, r *shardRecorder)
 }
 
 func (m ShardMapper) mapSampleExpr(expr SampleExpr, r *shardRecorder) SampleExpr {
+	if !m.shardable(minOffset(expr)) {
+		return DownstreamSampleExpr{SampleExpr: expr}
+	}
 	var head *ConcatSampleExpr
 	for i := m.shards - 1; i >= 0; i-- {
 		head = &ConcatSampleExpr{
@@ -201,6 +258,12 @@ func (m ShardMapper) mapSampleExpr(expr SampleExpr, r *shardRecorder) SampleExpr
 // technically, std{dev,var} are also parallelizable if there is no cross-shard merging
 // in descendent nodes in the AST. This optimization is currently avoided for simplicity.
 func (m ShardMapper) mapVectorAggregationExpr(expr *vectorAggregationExpr, r *shardRecorder) (SampleExpr, error) {
+	// sum by (app) (count_over_time(x[2h])) ->
+	// sum by (app) (sum by (app) (count_over_time(x[1h])) ++ sum by (app) (count_over_time(x[1h] offset 1h)))
+	if left, ok := expr.left.(*rangeAggregationExpr); ok && m.splittable(left) &&
+		splitRangeOps[left.operation] == expr.operation && expr.params == 0 {
+		return m.splitRangeAggregationExpr(left, expr.operation, expr.grouping, true, r), nil
+	}
 
 	// if this AST contains unshardable operations, don't shard this at this level,
 	// but attempt to shard a child node.
@@ -219,6 +282,7 @@ func (m ShardMapper) mapVectorAggregationExpr(expr *vectorAggregationExpr, r *sh
 			grouping:  expr.grouping,
 			params:    expr.params,
 			operation: expr.operation,
+			label:     expr.label,
 		}, nil
 
 	}
@@ -278,6 +342,10 @@ func (m ShardMapper) mapVectorAggregationExpr(expr *vectorAggregationExpr, r *sh
 }
 
 func (m ShardMapper) mapRangeAggregationExpr(expr *rangeAggregationExpr, r *shardRecorder) SampleExpr {
+	if m.splittable(expr) {
+		// count_over_time(x[2h]) -> sum without() (count_over_time(x[1h]) ++ count_over_time(x[1h] offset 1h))
+		return m.splitRangeAggregationExpr(expr, splitRangeOps[expr.operation], &grouping{without: true}, false, r)
+	}
 	switch expr.operation {
 	case OpRangeTypeCount, OpRangeTypeRate, OpRangeTypeBytesRate, OpRangeTypeBytes:
 		// count_over_time(x) -> count_over_time(x, shard=1) ++ count_over_time(x, shard=2)...
@@ -285,10 +353,152 @@ func (m ShardMapper) mapRangeAggregationExpr(expr *rangeAggregationExpr, r *shar
 		// same goes for bytes_rate and bytes_over_time
 		return m.mapSampleExpr(expr, r)
 	default:
-		return expr
+		// the downstream evaluator can't evaluate ranges itself.
+		return DownstreamSampleExpr{SampleExpr: expr}
 	}
 }
 
+// splittable returns true if the range of a range aggregation of an instant query must be split.
+func (m ShardMapper) splittable(expr *rangeAggregationExpr) bool {
+	_, ok := splitRangeOps[expr.operation]
+	return ok && m.splitRange > 0 && expr.left.interval > m.splitRange
+}
+
+// splitRangeAggregationExpr splits the range of a range aggregation into sub-ranges of at most splitRange,
+// the results of the sub-ranges are combined with the vector aggregation op by grouping.
+// When pushdown is set, the vector aggregation is applied to each sub-range too.
+// Rates are computed by dividing the combined counts of the sub-ranges by the whole range.
+func (m ShardMapper) splitRangeAggregationExpr(expr *rangeAggregationExpr, op string, g *grouping, pushdown bool, r *shardRecorder) SampleExpr {
+	operation := expr.operation
+	if countOp, ok := splitRateOps[operation]; ok {
+		operation = countOp
+	}
+
+	var downstreams []DownstreamSampleExpr
+	for _, sub := range splitRange(expr.left.interval, expr.left.offset, m.splitRange) {
+		var piece SampleExpr = &rangeAggregationExpr{
+			left: &logRange{
+				left:     expr.left.left,
+				interval: sub.interval,
+				unwrap:   expr.left.unwrap,
+				offset:   sub.offset,
+			},
+			operation: operation,
+			params:    expr.params,
+		}
+		if pushdown {
+			piece = &vectorAggregationExpr{
+				left:      piece,
+				grouping:  g,
+				operation: op,
+			}
+		}
+		if !m.shardable(sub.offset) || !isShardable(piece.Operations()) {
+			downstreams = append(downstreams, DownstreamSampleExpr{SampleExpr: piece})
+			continue
+		}
+		for i := 0; i < m.shards; i++ {
+			downstreams = append(downstreams, DownstreamSampleExpr{
+				shard: &astmapper.ShardAnnotation{
+					Shard: i,
+					Of:    m.shards,
+				},
+				SampleExpr: piece,
+			})
+		}
+		r.Add(m.shards, MetricsKey)
+	}
+
+	var head *ConcatSampleExpr
+	for i := len(downstreams) - 1; i >= 0; i-- {
+		head = &ConcatSampleExpr{
+			DownstreamSampleExpr: downstreams[i],
+			next:                 head,
+		}
+	}
+	var combined SampleExpr = &vectorAggregationExpr{
+		left:      head,
+		grouping:  g,
+		operation: op,
+	}
+	if operation != expr.operation {
+		return &binOpExpr{
+			SampleExpr: combined,
+			RHS:        &literalExpr{value: expr.left.interval.Seconds()},
+			op:         OpTypeDiv,
+		}
+	}
+	return combined
+}
+
+type subRange struct {
+	interval time.Duration
+	offset   time.Duration
+}
+
+// splitRange splits a range into sub-ranges of at most split, the most recent first.
+// Both ends of the range of an instant query are excluded, so each sub-range but the most recent one
+// is extended and moved forward by a nanosecond to include the start of the sub-range after it.
+func splitRange(interval, offset, split time.Duration) []subRange {
+	var subs []subRange
+	for start := time.Duration(0); start < interval; start += split {
+		sub := subRange{
+			interval: split,
+			offset:   offset + start,
+		}
+		if start+split > interval {
+			sub.interval = interval - start
+		}
+		if start > 0 {
+			sub.interval += time.Nanosecond
+			sub.offset -= time.Nanosecond
+		}
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// minOffset returns the offset of the most recent range of an expression.
+func minOffset(expr SampleExpr) time.Duration {
+	switch e := expr.(type) {
+	case *rangeAggregationExpr:
+		return e.left.offset
+	case *vectorAggregationExpr:
+		return minOffset(e.left)
+	case *labelReplaceExpr:
+		return minOffset(e.left)
+	case *binOpExpr:
+		lhs, rhs := minOffset(e.SampleExpr), minOffset(e.RHS)
+		if rhs < lhs {
+			return rhs
+		}
+		return lhs
+	case *literalExpr:
+		// literals don't use any data.
+		return math.MaxInt64
+	default:
+		return 0
+	}
+}
+
+// splitRangeOps lists the range aggregations which can be split into sub-ranges of an instant query,
+// with the vector aggregation combining the results of the sub-ranges.
+var splitRangeOps = map[string]string{
+	OpRangeTypeCount:     OpTypeSum,
+	OpRangeTypeRate:      OpTypeSum,
+	OpRangeTypeBytes:     OpTypeSum,
+	OpRangeTypeBytesRate: OpTypeSum,
+	OpRangeTypeSum:       OpTypeSum,
+	OpRangeTypeMax:       OpTypeMax,
+	OpRangeTypeMin:       OpTypeMin,
+}
+
+// splitRateOps lists the rates which are computed from the sums of their sub-ranges.
+var splitRateOps = map[string]string{
+	OpRangeTypeRate:      OpRangeTypeCount,
+	OpRangeTypeBytesRate: OpRangeTypeBytes,
+}
+
 // isShardable returns false if any of the listed operation types are not shardable and true otherwise
 func isShardable(ops []string) bool {
 	for _, op := range ops {
@@ -329,7 +539,14 @@ var shardableOps = map[string]bool{
 	OpRangeTypeBytes:     true,
 	OpRangeTypeBytesRate: true,
 
+	// absent_over_time is not shardable: a shard without samples would report the range as absent.
+	// subqueries are not shardable as a whole since their expression can aggregate across shards,
+	// the expression inside the subquery is sharded instead.
+
 	// binops - arith
 	OpTypeAdd: true,
 	OpTypeMul: true,
+
+	// label_replace is applied on each series independently.
+	OpLabelReplace: true,
 }
@@ -154,6 +154,46 @@ func TestMappingStrings(t *testing.T) {
 			in:  `sum by (cluster) (rate({foo="bar"} |= "id=123" [5m]))`,
 			out: `sum by(cluster)(downstream<sum by(cluster)(rate(({foo="bar"}|="id=123")[5m])), shard=0_of_2> ++ downstream<sum by(cluster)(rate(({foo="bar"}|="id=123")[5m])), shard=1_of_2>)`,
 		},
+		{
+			in:  `sum by (app, status) (rate({foo="bar"}[5m])) / ignoring(status) group_left sum by (app) (rate({foo="bar"}[5m]))`,
+			out: `sum by(app,status)(downstream<sum by(app,status)(rate(({foo="bar"})[5m])), shard=0_of_2> ++ downstream<sum by(app,status)(rate(({foo="bar"})[5m])), shard=1_of_2>) / ignoring(status) group_left() sum by(app)(downstream<sum by(app)(rate(({foo="bar"})[5m])), shard=0_of_2> ++ downstream<sum by(app)(rate(({foo="bar"})[5m])), shard=1_of_2>)`,
+		},
+		{
+			in:  `sum by (svc) (label_replace(rate({foo="bar"}[5m]), "svc", "$1", "app", "(.*)"))`,
+			out: `sum by(svc)(downstream<sum by(svc)(label_replace(rate(({foo="bar"})[5m]),"svc","$1","app","(.*)")), shard=0_of_2> ++ downstream<sum by(svc)(label_replace(rate(({foo="bar"})[5m]),"svc","$1","app","(.*)")), shard=1_of_2>)`,
+		},
+		{
+			in:  `label_replace(rate({foo="bar"}[5m]), "svc", "$1", "app", "(.*)")`,
+			out: `label_replace(downstream<rate(({foo="bar"})[5m]), shard=0_of_2> ++ downstream<rate(({foo="bar"})[5m]), shard=1_of_2>,"svc","$1","app","(.*)")`,
+		},
+		{
+			in:  `sum(absent_over_time({foo="bar"}[5m]))`,
+			out: `sum(downstream<absent_over_time(({foo="bar"})[5m])>)`,
+		},
+		{
+			in:  `max_over_time(sum(rate({foo="bar"}[5m]))[1h:1m])`,
+			out: `max_over_time(sum(downstream<sum(rate(({foo="bar"})[5m])), shard=0_of_2> ++ downstream<sum(rate(({foo="bar"})[5m])), shard=1_of_2>)[1h:1m])`,
+		},
+		{
+			in:  `sum(count_over_time(sum(rate({foo="bar"}[5m]))[1h:1m]))`,
+			out: `sum(count_over_time(sum(downstream<sum(rate(({foo="bar"})[5m])), shard=0_of_2> ++ downstream<sum(rate(({foo="bar"})[5m])), shard=1_of_2>)[1h:1m]))`,
+		},
+		{
+			in:  `count_values("value", sum by (app) (rate({foo="bar"}[5m])))`,
+			out: `count_values("value",sum by(app)(downstream<sum by(app)(rate(({foo="bar"})[5m])), shard=0_of_2> ++ downstream<sum by(app)(rate(({foo="bar"})[5m])), shard=1_of_2>))`,
+		},
+		{
+			in:  `sort_desc(sum by (app) (rate({foo="bar"}[5m])))`,
+			out: `sort_desc(sum by(app)(downstream<sum by(app)(rate(({foo="bar"})[5m])), shard=0_of_2> ++ downstream<sum by(app)(rate(({foo="bar"})[5m])), shard=1_of_2>))`,
+		},
+		{
+			in:  `sum(rate({foo="bar"}[5m] offset 1d))`,
+			out: `sum(downstream<sum(rate(({foo="bar"})[5m] offset 1d)), shard=0_of_2> ++ downstream<sum(rate(({foo="bar"})[5m] offset 1d)), shard=1_of_2>)`,
+		},
+		{
+			in:  `{foo="bar"} | logfmt | distinct trace`,
+			out: `downstream<{foo="bar"} | logfmt | distinct trace>`,
+		},
 	} {
 		t.Run(tc.in, func(t *testing.T) {
 			ast, err := ParseExpr(tc.in)
@@ -168,6 +208,49 @@ func TestMappingStrings(t *testing.T) {
 	}
 }
 
+func TestInstantMappingStrings(t *testing.T) {
+	m, err := NewInstantShardMapper(2, time.Hour, 2*time.Hour, nilMetrics)
+	require.Nil(t, err)
+	for _, tc := range []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  `count_over_time({foo="bar"}[1h])`,
+			out: `downstream<count_over_time(({foo="bar"})[1h])>`,
+		},
+		{
+			in:  `count_over_time({foo="bar"}[3h])`,
+			out: `sum without()(downstream<count_over_time(({foo="bar"})[1h])> ++ downstream<count_over_time(({foo="bar"})[3600000000001ns] offset 3599999999999ns)> ++ downstream<count_over_time(({foo="bar"})[3600000000001ns] offset 7199999999999ns), shard=0_of_2> ++ downstream<count_over_time(({foo="bar"})[3600000000001ns] offset 7199999999999ns), shard=1_of_2>)`,
+		},
+		{
+			in:  `sum by (app) (rate({foo="bar"}[150m]))`,
+			out: `sum by(app)(downstream<sum by(app)(count_over_time(({foo="bar"})[1h]))> ++ downstream<sum by(app)(count_over_time(({foo="bar"})[3600000000001ns] offset 3599999999999ns))> ++ downstream<sum by(app)(count_over_time(({foo="bar"})[1800000000001ns] offset 7199999999999ns)), shard=0_of_2> ++ downstream<sum by(app)(count_over_time(({foo="bar"})[1800000000001ns] offset 7199999999999ns)), shard=1_of_2>) / 9000.000000`,
+		},
+		{
+			in:  `max_over_time({foo="bar"} | unwrap bytes [2h] offset 1h)`,
+			out: `max without()(downstream<max_over_time(({foo="bar"}) | unwrap bytes[1h] offset 1h)> ++ downstream<max_over_time(({foo="bar"}) | unwrap bytes[3600000000001ns] offset 7199999999999ns)>)`,
+		},
+		{
+			in:  `max by (app) (min_over_time({foo="bar"} | unwrap bytes [2h]))`,
+			out: `max by(app)(min without()(downstream<min_over_time(({foo="bar"}) | unwrap bytes[1h])> ++ downstream<min_over_time(({foo="bar"}) | unwrap bytes[3600000000001ns] offset 3599999999999ns)>))`,
+		},
+		{
+			in:  `avg_over_time({foo="bar"} | unwrap bytes [2h])`,
+			out: `downstream<avg_over_time(({foo="bar"}) | unwrap bytes[2h])>`,
+		},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			ast, err := ParseExpr(tc.in)
+			require.Nil(t, err)
+
+			mapped, err := m.Map(ast, nilMetrics.shardRecorder())
+			require.Nil(t, err)
+			require.Equal(t, tc.out, mapped.String())
+		})
+	}
+}
+
 func TestMapping(t *testing.T) {
 	m, err := NewShardMapper(2, nilMetrics)
 	require.Nil(t, err)
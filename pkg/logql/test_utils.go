@@ -36,6 +36,11 @@ func (q MockQuerier) Select(_ context.Context, req SelectParams) (iter.EntryIter
 	if err != nil {
 		return nil, err
 	}
+	pipeline, err := expr.Pipeline()
+	if err != nil {
+		return nil, err
+	}
+	pipeline, _ = pipeline.Split()
 
 	matchers := expr.Matchers()
 
@@ -90,8 +95,14 @@ outer:
 
 	}
 
+	// apply the Pipeline per stream, as the ingesters and the store do.
+	iters := make([]iter.EntryIterator, 0, len(filtered))
+	for _, s := range filtered {
+		iters = append(iters, NewPipelineIterator(iter.NewStreamIterator(s), pipeline))
+	}
+
 	return iter.NewTimeRangedIterator(
-		iter.NewStreamsIterator(context.Background(), filtered, req.Direction),
+		iter.NewHeapIterator(context.Background(), iters, req.Direction),
 		req.Start,
 		req.End,
 	), nil
@@ -116,7 +127,7 @@ func (m MockDownstreamer) Downstream(ctx context.Context, queries []DownstreamQu
 			query.Params.Limit(),
 			query.Shards.Encode(),
 		)
-		res, err := m.Query(params).Exec(ctx)
+		res, err := m.QueryWithOptions(params, ParseOptions{Downstream: true}).Exec(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -54,7 +54,8 @@ func NewStream(s *loghttp.Stream) logproto.Stream {
 // NewEntry constructs a logproto.Entry from a Entry
 func NewEntry(e loghttp.Entry) logproto.Entry {
 	return logproto.Entry{
-		Timestamp: e.Timestamp,
-		Line:      e.Line,
+		Timestamp:          e.Timestamp,
+		Line:               e.Line,
+		StructuredMetadata: e.StructuredMetadata,
 	}
 }
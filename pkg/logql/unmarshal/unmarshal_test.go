@@ -41,6 +41,40 @@ var pushTests = []struct {
 			]
 		}`,
 	},
+	{
+		[]logproto.Stream{
+			{
+				Entries: []logproto.Entry{
+					{
+						Timestamp: time.Unix(0, 123456789012345),
+						Line:      "super line",
+						StructuredMetadata: []logproto.LabelPair{
+							{Name: "trace_id", Value: "3c5b1a"},
+							{Name: "pod_uid", Value: "8d0e"},
+						},
+					},
+					{
+						Timestamp: time.Unix(0, 123456789012346),
+						Line:      "super line without metadata",
+					},
+				},
+				Labels: `{test="test"}`,
+			},
+		},
+		`{
+			"streams": [
+				{
+					"stream": {
+						"test": "test"
+					},
+					"values":[
+						[ "123456789012345", "super line", { "trace_id": "3c5b1a", "pod_uid": "8d0e" } ],
+						[ "123456789012346", "super line without metadata" ]
+					]
+				}
+			]
+		}`,
+	},
 }
 
 func Test_DecodePushRequest(t *testing.T) {
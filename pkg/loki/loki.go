@@ -31,6 +31,7 @@ import (
 	"github.com/grafana/loki/pkg/ingester/client"
 	"github.com/grafana/loki/pkg/querier"
 	"github.com/grafana/loki/pkg/querier/queryrange"
+	"github.com/grafana/loki/pkg/scheduler"
 	"github.com/grafana/loki/pkg/storage"
 	serverutil "github.com/grafana/loki/pkg/util/server"
 	"github.com/grafana/loki/pkg/util/validation"
@@ -55,6 +56,7 @@ type Config struct {
 	Worker           frontend.WorkerConfig       `yaml:"frontend_worker,omitempty"`
 	Frontend         frontend.Config             `yaml:"frontend,omitempty"`
 	QueryRange       queryrange.Config           `yaml:"query_range,omitempty"`
+	QueryScheduler   scheduler.Config            `yaml:"query_scheduler,omitempty"`
 	RuntimeConfig    runtimeconfig.ManagerConfig `yaml:"runtime_config,omitempty"`
 	MemberlistKV     memberlist.KVConfig         `yaml:"memberlist"`
 }
@@ -80,6 +82,7 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	c.Frontend.RegisterFlags(f)
 	c.Worker.RegisterFlags(f)
 	c.QueryRange.RegisterFlags(f)
+	c.QueryScheduler.RegisterFlags(f)
 	c.RuntimeConfig.RegisterFlags(f)
 }
 
@@ -98,6 +101,9 @@ func (c *Config) Validate(log log.Logger) error {
 	if err := c.TableManager.Validate(); err != nil {
 		return errors.Wrap(err, "invalid tablemanager config")
 	}
+	if err := c.Ingester.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ingester config")
+	}
 	return nil
 }
 
@@ -118,6 +124,7 @@ type Loki struct {
 	store         storage.Store
 	tableManager  *chunk.TableManager
 	frontend      *frontend.Frontend
+	scheduler     *scheduler.Scheduler
 	stopper       queryrange.Stopper
 	runtimeConfig *runtimeconfig.Manager
 	memberlistKV  *memberlist.KVInit
@@ -160,7 +167,7 @@ var GRPCStreamAuthInterceptor = func(srv interface{}, ss grpc.ServerStream, info
 	// sending it and this could cause transfers to fail on update.
 	//
 	// Also don't check auth /frontend.Frontend/Process, as this handles
-	// queries for multiple users.
+	// queries for multiple users, from the query frontends and the query schedulers.
 	case "/logproto.Ingester/TransferChunks", "/frontend.Frontend/Process":
 		return handler(srv, ss)
 	default:
@@ -290,20 +297,22 @@ func (t *Loki) setupModuleManager() error {
 	mm.RegisterModule(Ingester, t.initIngester)
 	mm.RegisterModule(Querier, t.initQuerier)
 	mm.RegisterModule(QueryFrontend, t.initQueryFrontend)
+	mm.RegisterModule(QueryScheduler, t.initQueryScheduler)
 	mm.RegisterModule(TableManager, t.initTableManager)
 	mm.RegisterModule(All, nil)
 
 	// Add dependencies
 	deps := map[string][]string{
-		Ring:          {RuntimeConfig, Server, MemberlistKV},
-		Overrides:     {RuntimeConfig},
-		Distributor:   {Ring, Server, Overrides},
-		Store:         {Overrides},
-		Ingester:      {Store, Server, MemberlistKV},
-		Querier:       {Store, Ring, Server},
-		QueryFrontend: {Server, Overrides},
-		TableManager:  {Server},
-		All:           {Querier, Ingester, Distributor, TableManager},
+		Ring:           {RuntimeConfig, Server, MemberlistKV},
+		Overrides:      {RuntimeConfig},
+		Distributor:    {Ring, Server, Overrides},
+		Store:          {Overrides},
+		Ingester:       {Store, Server, MemberlistKV},
+		Querier:        {Store, Ring, Server},
+		QueryFrontend:  {Server, Overrides, MemberlistKV},
+		QueryScheduler: {Server, Overrides, MemberlistKV},
+		TableManager:   {Server},
+		All:            {Querier, Ingester, Distributor, TableManager},
 	}
 
 	for mod, targets := range deps {
@@ -1,6 +1,7 @@
 package loki
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -33,6 +34,7 @@ import (
 	"github.com/grafana/loki/pkg/logproto"
 	"github.com/grafana/loki/pkg/querier"
 	"github.com/grafana/loki/pkg/querier/queryrange"
+	"github.com/grafana/loki/pkg/scheduler"
 	loki_storage "github.com/grafana/loki/pkg/storage"
 	"github.com/grafana/loki/pkg/storage/stores/local"
 	serverutil "github.com/grafana/loki/pkg/util/server"
@@ -43,18 +45,19 @@ const maxChunkAgeForTableManager = 12 * time.Hour
 
 // The various modules that make up Loki.
 const (
-	Ring          string = "ring"
-	RuntimeConfig string = "runtime-config"
-	Overrides     string = "overrides"
-	Server        string = "server"
-	Distributor   string = "distributor"
-	Ingester      string = "ingester"
-	Querier       string = "querier"
-	QueryFrontend string = "query-frontend"
-	Store         string = "store"
-	TableManager  string = "table-manager"
-	MemberlistKV  string = "memberlist-kv"
-	All           string = "all"
+	Ring           string = "ring"
+	RuntimeConfig  string = "runtime-config"
+	Overrides      string = "overrides"
+	Server         string = "server"
+	Distributor    string = "distributor"
+	Ingester       string = "ingester"
+	Querier        string = "querier"
+	QueryFrontend  string = "query-frontend"
+	QueryScheduler string = "query-scheduler"
+	Store          string = "store"
+	TableManager   string = "table-manager"
+	MemberlistKV   string = "memberlist-kv"
+	All            string = "all"
 )
 
 func (t *Loki) initServer() (services.Service, error) {
@@ -137,7 +140,18 @@ func (t *Loki) initDistributor() (services.Service, error) {
 
 func (t *Loki) initQuerier() (services.Service, error) {
 	level.Debug(util.Logger).Log("msg", "initializing querier worker", "config", fmt.Sprintf("%+v", t.cfg.Worker))
-	worker, err := frontend.NewWorker(t.cfg.Worker, cortex_querier.Config{MaxConcurrent: t.cfg.Querier.MaxConcurrent}, httpgrpc_server.NewServer(t.server.HTTPServer.Handler), util.Logger)
+	var (
+		worker services.Service
+		err    error
+	)
+	if t.cfg.QueryScheduler.UseSchedulerRing {
+		t.cfg.QueryScheduler.SchedulerRing.KVStore.Multi.ConfigProvider = multiClientRuntimeConfigChannel(t.runtimeConfig)
+		t.cfg.QueryScheduler.SchedulerRing.KVStore.MemberlistKV = t.memberlistKV.GetMemberlistKV
+		querierID := t.cfg.QueryScheduler.SchedulerRing.InstanceID
+		worker, err = scheduler.NewWorker(t.cfg.QueryScheduler, t.cfg.Worker, querierID, t.cfg.Querier.MaxConcurrent, httpgrpc_server.NewServer(t.server.HTTPServer.Handler), util.Logger)
+	} else {
+		worker, err = frontend.NewWorker(t.cfg.Worker, cortex_querier.Config{MaxConcurrent: t.cfg.Querier.MaxConcurrent}, httpgrpc_server.NewServer(t.server.HTTPServer.Handler), util.Logger)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -163,6 +177,7 @@ func (t *Loki) initQuerier() (services.Service, error) {
 	t.server.HTTP.Handle("/loki/api/v1/label/{name}/values", httpMiddleware.Wrap(http.HandlerFunc(t.querier.LabelHandler)))
 	t.server.HTTP.Handle("/loki/api/v1/tail", httpMiddleware.Wrap(http.HandlerFunc(t.querier.TailHandler)))
 	t.server.HTTP.Handle("/loki/api/v1/series", httpMiddleware.Wrap(http.HandlerFunc(t.querier.SeriesHandler)))
+	t.server.HTTP.Handle("/loki/api/v1/format_query", httpMiddleware.Wrap(http.HandlerFunc(querier.FormatQueryHandler)))
 
 	t.server.HTTP.Handle("/api/prom/query", httpMiddleware.Wrap(http.HandlerFunc(t.querier.LogQueryHandler)))
 	t.server.HTTP.Handle("/api/prom/label", httpMiddleware.Wrap(http.HandlerFunc(t.querier.LabelHandler)))
@@ -287,8 +302,21 @@ func (t *Loki) initQueryFrontend() (_ services.Service, err error) {
 		return
 	}
 	t.stopper = stopper
+
+	var schedulerRoundTripper *scheduler.FrontendRoundTripper
+	if t.cfg.QueryScheduler.UseSchedulerRing {
+		// Queries are enqueued in the query schedulers instead of the frontend's own queue.
+		t.cfg.QueryScheduler.SchedulerRing.KVStore.Multi.ConfigProvider = multiClientRuntimeConfigChannel(t.runtimeConfig)
+		t.cfg.QueryScheduler.SchedulerRing.KVStore.MemberlistKV = t.memberlistKV.GetMemberlistKV
+		schedulerRoundTripper, err = scheduler.NewFrontendRoundTripper(t.cfg.QueryScheduler, util.Logger, prometheus.DefaultRegisterer)
+		if err != nil {
+			return
+		}
+		t.frontend.Wrap(func(_ http.RoundTripper) http.RoundTripper { return schedulerRoundTripper })
+	} else {
+		frontend.RegisterFrontendServer(t.server.GRPC, t.frontend)
+	}
 	t.frontend.Wrap(tripperware)
-	frontend.RegisterFrontendServer(t.server.GRPC, t.frontend)
 
 	frontendHandler := middleware.Merge(
 		serverutil.RecoveryHTTPMiddleware,
@@ -303,6 +331,11 @@ func (t *Loki) initQueryFrontend() (_ services.Service, err error) {
 	t.server.HTTP.Handle("/loki/api/v1/labels", frontendHandler)
 	t.server.HTTP.Handle("/loki/api/v1/label/{name}/values", frontendHandler)
 	t.server.HTTP.Handle("/loki/api/v1/series", frontendHandler)
+	// formatting doesn't need the queriers, it's served by the frontend itself.
+	t.server.HTTP.Handle("/loki/api/v1/format_query", middleware.Merge(
+		serverutil.RecoveryHTTPMiddleware,
+		serverutil.NewPrepopulateMiddleware(),
+	).Wrap(http.HandlerFunc(querier.FormatQueryHandler)))
 	t.server.HTTP.Handle("/api/prom/query", frontendHandler)
 	t.server.HTTP.Handle("/api/prom/label", frontendHandler)
 	t.server.HTTP.Handle("/api/prom/label/{name}/values", frontendHandler)
@@ -310,15 +343,39 @@ func (t *Loki) initQueryFrontend() (_ services.Service, err error) {
 	// fallback route
 	t.server.HTTP.PathPrefix("/").Handler(frontendHandler)
 
-	return services.NewIdleService(nil, func(_ error) error {
+	return services.NewIdleService(func(ctx context.Context) error {
+		if schedulerRoundTripper != nil {
+			return services.StartAndAwaitRunning(ctx, schedulerRoundTripper)
+		}
+		return nil
+	}, func(_ error) error {
 		t.frontend.Close()
 		if t.stopper != nil {
 			t.stopper.Stop()
 		}
+		if schedulerRoundTripper != nil {
+			return services.StopAndAwaitTerminated(context.Background(), schedulerRoundTripper)
+		}
 		return nil
 	}), nil
 }
 
+func (t *Loki) initQueryScheduler() (_ services.Service, err error) {
+	t.cfg.QueryScheduler.SchedulerRing.KVStore.Multi.ConfigProvider = multiClientRuntimeConfigChannel(t.runtimeConfig)
+	t.cfg.QueryScheduler.SchedulerRing.KVStore.MemberlistKV = t.memberlistKV.GetMemberlistKV
+	t.cfg.QueryScheduler.SchedulerRing.ListenPort = t.cfg.Server.GRPCListenPort
+
+	level.Debug(util.Logger).Log("msg", "initializing query scheduler", "config", fmt.Sprintf("%+v", t.cfg.QueryScheduler))
+	t.scheduler, err = scheduler.New(t.cfg.QueryScheduler, t.overrides, util.Logger, prometheus.DefaultRegisterer)
+	if err != nil {
+		return
+	}
+
+	frontend.RegisterFrontendServer(t.server.GRPC, t.scheduler)
+	scheduler.RegisterSchedulerServer(t.server.GRPC, t.scheduler)
+	return t.scheduler, nil
+}
+
 func (t *Loki) initMemberlistKV() (services.Service, error) {
 	t.cfg.MemberlistKV.MetricsRegisterer = prometheus.DefaultRegisterer
 	t.cfg.MemberlistKV.Codecs = []codec.Codec{
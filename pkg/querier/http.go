@@ -57,7 +57,7 @@ func (q *Querier) RangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		request.Limit,
 		request.Shards,
 	)
-	query := q.engine.Query(params)
+	query := q.engine.QueryWithOptions(params, logql.ParseOptions{Downstream: request.Downstream})
 	result, err := query.Exec(ctx)
 	if err != nil {
 		serverutil.WriteError(err, w)
@@ -95,9 +95,9 @@ func (q *Querier) InstantQueryHandler(w http.ResponseWriter, r *http.Request) {
 		0,
 		request.Direction,
 		request.Limit,
-		nil,
+		request.Shards,
 	)
-	query := q.engine.Query(params)
+	query := q.engine.QueryWithOptions(params, logql.ParseOptions{Downstream: request.Downstream})
 	result, err := query.Exec(ctx)
 	if err != nil {
 		serverutil.WriteError(err, w)
@@ -326,6 +326,20 @@ func (q *Querier) SeriesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// FormatQueryHandler is a http.HandlerFunc returning the pretty-printed form of a LogQL query.
+func FormatQueryHandler(w http.ResponseWriter, r *http.Request) {
+	formatted, err := logql.FormatQuery(r.Form.Get("query"))
+	if err != nil {
+		serverutil.WriteError(httpgrpc.Errorf(http.StatusBadRequest, err.Error()), w)
+		return
+	}
+
+	if err := marshal.WriteFormatQueryResponseJSON(formatted, w); err != nil {
+		serverutil.WriteError(err, w)
+		return
+	}
+}
+
 // parseRegexQuery parses regex and query querystring from httpRequest and returns the combined LogQL query.
 // This is used only to keep regexp query string support until it gets fully deprecated.
 func parseRegexQuery(httpRequest *http.Request) (string, error) {
@@ -108,6 +108,21 @@ func (codec) DecodeRequest(_ context.Context, r *http.Request) (queryrange.Reque
 			Path:   r.URL.Path,
 			Shards: req.Shards,
 		}, nil
+	case InstantQueryOp:
+		req, err := loghttp.ParseInstantQuery(r)
+		if err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+		}
+		// instant queries start and end at the time of the query.
+		return &LokiRequest{
+			Query:     req.Query,
+			Limit:     req.Limit,
+			Direction: req.Direction,
+			StartTs:   req.Ts.UTC(),
+			EndTs:     req.Ts.UTC(),
+			Path:      r.URL.Path,
+			Shards:    req.Shards,
+		}, nil
 	case SeriesOp:
 		req, err := loghttp.ParseSeriesQuery(r)
 		if err != nil {
@@ -129,8 +144,6 @@ func (codec) EncodeRequest(ctx context.Context, r queryrange.Request) (*http.Req
 	switch request := r.(type) {
 	case *LokiRequest:
 		params := url.Values{
-			"start":     []string{fmt.Sprintf("%d", request.StartTs.UnixNano())},
-			"end":       []string{fmt.Sprintf("%d", request.EndTs.UnixNano())},
 			"query":     []string{request.Query},
 			"direction": []string{request.Direction.String()},
 			"limit":     []string{fmt.Sprintf("%d", request.Limit)},
@@ -138,12 +151,23 @@ func (codec) EncodeRequest(ctx context.Context, r queryrange.Request) (*http.Req
 		if len(request.Shards) > 0 {
 			params["shards"] = request.Shards
 		}
+		if isDownstream(ctx) {
+			params["downstream"] = []string{"true"}
+		}
+		// the request could come /api/prom/query but we want to only use the new api.
+		path := "/loki/api/v1/query_range"
+		if logql.GetRangeType(paramsFromRequest(request)) == logql.InstantType {
+			path = "/loki/api/v1/query"
+			params["time"] = []string{fmt.Sprintf("%d", request.EndTs.UnixNano())}
+		} else {
+			params["start"] = []string{fmt.Sprintf("%d", request.StartTs.UnixNano())}
+			params["end"] = []string{fmt.Sprintf("%d", request.EndTs.UnixNano())}
+		}
 		if request.Step != 0 {
 			params["step"] = []string{fmt.Sprintf("%f", float64(request.Step)/float64(1e3))}
 		}
 		u := &url.URL{
-			// the request could come /api/prom/query but we want to only use the new api.
-			Path:     "/loki/api/v1/query_range",
+			Path:     path,
 			RawQuery: params.Encode(),
 		}
 		req := &http.Request{
@@ -233,6 +257,28 @@ func (codec) DecodeResponse(ctx context.Context, r *http.Response, req queryrang
 				},
 				Statistics: resp.Data.Statistics,
 			}, nil
+		case loghttp.ResultTypeVector:
+			return &LokiPromResponse{
+				Response: &queryrange.PrometheusResponse{
+					Status: resp.Status,
+					Data: queryrange.PrometheusData{
+						ResultType: loghttp.ResultTypeVector,
+						Result:     vectorToProto(resp.Data.Result.(loghttp.Vector)),
+					},
+				},
+				Statistics: resp.Data.Statistics,
+			}, nil
+		case loghttp.ResultTypeScalar:
+			return &LokiPromResponse{
+				Response: &queryrange.PrometheusResponse{
+					Status: resp.Status,
+					Data: queryrange.PrometheusData{
+						ResultType: loghttp.ResultTypeScalar,
+						Result:     scalarToProto(resp.Data.Result.(loghttp.Scalar)),
+					},
+				},
+				Statistics: resp.Data.Statistics,
+			}, nil
 		case loghttp.ResultTypeStream:
 			return &LokiResponse{
 				Status:     resp.Status,
@@ -507,6 +553,31 @@ func toProto(m loghttp.Matrix) []queryrange.SampleStream {
 	return res
 }
 
+// vectorToProto converts a vector to sample streams of a single sample.
+func vectorToProto(v loghttp.Vector) []queryrange.SampleStream {
+	res := make([]queryrange.SampleStream, 0, len(v))
+	for _, s := range v {
+		res = append(res, queryrange.SampleStream{
+			Labels: client.FromMetricsToLabelAdapters(s.Metric),
+			Samples: []client.Sample{{
+				Value:       float64(s.Value),
+				TimestampMs: int64(s.Timestamp),
+			}},
+		})
+	}
+	return res
+}
+
+// scalarToProto converts a scalar to a sample stream without labels of a single sample.
+func scalarToProto(s loghttp.Scalar) []queryrange.SampleStream {
+	return []queryrange.SampleStream{{
+		Samples: []client.Sample{{
+			Value:       float64(s.Value),
+			TimestampMs: int64(s.Timestamp),
+		}},
+	}}
+}
+
 func (res LokiResponse) Count() int64 {
 	var result int64
 	for _, s := range res.Data.Result {
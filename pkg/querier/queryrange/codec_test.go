@@ -48,6 +48,18 @@ func Test_codec_DecodeRequest(t *testing.T) {
 			StartTs:   start,
 			EndTs:     end,
 		}, false},
+		{"instant", func() (*http.Request, error) {
+			return http.NewRequest(http.MethodGet,
+				fmt.Sprintf(`/loki/api/v1/query?time=%d&query=count_over_time({foo="bar"}[1h])&limit=200&direction=FORWARD&shards=1_of_2`, end.UnixNano()), nil)
+		}, &LokiRequest{
+			Query:     `count_over_time({foo="bar"}[1h])`,
+			Limit:     200,
+			Direction: logproto.FORWARD,
+			Path:      "/loki/api/v1/query",
+			StartTs:   end,
+			EndTs:     end,
+			Shards:    []string{"1_of_2"},
+		}, false},
 		{"series", func() (*http.Request, error) {
 			return http.NewRequest(http.MethodGet,
 				fmt.Sprintf(`/series?start=%d&end=%d&match={foo="bar"}`, start.UnixNano(), end.UnixNano()), nil)
@@ -98,6 +110,27 @@ func Test_codec_DecodeResponse(t *testing.T) {
 				},
 				Statistics: statsResult,
 			}, false},
+		{"vector", &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(vectorString))}, nil,
+			&LokiPromResponse{
+				Response: &queryrange.PrometheusResponse{
+					Status: loghttp.QueryStatusSuccess,
+					Data: queryrange.PrometheusData{
+						ResultType: loghttp.ResultTypeVector,
+						Result:     vectorSampleStreams,
+					},
+				},
+				Statistics: statsResult,
+			}, false},
+		{"scalar", &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(`{"status":"success","data":{"resultType":"scalar","result":[1568404331.324,"2"]}}`))}, nil,
+			&LokiPromResponse{
+				Response: &queryrange.PrometheusResponse{
+					Status: loghttp.QueryStatusSuccess,
+					Data: queryrange.PrometheusData{
+						ResultType: loghttp.ResultTypeScalar,
+						Result:     []queryrange.SampleStream{{Samples: []client.Sample{{Value: 2, TimestampMs: 1568404331324}}}},
+					},
+				},
+			}, false},
 		{"streams v1", &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(streamsString))},
 			&LokiRequest{Direction: logproto.FORWARD, Limit: 100, Path: "/loki/api/v1/query_range"},
 			&LokiResponse{
@@ -184,6 +217,36 @@ func Test_codec_EncodeRequest(t *testing.T) {
 	require.Equal(t, "/loki/api/v1/query_range", req.(*LokiRequest).Path)
 }
 
+func Test_codec_instant_EncodeRequest(t *testing.T) {
+	ctx := context.Background()
+	toEncode := &LokiRequest{
+		Query:     `count_over_time({foo="bar"}[1h])`,
+		Limit:     200,
+		Direction: logproto.FORWARD,
+		Path:      "/loki/api/v1/query",
+		StartTs:   end,
+		EndTs:     end,
+		Shards:    []string{"1_of_2"},
+	}
+	got, err := lokiCodec.EncodeRequest(ctx, toEncode)
+	require.NoError(t, err)
+	require.Equal(t, "/loki/api/v1/query", got.URL.Path)
+	require.Equal(t, fmt.Sprintf("%d", end.UnixNano()), got.URL.Query().Get("time"))
+	require.Empty(t, got.URL.Query().Get("start"))
+	require.Empty(t, got.URL.Query().Get("step"))
+
+	// testing a full roundtrip
+	req, err := lokiCodec.DecodeRequest(context.TODO(), got)
+	require.NoError(t, err)
+	require.Equal(t, toEncode, req)
+
+	// downstream queries are flagged for the queriers.
+	require.Empty(t, got.URL.Query().Get("downstream"))
+	got, err = lokiCodec.EncodeRequest(withDownstream(ctx), toEncode)
+	require.NoError(t, err)
+	require.Equal(t, "true", got.URL.Query().Get("downstream"))
+}
+
 func Test_codec_series_EncodeRequest(t *testing.T) {
 	got, err := lokiCodec.EncodeRequest(context.TODO(), &queryrange.PrometheusRequest{})
 	require.Error(t, err)
@@ -232,6 +295,16 @@ func Test_codec_EncodeResponse(t *testing.T) {
 			},
 			Statistics: statsResult,
 		}, matrixString, false},
+		{"vector", &LokiPromResponse{
+			Response: &queryrange.PrometheusResponse{
+				Status: loghttp.QueryStatusSuccess,
+				Data: queryrange.PrometheusData{
+					ResultType: loghttp.ResultTypeVector,
+					Result:     vectorSampleStreams,
+				},
+			},
+			Statistics: statsResult,
+		}, vectorString, false},
 		{"loki v1",
 			&LokiResponse{
 				Status:    loghttp.QueryStatusSuccess,
@@ -805,6 +878,31 @@ var (
 			Samples: []client.Sample{{Value: 3.45, TimestampMs: 1568404331324}, {Value: 4.45, TimestampMs: 1568404331339}},
 		},
 	}
+	vectorString = `{
+	"data": {
+	  ` + statsResultString + `
+	  "resultType": "vector",
+	  "result": [
+		{
+		  "metric": {
+			"filename": "\/var\/hostlog\/apport.log",
+			"job": "varlogs"
+		  },
+		  "value": [
+			1568404331.324,
+			"0.013333333333333334"
+		  ]
+		}
+	  ]
+	},
+	"status": "success"
+  }`
+	vectorSampleStreams = []queryrange.SampleStream{
+		{
+			Labels:  []client.LabelAdapter{{Name: "filename", Value: "/var/hostlog/apport.log"}, {Name: "job", Value: "varlogs"}},
+			Samples: []client.Sample{{Value: 0.013333333333333334, TimestampMs: 1568404331324}},
+		},
+	}
 	streamsString = `{
 		"status": "success",
 		"data": {
@@ -12,6 +12,7 @@ import (
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/promql/parser"
 
+	"github.com/grafana/loki/pkg/loghttp"
 	"github.com/grafana/loki/pkg/logql"
 )
 
@@ -24,6 +25,10 @@ type DownstreamHandler struct {
 }
 
 func ParamsToLokiRequest(params logql.Params) *LokiRequest {
+	path := "/loki/api/v1/query_range"
+	if logql.GetRangeType(params) == logql.InstantType {
+		path = "/loki/api/v1/query"
+	}
 	return &LokiRequest{
 		Query:     params.Query(),
 		Limit:     params.Limit(),
@@ -31,7 +36,7 @@ func ParamsToLokiRequest(params logql.Params) *LokiRequest {
 		StartTs:   params.Start(),
 		EndTs:     params.End(),
 		Direction: params.Direction(),
-		Path:      "/loki/api/v1/query_range", // TODO(owen-d): make this derivable
+		Path:      path,
 	}
 }
 
@@ -56,8 +61,9 @@ type instance struct {
 }
 
 func (in instance) Downstream(ctx context.Context, queries []logql.DownstreamQuery) ([]logql.Result, error) {
-	return in.For(queries, func(qry logql.DownstreamQuery) (logql.Result, error) {
+	return in.For(ctx, queries, func(ctx context.Context, qry logql.DownstreamQuery) (logql.Result, error) {
 		req := ParamsToLokiRequest(qry.Params).WithShards(qry.Shards).WithQuery(qry.Expr.String()).(*LokiRequest)
+		ctx = withDownstream(ctx)
 		logger, ctx := spanlogger.New(ctx, "DownstreamHandler.instance")
 		defer logger.Finish()
 		level.Debug(logger).Log("shards", fmt.Sprintf("%+v", req.Shards), "query", req.Query)
@@ -70,10 +76,25 @@ func (in instance) Downstream(ctx context.Context, queries []logql.DownstreamQue
 	})
 }
 
+// withDownstream marks the requests of the context as downstream queries, their expressions
+// are written by the query frontend and parsed by the queriers with logql.ParseOptions.Downstream.
+func withDownstream(ctx context.Context) context.Context {
+	return context.WithValue(ctx, downstreamCtxKey, true)
+}
+
+// isDownstream returns true if the requests of the context are downstream queries.
+func isDownstream(ctx context.Context) bool {
+	downstream, _ := ctx.Value(downstreamCtxKey).(bool)
+	return downstream
+}
+
 // For runs a function against a list of queries, collecting the results or returning an error. The indices are preserved such that input[i] maps to output[i].
+// The queries still running are canceled as soon as one of them fails or the context is canceled,
+// e.g. when the split middleware has already collected enough entries for a log query.
 func (in instance) For(
+	ctx context.Context,
 	queries []logql.DownstreamQuery,
-	fn func(logql.DownstreamQuery) (logql.Result, error),
+	fn func(context.Context, logql.DownstreamQuery) (logql.Result, error),
 ) ([]logql.Result, error) {
 	type resp struct {
 		i   int
@@ -81,8 +102,8 @@ func (in instance) For(
 		err error
 	}
 
-	done := make(chan struct{})
-	defer close(done)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	ch := make(chan resp)
 
@@ -90,16 +111,21 @@ func (in instance) For(
 	go func() {
 		for i := 0; i < len(queries); i++ {
 			select {
-			case <-done:
-				break
+			case <-ctx.Done():
+				return
 			case <-in.locks:
+				// the context may have been canceled while waiting for a lock.
+				if ctx.Err() != nil {
+					in.locks <- struct{}{}
+					return
+				}
 				go func(i int) {
 					// release lock back into pool
 					defer func() {
 						in.locks <- struct{}{}
 					}()
 
-					res, err := fn(queries[i])
+					res, err := fn(ctx, queries[i])
 					response := resp{
 						i:   i,
 						res: res,
@@ -108,7 +134,7 @@ func (in instance) For(
 
 					// Feed the result into the channel unless the work has completed.
 					select {
-					case <-done:
+					case <-ctx.Done():
 					case ch <- response:
 					}
 				}(i)
@@ -118,11 +144,15 @@ func (in instance) For(
 
 	results := make([]logql.Result, len(queries))
 	for i := 0; i < len(queries); i++ {
-		resp := <-ch
-		if resp.err != nil {
-			return nil, resp.err
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case resp := <-ch:
+			if resp.err != nil {
+				return nil, resp.err
+			}
+			results[resp.i] = resp.res
 		}
-		results[resp.i] = resp.res
 	}
 	return results, nil
 
@@ -152,6 +182,37 @@ func sampleStreamToMatrix(streams []queryrange.SampleStream) parser.Value {
 	return xs
 }
 
+func sampleStreamToVector(streams []queryrange.SampleStream) parser.Value {
+	xs := make(promql.Vector, 0, len(streams))
+	for _, stream := range streams {
+		metric := make(labels.Labels, 0, len(stream.Labels))
+		for _, l := range stream.Labels {
+			metric = append(metric, labels.Label(l))
+		}
+		for _, sample := range stream.Samples {
+			xs = append(xs, promql.Sample{
+				Metric: metric,
+				Point: promql.Point{
+					T: sample.TimestampMs,
+					V: sample.Value,
+				},
+			})
+		}
+	}
+	return xs
+}
+
+func sampleStreamToScalar(streams []queryrange.SampleStream) parser.Value {
+	if len(streams) == 0 || len(streams[0].Samples) == 0 {
+		return promql.Scalar{}
+	}
+	sample := streams[0].Samples[0]
+	return promql.Scalar{
+		T: sample.TimestampMs,
+		V: sample.Value,
+	}
+}
+
 func ResponseToResult(resp queryrange.Response) (logql.Result, error) {
 	switch r := resp.(type) {
 	case *LokiResponse:
@@ -175,9 +236,18 @@ func ResponseToResult(resp queryrange.Response) (logql.Result, error) {
 			return logql.Result{}, fmt.Errorf("%s: %s", r.Response.ErrorType, r.Response.Error)
 		}
 
+		var data parser.Value
+		switch r.Response.Data.ResultType {
+		case loghttp.ResultTypeVector:
+			data = sampleStreamToVector(r.Response.Data.Result)
+		case loghttp.ResultTypeScalar:
+			data = sampleStreamToScalar(r.Response.Data.Result)
+		default:
+			data = sampleStreamToMatrix(r.Response.Data.Result)
+		}
 		return logql.Result{
 			Statistics: r.Statistics,
-			Data:       sampleStreamToMatrix(r.Response.Data.Result),
+			Data:       data,
 		}, nil
 
 	default:
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -220,7 +221,7 @@ func TestInstanceFor(t *testing.T) {
 	var ct int
 
 	// ensure we can execute queries that number more than the parallelism parameter
-	_, err := in.For(queries, func(_ logql.DownstreamQuery) (logql.Result, error) {
+	_, err := in.For(context.Background(), queries, func(_ context.Context, _ logql.DownstreamQuery) (logql.Result, error) {
 		mtx.Lock()
 		defer mtx.Unlock()
 		ct++
@@ -233,7 +234,7 @@ func TestInstanceFor(t *testing.T) {
 	// ensure an early error abandons the other queues queries
 	in = mkIn()
 	ct = 0
-	_, err = in.For(queries, func(_ logql.DownstreamQuery) (logql.Result, error) {
+	_, err = in.For(context.Background(), queries, func(_ context.Context, _ logql.DownstreamQuery) (logql.Result, error) {
 		mtx.Lock()
 		defer mtx.Unlock()
 		ct++
@@ -250,6 +251,7 @@ func TestInstanceFor(t *testing.T) {
 
 	in = mkIn()
 	results, err := in.For(
+		context.Background(),
 		[]logql.DownstreamQuery{
 			{
 				Shards: logql.Shards{
@@ -262,7 +264,7 @@ func TestInstanceFor(t *testing.T) {
 				},
 			},
 		},
-		func(qry logql.DownstreamQuery) (logql.Result, error) {
+		func(_ context.Context, qry logql.DownstreamQuery) (logql.Result, error) {
 
 			return logql.Result{
 				Data: logql.Streams{{
@@ -288,6 +290,45 @@ func TestInstanceFor(t *testing.T) {
 
 }
 
+func TestInstanceFor_Cancel(t *testing.T) {
+	in := DownstreamHandler{nil}.Downstreamer().(*instance)
+	queries := make([]logql.DownstreamQuery, in.parallelism+1)
+
+	// queries still running are canceled once one of them fails.
+	var started, canceled int32
+	_, err := in.For(context.Background(), queries, func(ctx context.Context, _ logql.DownstreamQuery) (logql.Result, error) {
+		if atomic.AddInt32(&started, 1) == int32(in.parallelism) {
+			return logql.Result{}, errors.New("testerr")
+		}
+		<-ctx.Done()
+		atomic.AddInt32(&canceled, 1)
+		return logql.Result{}, ctx.Err()
+	})
+	require.EqualError(t, err, "testerr")
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&canceled) >= int32(in.parallelism-1)
+	}, time.Second, time.Millisecond)
+	ensureParallelism(t, in, in.parallelism)
+
+	// queries are canceled with their context, the remaining ones aren't dispatched.
+	in = DownstreamHandler{nil}.Downstreamer().(*instance)
+	ctx, cancel := context.WithCancel(context.Background())
+	started = 0
+	_, err = in.For(ctx, queries, func(ctx context.Context, _ logql.DownstreamQuery) (logql.Result, error) {
+		if atomic.AddInt32(&started, 1) == int32(in.parallelism) {
+			cancel()
+		}
+		<-ctx.Done()
+		return logql.Result{}, ctx.Err()
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Eventually(t, func() bool {
+		return len(in.locks) == in.parallelism
+	}, time.Second, time.Millisecond)
+	ensureParallelism(t, in, in.parallelism)
+	require.Equal(t, int32(in.parallelism), atomic.LoadInt32(&started))
+}
+
 func TestInstanceDownstream(t *testing.T) {
 	params := logql.NewLiteralParams(
 		"",
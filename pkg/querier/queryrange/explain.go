@@ -0,0 +1,157 @@
+package queryrange
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	json "github.com/json-iterator/go"
+	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/logql"
+	"github.com/grafana/loki/pkg/logql/explain"
+)
+
+// explainResponse is the response of an explained query.
+type explainResponse struct {
+	Status string        `json:"status"`
+	Data   *explain.Node `json:"data"`
+}
+
+// explainMode returns whether the query of a request is explained and, if so, whether it is analyzed.
+// Analyzing a query executes it to collect the statistics of each step of the plan.
+func explainMode(req *http.Request) (explained bool, analyze bool, err error) {
+	for _, p := range []struct {
+		name string
+		v    *bool
+	}{{"explain", &explained}, {"analyze", &analyze}} {
+		s := req.Form.Get(p.name)
+		if s == "" {
+			continue
+		}
+		*p.v, err = strconv.ParseBool(s)
+		if err != nil {
+			return false, false, httpgrpc.Errorf(http.StatusBadRequest, "invalid %s parameter: %s", p.name, err)
+		}
+	}
+	return explained || analyze, analyze, nil
+}
+
+// newPlanNode creates a plan node for a request.
+func newPlanNode(typ string, r queryrange.Request) *explain.Node {
+	return &explain.Node{
+		Type:  typ,
+		Query: r.GetQuery(),
+		Start: TimeFromMillis(r.GetStart()),
+		End:   TimeFromMillis(r.GetEnd()),
+	}
+}
+
+// ExplainMiddleware records the downstream queries in the plan of explained queries.
+// When the query is only planned, downstream queries are not executed and an empty response is returned instead.
+func ExplainMiddleware(queryIngestersWithin time.Duration) queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+			parent := explain.FromContext(ctx)
+			if parent == nil {
+				return next.Do(ctx, r)
+			}
+			node := newPlanNode(explain.TypeDownstream, r)
+			if req, ok := r.(*LokiRequest); ok {
+				node.Shards = req.Shards
+			}
+			// queriers only query ingesters for the recent part of the data, but always query the store.
+			if queryIngestersWithin == 0 || !node.End.Before(time.Now().Add(-queryIngestersWithin)) {
+				node.Sources = append(node.Sources, explain.SourceIngesters)
+			}
+			node.Sources = append(node.Sources, explain.SourceStore)
+			parent.AddChild(node)
+
+			if explain.DryRun(ctx) {
+				return emptyResponse(r)
+			}
+			resp, err := next.Do(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			switch res := resp.(type) {
+			case *LokiResponse:
+				node.SetStats(res.Statistics)
+			case *LokiPromResponse:
+				node.SetStats(res.Statistics)
+			}
+			return resp, nil
+		})
+	})
+}
+
+// skipOnDryRun bypasses a middleware for queries that are only planned,
+// for instance to not cache their empty responses.
+func skipOnDryRun(m queryrange.Middleware) queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		wrapped := m.Wrap(next)
+		return queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+			if explain.DryRun(ctx) {
+				return next.Do(ctx, r)
+			}
+			return wrapped.Do(ctx, r)
+		})
+	})
+}
+
+// emptyResponse returns an empty response of the type expected for a request, which may be a downstream query.
+func emptyResponse(r queryrange.Request) (queryrange.Response, error) {
+	req, ok := r.(*LokiRequest)
+	if !ok {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "unknown request type")
+	}
+	expr, err := logql.ParseExprWithOptions(req.Query, logql.ParseOptions{Downstream: true})
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	if _, ok := expr.(logql.SampleExpr); ok {
+		return &LokiPromResponse{
+			Response: &queryrange.PrometheusResponse{
+				Status: loghttp.QueryStatusSuccess,
+				Data: queryrange.PrometheusData{
+					ResultType: loghttp.ResultTypeMatrix,
+					Result:     []queryrange.SampleStream{},
+				},
+			},
+		}, nil
+	}
+	return &LokiResponse{
+		Status:    loghttp.QueryStatusSuccess,
+		Direction: req.Direction,
+		Limit:     req.Limit,
+		Version:   uint32(loghttp.GetVersion(req.Path)),
+		Data: LokiData{
+			ResultType: loghttp.ResultTypeStream,
+			Result:     []logproto.Stream{},
+		},
+	}, nil
+}
+
+// encodeExplainResponse encodes the plan of an explained query.
+func encodeExplainResponse(root *explain.Node) (*http.Response, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(explainResponse{
+		Status: loghttp.QueryStatusSuccess,
+		Data:   root.Snapshot(),
+	}); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+		Body:       ioutil.NopCloser(&buf),
+		StatusCode: http.StatusOK,
+	}, nil
+}
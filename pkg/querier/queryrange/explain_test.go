@@ -0,0 +1,217 @@
+package queryrange
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log"
+	json "github.com/json-iterator/go"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/logql/explain"
+)
+
+func Test_explainMode(t *testing.T) {
+	for _, tc := range []struct {
+		query     string
+		explained bool
+		analyze   bool
+		err       bool
+	}{
+		{"", false, false, false},
+		{"explain=true", true, false, false},
+		{"explain=false", false, false, false},
+		{"analyze=true", true, true, false},
+		{"explain=true&analyze=1", true, true, false},
+		{"explain=foo", false, false, true},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/loki/api/v1/query_range?"+tc.query, nil)
+			require.NoError(t, err)
+			require.NoError(t, req.ParseForm())
+
+			explained, analyze, err := explainMode(req)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.explained, explained)
+			require.Equal(t, tc.analyze, analyze)
+		})
+	}
+}
+
+func Test_astMapper_Explain(t *testing.T) {
+	called := 0
+	handler := queryrange.HandlerFunc(func(ctx context.Context, req queryrange.Request) (queryrange.Response, error) {
+		called++
+		return lokiResps[0], nil
+	})
+
+	mware := newASTMapperware(
+		queryrange.ShardingConfigs{
+			chunk.PeriodConfig{
+				RowShards: 2,
+			},
+		},
+		ExplainMiddleware(0).Wrap(handler),
+		log.NewNopLogger(),
+		nilShardingMetrics,
+	)
+
+	root := &explain.Node{Type: explain.TypeQuery}
+	ctx := explain.NewContext(context.Background(), root, false)
+	_, err := mware.Do(ctx, defaultReq().WithQuery(`{food="bar"}`))
+	require.NoError(t, err)
+	// the query is only planned.
+	require.Equal(t, 0, called)
+
+	plan := root.Snapshot()
+	require.Len(t, plan.Children, 1)
+	sharding := plan.Children[0]
+	require.Equal(t, explain.TypeSharding, sharding.Type)
+	require.Equal(t, `downstream<{food="bar"}, shard=0_of_2> ++ downstream<{food="bar"}, shard=1_of_2>`, sharding.Query)
+	require.Len(t, sharding.Children, 2)
+	for i, shard := range []string{"0_of_2", "1_of_2"} {
+		leaf := sharding.Children[i]
+		require.Equal(t, explain.TypeDownstream, leaf.Type)
+		require.Equal(t, `{food="bar"}`, leaf.Query)
+		require.Equal(t, []string{shard}, leaf.Shards)
+		require.Equal(t, []string{explain.SourceIngesters, explain.SourceStore}, leaf.Sources)
+		require.Nil(t, leaf.Stats)
+	}
+}
+
+func TestExplainTripperware(t *testing.T) {
+	tpw, stopper, err := NewTripperware(testConfig, util.Logger, fakeLimits{}, chunk.SchemaConfig{}, 0, nil)
+	if stopper != nil {
+		defer stopper.Stop()
+	}
+	require.NoError(t, err)
+	rt, err := newfakeRoundTripper()
+	require.NoError(t, err)
+	defer rt.Close()
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	explainRequest := func(lreq *LokiRequest, param string) *http.Request {
+		req, err := lokiCodec.EncodeRequest(ctx, lreq)
+		require.NoError(t, err)
+		if param != "" {
+			params := req.URL.Query()
+			params.Set(param, "true")
+			req.URL.RawQuery = params.Encode()
+		}
+		req = req.WithContext(ctx)
+		require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+		return req
+	}
+	decodePlan := func(resp *http.Response) *explain.Node {
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		var res explainResponse
+		require.NoError(t, json.Unmarshal(body, &res))
+		require.Equal(t, "success", res.Status)
+		return res.Data
+	}
+
+	metricReq := &LokiRequest{
+		Query:     `rate({app="foo"} |= "foo"[1m])`,
+		Limit:     1000,
+		Step:      30000, //30sec
+		StartTs:   testTime.Add(-6 * time.Hour),
+		EndTs:     testTime,
+		Direction: logproto.FORWARD,
+		Path:      "/query_range",
+	}
+
+	// explained queries are not executed.
+	count, h := staticResult(`{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+	rt.setHandler(h)
+	resp, err := tpw(rt).RoundTrip(explainRequest(metricReq, "explain"))
+	require.NoError(t, err)
+	require.Equal(t, 0, *count)
+
+	plan := decodePlan(resp)
+	require.Equal(t, explain.TypeQuery, plan.Type)
+	require.Equal(t, metricReq.Query, plan.Query)
+	require.Nil(t, plan.Stats)
+	require.Len(t, plan.Children, 1)
+	split := plan.Children[0]
+	require.Equal(t, explain.TypeSplitByInterval, split.Type)
+	require.Len(t, split.Children, 2)
+	for _, interval := range split.Children {
+		require.Equal(t, explain.TypeInterval, interval.Type)
+		require.Len(t, interval.Children, 1)
+		require.Equal(t, explain.TypeDownstream, interval.Children[0].Type)
+		require.Equal(t, interval.Start, interval.Children[0].Start)
+		require.Equal(t, interval.End, interval.Children[0].End)
+	}
+
+	// empty responses of explained queries are not cached.
+	_, err = tpw(rt).RoundTrip(explainRequest(metricReq, ""))
+	require.NoError(t, err)
+	require.Equal(t, 2, *count)
+
+	// analyzed queries are executed and report statistics.
+	count, h = staticResult(`{"status":"success","data":{"resultType":"streams","result":[],"stats":{"store":{"totalChunksRef":1}}}}`)
+	rt.setHandler(h)
+	resp, err = tpw(rt).RoundTrip(explainRequest(&LokiRequest{
+		Query:     `{app="foo"} |= "foo"`,
+		Limit:     1000,
+		StartTs:   testTime.Add(-6 * time.Hour),
+		EndTs:     testTime,
+		Direction: logproto.FORWARD,
+		Path:      "/loki/api/v1/query_range",
+	}, "analyze"))
+	require.NoError(t, err)
+	require.Equal(t, 2, *count)
+
+	plan = decodePlan(resp)
+	require.Equal(t, int64(2), plan.Stats.Store.TotalChunksRef)
+	require.Len(t, plan.Children, 1)
+	require.Len(t, plan.Children[0].Children, 2)
+	for _, interval := range plan.Children[0].Children {
+		require.Equal(t, int64(1), interval.Stats.Store.TotalChunksRef)
+		require.Equal(t, int64(1), interval.Children[0].Stats.Store.TotalChunksRef)
+	}
+
+	// queries which are not split are recorded too.
+	count, h = staticResult(`{"status":"success","data":{"resultType":"streams","result":[]}}`)
+	rt.setHandler(h)
+	resp, err = tpw(rt).RoundTrip(explainRequest(&LokiRequest{
+		Query:     `{app="foo"}`,
+		Limit:     1000,
+		StartTs:   testTime.Add(-6 * time.Hour),
+		EndTs:     testTime,
+		Direction: logproto.FORWARD,
+		Path:      "/loki/api/v1/query_range",
+	}, "explain"))
+	require.NoError(t, err)
+	require.Equal(t, 0, *count)
+
+	plan = decodePlan(resp)
+	require.Len(t, plan.Children, 1)
+	require.Equal(t, explain.TypeDownstream, plan.Children[0].Type)
+	require.Equal(t, `{app="foo"}`, plan.Children[0].Query)
+}
+
+func staticResult(body string) (*int, http.Handler) {
+	count := 0
+	var lock sync.Mutex
+	return &count, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		_, _ = w.Write([]byte(body))
+		count++
+	})
+}
@@ -0,0 +1,117 @@
+package queryrange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/util/spanlogger"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/grafana/loki/pkg/logql"
+	"github.com/grafana/loki/pkg/logql/marshal"
+)
+
+// NewInstantQueryMiddleware creates a middleware parallelising instant metric queries.
+// Ranges larger than the split interval of the tenant are split into sub-ranges whose results are combined,
+// e.g. the counts of the sub-ranges of a count_over_time are summed.
+// When sharding configurations are given, the sub-ranges older than minShardingLookback are sharded too.
+func NewInstantQueryMiddleware(
+	logger log.Logger,
+	limits Limits,
+	confs queryrange.ShardingConfigs,
+	minShardingLookback time.Duration,
+	metrics *logql.ShardingMetrics,
+) queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return &instantQuery{
+			logger:              log.With(logger, "middleware", "InstantQuery"),
+			limits:              limits,
+			confs:               confs,
+			minShardingLookback: minShardingLookback,
+			next:                next,
+			ng:                  logql.NewShardedEngine(logql.EngineOpts{}, DownstreamHandler{next}, metrics),
+			now:                 time.Now,
+		}
+	})
+}
+
+type instantQuery struct {
+	logger              log.Logger
+	limits              Limits
+	confs               queryrange.ShardingConfigs
+	minShardingLookback time.Duration
+	next                queryrange.Handler
+	ng                  *logql.ShardedEngine
+	now                 func() time.Time // injectable time.Now
+}
+
+func (q *instantQuery) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	req, ok := r.(*LokiRequest)
+	if !ok {
+		return q.next.Do(ctx, r)
+	}
+
+	splitRange := q.limits.QuerySplitDuration(userID)
+	var shards int
+	if conf, err := q.confs.GetConf(r); err == nil {
+		shards = int(conf.RowShards)
+	}
+	if shards < 2 && splitRange == 0 {
+		return q.next.Do(ctx, r)
+	}
+	// data more recent than the cutoff may still be in the ingesters, which don't support shards.
+	minShardingOffset := req.EndTs.Sub(q.now().Add(-q.minShardingLookback))
+	if minShardingOffset < 0 {
+		minShardingOffset = 0
+	}
+
+	shardedLog, ctx := spanlogger.New(ctx, "instantQuery")
+	defer shardedLog.Finish()
+	level.Debug(q.logger).Log("shards", shards, "split_range", splitRange, "min_sharding_offset", minShardingOffset)
+
+	query := q.ng.InstantQuery(paramsFromRequest(req), shards, splitRange, minShardingOffset)
+	res, err := query.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := marshal.NewResultValue(res.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var result queryrange.PrometheusData
+	switch res.Data.Type() {
+	case parser.ValueTypeVector:
+		result = queryrange.PrometheusData{
+			ResultType: loghttp.ResultTypeVector,
+			Result:     vectorToProto(value.(loghttp.Vector)),
+		}
+	case parser.ValueTypeScalar:
+		result = queryrange.PrometheusData{
+			ResultType: loghttp.ResultTypeScalar,
+			Result:     scalarToProto(value.(loghttp.Scalar)),
+		}
+	default:
+		return nil, fmt.Errorf("unexpected instant query result type (%T)", res.Data)
+	}
+	return &LokiPromResponse{
+		Response: &queryrange.PrometheusResponse{
+			Status: loghttp.QueryStatusSuccess,
+			Data:   result,
+		},
+		Statistics: res.Statistics,
+	}, nil
+}
@@ -0,0 +1,144 @@
+package queryrange
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+func Test_instantQuery(t *testing.T) {
+	now := time.Unix(0, 0).Add(30 * 24 * time.Hour)
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	for _, tc := range []struct {
+		name     string
+		query    string
+		ts       time.Time
+		split    time.Duration
+		confs    queryrange.ShardingConfigs
+		expected []*LokiRequest
+	}{
+		{
+			name:  "not split",
+			query: `sum by (app) (count_over_time({app="foo"}[3h]))`,
+			ts:    now,
+			expected: []*LokiRequest{
+				{Query: `sum by (app) (count_over_time({app="foo"}[3h]))`},
+			},
+		},
+		{
+			name:  "split",
+			query: `sum by (app) (count_over_time({app="foo"}[3h]))`,
+			ts:    now,
+			split: time.Hour,
+			expected: []*LokiRequest{
+				{Query: `sum by(app)(count_over_time(({app="foo"})[1h]))`},
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3600000000001ns] offset 3599999999999ns))`},
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3600000000001ns] offset 7199999999999ns))`},
+			},
+		},
+		{
+			name:  "sub-ranges older than the sharding lookback are sharded",
+			query: `sum by (app) (count_over_time({app="foo"}[3h]))`,
+			ts:    now,
+			split: time.Hour,
+			confs: queryrange.ShardingConfigs{chunk.PeriodConfig{RowShards: 2}},
+			expected: []*LokiRequest{
+				{Query: `sum by(app)(count_over_time(({app="foo"})[1h]))`},
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3600000000001ns] offset 3599999999999ns))`, Shards: []string{"0_of_2"}},
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3600000000001ns] offset 3599999999999ns))`, Shards: []string{"1_of_2"}},
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3600000000001ns] offset 7199999999999ns))`, Shards: []string{"0_of_2"}},
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3600000000001ns] offset 7199999999999ns))`, Shards: []string{"1_of_2"}},
+			},
+		},
+		{
+			name:  "sharded",
+			query: `sum by (app) (count_over_time({app="foo"}[3h]))`,
+			ts:    now.Add(-24 * time.Hour),
+			confs: queryrange.ShardingConfigs{chunk.PeriodConfig{RowShards: 2}},
+			expected: []*LokiRequest{
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3h]))`, Shards: []string{"0_of_2"}},
+				{Query: `sum by(app)(count_over_time(({app="foo"})[3h]))`, Shards: []string{"1_of_2"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				lock     sync.Mutex
+				received []*LokiRequest
+			)
+			handler := queryrange.HandlerFunc(func(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+				lock.Lock()
+				defer lock.Unlock()
+				// the expressions of the split or sharded queries are parsed with the downstream syntax.
+				require.Equal(t, tc.split != 0 || len(tc.confs) > 0, isDownstream(ctx))
+				received = append(received, r.(*LokiRequest))
+				return &LokiPromResponse{
+					Response: &queryrange.PrometheusResponse{
+						Status: loghttp.QueryStatusSuccess,
+						Data: queryrange.PrometheusData{
+							ResultType: loghttp.ResultTypeVector,
+							Result: []queryrange.SampleStream{{
+								Labels:  []client.LabelAdapter{{Name: "app", Value: "foo"}},
+								Samples: []client.Sample{{Value: 1, TimestampMs: TimeToMillis(tc.ts)}},
+							}},
+						},
+					},
+				}, nil
+			})
+			mware := NewInstantQueryMiddleware(
+				log.NewNopLogger(),
+				fakeLimits{splits: map[string]time.Duration{"1": tc.split}},
+				tc.confs,
+				time.Hour,
+				nilShardingMetrics,
+			).Wrap(handler)
+			if q, ok := mware.(*instantQuery); ok {
+				q.now = func() time.Time { return now }
+			}
+
+			req := &LokiRequest{
+				Query:     tc.query,
+				Limit:     100,
+				StartTs:   tc.ts,
+				EndTs:     tc.ts,
+				Direction: logproto.BACKWARD,
+				Path:      "/loki/api/v1/query",
+			}
+			resp, err := mware.Do(ctx, req)
+			require.NoError(t, err)
+
+			// downstream queries are executed in parallel.
+			require.Len(t, received, len(tc.expected))
+			var expected, actual []string
+			for i := range tc.expected {
+				expected = append(expected, fmt.Sprintf("%s %v", tc.expected[i].Query, tc.expected[i].Shards))
+				actual = append(actual, fmt.Sprintf("%s %v", received[i].Query, received[i].Shards))
+				require.Equal(t, tc.ts, received[i].StartTs)
+				require.Equal(t, tc.ts, received[i].EndTs)
+				require.Equal(t, "/loki/api/v1/query", received[i].Path)
+			}
+			require.ElementsMatch(t, expected, actual)
+
+			// each downstream query counts one entry.
+			res := resp.(*LokiPromResponse).Response
+			require.Equal(t, loghttp.ResultTypeVector, res.Data.ResultType)
+			require.Equal(t, []queryrange.SampleStream{{
+				Labels:  []client.LabelAdapter{{Name: "app", Value: "foo"}},
+				Samples: []client.Sample{{Value: float64(len(tc.expected)), TimestampMs: TimeToMillis(tc.ts)}},
+			}}, res.Data.Result)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package queryrange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/grafana/loki/pkg/logql/stats"
+)
+
+// LogResultCacheMiddleware creates a new Middleware caching the responses of log queries.
+// It must be used after the SplitByIntervalMiddleware: only requests covering a whole split interval are cached,
+// they are keyed by the query, the interval, the limit and the direction.
+// Since each split request is executed with the limit of the original request, a cached response can be
+// merged with the other splits of any request with the same query, limit and direction.
+func LogResultCacheMiddleware(logger log.Logger, limits Limits, c cache.Cache) queryrange.Middleware {
+	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
+		return &logResultCache{
+			next:   next,
+			limits: limits,
+			cache:  c,
+			logger: logger,
+		}
+	})
+}
+
+type logResultCache struct {
+	next   queryrange.Handler
+	limits Limits
+	cache  cache.Cache
+	logger log.Logger
+}
+
+func (l *logResultCache) Do(ctx context.Context, r queryrange.Request) (queryrange.Response, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+	}
+	req, ok := r.(*LokiRequest)
+	if !ok {
+		return l.next.Do(ctx, r)
+	}
+	interval := l.limits.QuerySplitDuration(userID)
+	if interval == 0 || !isWholeInterval(req, interval) {
+		return l.next.Do(ctx, r)
+	}
+	// recent data may still be ingested.
+	if req.EndTs.After(time.Now().Add(-l.limits.MaxCacheFreshness(userID))) {
+		return l.next.Do(ctx, r)
+	}
+
+	key := logResultCacheKey(userID, req, interval)
+	if cached, ok := l.get(ctx, key); ok {
+		return cached, nil
+	}
+
+	resp, err := l.next.Do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if res, ok := resp.(*LokiResponse); ok && res.Status == loghttp.QueryStatusSuccess {
+		l.put(ctx, key, req, res)
+	}
+	return resp, nil
+}
+
+// isWholeInterval returns true if the request covers exactly one split interval.
+func isWholeInterval(req *LokiRequest, interval time.Duration) bool {
+	return req.StartTs.UnixNano()%int64(interval) == 0 && req.EndTs.Sub(req.StartTs) == interval
+}
+
+func logResultCacheKey(userID string, req *LokiRequest, interval time.Duration) string {
+	return fmt.Sprintf("log:%s:%s:%d:%s:%d:%d", userID, req.Query, req.Limit, req.Direction, interval, req.StartTs.UnixNano()/int64(interval))
+}
+
+func (l *logResultCache) get(ctx context.Context, key string) (*LokiResponse, bool) {
+	found, bufs, _ := l.cache.Fetch(ctx, []string{cache.HashKey(key)})
+	if len(found) != 1 {
+		return nil, false
+	}
+
+	var cached queryrange.CachedResponse
+	if err := proto.Unmarshal(bufs[0], &cached); err != nil {
+		level.Error(l.logger).Log("msg", "error unmarshalling cached log response", "err", err)
+		return nil, false
+	}
+	// the key is hashed, make sure it is not a collision.
+	if cached.Key != key || len(cached.Extents) != 1 || cached.Extents[0].Response == nil {
+		return nil, false
+	}
+
+	var resp LokiResponse
+	if err := types.UnmarshalAny(cached.Extents[0].Response, &resp); err != nil {
+		level.Error(l.logger).Log("msg", "error unmarshalling cached log response", "err", err)
+		return nil, false
+	}
+	return &resp, true
+}
+
+func (l *logResultCache) put(ctx context.Context, key string, req *LokiRequest, res *LokiResponse) {
+	// statistics are only meaningful for the request which has been executed.
+	cached := *res
+	cached.Statistics = stats.Result{}
+
+	any, err := types.MarshalAny(&cached)
+	if err != nil {
+		level.Error(l.logger).Log("msg", "error marshalling log response", "err", err)
+		return
+	}
+	buf, err := proto.Marshal(&queryrange.CachedResponse{
+		Key: key,
+		Extents: []queryrange.Extent{{
+			Start:    req.GetStart(),
+			End:      req.GetEnd(),
+			Response: any,
+		}},
+	})
+	if err != nil {
+		level.Error(l.logger).Log("msg", "error marshalling cached log response", "err", err)
+		return
+	}
+	l.cache.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
+}
@@ -0,0 +1,91 @@
+package queryrange
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/grafana/loki/pkg/loghttp"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/logql/stats"
+)
+
+func Test_LogResultCache(t *testing.T) {
+	var (
+		called   int
+		ctx      = user.InjectOrgID(context.Background(), "1")
+		interval = time.Hour
+		start    = time.Now().Truncate(interval).Add(-2 * interval)
+		lim      = fakeLimits{splits: map[string]time.Duration{"1": interval}}
+	)
+	resp := &LokiResponse{
+		Status:     loghttp.QueryStatusSuccess,
+		Direction:  logproto.FORWARD,
+		Limit:      100,
+		Version:    uint32(loghttp.VersionV1),
+		Statistics: stats.Result{Summary: stats.Summary{TotalLinesProcessed: 10}},
+		Data: LokiData{
+			ResultType: loghttp.ResultTypeStream,
+			Result: []logproto.Stream{
+				{
+					Labels:  `{app="foo"}`,
+					Entries: []logproto.Entry{{Timestamp: start.Add(time.Minute), Line: "foo"}},
+				},
+			},
+		},
+	}
+	h := LogResultCacheMiddleware(util.Logger, lim, cache.NewMockCache()).Wrap(
+		queryrange.HandlerFunc(func(ctx context.Context, req queryrange.Request) (queryrange.Response, error) {
+			called++
+			return resp, nil
+		}))
+	req := func(start, end time.Time, limit uint32) *LokiRequest {
+		return &LokiRequest{
+			Query:     `{app="foo"} |= "foo"`,
+			Limit:     limit,
+			StartTs:   start,
+			EndTs:     end,
+			Direction: logproto.FORWARD,
+			Path:      "/loki/api/v1/query_range",
+		}
+	}
+
+	// whole intervals are cached.
+	res, err := h.Do(ctx, req(start, start.Add(interval), 100))
+	require.NoError(t, err)
+	require.Equal(t, resp, res)
+	require.Equal(t, 1, called)
+
+	res, err = h.Do(ctx, req(start, start.Add(interval), 100))
+	require.NoError(t, err)
+	require.Equal(t, 1, called)
+	require.Equal(t, resp.Data, res.(*LokiResponse).Data)
+	// statistics are not cached.
+	require.Equal(t, stats.Result{}, res.(*LokiResponse).Statistics)
+
+	// the limit is part of the key.
+	_, err = h.Do(ctx, req(start, start.Add(interval), 10))
+	require.NoError(t, err)
+	require.Equal(t, 2, called)
+
+	// partial intervals are not cached.
+	for i := 0; i < 2; i++ {
+		_, err = h.Do(ctx, req(start.Add(time.Minute), start.Add(interval), 100))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, called)
+
+	// recent intervals are not cached.
+	recent := time.Now().Truncate(interval)
+	for i := 0; i < 2; i++ {
+		_, err = h.Do(ctx, req(recent, recent.Add(interval), 100))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 6, called)
+}
@@ -6,11 +6,14 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/querier/queryrange"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/common/model"
 
+	"github.com/grafana/loki/pkg/loghttp"
 	"github.com/grafana/loki/pkg/logql/stats"
 )
 
@@ -45,7 +48,8 @@ func (p *LokiPromResponse) encode(ctx context.Context) (*http.Response, error) {
 	b, err := jsonStd.Marshal(struct {
 		Status string `json:"status"`
 		Data   struct {
-			queryrange.PrometheusData
+			ResultType string       `json:"resultType"`
+			Result     interface{}  `json:"result"`
 			Statistics stats.Result `json:"stats"`
 		} `json:"data,omitempty"`
 		ErrorType string `json:"errorType,omitempty"`
@@ -53,11 +57,13 @@ func (p *LokiPromResponse) encode(ctx context.Context) (*http.Response, error) {
 	}{
 		Error: p.Response.Error,
 		Data: struct {
-			queryrange.PrometheusData
+			ResultType string       `json:"resultType"`
+			Result     interface{}  `json:"result"`
 			Statistics stats.Result `json:"stats"`
 		}{
-			PrometheusData: p.Response.Data,
-			Statistics:     p.Statistics,
+			ResultType: p.Response.Data.ResultType,
+			Result:     p.result(),
+			Statistics: p.Statistics,
 		},
 		ErrorType: p.Response.ErrorType,
 		Status:    p.Response.Status,
@@ -79,3 +85,34 @@ func (p *LokiPromResponse) encode(ctx context.Context) (*http.Response, error) {
 	}
 	return &resp, nil
 }
+
+// result returns the result of the response in the format of the Prometheus API.
+// Vectors and scalars are stored as sample streams of a single sample.
+func (p *LokiPromResponse) result() interface{} {
+	streams := p.Response.Data.Result
+	switch p.Response.Data.ResultType {
+	case loghttp.ResultTypeVector:
+		vector := make(loghttp.Vector, 0, len(streams))
+		for _, stream := range streams {
+			for _, s := range stream.Samples {
+				vector = append(vector, model.Sample{
+					Metric:    client.FromLabelAdaptersToMetric(stream.Labels),
+					Timestamp: model.Time(s.TimestampMs),
+					Value:     model.SampleValue(s.Value),
+				})
+			}
+		}
+		return vector
+	case loghttp.ResultTypeScalar:
+		if len(streams) == 0 || len(streams[0].Samples) == 0 {
+			return nil
+		}
+		s := streams[0].Samples[0]
+		return model.Scalar{
+			Timestamp: model.Time(s.TimestampMs),
+			Value:     model.SampleValue(s.Value),
+		}
+	default:
+		return streams
+	}
+}
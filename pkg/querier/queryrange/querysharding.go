@@ -13,6 +13,7 @@ import (
 
 	"github.com/grafana/loki/pkg/loghttp"
 	"github.com/grafana/loki/pkg/logql"
+	"github.com/grafana/loki/pkg/logql/explain"
 	"github.com/grafana/loki/pkg/logql/marshal"
 )
 
@@ -93,6 +94,9 @@ func (ast *astMapperware) Do(ctx context.Context, r queryrange.Request) (queryra
 	if !ok {
 		return nil, fmt.Errorf("expected *LokiRequest, got (%T)", r)
 	}
+	// the sharded engine records the mapped query in the plan.
+	ctx = explain.WithNode(ctx, explain.FromContext(ctx).AddChild(newPlanNode(explain.TypeSharding, req)))
+
 	params := paramsFromRequest(req)
 	query := ast.ng.Query(params, int(conf.RowShards))
 
@@ -3,6 +3,8 @@ package queryrange
 import (
 	"errors"
 	"flag"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
@@ -20,6 +22,7 @@ import (
 
 	"github.com/grafana/loki/pkg/loghttp"
 	"github.com/grafana/loki/pkg/logql"
+	"github.com/grafana/loki/pkg/logql/explain"
 )
 
 // Config is the configuration for the queryrange tripperware
@@ -50,16 +53,27 @@ func NewTripperware(
 	// This avoids divide by zero errors when determining cache keys where user specific overrides don't exist.
 	limits = WithDefaultLimits(limits, cfg.Config)
 
+	var c cache.Cache
+	if cfg.CacheResults {
+		// the results cache is shared by metric and log queries.
+		var err error
+		c, err = cache.New(cfg.CacheConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.CacheConfig.Cache = c
+	}
+
 	instrumentMetrics := queryrange.NewInstrumentMiddlewareMetrics(registerer)
 	retryMetrics := queryrange.NewRetryMiddlewareMetrics(registerer)
 	shardingMetrics := logql.NewShardingMetrics(registerer)
 	splitByMetrics := NewSplitByMetrics(registerer)
 
-	metricsTripperware, cache, err := NewMetricTripperware(cfg, log, limits, schema, minShardingLookback, lokiCodec, PrometheusExtractor{}, instrumentMetrics, retryMetrics, shardingMetrics, splitByMetrics)
+	metricsTripperware, _, err := NewMetricTripperware(cfg, log, limits, schema, minShardingLookback, lokiCodec, PrometheusExtractor{}, instrumentMetrics, retryMetrics, shardingMetrics, splitByMetrics)
 	if err != nil {
 		return nil, nil, err
 	}
-	logFilterTripperware, err := NewLogFilterTripperware(cfg, log, limits, schema, minShardingLookback, lokiCodec, instrumentMetrics, retryMetrics, shardingMetrics, splitByMetrics)
+	logFilterTripperware, err := NewLogFilterTripperware(cfg, log, limits, schema, minShardingLookback, lokiCodec, c, instrumentMetrics, retryMetrics, shardingMetrics, splitByMetrics)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -73,24 +87,28 @@ func NewTripperware(
 		metricRT := metricsTripperware(next)
 		logFilterRT := logFilterTripperware(next)
 		seriesRT := seriesTripperware(next)
-		return newRoundTripper(next, logFilterRT, metricRT, seriesRT, limits)
-	}, cache, nil
+		explainRT := queryrange.NewRoundTripper(next, lokiCodec, ExplainMiddleware(minShardingLookback))
+		return newRoundTripper(next, logFilterRT, metricRT, seriesRT, explainRT, limits)
+	}, c, nil
 }
 
 type roundTripper struct {
 	next, log, metric, series http.RoundTripper
+	// explain records the queries sent as is to the queriers in the plan of explained queries.
+	explain http.RoundTripper
 
 	limits Limits
 }
 
 // newRoundTripper creates a new queryrange roundtripper
-func newRoundTripper(next, log, metric, series http.RoundTripper, limits Limits) roundTripper {
+func newRoundTripper(next, log, metric, series, explain http.RoundTripper, limits Limits) roundTripper {
 	return roundTripper{
-		log:    log,
-		limits: limits,
-		metric: metric,
-		series: series,
-		next:   next,
+		log:     log,
+		limits:  limits,
+		metric:  metric,
+		series:  series,
+		explain: explain,
+		next:    next,
 	}
 }
 
@@ -110,9 +128,27 @@ func (r roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		if err != nil {
 			return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
 		}
+		explained, analyze, err := explainMode(req)
+		if err != nil {
+			return nil, err
+		}
+		next := r.next
+		var plan *explain.Node
+		if explained {
+			plan = &explain.Node{
+				Type:  explain.TypeQuery,
+				Query: rangeQuery.Query,
+				Start: rangeQuery.Start,
+				End:   rangeQuery.End,
+			}
+			req = req.WithContext(explain.NewContext(req.Context(), plan, analyze))
+			// queries sent as is to the queriers are still recorded in the plan.
+			next = r.explain
+		}
+		var rt http.RoundTripper
 		switch e := expr.(type) {
 		case logql.SampleExpr:
-			return r.metric.RoundTrip(req)
+			rt = r.metric
 		case logql.LogSelectorExpr:
 			filter, err := transformRegexQuery(req, e).Filter()
 			if err != nil {
@@ -121,14 +157,32 @@ func (r roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 			if err := validateLimits(req, rangeQuery.Limit, r.limits); err != nil {
 				return nil, err
 			}
-			if filter == nil {
-				return r.next.RoundTrip(req)
+			rt = r.log
+			// queries with stages such as distinct can't be split.
+			if filter == nil || logql.HasMergedStages(e) {
+				rt = next
 			}
-			return r.log.RoundTrip(req)
-
 		default:
-			return r.next.RoundTrip(req)
+			rt = next
+		}
+		if plan == nil {
+			return rt.RoundTrip(req)
+		}
+		return roundTripExplain(rt, req, plan)
+	case InstantQueryOp:
+		instantQuery, err := loghttp.ParseInstantQuery(req)
+		if err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+		}
+		expr, err := logql.ParseExpr(instantQuery.Query)
+		if err != nil {
+			return nil, httpgrpc.Errorf(http.StatusBadRequest, err.Error())
+		}
+		// only the ranges of metric queries are split.
+		if _, ok := expr.(logql.SampleExpr); ok {
+			return r.metric.RoundTrip(req)
 		}
+		return r.next.RoundTrip(req)
 	case SeriesOp:
 		_, err := loghttp.ParseSeriesQuery(req)
 		if err != nil {
@@ -140,6 +194,20 @@ func (r roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 }
 
+// roundTripExplain executes an explained request and replaces its response by the plan of the query.
+func roundTripExplain(rt http.RoundTripper, req *http.Request, plan *explain.Node) (*http.Response, error) {
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return resp, nil
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return encodeExplainResponse(plan)
+}
+
 // transformRegexQuery backport the old regexp params into the v1 query format
 func transformRegexQuery(req *http.Request, expr logql.LogSelectorExpr) logql.LogSelectorExpr {
 	regexp := req.Form.Get("regexp")
@@ -171,13 +239,16 @@ func validateLimits(req *http.Request, reqLimit uint32, limits Limits) error {
 }
 
 const (
-	QueryRangeOp = "query_range"
-	SeriesOp     = "series"
+	QueryRangeOp   = "query_range"
+	InstantQueryOp = "query"
+	SeriesOp       = "series"
 )
 
 func getOperation(req *http.Request) string {
 	if strings.HasSuffix(req.URL.Path, "/query_range") || strings.HasSuffix(req.URL.Path, "/prom/query") {
 		return QueryRangeOp
+	} else if strings.HasSuffix(req.URL.Path, "/v1/query") {
+		return InstantQueryOp
 	} else if strings.HasSuffix(req.URL.Path, "/series") {
 		return SeriesOp
 	} else {
@@ -193,6 +264,7 @@ func NewLogFilterTripperware(
 	schema chunk.SchemaConfig,
 	minShardingLookback time.Duration,
 	codec queryrange.Codec,
+	c cache.Cache,
 	instrumentMetrics *queryrange.InstrumentMiddlewareMetrics,
 	retryMiddlewareMetrics *queryrange.RetryMiddlewareMetrics,
 	shardingMetrics *logql.ShardingMetrics,
@@ -200,7 +272,15 @@ func NewLogFilterTripperware(
 ) (frontend.Tripperware, error) {
 	queryRangeMiddleware := []queryrange.Middleware{StatsCollectorMiddleware(), queryrange.LimitsMiddleware(limits)}
 	if cfg.SplitQueriesByInterval != 0 {
-		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("split_by_interval", instrumentMetrics), SplitByIntervalMiddleware(limits, codec, splitByMetrics))
+		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("split_by_interval", instrumentMetrics), SplitByIntervalMiddleware(limits, codec, c, splitByMetrics))
+		if c != nil {
+			queryRangeMiddleware = append(
+				queryRangeMiddleware,
+				queryrange.InstrumentMiddleware("log_results_cache", instrumentMetrics),
+				// responses of queries that are only planned are empty and must not be cached.
+				skipOnDryRun(LogResultCacheMiddleware(log, limits, c)),
+			)
+		}
 	}
 
 	if cfg.ShardedQueries {
@@ -218,6 +298,8 @@ func NewLogFilterTripperware(
 		)
 	}
 
+	queryRangeMiddleware = append(queryRangeMiddleware, ExplainMiddleware(minShardingLookback))
+
 	if cfg.MaxRetries > 0 {
 		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("retry", instrumentMetrics), queryrange.NewRetryMiddleware(log, cfg.MaxRetries, retryMiddlewareMetrics))
 	}
@@ -242,7 +324,7 @@ func NewSeriesTripperware(
 ) (frontend.Tripperware, error) {
 	queryRangeMiddleware := []queryrange.Middleware{}
 	if cfg.SplitQueriesByInterval != 0 {
-		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("split_by_interval", instrumentMetrics), SplitByIntervalMiddleware(limits, codec, splitByMetrics))
+		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("split_by_interval", instrumentMetrics), SplitByIntervalMiddleware(limits, codec, nil, splitByMetrics))
 	}
 	if cfg.MaxRetries > 0 {
 		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("retry", instrumentMetrics), queryrange.NewRetryMiddleware(log, cfg.MaxRetries, retryMiddlewareMetrics))
@@ -287,7 +369,7 @@ func NewMetricTripperware(
 	queryRangeMiddleware = append(
 		queryRangeMiddleware,
 		queryrange.InstrumentMiddleware("split_by_interval", instrumentMetrics),
-		SplitByIntervalMiddleware(limits, codec, splitByMetrics),
+		SplitByIntervalMiddleware(limits, codec, nil, splitByMetrics),
 	)
 
 	var c cache.Cache
@@ -308,7 +390,8 @@ func NewMetricTripperware(
 		queryRangeMiddleware = append(
 			queryRangeMiddleware,
 			queryrange.InstrumentMiddleware("results_cache", instrumentMetrics),
-			queryCacheMiddleware,
+			// responses of queries that are only planned are empty and must not be cached.
+			skipOnDryRun(queryCacheMiddleware),
 		)
 	}
 
@@ -327,25 +410,40 @@ func NewMetricTripperware(
 		)
 	}
 
+	queryRangeMiddleware = append(queryRangeMiddleware, ExplainMiddleware(minShardingLookback))
+
+	// instant queries are sharded with the same configurations as range queries.
+	var shardingConfigs queryrange.ShardingConfigs
+	if cfg.ShardedQueries {
+		shardingConfigs = schema.Configs
+	}
+	instantQueryMiddleware := []queryrange.Middleware{
+		StatsCollectorMiddleware(),
+		queryrange.InstrumentMiddleware("instant_query", instrumentMetrics),
+		NewInstantQueryMiddleware(log, limits, shardingConfigs, minShardingLookback, shardingMetrics),
+	}
+
 	if cfg.MaxRetries > 0 {
-		queryRangeMiddleware = append(
-			queryRangeMiddleware,
+		retry := []queryrange.Middleware{
 			queryrange.InstrumentMiddleware("retry", instrumentMetrics),
 			queryrange.NewRetryMiddleware(log, cfg.MaxRetries, retryMiddlewareMetrics),
-		)
+		}
+		queryRangeMiddleware = append(queryRangeMiddleware, retry...)
+		instantQueryMiddleware = append(instantQueryMiddleware, retry...)
 	}
 
 	return func(next http.RoundTripper) http.RoundTripper {
-		// Finally, if the user selected any query range middleware, stitch it in.
-		if len(queryRangeMiddleware) > 0 {
-			rt := queryrange.NewRoundTripper(next, codec, queryRangeMiddleware...)
-			return frontend.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
-				if !strings.HasSuffix(r.URL.Path, "/query_range") {
-					return next.RoundTrip(r)
-				}
+		rt := queryrange.NewRoundTripper(next, codec, queryRangeMiddleware...)
+		instantRT := queryrange.NewRoundTripper(next, codec, instantQueryMiddleware...)
+		return frontend.RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/query_range"):
 				return rt.RoundTrip(r)
-			})
-		}
-		return next
+			case getOperation(r) == InstantQueryOp:
+				return instantRT.RoundTrip(r)
+			default:
+				return next.RoundTrip(r)
+			}
+		})
 	}, c, nil
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/querier/frontend"
 	"github.com/cortexproject/cortex/pkg/querier/queryrange"
 	"github.com/cortexproject/cortex/pkg/util"
@@ -302,6 +303,53 @@ func TestUnhandledPath(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestInstantQueryTripperware(t *testing.T) {
+	tpw, stopper, err := NewTripperware(testConfig, util.Logger, fakeLimits{}, chunk.SchemaConfig{}, 0, nil)
+	if stopper != nil {
+		defer stopper.Stop()
+	}
+	require.NoError(t, err)
+	rt, err := newfakeRoundTripper()
+	require.NoError(t, err)
+	defer rt.Close()
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	instantRequest := func(query string) *http.Request {
+		req, err := lokiCodec.EncodeRequest(ctx, &LokiRequest{
+			Query:     query,
+			Limit:     1000,
+			StartTs:   testTime,
+			EndTs:     testTime,
+			Direction: logproto.FORWARD,
+			Path:      "/loki/api/v1/query",
+		})
+		require.NoError(t, err)
+		req = req.WithContext(ctx)
+		require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+		return req
+	}
+
+	// the range is split by the 4h split interval and the counts of the sub-ranges are summed.
+	count, h := staticResult(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"app":"foo"},"value":[1568404331.324,"2"]}]}}`)
+	rt.setHandler(h)
+	resp, err := tpw(rt).RoundTrip(instantRequest(`sum by (app) (count_over_time({app="foo"}[12h]))`))
+	require.NoError(t, err)
+	require.Equal(t, 3, *count)
+	res, err := lokiCodec.DecodeResponse(ctx, resp, nil)
+	require.NoError(t, err)
+	require.Equal(t, []queryrange.SampleStream{{
+		Labels:  []client.LabelAdapter{{Name: "app", Value: "foo"}},
+		Samples: []client.Sample{{Value: 6, TimestampMs: toMs(testTime)}},
+	}}, res.(*LokiPromResponse).Response.Data.Result)
+
+	// log queries are not split.
+	count, h = staticResult(`{"status":"success","data":{"resultType":"streams","result":[]}}`)
+	rt.setHandler(h)
+	_, err = tpw(rt).RoundTrip(instantRequest(`{app="foo"}`))
+	require.NoError(t, err)
+	require.Equal(t, 1, *count)
+}
+
 func TestRegexpParamsSupport(t *testing.T) {
 	tpw, stopper, err := NewTripperware(testConfig, util.Logger, fakeLimits{}, chunk.SchemaConfig{}, 0, nil)
 	if stopper != nil {
@@ -372,6 +420,10 @@ func TestPostQueries(t *testing.T) {
 			t.Error("unexpected series roundtripper called")
 			return nil, nil
 		}),
+		frontend.RoundTripFunc(func(*http.Request) (*http.Response, error) {
+			t.Error("unexpected explain roundtripper called")
+			return nil, nil
+		}),
 		fakeLimits{},
 	).RoundTrip(req)
 	require.NoError(t, err)
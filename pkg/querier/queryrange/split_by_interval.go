@@ -2,10 +2,15 @@ package queryrange
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
 	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
 	"github.com/prometheus/client_golang/prometheus"
@@ -13,12 +18,16 @@ import (
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/user"
 
+	"github.com/grafana/loki/pkg/loghttp"
 	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/logql"
+	"github.com/grafana/loki/pkg/logql/explain"
 )
 
 type lokiResult struct {
-	req queryrange.Request
-	ch  chan *packedResp
+	req  queryrange.Request
+	ch   chan *packedResp
+	plan *explain.Node // nil if the query isn't explained.
 }
 
 type packedResp struct {
@@ -27,7 +36,8 @@ type packedResp struct {
 }
 
 type SplitByMetrics struct {
-	splits prometheus.Histogram
+	splits         prometheus.Histogram
+	emptyIntervals prometheus.Counter
 }
 
 func NewSplitByMetrics(r prometheus.Registerer) *SplitByMetrics {
@@ -38,6 +48,11 @@ func NewSplitByMetrics(r prometheus.Registerer) *SplitByMetrics {
 			Help:      "Number of time-based partitions (sub-requests) per request",
 			Buckets:   prometheus.ExponentialBuckets(1, 4, 5), // 1 -> 1024
 		}),
+		emptyIntervals: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Namespace: "loki",
+			Name:      "query_frontend_empty_intervals_skipped_total",
+			Help:      "Number of sub-requests skipped because their interval is known to have no entries",
+		}),
 	}
 }
 
@@ -45,16 +60,20 @@ type splitByInterval struct {
 	next    queryrange.Handler
 	limits  Limits
 	merger  queryrange.Merger
+	cache   cache.Cache
 	metrics *SplitByMetrics
 }
 
 // SplitByIntervalMiddleware creates a new Middleware that splits log requests by a given interval.
-func SplitByIntervalMiddleware(limits Limits, merger queryrange.Merger, metrics *SplitByMetrics) queryrange.Middleware {
+// When a cache is given, the intervals in which a log query returned no entries are remembered
+// and are not queried again by subsequent requests with the same query.
+func SplitByIntervalMiddleware(limits Limits, merger queryrange.Merger, c cache.Cache, metrics *SplitByMetrics) queryrange.Middleware {
 	return queryrange.MiddlewareFunc(func(next queryrange.Handler) queryrange.Handler {
 		return &splitByInterval{
 			next:    next,
 			limits:  limits,
 			merger:  merger,
+			cache:   c,
 			metrics: metrics,
 		}
 	})
@@ -140,7 +159,7 @@ func (h *splitByInterval) loop(ctx context.Context, ch <-chan *lokiResult) {
 		sp, ctx := opentracing.StartSpanFromContext(ctx, "interval")
 		queryrange.LogToSpan(ctx, data.req)
 
-		resp, err := h.next.Do(ctx, data.req)
+		resp, err := h.next.Do(explain.WithNode(ctx, data.plan), data.req)
 
 		select {
 		case <-ctx.Done():
@@ -195,11 +214,29 @@ func (h *splitByInterval) Do(ctx context.Context, r queryrange.Request) (queryra
 		return nil, httpgrpc.Errorf(http.StatusBadRequest, "unknown request type")
 	}
 
+	var empty map[string][]queryrange.Extent
+	if h.cache != nil && isLogQuery(r.GetQuery()) {
+		empty = h.fetchEmpty(ctx, userid, interval, intervals)
+	}
+
+	plan := explain.FromContext(ctx).AddChild(newPlanNode(explain.TypeSplitByInterval, r))
 	input := make([]*lokiResult, 0, len(intervals))
-	for _, interval := range intervals {
+	var skipped []queryrange.Response
+	for _, req := range intervals {
+		node := plan.AddChild(newPlanNode(explain.TypeInterval, req))
+		if isWithin(req, empty[emptyCacheKey(userid, req, interval)]) {
+			resp, err := emptyResponse(req)
+			if err != nil {
+				return nil, err
+			}
+			h.metrics.emptyIntervals.Inc()
+			skipped = append(skipped, resp)
+			continue
+		}
 		input = append(input, &lokiResult{
-			req: interval,
-			ch:  make(chan *packedResp),
+			req:  req,
+			ch:   make(chan *packedResp),
+			plan: node,
 		})
 	}
 
@@ -207,16 +244,152 @@ func (h *splitByInterval) Do(ctx context.Context, r queryrange.Request) (queryra
 	if err != nil {
 		return nil, err
 	}
-	return h.merger.MergeResponse(resps...)
+	if empty != nil && !explain.DryRun(ctx) {
+		h.storeEmpty(ctx, userid, interval, input, resps)
+	}
+	return h.merger.MergeResponse(append(resps, skipped...)...)
+}
+
+// emptyCacheKey returns the key under which the time ranges without entries of a log query are cached.
+// Log queries are split on multiples of the interval, there is one key per interval.
+// The limit and the direction are not part of the key: an empty range is empty regardless of them.
+func emptyCacheKey(userID string, req queryrange.Request, interval time.Duration) string {
+	bucket := req.GetStart() * int64(time.Millisecond) / int64(interval)
+	return fmt.Sprintf("empty:%s:%s:%d:%d", userID, req.GetQuery(), interval, bucket)
+}
+
+// fetchEmpty returns the time ranges known to have no entries for each interval, keyed by emptyCacheKey.
+func (h *splitByInterval) fetchEmpty(ctx context.Context, userID string, interval time.Duration, reqs []queryrange.Request) map[string][]queryrange.Extent {
+	keys := make([]string, 0, len(reqs))
+	hashed := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		key := emptyCacheKey(userID, req, interval)
+		keys = append(keys, key)
+		hashed = append(hashed, cache.HashKey(key))
+	}
+
+	found, bufs, _ := h.cache.Fetch(ctx, hashed)
+	bufsByKey := make(map[string][]byte, len(found))
+	for i := range found {
+		bufsByKey[found[i]] = bufs[i]
+	}
+
+	empty := make(map[string][]queryrange.Extent, len(keys))
+	for i, key := range keys {
+		buf, ok := bufsByKey[hashed[i]]
+		if !ok {
+			continue
+		}
+		var cached queryrange.CachedResponse
+		if err := proto.Unmarshal(buf, &cached); err != nil {
+			level.Error(util.Logger).Log("msg", "error unmarshalling cached empty intervals", "err", err)
+			continue
+		}
+		// the key is hashed, make sure it is not a collision.
+		if cached.Key != key {
+			continue
+		}
+		empty[key] = cached.Extents
+	}
+	return empty
+}
+
+// storeEmpty records the time ranges of the successful requests which returned no entries.
+// Recent ranges are not recorded since data may still be ingested.
+// The cached ranges are fetched again just before being updated, to keep the ones stored by
+// concurrent queries meanwhile. The cache has no compare-and-swap: a range stored between
+// the fetch and the store may still be overwritten, it's then only queried again.
+func (h *splitByInterval) storeEmpty(
+	ctx context.Context,
+	userID string,
+	interval time.Duration,
+	input []*lokiResult,
+	resps []queryrange.Response,
+) {
+	freshness := TimeToMillis(time.Now().Add(-h.limits.MaxCacheFreshness(userID)))
+
+	var reqs []queryrange.Request
+	for i, resp := range resps {
+		res, ok := resp.(*LokiResponse)
+		if !ok || res.Status != loghttp.QueryStatusSuccess || res.Count() != 0 {
+			continue
+		}
+		if req := input[i].req; req.GetEnd() <= freshness {
+			reqs = append(reqs, req)
+		}
+	}
+	if len(reqs) == 0 {
+		return
+	}
+
+	empty := h.fetchEmpty(ctx, userID, interval, reqs)
+	var keys []string
+	var bufs [][]byte
+	for _, req := range reqs {
+		key := emptyCacheKey(userID, req, interval)
+		extents := mergeExtent(empty[key], queryrange.Extent{Start: req.GetStart(), End: req.GetEnd()})
+		buf, err := proto.Marshal(&queryrange.CachedResponse{Key: key, Extents: extents})
+		if err != nil {
+			level.Error(util.Logger).Log("msg", "error marshalling cached empty intervals", "err", err)
+			continue
+		}
+		keys = append(keys, cache.HashKey(key))
+		bufs = append(bufs, buf)
+	}
+	if len(keys) > 0 {
+		h.cache.Store(ctx, keys, bufs)
+	}
+}
+
+// isWithin returns true if the time range of the request is included in one of the extents.
+func isWithin(req queryrange.Request, extents []queryrange.Extent) bool {
+	for _, e := range extents {
+		if req.GetStart() >= e.Start && req.GetEnd() <= e.End {
+			return true
+		}
+	}
+	return false
 }
 
+// mergeExtent adds an extent to a list of sorted, non overlapping extents.
+func mergeExtent(extents []queryrange.Extent, extent queryrange.Extent) []queryrange.Extent {
+	merged := make([]queryrange.Extent, 0, len(extents)+1)
+	for _, e := range extents {
+		switch {
+		case e.End < extent.Start:
+			merged = append(merged, e)
+		case extent.End < e.Start:
+			merged = append(merged, extent)
+			extent = e
+		default:
+			if e.Start < extent.Start {
+				extent.Start = e.Start
+			}
+			if e.End > extent.End {
+				extent.End = e.End
+			}
+		}
+	}
+	return append(merged, extent)
+}
+
+// splitByTime splits a request into sub-requests of at most interval.
+// Splits are made on the evaluation time range, range modifiers such as `offset` are kept in the
+// query of each sub-request and applied by the querier.
+// Subqueries are not split either: their steps are aligned on the absolute time by the querier,
+// so each sub-request evaluates them at the same timestamps as the original request would.
 func splitByTime(req queryrange.Request, interval time.Duration) []queryrange.Request {
 	var reqs []queryrange.Request
 
 	switch r := req.(type) {
 	case *LokiRequest:
-		for start := r.StartTs; start.Before(r.EndTs); start = start.Add(interval) {
+		// log queries are split on multiples of the interval so that the responses of whole intervals can be cached.
+		aligned := isLogQuery(r.Query)
+		for start := r.StartTs; start.Before(r.EndTs); {
 			end := start.Add(interval)
+			if aligned {
+				end = start.Add(interval - time.Duration(start.UnixNano()%int64(interval)))
+			}
 			if end.After(r.EndTs) {
 				end = r.EndTs
 			}
@@ -229,6 +402,7 @@ func splitByTime(req queryrange.Request, interval time.Duration) []queryrange.Re
 				StartTs:   start,
 				EndTs:     end,
 			})
+			start = end
 		}
 		return reqs
 	case *LokiSeriesRequest:
@@ -249,3 +423,13 @@ func splitByTime(req queryrange.Request, interval time.Duration) []queryrange.Re
 		return nil
 	}
 }
+
+// isLogQuery returns true if the query returns log lines.
+func isLogQuery(query string) bool {
+	expr, err := logql.ParseExpr(query)
+	if err != nil {
+		return false
+	}
+	_, ok := expr.(logql.LogSelectorExpr)
+	return ok
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
 	"github.com/cortexproject/cortex/pkg/querier/queryrange"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/user"
@@ -72,6 +73,80 @@ func Test_splitQuery(t *testing.T) {
 				},
 			},
 		},
+		{
+			"2 intervals metric query with offset",
+			&LokiRequest{
+				Query:   `count_over_time({app="foo"}[5m] offset 1d)`,
+				Step:    60000,
+				StartTs: time.Date(2019, 12, 9, 12, 0, 0, 0, time.UTC),
+				EndTs:   time.Date(2019, 12, 9, 13, 30, 0, 0, time.UTC),
+			},
+			time.Hour,
+			[]queryrange.Request{
+				&LokiRequest{
+					Query:   `count_over_time({app="foo"}[5m] offset 1d)`,
+					Step:    60000,
+					StartTs: time.Date(2019, 12, 9, 12, 0, 0, 0, time.UTC),
+					EndTs:   time.Date(2019, 12, 9, 13, 0, 0, 0, time.UTC),
+				},
+				&LokiRequest{
+					Query:   `count_over_time({app="foo"}[5m] offset 1d)`,
+					Step:    60000,
+					StartTs: time.Date(2019, 12, 9, 13, 0, 0, 0, time.UTC),
+					EndTs:   time.Date(2019, 12, 9, 13, 30, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			"2 intervals metric query with subquery",
+			&LokiRequest{
+				Query:   `max_over_time(rate({app="foo"}[1m])[1h:1m])`,
+				Step:    60000,
+				StartTs: time.Date(2019, 12, 9, 12, 0, 0, 0, time.UTC),
+				EndTs:   time.Date(2019, 12, 9, 13, 30, 0, 0, time.UTC),
+			},
+			time.Hour,
+			[]queryrange.Request{
+				&LokiRequest{
+					Query:   `max_over_time(rate({app="foo"}[1m])[1h:1m])`,
+					Step:    60000,
+					StartTs: time.Date(2019, 12, 9, 12, 0, 0, 0, time.UTC),
+					EndTs:   time.Date(2019, 12, 9, 13, 0, 0, 0, time.UTC),
+				},
+				&LokiRequest{
+					Query:   `max_over_time(rate({app="foo"}[1m])[1h:1m])`,
+					Step:    60000,
+					StartTs: time.Date(2019, 12, 9, 13, 0, 0, 0, time.UTC),
+					EndTs:   time.Date(2019, 12, 9, 13, 30, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			"3 intervals log query aligned on the interval",
+			&LokiRequest{
+				Query:   `{app="foo"} |= "bar"`,
+				StartTs: time.Date(2019, 12, 9, 12, 30, 0, 0, time.UTC),
+				EndTs:   time.Date(2019, 12, 9, 14, 15, 0, 0, time.UTC),
+			},
+			time.Hour,
+			[]queryrange.Request{
+				&LokiRequest{
+					Query:   `{app="foo"} |= "bar"`,
+					StartTs: time.Date(2019, 12, 9, 12, 30, 0, 0, time.UTC),
+					EndTs:   time.Date(2019, 12, 9, 13, 0, 0, 0, time.UTC),
+				},
+				&LokiRequest{
+					Query:   `{app="foo"} |= "bar"`,
+					StartTs: time.Date(2019, 12, 9, 13, 0, 0, 0, time.UTC),
+					EndTs:   time.Date(2019, 12, 9, 14, 0, 0, 0, time.UTC),
+				},
+				&LokiRequest{
+					Query:   `{app="foo"} |= "bar"`,
+					StartTs: time.Date(2019, 12, 9, 14, 0, 0, 0, time.UTC),
+					EndTs:   time.Date(2019, 12, 9, 14, 15, 0, 0, time.UTC),
+				},
+			},
+		},
 		{
 			"3 intervals series",
 			&LokiSeriesRequest{
@@ -129,6 +204,7 @@ func Test_splitByInterval_Do(t *testing.T) {
 	split := SplitByIntervalMiddleware(
 		l,
 		lokiCodec,
+		nil,
 		nilMetrics,
 	).Wrap(next)
 
@@ -297,6 +373,7 @@ func Test_series_splitByInterval_Do(t *testing.T) {
 	split := SplitByIntervalMiddleware(
 		l,
 		lokiCodec,
+		nil,
 		nilMetrics,
 	).Wrap(next)
 
@@ -378,6 +455,7 @@ func Test_ExitEarly(t *testing.T) {
 	split := SplitByIntervalMiddleware(
 		l,
 		lokiCodec,
+		nil,
 		nilMetrics,
 	).Wrap(next)
 
@@ -457,6 +535,7 @@ func Test_DoesntDeadlock(t *testing.T) {
 	split := SplitByIntervalMiddleware(
 		l,
 		lokiCodec,
+		nil,
 		nilMetrics,
 	).Wrap(next)
 
@@ -491,3 +570,163 @@ func Test_DoesntDeadlock(t *testing.T) {
 	require.LessOrEqual(t, endingGoroutines, startingGoroutines*101/100)
 
 }
+
+func Test_splitByInterval_EmptyIntervals(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		called int
+		base   = time.Now().Truncate(time.Hour).Add(-5 * time.Hour)
+		needle = base.Add(time.Hour)
+	)
+	next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		called++
+
+		req := r.(*LokiRequest)
+		resp := &LokiResponse{
+			Status:    loghttp.QueryStatusSuccess,
+			Direction: req.Direction,
+			Limit:     req.Limit,
+			Version:   uint32(loghttp.VersionV1),
+			Data: LokiData{
+				ResultType: loghttp.ResultTypeStream,
+				Result:     []logproto.Stream{},
+			},
+		}
+		if req.StartTs.Equal(needle) {
+			resp.Data.Result = append(resp.Data.Result, logproto.Stream{
+				Labels:  `{app="foo"}`,
+				Entries: []logproto.Entry{{Timestamp: needle, Line: "traceID=2612c3ff044b7d02"}},
+			})
+		}
+		return resp, nil
+	})
+
+	l := WithDefaultLimits(fakeLimits{splits: map[string]time.Duration{"1": time.Hour}}, queryrange.Config{})
+	split := SplitByIntervalMiddleware(
+		l,
+		lokiCodec,
+		cache.NewMockCache(),
+		nilMetrics,
+	).Wrap(next)
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	search := func(start, end time.Time, limit uint32, direction logproto.Direction) (int, int64) {
+		called = 0
+		res, err := split.Do(ctx, &LokiRequest{
+			Query:     `{app="foo"} |= "traceID=2612c3ff044b7d02"`,
+			Limit:     limit,
+			StartTs:   start,
+			EndTs:     end,
+			Direction: direction,
+			Path:      "/loki/api/v1/query_range",
+		})
+		require.NoError(t, err)
+		return called, res.(*LokiResponse).Count()
+	}
+	expect := func(calls int, start, end time.Time, limit uint32, direction logproto.Direction) {
+		called, count := search(start, end, limit, direction)
+		require.Equal(t, calls, called)
+		require.Equal(t, int64(1), count)
+	}
+
+	expect(3, base.Add(30*time.Minute), base.Add(3*time.Hour), 100, logproto.FORWARD)
+	// only the interval containing entries is queried again.
+	expect(1, base.Add(30*time.Minute), base.Add(3*time.Hour), 100, logproto.FORWARD)
+	// empty ranges are independent of the limit and the direction, and include their sub-ranges.
+	expect(1, base.Add(45*time.Minute), base.Add(150*time.Minute), 10, logproto.BACKWARD)
+	// ranges which are not known to be empty are queried.
+	expect(2, base.Add(15*time.Minute), base.Add(3*time.Hour), 100, logproto.FORWARD)
+	expect(2, base, base.Add(3*time.Hour), 100, logproto.FORWARD)
+	expect(1, base, base.Add(3*time.Hour), 100, logproto.FORWARD)
+
+	// recent empty ranges are not remembered.
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		called, count := search(now.Add(-30*time.Second), now, 100, logproto.FORWARD)
+		require.NotZero(t, called)
+		require.Zero(t, count)
+	}
+}
+
+func Test_splitByInterval_EmptyIntervalsConcurrentStore(t *testing.T) {
+	var (
+		split  queryrange.Handler
+		called int
+		nested bool
+		base   = time.Now().Truncate(time.Hour).Add(-5 * time.Hour)
+		ctx    = user.InjectOrgID(context.Background(), "1")
+	)
+	search := func(start, end time.Time) {
+		_, err := split.Do(ctx, &LokiRequest{
+			Query:     `{app="foo"} |= "bar"`,
+			Limit:     100,
+			StartTs:   start,
+			EndTs:     end,
+			Direction: logproto.FORWARD,
+			Path:      "/loki/api/v1/query_range",
+		})
+		require.NoError(t, err)
+	}
+	next := queryrange.HandlerFunc(func(_ context.Context, r queryrange.Request) (queryrange.Response, error) {
+		called++
+		// another query stores an empty range of the same interval meanwhile.
+		if !nested {
+			nested = true
+			search(base.Add(30*time.Minute), base.Add(time.Hour))
+		}
+		req := r.(*LokiRequest)
+		return &LokiResponse{
+			Status:    loghttp.QueryStatusSuccess,
+			Direction: req.Direction,
+			Limit:     req.Limit,
+			Version:   uint32(loghttp.VersionV1),
+			Data: LokiData{
+				ResultType: loghttp.ResultTypeStream,
+				Result:     []logproto.Stream{},
+			},
+		}, nil
+	})
+
+	l := WithDefaultLimits(fakeLimits{splits: map[string]time.Duration{"1": time.Hour}}, queryrange.Config{})
+	split = SplitByIntervalMiddleware(
+		l,
+		lokiCodec,
+		cache.NewMockCache(),
+		nilMetrics,
+	).Wrap(next)
+
+	search(base, base.Add(30*time.Minute))
+	require.Equal(t, 2, called)
+
+	// both ranges were kept.
+	called = 0
+	search(base, base.Add(time.Hour))
+	require.Equal(t, 0, called)
+}
+
+func Test_mergeExtent(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		extents  []queryrange.Extent
+		extent   queryrange.Extent
+		expected []queryrange.Extent
+	}{
+		{"empty", nil, queryrange.Extent{Start: 1, End: 2}, []queryrange.Extent{{Start: 1, End: 2}}},
+		{"before", []queryrange.Extent{{Start: 5, End: 6}}, queryrange.Extent{Start: 1, End: 2}, []queryrange.Extent{{Start: 1, End: 2}, {Start: 5, End: 6}}},
+		{"after", []queryrange.Extent{{Start: 1, End: 2}}, queryrange.Extent{Start: 5, End: 6}, []queryrange.Extent{{Start: 1, End: 2}, {Start: 5, End: 6}}},
+		{"adjacent", []queryrange.Extent{{Start: 1, End: 2}}, queryrange.Extent{Start: 2, End: 6}, []queryrange.Extent{{Start: 1, End: 6}}},
+		{"included", []queryrange.Extent{{Start: 1, End: 6}}, queryrange.Extent{Start: 2, End: 3}, []queryrange.Extent{{Start: 1, End: 6}}},
+		{
+			"bridging",
+			[]queryrange.Extent{{Start: 1, End: 2}, {Start: 4, End: 5}, {Start: 8, End: 9}},
+			queryrange.Extent{Start: 2, End: 4},
+			[]queryrange.Extent{{Start: 1, End: 5}, {Start: 8, End: 9}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, mergeExtent(tc.extents, tc.extent))
+		})
+	}
+}
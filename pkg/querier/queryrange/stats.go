@@ -20,7 +20,10 @@ import (
 
 type ctxKeyType string
 
-const ctxKey ctxKeyType = "stats"
+const (
+	ctxKey           ctxKeyType = "stats"
+	downstreamCtxKey ctxKeyType = "downstream"
+)
 
 var (
 	defaultMetricRecorder = metricRecorderFn(func(ctx context.Context, p logql.Params, status string, stats stats.Result) {
@@ -0,0 +1,157 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	ring_client "github.com/cortexproject/cortex/pkg/ring/client"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/httpgrpc/server"
+	"github.com/weaveworks/common/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// FrontendRoundTripper is the http.RoundTripper of the query frontends enqueuing
+// their requests in the schedulers found in the ring.
+type FrontendRoundTripper struct {
+	services.Service
+
+	ring *ring.Ring
+	pool *ring_client.Pool
+	next uint32
+
+	subservices        *services.Manager
+	subservicesWatcher *services.FailureWatcher
+}
+
+// NewFrontendRoundTripper makes a new FrontendRoundTripper.
+func NewFrontendRoundTripper(cfg Config, log log.Logger, registerer prometheus.Registerer) (*FrontendRoundTripper, error) {
+	r, err := newRing(cfg.SchedulerRing)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := cfg.GRPCClientConfig.DialOption([]grpc.UnaryClientInterceptor{middleware.ClientUserHeaderInterceptor}, nil)
+	if err != nil {
+		return nil, err
+	}
+	factory := func(addr string) (ring_client.PoolClient, error) {
+		conn, err := grpc.Dial(addr, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &schedulerPoolClient{
+			SchedulerClient: NewSchedulerClient(conn),
+			HealthClient:    grpc_health_v1.NewHealthClient(conn),
+			Closer:          conn,
+		}, nil
+	}
+	pool := ring_client.NewPool("scheduler", ring_client.PoolConfig{CheckInterval: 10 * time.Second}, ring_client.NewRingServiceDiscovery(r), factory,
+		promauto.With(registerer).NewGauge(prometheus.GaugeOpts{
+			Namespace: "loki",
+			Name:      "query_frontend_scheduler_clients",
+			Help:      "The current number of clients of the query frontend to the query schedulers.",
+		}), log)
+
+	rt := &FrontendRoundTripper{
+		ring: r,
+		pool: pool,
+	}
+	rt.subservices, err = services.NewManager(r, pool)
+	if err != nil {
+		return nil, errors.Wrap(err, "services manager")
+	}
+	rt.subservicesWatcher = services.NewFailureWatcher()
+	rt.subservicesWatcher.WatchManager(rt.subservices)
+	rt.Service = services.NewBasicService(rt.starting, rt.running, rt.stopping)
+	return rt, nil
+}
+
+type schedulerPoolClient struct {
+	SchedulerClient
+	grpc_health_v1.HealthClient
+	io.Closer
+}
+
+func (rt *FrontendRoundTripper) starting(ctx context.Context) error {
+	return services.StartManagerAndAwaitHealthy(ctx, rt.subservices)
+}
+
+func (rt *FrontendRoundTripper) running(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-rt.subservicesWatcher.Chan():
+		return errors.Wrap(err, "scheduler client subservice failed")
+	}
+}
+
+func (rt *FrontendRoundTripper) stopping(_ error) error {
+	return services.StopManagerAndAwaitStopped(context.Background(), rt.subservices)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *FrontendRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	req, err := server.HTTPRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.enqueue(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp := &http.Response{
+		StatusCode: int(resp.Code),
+		Body:       ioutil.NopCloser(bytes.NewReader(resp.Body)),
+		Header:     http.Header{},
+	}
+	for _, h := range resp.Headers {
+		httpResp.Header[h.Key] = h.Values
+	}
+	return httpResp, nil
+}
+
+// enqueue sends the request to the ACTIVE schedulers in turn, moving on to the
+// next one when a scheduler can't be reached or is stopping.
+func (rt *FrontendRoundTripper) enqueue(ctx context.Context, req *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error) {
+	rs, err := rt.ring.GetAll(ring.Write)
+	if err != nil {
+		return nil, err
+	}
+
+	start := atomic.AddUint32(&rt.next, 1)
+	for i := range rs.Ingesters {
+		instance := rs.Ingesters[(int(start)+i)%len(rs.Ingesters)]
+
+		var client ring_client.PoolClient
+		client, err = rt.pool.GetClientFor(instance.Addr)
+		if err != nil {
+			continue
+		}
+
+		var resp *httpgrpc.HTTPResponse
+		resp, err = client.(SchedulerClient).Enqueue(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		// Errors carrying an HTTP response come from the scheduler or the querier.
+		if _, ok := httpgrpc.HTTPResponseFromError(err); ok || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
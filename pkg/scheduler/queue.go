@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+)
+
+// queues holds the per tenant request queues. Each querier connection iterates
+// over the tenants in a round robin fashion, skipping the tenants whose requests
+// are not allowed to be executed by its querier.
+type queues struct {
+	userQueues map[string]*userQueue
+
+	// users is the round robin order of the tenants. The slot of a deleted
+	// tenant is left empty and reused by the next added one, so that the
+	// position of the other tenants doesn't change.
+	users []string
+
+	maxUserQueueSize int
+
+	// queriers counts the connections of each connected querier.
+	queriers map[string]int
+	// sortedQueriers are the IDs of the connected queriers, the tenants are
+	// shuffle sharded over them.
+	sortedQueriers []string
+}
+
+type userQueue struct {
+	ch chan *request
+
+	// queriers allowed to execute the requests of the tenant, nil when every
+	// querier is allowed to.
+	queriers    map[string]struct{}
+	maxQueriers int
+	seed        int64
+
+	// index of the tenant in queues.users.
+	index int
+}
+
+func newQueues(maxUserQueueSize int) *queues {
+	return &queues{
+		userQueues:       map[string]*userQueue{},
+		maxUserQueueSize: maxUserQueueSize,
+		queriers:         map[string]int{},
+	}
+}
+
+// len returns the number of tenants with queued requests.
+func (q *queues) len() int {
+	return len(q.userQueues)
+}
+
+// getOrAddQueue returns the queue of a tenant, creating it when needed. The
+// requests of the tenant are executed by at most maxQueriers queriers, 0 meaning
+// all of them.
+func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan *request {
+	if maxQueriers < 0 {
+		maxQueriers = 0
+	}
+
+	uq := q.userQueues[userID]
+	if uq == nil {
+		uq = &userQueue{
+			ch:    make(chan *request, q.maxUserQueueSize),
+			seed:  shuffleShardSeed(userID),
+			index: -1,
+		}
+		q.userQueues[userID] = uq
+
+		// Reuse the first free slot, otherwise add the tenant at the end.
+		for i, u := range q.users {
+			if u == "" {
+				uq.index = i
+				q.users[i] = userID
+				break
+			}
+		}
+		if uq.index < 0 {
+			uq.index = len(q.users)
+			q.users = append(q.users, userID)
+		}
+	}
+
+	if uq.maxQueriers != maxQueriers {
+		uq.maxQueriers = maxQueriers
+		uq.queriers = shuffleShard(uq.seed, q.sortedQueriers, maxQueriers)
+	}
+	return uq.ch
+}
+
+// getNextQueueForQuerier returns the queue of the first tenant after the
+// lastUserIndex one whose requests can be executed by the querier, and its index
+// to be passed on the next call. Pass -1 to start from the first tenant.
+func (q *queues) getNextQueueForQuerier(lastUserIndex int, querierID string) (chan *request, string, int) {
+	for iters := 0; iters < len(q.users); iters++ {
+		lastUserIndex++
+		if lastUserIndex >= len(q.users) {
+			lastUserIndex = 0
+		}
+
+		userID := q.users[lastUserIndex]
+		if userID == "" {
+			continue
+		}
+
+		uq := q.userQueues[userID]
+		if uq.queriers != nil {
+			if _, ok := uq.queriers[querierID]; !ok {
+				continue
+			}
+		}
+		return uq.ch, userID, lastUserIndex
+	}
+	return nil, "", lastUserIndex
+}
+
+func (q *queues) deleteQueue(userID string) {
+	uq := q.userQueues[userID]
+	if uq == nil {
+		return
+	}
+
+	delete(q.userQueues, userID)
+	q.users[uq.index] = ""
+
+	// Shrink the round robin order when its tail is free.
+	for len(q.users) > 0 && q.users[len(q.users)-1] == "" {
+		q.users = q.users[:len(q.users)-1]
+	}
+}
+
+func (q *queues) addQuerierConnection(querierID string) {
+	q.queriers[querierID]++
+	if q.queriers[querierID] == 1 {
+		q.sortedQueriers = append(q.sortedQueriers, querierID)
+		sort.Strings(q.sortedQueriers)
+		q.reshardUsers()
+	}
+}
+
+func (q *queues) removeQuerierConnection(querierID string) {
+	q.queriers[querierID]--
+	if q.queriers[querierID] > 0 {
+		return
+	}
+
+	delete(q.queriers, querierID)
+	i := sort.SearchStrings(q.sortedQueriers, querierID)
+	if i < len(q.sortedQueriers) && q.sortedQueriers[i] == querierID {
+		q.sortedQueriers = append(q.sortedQueriers[:i], q.sortedQueriers[i+1:]...)
+	}
+	q.reshardUsers()
+}
+
+// reshardUsers recomputes the queriers of every tenant after a querier connected
+// or disconnected.
+func (q *queues) reshardUsers() {
+	for _, uq := range q.userQueues {
+		uq.queriers = shuffleShard(uq.seed, q.sortedQueriers, uq.maxQueriers)
+	}
+}
+
+// shuffleShard picks n queriers out of the sorted ones, the same seed always
+// picking the same queriers as long as the connected queriers don't change.
+// It returns nil when every querier is to be used.
+func shuffleShard(seed int64, sortedQueriers []string, n int) map[string]struct{} {
+	if n <= 0 || n >= len(sortedQueriers) {
+		return nil
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	queriers := make(map[string]struct{}, n)
+	for _, i := range r.Perm(len(sortedQueriers))[:n] {
+		queriers[sortedQueriers[i]] = struct{}{}
+	}
+	return queriers
+}
+
+func shuffleShardSeed(userID string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(userID))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueues_RoundRobin(t *testing.T) {
+	q := newQueues(10)
+	q.addQuerierConnection("querier")
+	for _, userID := range []string{"a", "b", "c"} {
+		q.getOrAddQueue(userID, 0)
+	}
+
+	var (
+		users []string
+		last  = -1
+	)
+	for i := 0; i < 6; i++ {
+		queue, userID, idx := q.getNextQueueForQuerier(last, "querier")
+		require.NotNil(t, queue)
+		users = append(users, userID)
+		last = idx
+	}
+	require.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, users)
+
+	// the slot of a deleted tenant is reused by the next one.
+	q.deleteQueue("b")
+	q.getOrAddQueue("d", 0)
+	require.Equal(t, []string{"a", "d", "c"}, q.users)
+
+	q.deleteQueue("c")
+	require.Equal(t, []string{"a", "d"}, q.users)
+	require.Equal(t, 2, q.len())
+}
+
+func TestQueues_ShuffleSharding(t *testing.T) {
+	q := newQueues(10)
+	for i := 0; i < 5; i++ {
+		q.addQuerierConnection(fmt.Sprintf("querier-%d", i))
+	}
+	q.getOrAddQueue("limited", 2)
+	q.getOrAddQueue("unlimited", 0)
+
+	allowed := func(userID string) []string {
+		var queriers []string
+		for i := 0; i < 5; i++ {
+			querierID := fmt.Sprintf("querier-%d", i)
+			last := -1
+			for j := 0; j < q.len(); j++ {
+				queue, u, idx := q.getNextQueueForQuerier(last, querierID)
+				require.NotNil(t, queue)
+				if u == userID {
+					queriers = append(queriers, querierID)
+					break
+				}
+				last = idx
+			}
+		}
+		return queriers
+	}
+
+	require.Len(t, allowed("unlimited"), 5)
+	limited := allowed("limited")
+	require.Len(t, limited, 2)
+
+	// the same queriers are picked as long as the connected queriers don't change.
+	q.deleteQueue("limited")
+	q.getOrAddQueue("limited", 2)
+	require.Equal(t, limited, allowed("limited"))
+
+	// more connections of a querier don't change the sharding either.
+	q.addQuerierConnection("querier-0")
+	require.Equal(t, limited, allowed("limited"))
+
+	// a tenant is resharded when one of its queriers disconnects.
+	q.removeQuerierConnection(limited[1])
+	require.Len(t, q.sortedQueriers, 4)
+	require.Len(t, q.userQueues["limited"].queriers, 2)
+	require.NotContains(t, q.userQueues["limited"].queriers, limited[1])
+
+	// every querier is used when the limit is raised above the number of queriers.
+	q.getOrAddQueue("limited", 10)
+	require.Nil(t, q.userQueues["limited"].queriers)
+}
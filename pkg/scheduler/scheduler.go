@@ -0,0 +1,384 @@
+package scheduler
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// querierIDKey is the gRPC metadata key identifying the querier of a Process stream.
+const querierIDKey = "querier-id"
+
+var (
+	errTooManyRequest = httpgrpc.Errorf(http.StatusTooManyRequests, "too many outstanding requests")
+	// errSchedulerStopping is not an httpgrpc error, query frontends retry it on another scheduler.
+	errSchedulerStopping = status.Error(codes.Unavailable, "scheduler is stopping")
+)
+
+// Config for a Scheduler.
+type Config struct {
+	MaxOutstandingPerTenant int `yaml:"max_outstanding_requests_per_tenant"`
+
+	// UseSchedulerRing makes the query frontends and the queriers go through the
+	// schedulers registered in the ring instead of talking to each other.
+	UseSchedulerRing bool       `yaml:"use_scheduler_ring"`
+	SchedulerRing    RingConfig `yaml:"scheduler_ring"`
+
+	// GRPCClientConfig configures the clients of the query frontends to the schedulers.
+	GRPCClientConfig grpcclient.ConfigWithTLS `yaml:"grpc_client_config"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxOutstandingPerTenant, "query-scheduler.max-outstanding-requests-per-tenant", 100, "Maximum number of outstanding requests per tenant per query scheduler; requests beyond this error with HTTP 429.")
+	f.BoolVar(&cfg.UseSchedulerRing, "query-scheduler.use-scheduler-ring", false, "Enqueue the queries of the query frontends in the query schedulers found in the ring, and make the queriers execute the queries of these query schedulers.")
+	cfg.SchedulerRing.RegisterFlags(f)
+	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("query-scheduler.grpc-client-config", f)
+}
+
+// Limits needed by the Scheduler.
+type Limits interface {
+	// MaxQueriersPerUser returns the number of queriers which can execute the
+	// requests of a tenant, 0 meaning all of them.
+	MaxQueriersPerUser(userID string) int
+}
+
+// Scheduler holds the queries enqueued by the query frontends in per tenant
+// queues, and dispatches them fairly to the connected queriers.
+type Scheduler struct {
+	services.Service
+
+	cfg    Config
+	limits Limits
+	log    log.Logger
+
+	mtx      sync.Mutex
+	cond     *sync.Cond
+	queues   *queues
+	stopping bool
+
+	lifecycler        *ring.Lifecycler
+	lifecyclerWatcher *services.FailureWatcher
+
+	// Metrics.
+	queueDuration     prometheus.Histogram
+	queueLength       *prometheus.GaugeVec
+	connectedQueriers prometheus.Gauge
+}
+
+type request struct {
+	enqueueTime time.Time
+	queueSpan   opentracing.Span
+	originalCtx context.Context
+
+	request  *frontend.ProcessRequest
+	err      chan error
+	response chan *frontend.ProcessResponse
+}
+
+// New creates a new Scheduler, registering itself in the schedulers ring.
+func New(cfg Config, limits Limits, log log.Logger, registerer prometheus.Registerer) (*Scheduler, error) {
+	lifecycler, err := ring.NewLifecycler(cfg.SchedulerRing.ToLifecyclerConfig(), nil, ringName, RingKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scheduler{
+		cfg:               cfg,
+		limits:            limits,
+		log:               log,
+		queues:            newQueues(cfg.MaxOutstandingPerTenant),
+		lifecycler:        lifecycler,
+		lifecyclerWatcher: services.NewFailureWatcher(),
+		queueDuration: promauto.With(registerer).NewHistogram(prometheus.HistogramOpts{
+			Namespace: "loki",
+			Name:      "query_scheduler_queue_duration_seconds",
+			Help:      "Time spent by requests queued in the query scheduler.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		queueLength: promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "loki",
+			Name:      "query_scheduler_queue_length",
+			Help:      "Number of queries queued in the query scheduler per tenant.",
+		}, []string{"tenant"}),
+		connectedQueriers: promauto.With(registerer).NewGauge(prometheus.GaugeOpts{
+			Namespace: "loki",
+			Name:      "query_scheduler_connected_queriers",
+			Help:      "Number of queriers connected to the query scheduler.",
+		}),
+	}
+	s.cond = sync.NewCond(&s.mtx)
+	s.lifecyclerWatcher.WatchService(lifecycler)
+	s.Service = services.NewBasicService(s.starting, s.running, s.stop)
+
+	return s, nil
+}
+
+func (s *Scheduler) starting(ctx context.Context) error {
+	return services.StartAndAwaitRunning(ctx, s.lifecycler)
+}
+
+func (s *Scheduler) running(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-s.lifecyclerWatcher.Chan():
+		return errors.Wrap(err, "scheduler lifecycler failed")
+	}
+}
+
+// stop leaves the ring, so that the query frontends stop enqueuing requests,
+// and waits for the queued requests to be dispatched to the queriers.
+func (s *Scheduler) stop(_ error) error {
+	err := services.StopAndAwaitTerminated(context.Background(), s.lifecycler)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stopping = true
+	for s.queues.len() > 0 {
+		s.cond.Wait()
+	}
+	return err
+}
+
+type httpgrpcHeadersCarrier httpgrpc.HTTPRequest
+
+func (c *httpgrpcHeadersCarrier) Set(key, val string) {
+	c.Headers = append(c.Headers, &httpgrpc.Header{
+		Key:    key,
+		Values: []string{val},
+	})
+}
+
+// Enqueue queues the request of a query frontend, and returns its response once
+// a querier executed it.
+func (s *Scheduler) Enqueue(ctx context.Context, req *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error) {
+	// Propagate trace context to the querier.
+	tracer, span := opentracing.GlobalTracer(), opentracing.SpanFromContext(ctx)
+	if tracer != nil && span != nil {
+		carrier := (*httpgrpcHeadersCarrier)(req)
+		tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier)
+	}
+
+	request := request{
+		request:     &frontend.ProcessRequest{HttpRequest: req},
+		originalCtx: ctx,
+
+		// Buffer of 1 to ensure response can be written by the server side
+		// of the Process stream, even if this goroutine goes away due to
+		// client context cancellation.
+		err:      make(chan error, 1),
+		response: make(chan *frontend.ProcessResponse, 1),
+	}
+
+	if err := s.queueRequest(ctx, &request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+
+	case resp := <-request.response:
+		return resp.HttpResponse, nil
+
+	case err := <-request.err:
+		return nil, err
+	}
+}
+
+func (s *Scheduler) queueRequest(ctx context.Context, req *request) error {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.enqueueTime = time.Now()
+	req.queueSpan, _ = opentracing.StartSpanFromContext(ctx, "queued")
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.stopping {
+		return errSchedulerStopping
+	}
+
+	queue := s.queues.getOrAddQueue(userID, s.limits.MaxQueriersPerUser(userID))
+
+	select {
+	case queue <- req:
+		s.queueLength.WithLabelValues(userID).Inc()
+		s.cond.Broadcast()
+		return nil
+	default:
+		return errTooManyRequest
+	}
+}
+
+// Process allows queriers to pull requests from the scheduler.
+func (s *Scheduler) Process(server frontend.Frontend_ProcessServer) error {
+	querierID := querierIDFromContext(server.Context())
+
+	s.addQuerierConnection(querierID)
+	defer s.removeQuerierConnection(querierID)
+
+	// If the downstream request (from querier -> scheduler) is cancelled,
+	// we need to ping the condition variable to unblock getNextRequestForQuerier.
+	go func() {
+		<-server.Context().Done()
+		s.cond.Broadcast()
+	}()
+
+	lastUserIndex := -1
+	for {
+		req, idx, err := s.getNextRequestForQuerier(server.Context(), lastUserIndex, querierID)
+		if err != nil {
+			return err
+		}
+		lastUserIndex = idx
+
+		// Handle the stream sending & receiving on a goroutine so we can
+		// monitoring the contexts in a select and cancel things appropriately.
+		resps := make(chan *frontend.ProcessResponse, 1)
+		errs := make(chan error, 1)
+		go func() {
+			err := server.Send(req.request)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			resp, err := server.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			resps <- resp
+		}()
+
+		select {
+		// If the upstream request is cancelled, we need to cancel the
+		// downstream req. Only way we can do that is to close the stream.
+		// The worker client is expecting this semantics.
+		case <-req.originalCtx.Done():
+			return req.originalCtx.Err()
+
+		// Is there was an error handling this request due to network IO,
+		// then error out this upstream request _and_ stream.
+		case err := <-errs:
+			req.err <- err
+			return err
+
+		// Happy path: propagate the response.
+		case resp := <-resps:
+			req.response <- resp
+		}
+	}
+}
+
+// addQuerierConnection registers a connection of a querier. The tenants are
+// resharded when it's the first one, which wakes up the waiting queriers since
+// their tenants may have changed.
+func (s *Scheduler) addQuerierConnection(querierID string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.queues.addQuerierConnection(querierID)
+	s.connectedQueriers.Set(float64(len(s.queues.queriers)))
+	s.cond.Broadcast()
+}
+
+// removeQuerierConnection unregisters a connection of a querier. The tenants are
+// resharded when it was the last one, the requests it was the only one allowed
+// to execute are then dispatched to the other queriers.
+func (s *Scheduler) removeQuerierConnection(querierID string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.queues.removeQuerierConnection(querierID)
+	s.connectedQueriers.Set(float64(len(s.queues.queriers)))
+	s.cond.Broadcast()
+}
+
+// getNextRequestForQuerier takes the next unexpired request of the first tenant
+// after lastUserIndex which the querier is allowed to execute, so that tenants
+// are processed fairly. It blocks until such a request is queued.
+func (s *Scheduler) getNextRequestForQuerier(ctx context.Context, lastUserIndex int, querierID string) (*request, int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, lastUserIndex, err
+		}
+
+		queue, userID, idx := s.queues.getNextQueueForQuerier(lastUserIndex, querierID)
+		if queue == nil {
+			s.cond.Wait()
+			continue
+		}
+		lastUserIndex = idx
+
+		// Pick the first non-expired request from this tenant's queue (if any).
+		for {
+			lastRequest := false
+			request := <-queue
+			if len(queue) == 0 {
+				s.queues.deleteQueue(userID)
+				s.queueLength.DeleteLabelValues(userID)
+				lastRequest = true
+			} else {
+				s.queueLength.WithLabelValues(userID).Dec()
+			}
+
+			// Tell stop() we've processed a request.
+			s.cond.Broadcast()
+
+			s.queueDuration.Observe(time.Since(request.enqueueTime).Seconds())
+			request.queueSpan.Finish()
+
+			// Ensure the request has not already expired.
+			if request.originalCtx.Err() == nil {
+				return request, lastUserIndex, nil
+			}
+
+			// Stop iterating on this queue if we've just consumed the last request.
+			if lastRequest {
+				break
+			}
+		}
+	}
+}
+
+func querierIDFromContext(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(querierIDKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+
+	// Queriers not sending their ID are identified by the address of the connection.
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
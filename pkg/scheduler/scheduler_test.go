@@ -0,0 +1,219 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+)
+
+type fakeLimits struct {
+	maxQueriers int
+}
+
+func (l fakeLimits) MaxQueriersPerUser(string) int {
+	return l.maxQueriers
+}
+
+// handlerFunc executes the requests dispatched to a querier.
+type handlerFunc func(context.Context, *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error)
+
+func (f handlerFunc) Handle(ctx context.Context, r *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error) {
+	return f(ctx, r)
+}
+
+func testConfig(t *testing.T) Config {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.UseSchedulerRing = true
+	cfg.SchedulerRing.KVStore.Mock = consul.NewInMemoryClient(ring.GetCodec())
+	cfg.SchedulerRing.HeartbeatPeriod = 100 * time.Millisecond
+	cfg.SchedulerRing.InstanceID = "scheduler-1"
+	cfg.SchedulerRing.InstanceAddr = "127.0.0.1"
+	return cfg
+}
+
+func startScheduler(t *testing.T, cfg Config, limits Limits) *Scheduler {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	cfg.SchedulerRing.InstancePort = lis.Addr().(*net.TCPAddr).Port
+
+	s, err := New(cfg, limits, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(middleware.ServerUserHeaderInterceptor))
+	frontend.RegisterFrontendServer(server, s)
+	RegisterSchedulerServer(server, s)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), s))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), s))
+	})
+	return s
+}
+
+func startWorker(t *testing.T, cfg Config, querierID string, handler httpgrpc.HTTPServer) {
+	var workerCfg frontend.WorkerConfig
+	flagext.DefaultValues(&workerCfg)
+	workerCfg.Parallelism = 2
+	workerCfg.DNSLookupDuration = 100 * time.Millisecond
+
+	w, err := NewWorker(cfg, workerCfg, querierID, 0, handler, log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), w))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), w))
+	})
+}
+
+func startFrontend(t *testing.T, cfg Config) *FrontendRoundTripper {
+	rt, err := NewFrontendRoundTripper(cfg, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), rt))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(context.Background(), rt))
+	})
+	return rt
+}
+
+func roundTrip(t *testing.T, rt http.RoundTripper, userID string) *http.Response {
+	req := httptest.NewRequest("GET", "/loki/api/v1/query_range?query={app=\"foo\"}", nil)
+	ctx := user.InjectOrgID(context.Background(), userID)
+	require.NoError(t, user.InjectOrgIDIntoHTTPRequest(ctx, req))
+	req = req.WithContext(ctx)
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = rt.RoundTrip(req)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+	return resp
+}
+
+func TestScheduler(t *testing.T) {
+	cfg := testConfig(t)
+	startScheduler(t, cfg, fakeLimits{})
+	startWorker(t, cfg, "querier-1", handlerFunc(func(_ context.Context, r *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error) {
+		// the tenant is forwarded in the headers of the request.
+		var userID string
+		for _, h := range r.Headers {
+			if http.CanonicalHeaderKey(h.Key) == http.CanonicalHeaderKey(user.OrgIDHeaderName) {
+				userID = h.Values[0]
+			}
+		}
+		return &httpgrpc.HTTPResponse{
+			Code: http.StatusOK,
+			Body: []byte(fmt.Sprintf("%s %s", userID, r.Url)),
+		}, nil
+	}))
+	rt := startFrontend(t, cfg)
+
+	resp := roundTrip(t, rt, "tenant")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, `tenant /loki/api/v1/query_range?query={app="foo"}`, string(body))
+}
+
+func TestScheduler_MaxQueriersPerTenant(t *testing.T) {
+	cfg := testConfig(t)
+	s := startScheduler(t, cfg, fakeLimits{maxQueriers: 1})
+
+	var (
+		mtx      sync.Mutex
+		queriers = map[string]int{}
+	)
+	for _, querierID := range []string{"querier-1", "querier-2", "querier-3"} {
+		querierID := querierID
+		startWorker(t, cfg, querierID, handlerFunc(func(context.Context, *httpgrpc.HTTPRequest) (*httpgrpc.HTTPResponse, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			queriers[querierID]++
+			return &httpgrpc.HTTPResponse{Code: http.StatusOK}, nil
+		}))
+	}
+	rt := startFrontend(t, cfg)
+
+	// the tenant is sharded over the connected queriers.
+	require.Eventually(t, func() bool {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		return len(s.queues.queriers) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+
+	for i := 0; i < 30; i++ {
+		require.Equal(t, http.StatusOK, roundTrip(t, rt, "tenant").StatusCode)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.Len(t, queriers, 1)
+}
+
+func TestScheduler_QuerierDisconnects(t *testing.T) {
+	s, err := New(testConfig(t), fakeLimits{maxQueriers: 1}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	s.addQuerierConnection("querier-1")
+	s.addQuerierConnection("querier-2")
+
+	ctx := user.InjectOrgID(context.Background(), "tenant")
+	req := &request{originalCtx: ctx}
+	require.NoError(t, s.queueRequest(ctx, req))
+
+	// the tenant is sharded to a single querier.
+	s.mtx.Lock()
+	sharded, other := "querier-1", "querier-2"
+	if _, ok := s.queues.userQueues["tenant"].queriers[other]; ok {
+		sharded, other = other, sharded
+	}
+	s.mtx.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		s.cond.Broadcast()
+	})
+	dispatched := make(chan *request, 1)
+	go func() {
+		r, _, err := s.getNextRequestForQuerier(ctx, -1, other)
+		if err == nil {
+			dispatched <- r
+		}
+	}()
+
+	select {
+	case <-dispatched:
+		t.Fatal("the request was dispatched to a querier not allowed to execute it")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the other querier takes the request once the tenant is resharded.
+	s.removeQuerierConnection(sharded)
+	select {
+	case r := <-dispatched:
+		require.Equal(t, req, r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request wasn't dispatched to the remaining querier")
+	}
+}
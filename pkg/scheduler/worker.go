@@ -0,0 +1,294 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var backoffConfig = util.BackoffConfig{
+	MinBackoff: 50 * time.Millisecond,
+	MaxBackoff: 1 * time.Second,
+}
+
+// worker connects a querier to the schedulers found in the ring, and executes
+// the requests they dispatch.
+type worker struct {
+	cfg           frontend.WorkerConfig
+	maxConcurrent int
+	querierID     string
+	handler       httpgrpc.HTTPServer
+	log           log.Logger
+
+	ring     *ring.Ring
+	managers map[string]*schedulerManager
+}
+
+// NewWorker creates a new worker pulling requests from the schedulers and
+// returns a service that is wrapping it. The worker config is shared with the
+// query frontend workers: it uses the same parallelism and gRPC client, and
+// looks up the ring for schedulers every DNSLookupDuration.
+func NewWorker(cfg Config, workerCfg frontend.WorkerConfig, querierID string, maxConcurrent int, handler httpgrpc.HTTPServer, log log.Logger) (services.Service, error) {
+	r, err := newRing(cfg.SchedulerRing)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &worker{
+		cfg:           workerCfg,
+		maxConcurrent: maxConcurrent,
+		querierID:     querierID,
+		handler:       handler,
+		log:           log,
+		ring:          r,
+		managers:      map[string]*schedulerManager{},
+	}
+	return services.NewBasicService(w.starting, w.running, w.stopping), nil
+}
+
+func (w *worker) starting(ctx context.Context) error {
+	return services.StartAndAwaitRunning(ctx, w.ring)
+}
+
+func (w *worker) running(ctx context.Context) error {
+	ticker := time.NewTicker(w.cfg.DNSLookupDuration)
+	defer ticker.Stop()
+
+	for {
+		w.updateSchedulers(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *worker) stopping(_ error) error {
+	for _, mgr := range w.managers {
+		mgr.stop()
+	}
+	return services.StopAndAwaitTerminated(context.Background(), w.ring)
+}
+
+// updateSchedulers connects to the new schedulers of the ring and disconnects
+// from the ones which left it.
+func (w *worker) updateSchedulers(ctx context.Context) {
+	addrs := map[string]struct{}{}
+	rs, err := w.ring.GetAll(ring.Read)
+	if err != nil && err != ring.ErrEmptyRing {
+		level.Error(w.log).Log("msg", "error getting schedulers from the ring", "err", err)
+		return
+	}
+	for _, instance := range rs.Ingesters {
+		addrs[instance.Addr] = struct{}{}
+	}
+
+	changed := false
+	for addr := range addrs {
+		if _, ok := w.managers[addr]; ok {
+			continue
+		}
+		level.Debug(w.log).Log("msg", "adding connection", "addr", addr)
+		client, err := w.connect(ctx, addr)
+		if err != nil {
+			level.Error(w.log).Log("msg", "error connecting", "addr", addr, "err", err)
+			continue
+		}
+		w.managers[addr] = newSchedulerManager(ctx, w.log, w.querierID, w.handler, client, w.cfg.GRPCClientConfig.GRPC.MaxSendMsgSize)
+		changed = true
+	}
+	for addr, mgr := range w.managers {
+		if _, ok := addrs[addr]; ok {
+			continue
+		}
+		level.Debug(w.log).Log("msg", "removing connection", "addr", addr)
+		mgr.stop()
+		delete(w.managers, addr)
+		changed = true
+	}
+
+	if changed {
+		w.resetConcurrency()
+	}
+}
+
+func (w *worker) connect(ctx context.Context, address string) (frontend.FrontendClient, error) {
+	opts, err := w.cfg.GRPCClientConfig.DialOption([]grpc.UnaryClientInterceptor{middleware.ClientUserHeaderInterceptor}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.DialContext(ctx, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return frontend.NewFrontendClient(conn), nil
+}
+
+func (w *worker) resetConcurrency() {
+	addresses := make([]string, 0, len(w.managers))
+	for addr := range w.managers {
+		addresses = append(addresses, addr)
+	}
+	rand.Shuffle(len(addresses), func(i, j int) { addresses[i], addresses[j] = addresses[j], addresses[i] })
+
+	for i, addr := range addresses {
+		w.managers[addr].concurrentRequests(w.concurrency(i))
+	}
+}
+
+func (w *worker) concurrency(index int) int {
+	concurrentRequests := w.cfg.Parallelism
+	if w.cfg.MatchMaxConcurrency {
+		// If max concurrency does not evenly divide into our schedulers a random
+		// subset of them will receive an extra connection.
+		concurrentRequests = w.maxConcurrent / len(w.managers)
+		if index < w.maxConcurrent%len(w.managers) {
+			concurrentRequests++
+		}
+	}
+
+	// Always connect once to every scheduler to not starve any of them.
+	if concurrentRequests <= 0 {
+		concurrentRequests = 1
+	}
+	return concurrentRequests
+}
+
+// schedulerManager runs the processing loops connected to a scheduler.
+type schedulerManager struct {
+	log            log.Logger
+	querierID      string
+	handler        httpgrpc.HTTPServer
+	client         frontend.FrontendClient
+	maxSendMsgSize int
+
+	serverCtx     context.Context
+	workerCancels []context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+func newSchedulerManager(serverCtx context.Context, log log.Logger, querierID string, handler httpgrpc.HTTPServer, client frontend.FrontendClient, maxSendMsgSize int) *schedulerManager {
+	return &schedulerManager{
+		log:            log,
+		querierID:      querierID,
+		handler:        handler,
+		client:         client,
+		maxSendMsgSize: maxSendMsgSize,
+		serverCtx:      serverCtx,
+	}
+}
+
+func (m *schedulerManager) stop() {
+	m.concurrentRequests(0)
+	m.wg.Wait()
+}
+
+func (m *schedulerManager) concurrentRequests(n int) {
+	for len(m.workerCancels) < n {
+		ctx, cancel := context.WithCancel(m.serverCtx)
+		m.workerCancels = append(m.workerCancels, cancel)
+
+		m.wg.Add(1)
+		go m.runOne(ctx)
+	}
+
+	for len(m.workerCancels) > n {
+		var cancel context.CancelFunc
+		cancel, m.workerCancels = m.workerCancels[0], m.workerCancels[1:]
+		cancel()
+	}
+}
+
+// runOne loops, trying to establish a stream to the scheduler to begin
+// request processing.
+func (m *schedulerManager) runOne(ctx context.Context) {
+	defer m.wg.Done()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, querierIDKey, m.querierID)
+	backoff := util.NewBackoff(ctx, backoffConfig)
+	for backoff.Ongoing() {
+		c, err := m.client.Process(ctx)
+		if err != nil {
+			level.Error(m.log).Log("msg", "error contacting scheduler", "err", err)
+			backoff.Wait()
+			continue
+		}
+
+		if err := m.process(c); err != nil {
+			if ctx.Err() == nil {
+				level.Error(m.log).Log("msg", "error processing requests", "err", err)
+			}
+			backoff.Wait()
+			continue
+		}
+
+		backoff.Reset()
+	}
+}
+
+// process loops processing requests on an established stream.
+func (m *schedulerManager) process(c frontend.Frontend_ProcessClient) error {
+	// Build a child context so we can cancel a query when the stream is closed.
+	ctx, cancel := context.WithCancel(c.Context())
+	defer cancel()
+
+	for {
+		request, err := c.Recv()
+		if err != nil {
+			return errors.Wrap(err, "receiving request")
+		}
+
+		// Handle the request on a "background" goroutine, so we go back to
+		// blocking on c.Recv(). This allows us to detect the stream closing
+		// and cancel the query. We don't actually handle queries in parallel
+		// here, as we're running in lock step with the server - each Recv is
+		// paired with a Send.
+		go func() {
+			response, err := m.handler.Handle(ctx, request.HttpRequest)
+			if err != nil {
+				var ok bool
+				response, ok = httpgrpc.HTTPResponseFromError(err)
+				if !ok {
+					response = &httpgrpc.HTTPResponse{
+						Code: http.StatusInternalServerError,
+						Body: []byte(err.Error()),
+					}
+				}
+			}
+
+			// Ensure responses that are too big are not retried.
+			if len(response.Body) >= m.maxSendMsgSize {
+				errMsg := fmt.Sprintf("response larger than the max (%d vs %d)", len(response.Body), m.maxSendMsgSize)
+				response = &httpgrpc.HTTPResponse{
+					Code: http.StatusRequestEntityTooLarge,
+					Body: []byte(errMsg),
+				}
+				level.Error(m.log).Log("msg", "error processing query", "err", errMsg)
+			}
+
+			if err := c.Send(&frontend.ProcessResponse{
+				HttpResponse: response,
+			}); err != nil {
+				level.Error(m.log).Log("msg", "error processing requests", "err", err)
+			}
+		}()
+	}
+}
@@ -79,6 +79,7 @@ type batchChunkIterator struct {
 	cancel   context.CancelFunc
 	matchers []*labels.Matcher
 	filter   logql.LineFilter
+	pipeline logql.Pipeline
 	req      *logproto.QueryRequest
 	next     chan *struct {
 		iter iter.EntryIterator
@@ -87,7 +88,7 @@ type batchChunkIterator struct {
 }
 
 // newBatchChunkIterator creates a new batch iterator with the given batchSize.
-func newBatchChunkIterator(ctx context.Context, chunks []*chunkenc.LazyChunk, batchSize int, matchers []*labels.Matcher, filter logql.LineFilter, req *logproto.QueryRequest) *batchChunkIterator {
+func newBatchChunkIterator(ctx context.Context, chunks []*chunkenc.LazyChunk, batchSize int, matchers []*labels.Matcher, filter logql.LineFilter, pipeline logql.Pipeline, req *logproto.QueryRequest) *batchChunkIterator {
 	// __name__ is not something we filter by because it's a constant in loki
 	// and only used for upstream compatibility; therefore remove it.
 	// The same applies to the sharding label which is injected by the cortex storage code.
@@ -105,6 +106,7 @@ func newBatchChunkIterator(ctx context.Context, chunks []*chunkenc.LazyChunk, ba
 		batchSize: batchSize,
 		matchers:  matchers,
 		filter:    filter,
+		pipeline:  pipeline,
 		req:       req,
 		ctx:       ctx,
 		cancel:    cancel,
@@ -254,7 +256,7 @@ func (it *batchChunkIterator) nextBatch() (iter.EntryIterator, error) {
 	}
 
 	// create the new chunks iterator from the current batch.
-	return newChunksIterator(it.ctx, batch, it.matchers, it.filter, it.req.Direction, from, through)
+	return newChunksIterator(it.ctx, batch, it.matchers, it.filter, it.pipeline, it.req.Direction, from, through)
 }
 
 func (it *batchChunkIterator) Entry() logproto.Entry {
@@ -284,7 +286,7 @@ func (it *batchChunkIterator) Close() error {
 }
 
 // newChunksIterator creates an iterator over a set of lazychunks.
-func newChunksIterator(ctx context.Context, chunks []*chunkenc.LazyChunk, matchers []*labels.Matcher, filter logql.LineFilter, direction logproto.Direction, from, through time.Time) (iter.EntryIterator, error) {
+func newChunksIterator(ctx context.Context, chunks []*chunkenc.LazyChunk, matchers []*labels.Matcher, filter logql.LineFilter, pipeline logql.Pipeline, direction logproto.Direction, from, through time.Time) (iter.EntryIterator, error) {
 	chksBySeries := partitionBySeriesChunks(chunks)
 
 	// Make sure the initial chunks are loaded. This is not one chunk
@@ -309,7 +311,7 @@ func newChunksIterator(ctx context.Context, chunks []*chunkenc.LazyChunk, matche
 		return nil, err
 	}
 
-	iters, err := buildIterators(ctx, chksBySeries, filter, direction, from, through)
+	iters, err := buildIterators(ctx, chksBySeries, filter, pipeline, direction, from, through)
 	if err != nil {
 		return nil, err
 	}
@@ -317,10 +319,10 @@ func newChunksIterator(ctx context.Context, chunks []*chunkenc.LazyChunk, matche
 	return iter.NewHeapIterator(ctx, iters, direction), nil
 }
 
-func buildIterators(ctx context.Context, chks map[model.Fingerprint][][]*chunkenc.LazyChunk, filter logql.LineFilter, direction logproto.Direction, from, through time.Time) ([]iter.EntryIterator, error) {
+func buildIterators(ctx context.Context, chks map[model.Fingerprint][][]*chunkenc.LazyChunk, filter logql.LineFilter, pipeline logql.Pipeline, direction logproto.Direction, from, through time.Time) ([]iter.EntryIterator, error) {
 	result := make([]iter.EntryIterator, 0, len(chks))
 	for _, chunks := range chks {
-		iterator, err := buildHeapIterator(ctx, chunks, filter, direction, from, through)
+		iterator, err := buildHeapIterator(ctx, chunks, filter, pipeline, direction, from, through)
 		if err != nil {
 			return nil, err
 		}
@@ -330,7 +332,7 @@ func buildIterators(ctx context.Context, chks map[model.Fingerprint][][]*chunken
 	return result, nil
 }
 
-func buildHeapIterator(ctx context.Context, chks [][]*chunkenc.LazyChunk, filter logql.LineFilter, direction logproto.Direction, from, through time.Time) (iter.EntryIterator, error) {
+func buildHeapIterator(ctx context.Context, chks [][]*chunkenc.LazyChunk, filter logql.LineFilter, pipeline logql.Pipeline, direction logproto.Direction, from, through time.Time) (iter.EntryIterator, error) {
 	result := make([]iter.EntryIterator, 0, len(chks))
 
 	// __name__ is only used for upstream compatibility and is hardcoded within loki. Strip it from the return label set.
@@ -355,7 +357,8 @@ func buildHeapIterator(ctx context.Context, chks [][]*chunkenc.LazyChunk, filter
 		result = append(result, iter.NewNonOverlappingIterator(iterators, labels))
 	}
 
-	return iter.NewHeapIterator(ctx, result, direction), nil
+	// the pipeline is applied per series so that entries are merged with the ones of other series using their final labels.
+	return logql.NewPipelineIterator(iter.NewHeapIterator(ctx, result, direction), pipeline), nil
 }
 
 func filterSeriesByMatchers(chks map[model.Fingerprint][][]*chunkenc.LazyChunk, matchers []*labels.Matcher) map[model.Fingerprint][][]*chunkenc.LazyChunk {
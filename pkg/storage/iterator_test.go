@@ -549,7 +549,7 @@ func Test_newBatchChunkIterator(t *testing.T) {
 	for name, tt := range tests {
 		tt := tt
 		t.Run(name, func(t *testing.T) {
-			it := newBatchChunkIterator(context.Background(), tt.chunks, tt.batchSize, newMatchers(tt.matchers), nil, newQuery("", tt.start, tt.end, tt.direction, nil))
+			it := newBatchChunkIterator(context.Background(), tt.chunks, tt.batchSize, newMatchers(tt.matchers), nil, nil, newQuery("", tt.start, tt.end, tt.direction, nil))
 			streams, _, err := iter.ReadBatch(it, 1000)
 			_ = it.Close()
 			if err != nil {
@@ -751,7 +751,7 @@ func TestBuildHeapIterator(t *testing.T) {
 	} {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
 			ctx = user.InjectOrgID(context.Background(), "test-user")
-			it, err := buildHeapIterator(ctx, tc.input, nil, logproto.FORWARD, from, from.Add(6*time.Millisecond))
+			it, err := buildHeapIterator(ctx, tc.input, nil, nil, logproto.FORWARD, from, from.Add(6*time.Millisecond))
 			if err != nil {
 				t.Errorf("buildHeapIterator error = %v", err)
 				return
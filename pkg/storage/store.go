@@ -73,28 +73,35 @@ func NewTableClient(name string, cfg Config) (chunk.TableClient, error) {
 
 // decodeReq sanitizes an incoming request, rounds bounds, appends the __name__ matcher,
 // and adds the "__cortex_shard__" label if this is a sharded query.
-func decodeReq(req logql.SelectParams) ([]*labels.Matcher, logql.LineFilter, model.Time, model.Time, error) {
+func decodeReq(req logql.SelectParams) ([]*labels.Matcher, logql.LineFilter, logql.Pipeline, model.Time, model.Time, error) {
 	expr, err := req.LogSelector()
 	if err != nil {
-		return nil, nil, 0, 0, err
+		return nil, nil, nil, 0, 0, err
 	}
 
 	filter, err := expr.Filter()
 	if err != nil {
-		return nil, nil, 0, 0, err
+		return nil, nil, nil, 0, 0, err
 	}
 
+	pipeline, err := expr.Pipeline()
+	if err != nil {
+		return nil, nil, nil, 0, 0, err
+	}
+	// the merged part of the pipeline is applied by the querier.
+	pipeline, _ = pipeline.Split()
+
 	matchers := expr.Matchers()
 	nameLabelMatcher, err := labels.NewMatcher(labels.MatchEqual, labels.MetricName, "logs")
 	if err != nil {
-		return nil, nil, 0, 0, err
+		return nil, nil, nil, 0, 0, err
 	}
 	matchers = append(matchers, nameLabelMatcher)
 
 	if shards := req.GetShards(); shards != nil {
 		parsed, err := logql.ParseShards(shards)
 		if err != nil {
-			return nil, nil, 0, 0, err
+			return nil, nil, nil, 0, 0, err
 		}
 		for _, s := range parsed {
 			shardMatcher, err := labels.NewMatcher(
@@ -103,7 +110,7 @@ func decodeReq(req logql.SelectParams) ([]*labels.Matcher, logql.LineFilter, mod
 				s.String(),
 			)
 			if err != nil {
-				return nil, nil, 0, 0, err
+				return nil, nil, nil, 0, 0, err
 			}
 			matchers = append(matchers, shardMatcher)
 
@@ -115,7 +122,7 @@ func decodeReq(req logql.SelectParams) ([]*labels.Matcher, logql.LineFilter, mod
 	}
 
 	from, through := util.RoundToMilliseconds(req.Start, req.End)
-	return matchers, filter, from, through, nil
+	return matchers, filter, pipeline, from, through, nil
 }
 
 // lazyChunks is an internal function used to resolve a set of lazy chunks from the store without actually loading them. It's used internally by `LazyQuery` and `GetSeries`
@@ -149,7 +156,7 @@ func (s *store) lazyChunks(ctx context.Context, matchers []*labels.Matcher, from
 }
 
 func (s *store) GetSeries(ctx context.Context, req logql.SelectParams) ([]logproto.SeriesIdentifier, error) {
-	matchers, _, from, through, err := decodeReq(req)
+	matchers, _, _, from, through, err := decodeReq(req)
 	if err != nil {
 		return nil, err
 	}
@@ -216,7 +223,7 @@ func (s *store) GetSeries(ctx context.Context, req logql.SelectParams) ([]logpro
 // LazyQuery returns an iterator that will query the store for more chunks while iterating instead of fetching all chunks upfront
 // for that request.
 func (s *store) LazyQuery(ctx context.Context, req logql.SelectParams) (iter.EntryIterator, error) {
-	matchers, filter, from, through, err := decodeReq(req)
+	matchers, filter, pipeline, from, through, err := decodeReq(req)
 	if err != nil {
 		return nil, err
 	}
@@ -226,7 +233,7 @@ func (s *store) LazyQuery(ctx context.Context, req logql.SelectParams) (iter.Ent
 		return nil, err
 	}
 
-	return newBatchChunkIterator(ctx, lazyChunks, s.cfg.MaxChunkBatchSize, matchers, filter, req.QueryRequest), nil
+	return newBatchChunkIterator(ctx, lazyChunks, s.cfg.MaxChunkBatchSize, matchers, filter, pipeline, req.QueryRequest), nil
 
 }
 
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"time"
 
+	"github.com/grafana/loki/pkg/chunkenc"
 	"github.com/grafana/loki/pkg/util/flagext"
 )
 
@@ -34,8 +35,10 @@ type Limits struct {
 	MaxLineSize            flagext.ByteSize `yaml:"max_line_size"`
 
 	// Ingester enforced limits.
-	MaxLocalStreamsPerUser  int `yaml:"max_streams_per_user"`
-	MaxGlobalStreamsPerUser int `yaml:"max_global_streams_per_user"`
+	MaxLocalStreamsPerUser  int           `yaml:"max_streams_per_user"`
+	MaxGlobalStreamsPerUser int           `yaml:"max_global_streams_per_user"`
+	UnorderedWritesWindow   time.Duration `yaml:"unordered_writes_window"`
+	ChunkEncoding           string        `yaml:"chunk_encoding"`
 
 	// Querier enforced limits.
 	MaxChunksPerQuery          int           `yaml:"max_chunks_per_query"`
@@ -50,6 +53,9 @@ type Limits struct {
 	// Query frontend enforced limits. The default is actually parameterized by the queryrange config.
 	QuerySplitDuration time.Duration `yaml:"split_queries_by_interval"`
 
+	// Query scheduler enforced limits.
+	MaxQueriersPerTenant int `yaml:"max_queriers_per_tenant"`
+
 	// Config for overrides, convenient if it goes here.
 	PerTenantOverrideConfig string        `yaml:"per_tenant_override_config"`
 	PerTenantOverridePeriod time.Duration `yaml:"per_tenant_override_period"`
@@ -72,6 +78,8 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 
 	f.IntVar(&l.MaxLocalStreamsPerUser, "ingester.max-streams-per-user", 10e3, "Maximum number of active streams per user, per ingester. 0 to disable.")
 	f.IntVar(&l.MaxGlobalStreamsPerUser, "ingester.max-global-streams-per-user", 0, "Maximum number of active streams per user, across the cluster. 0 to disable.")
+	f.DurationVar(&l.UnorderedWritesWindow, "ingester.unordered-writes-window", 0, "How much older than the newest entry of a stream an entry can be to be accepted. 0 to reject all the entries written out of order.")
+	f.StringVar(&l.ChunkEncoding, "ingester.tenant-chunk-encoding", "", "The algorithm to use for compressing the chunks of the tenant, overriding -ingester.chunk-encoding. Empty to use -ingester.chunk-encoding.")
 
 	f.IntVar(&l.MaxChunksPerQuery, "store.query-chunk-limit", 2e6, "Maximum number of chunks that can be fetched in a single query.")
 	f.DurationVar(&l.MaxQueryLength, "store.max-query-length", 0, "Limit to length of chunk store queries, 0 to disable.")
@@ -81,6 +89,8 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&l.MaxConcurrentTailRequests, "querier.max-concurrent-tail-requests", 10, "Limit the number of concurrent tail requests")
 	f.DurationVar(&l.MaxCacheFreshness, "frontend.max-cache-freshness", 1*time.Minute, "Most recent allowed cacheable result per-tenant, to prevent caching very recent results that might still be in flux.")
 
+	f.IntVar(&l.MaxQueriersPerTenant, "query-scheduler.max-queriers-per-tenant", 0, "Maximum number of queriers that can handle requests for a single tenant, the tenant being shuffle sharded over the queriers connected to each query scheduler. 0 to use all queriers.")
+
 	f.StringVar(&l.PerTenantOverrideConfig, "limits.per-user-override-config", "", "File name of per-user overrides.")
 	f.DurationVar(&l.PerTenantOverridePeriod, "limits.per-user-override-period", 10*time.Second, "Period with this to reload the overrides.")
 }
@@ -96,7 +106,16 @@ func (l *Limits) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		*l = *defaultLimits
 	}
 	type plain Limits
-	return unmarshal((*plain)(l))
+	if err := unmarshal((*plain)(l)); err != nil {
+		return err
+	}
+
+	if l.ChunkEncoding != "" {
+		if _, err := chunkenc.ParseEncoding(l.ChunkEncoding); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // When we load YAML from disk, we want the various per-customer limits
@@ -194,6 +213,16 @@ func (o *Overrides) MaxGlobalStreamsPerUser(userID string) int {
 	return o.getOverridesForUser(userID).MaxGlobalStreamsPerUser
 }
 
+// UnorderedWritesWindow returns how much older than the newest entry of a stream an entry can be to be accepted.
+func (o *Overrides) UnorderedWritesWindow(userID string) time.Duration {
+	return o.getOverridesForUser(userID).UnorderedWritesWindow
+}
+
+// ChunkEncoding returns the algorithm to use for compressing the chunks of a tenant.
+func (o *Overrides) ChunkEncoding(userID string) string {
+	return o.getOverridesForUser(userID).ChunkEncoding
+}
+
 // MaxChunksPerQuery returns the maximum number of chunks allowed per query.
 func (o *Overrides) MaxChunksPerQuery(userID string) int {
 	return o.getOverridesForUser(userID).MaxChunksPerQuery
@@ -249,6 +278,11 @@ func (o *Overrides) MaxCacheFreshness(userID string) time.Duration {
 	return o.getOverridesForUser(userID).MaxCacheFreshness
 }
 
+// MaxQueriersPerUser returns the maximum number of queriers the query scheduler can use to execute the queries of a tenant.
+func (o *Overrides) MaxQueriersPerUser(userID string) int {
+	return o.getOverridesForUser(userID).MaxQueriersPerTenant
+}
+
 func (o *Overrides) getOverridesForUser(userID string) *Limits {
 	if o.tenantLimits != nil {
 		l := o.tenantLimits(userID)
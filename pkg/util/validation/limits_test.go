@@ -0,0 +1,31 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLimitsChunkEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		exp string
+		err bool
+	}{
+		{in: `chunk_encoding: snappy`, exp: "snappy"},
+		{in: `chunk_encoding: ""`, exp: ""},
+		{in: `chunk_encoding: foo`, err: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			var l Limits
+			err := yaml.UnmarshalStrict([]byte(tc.in), &l)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.exp, l.ChunkEncoding)
+		})
+	}
+}
@@ -39,6 +39,9 @@ const (
 	// DuplicateLabelNames is a reason for discarding a log line which has duplicate label names
 	DuplicateLabelNames         = "duplicate_label_names"
 	duplicateLabelNamesErrorMsg = "stream '%s' has duplicate label name: '%s'"
+	// InvalidMetadataName is a reason for discarding a log line which has a structured metadata name not valid as a label name
+	InvalidMetadataName         = "invalid_metadata_name"
+	invalidMetadataNameErrorMsg = "entry for stream '%s' has invalid structured metadata name: '%s'"
 )
 
 // DiscardedBytes is a metric of the total discarded bytes, by reason.
@@ -109,3 +112,8 @@ func LabelValueTooLongErrorMsg(stream, labelValue string) string {
 func DuplicateLabelNamesErrorMsg(stream, label string) string {
 	return fmt.Sprintf(duplicateLabelNamesErrorMsg, stream, label)
 }
+
+// InvalidMetadataNameErrorMsg returns an error string for a line which has a structured metadata name not valid as a label name
+func InvalidMetadataNameErrorMsg(stream, name string) string {
+	return fmt.Sprintf(invalidMetadataNameErrorMsg, stream, name)
+}